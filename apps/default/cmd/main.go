@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"encoding/base64"
+	"fmt"
 	"net/http"
 
 	"buf.build/gen/go/antinvestor/notification/connectrpc/go/notification/v1/notificationv1connect"
@@ -28,6 +29,7 @@ import (
 	aconfig "github.com/antinvestor/service-profile/apps/default/config"
 	"github.com/antinvestor/service-profile/apps/default/service/authz"
 	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/encryption"
 	"github.com/antinvestor/service-profile/apps/default/service/events"
 	"github.com/antinvestor/service-profile/apps/default/service/handlers"
 	"github.com/antinvestor/service-profile/apps/default/service/repository"
@@ -66,6 +68,10 @@ func main() {
 		log.WithError(dekErr).Fatal("main -- Could not decode DEK encryption keys")
 	}
 
+	if len(cfg.VerificationLinkTenants) > 0 && cfg.VerificationTokenSigningKey == "" {
+		log.Fatal("main -- VERIFICATION_TOKEN_SIGNING_KEY is required when VERIFICATION_LINK_TENANTS is set")
+	}
+
 	// Setup plan: migrate + bootstrap seed. Permissions via setup step only.
 	// DEK env required on the setup/migrate Job:
 	//   DEK_ACTIVE_KEY_ID, DEK_AES256GCM_KEY, DEK_LOOKUP_TOKEN_HMACSHA256_KEY
@@ -74,6 +80,9 @@ func main() {
 		return repository.Migrate(ctx, dbManager, cfg.GetDatabaseMigrationPath())
 	})
 	svc.Setup().RegisterFunc(setup.NameBootstrap, func(ctx context.Context) error {
+		if providerErr := setupKeyProvider(ctx, svc, dek); providerErr != nil {
+			return providerErr
+		}
 		seedDefaultData(ctx, svc, dek)
 		return nil
 	})
@@ -95,6 +104,27 @@ func main() {
 
 	svc.Init(ctx, runtimeServiceOptions(ctx, svc, &cfg, profileSD, dek, notificationCli)...)
 
+	if providerErr := setupKeyProvider(ctx, svc, dek); providerErr != nil {
+		log.WithError(providerErr).Fatal("main -- Could not setup contact encryption key provider")
+	}
+
+	// Background jobs run immediately and then on their configured interval,
+	// each on one replica at a time.
+	schedulerCtx, cancelSchedulers := context.WithCancel(ctx)
+	defer cancelSchedulers()
+	jobLocker := repository.NewJobLocker(svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName))
+	for _, scheduler := range []business.Scheduler{
+		newDuplicateBusiness(ctx, svc, dek),
+		newPropertyCompactionBusiness(ctx, svc),
+		newVerificationExpiryBusiness(ctx, svc),
+		newRelationshipExpiryBusiness(ctx, svc),
+		newKeyRotationBusiness(ctx, svc, dek),
+		newLookupTokenRotationBusiness(ctx, svc, dek),
+		newProfileExportBusiness(ctx, svc, dek),
+	} {
+		go scheduler.StartScheduler(schedulerCtx, jobLocker)
+	}
+
 	if runErr := svc.Run(ctx, ""); runErr != nil {
 		log.WithError(runErr).Fatal("could not run Server")
 	}
@@ -127,6 +157,30 @@ func runtimeServiceOptions(
 			cfg.QueueRelationshipDisConnectName,
 			cfg.QueueRelationshipDisConnectURI,
 		),
+		frame.WithRegisterPublisher(
+			cfg.QueueProfileMergedName,
+			cfg.QueueProfileMergedURI,
+		),
+		frame.WithRegisterPublisher(
+			cfg.QueueProfileErasedName,
+			cfg.QueueProfileErasedURI,
+		),
+		frame.WithRegisterPublisher(
+			cfg.QueueProfileExportCompletedName,
+			cfg.QueueProfileExportCompletedURI,
+		),
+		frame.WithRegisterPublisher(
+			cfg.QueueRosterMatchName,
+			cfg.QueueRosterMatchURI,
+		),
+		frame.WithRegisterPublisher(
+			cfg.QueueContactVerificationExpiredName,
+			cfg.QueueContactVerificationExpiredURI,
+		),
+		frame.WithRegisterPublisher(
+			cfg.QueueRelationshipExpiredName,
+			cfg.QueueRelationshipExpiredURI,
+		),
 		frame.WithRegisterEvents(
 			events.NewClientConnectedSetupQueue(
 				ctx,
@@ -137,21 +191,127 @@ func runtimeServiceOptions(
 			),
 			events.NewContactVerificationQueue(
 				cfg,
+				dek,
 				contactRepository,
 				repository.NewVerificationRepository(ctx, dbPool, workMan),
 				notificationCli,
 			),
 			events.NewContactVerificationAttemptedQueue(
-				contactRepository,
 				repository.NewVerificationRepository(ctx, dbPool, workMan),
 			),
 			events.NewContactKeyRotationQueue(
 				cfg, dek, contactRepository,
 			),
+			events.NewProfileMergedQueue(cfg, qMan),
+			events.NewProfileErasedQueue(cfg, qMan),
+			events.NewProfileExportCompletedQueue(cfg, qMan),
+			events.NewRosterMatchQueue(
+				cfg,
+				qMan,
+				repository.NewProfileRepository(ctx, dbPool, workMan),
+				repository.NewRosterRepository(ctx, dbPool, workMan),
+			),
+			events.NewContactVerificationExpiredQueue(cfg, qMan),
+			events.NewRelationshipExpiredQueue(cfg, qMan),
 		),
 	}
 }
 
+// newDuplicateBusiness builds the duplicate detection business for the scheduler.
+func newDuplicateBusiness(ctx context.Context, svc *frame.Service, dek *aconfig.DEK) business.DuplicateBusiness {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+	workMan := svc.WorkManager()
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+
+	return business.NewDuplicateBusiness(
+		ctx,
+		cfg,
+		dek,
+		repository.NewContactRepository(ctx, dbPool, workMan),
+		repository.NewDuplicateCandidateRepository(ctx, dbPool, workMan),
+	)
+}
+
+// newPropertyCompactionBusiness builds the property ledger compaction business
+// for the scheduler.
+func newPropertyCompactionBusiness(ctx context.Context, svc *frame.Service) business.PropertyCompactionBusiness {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+	workMan := svc.WorkManager()
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+
+	return business.NewPropertyCompactionBusiness(
+		ctx,
+		cfg,
+		repository.NewPropertyEntryRepository(ctx, dbPool, workMan),
+	)
+}
+
+// newVerificationExpiryBusiness builds the contact verification expiry
+// business for the scheduler.
+func newVerificationExpiryBusiness(ctx context.Context, svc *frame.Service) business.VerificationExpiryBusiness {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+	workMan := svc.WorkManager()
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+
+	return business.NewVerificationExpiryBusiness(
+		ctx,
+		cfg,
+		svc.EventsManager(),
+		repository.NewContactRepository(ctx, dbPool, workMan),
+	)
+}
+
+// newRelationshipExpiryBusiness builds the relationship expiry business for
+// the scheduler.
+func newRelationshipExpiryBusiness(ctx context.Context, svc *frame.Service) business.RelationshipExpiryBusiness {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+	workMan := svc.WorkManager()
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+
+	return business.NewRelationshipExpiryBusiness(
+		ctx,
+		cfg,
+		svc.EventsManager(),
+		repository.NewRelationshipRepository(ctx, dbPool, workMan),
+	)
+}
+
+// newKeyRotationBusiness builds the contact key rotation business for the
+// scheduler.
+func newKeyRotationBusiness(ctx context.Context, svc *frame.Service, dek *aconfig.DEK) business.KeyRotationBusiness {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+	workMan := svc.WorkManager()
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+
+	return business.NewKeyRotationBusiness(
+		ctx,
+		cfg,
+		dek,
+		svc.EventsManager(),
+		repository.NewContactRepository(ctx, dbPool, workMan),
+		repository.NewKeyRotationSweepRepository(ctx, dbPool, workMan),
+	)
+}
+
+// newLookupTokenRotationBusiness builds the contact lookup token rotation
+// business for the scheduler.
+func newLookupTokenRotationBusiness(
+	ctx context.Context,
+	svc *frame.Service,
+	dek *aconfig.DEK,
+) business.LookupTokenRotationBusiness {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+	workMan := svc.WorkManager()
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+
+	return business.NewLookupTokenRotationBusiness(
+		ctx,
+		cfg,
+		dek,
+		repository.NewContactRepository(ctx, dbPool, workMan),
+	)
+}
+
 // setupNotificationClient creates and configures the notification client.
 func setupNotificationClient(
 	ctx context.Context,
@@ -183,14 +343,62 @@ func decodeDEK(cfg aconfig.ProfileConfig) (*aconfig.DEK, error) {
 		}
 	}
 
+	var oldLookupKey []byte
+	if cfg.DEKOldLookupTokenHMACSHA256Key != "" {
+		oldLookupKey, err = base64.StdEncoding.DecodeString(cfg.DEKOldLookupTokenHMACSHA256Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	historicKeys := make(map[string][]byte, len(cfg.DEKHistoricAES256GCMKeys))
+	for keyID, encodedKey := range cfg.DEKHistoricAES256GCMKeys {
+		historicKeys[keyID], err = base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("historic key %s: %w", keyID, err)
+		}
+	}
+
 	return &aconfig.DEK{
 		KeyID:     cfg.DEKActiveKeyID,
 		Key:       key,
+		OldKeyID:  cfg.DEKOldKeyID,
 		OldKey:    oldKey,
 		LookUpKey: lookupKey,
+		OldKeys:   historicKeys,
+
+		LookUpKeyID:         cfg.DEKLookupTokenKeyID,
+		OldLookUpKeyID:      cfg.DEKOldLookupTokenKeyID,
+		OldLookUpKey:        oldLookupKey,
+		OldLookUpKeyRetired: cfg.DEKOldLookupTokenRetired,
 	}, nil
 }
 
+// setupKeyProvider switches dek to envelope encryption, with per-tenant data
+// keys wrapped by the configured master key backend, when
+// ContactEncryptionMode asks for it.
+func setupKeyProvider(ctx context.Context, svc *frame.Service, dek *aconfig.DEK) error {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+
+	switch cfg.ContactEncryptionMode {
+	case "", aconfig.EncryptionModeStatic:
+		return nil
+	case aconfig.EncryptionModeEnvelope:
+	default:
+		return fmt.Errorf("unsupported contact encryption mode %q", cfg.ContactEncryptionMode)
+	}
+
+	backend, err := encryption.NewMasterKeyBackend(cfg)
+	if err != nil {
+		return err
+	}
+
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+	dek.Provider = encryption.NewEnvelopeKeyProvider(backend,
+		repository.NewDataKeyRepository(ctx, dbPool, svc.WorkManager()))
+	return nil
+}
+
 // seedDefaultData ensures bootstrap profiles have their encrypted contacts.
 // Profile rows are created by SQL migration; this function adds contacts
 // since they require application-level encryption (DEK).
@@ -217,6 +425,8 @@ func seedDefaultData(ctx context.Context, svc *frame.Service, dek *aconfig.DEK)
 	propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, workMan)
 	addressRepo := repository.NewAddressRepository(ctx, dbPool, workMan)
 	addressBiz := business.NewAddressBusiness(ctx, addressRepo)
+	schemaRepo := repository.NewPropertySchemaRepository(ctx, dbPool, workMan)
+	schemaBiz := business.NewPropertySchemaBusiness(ctx, schemaRepo)
 	profileBiz := business.NewProfileBusiness(
 		ctx,
 		cfg,
@@ -224,6 +434,7 @@ func seedDefaultData(ctx context.Context, svc *frame.Service, dek *aconfig.DEK)
 		evtsMan,
 		contactBiz,
 		addressBiz,
+		schemaBiz,
 		profileRepo,
 		propertyEntryRepo,
 	)
@@ -316,7 +527,32 @@ func setupConnectServer(ctx context.Context, svc *frame.Service, dek *aconfig.DE
 	mux := http.NewServeMux()
 	mux.Handle("/", serverHandler)
 	mux.Handle("/public/", http.StripPrefix("/public", publicRestHandler))
+	// Verification links are opened by users who may not be signed in, the
+	// token they carry is the credential.
+	mux.HandleFunc("POST /public/verification/confirm", implementation.RestConfirmVerificationTokenEndpoint)
 	mux.Handle("/openapi.yaml", apis.NewOpenAPIHandler(profileAPISpecFile, nil))
 
 	return mux
 }
+
+// newProfileExportBusiness builds the profile data export business for the
+// scheduler.
+func newProfileExportBusiness(
+	ctx context.Context,
+	svc *frame.Service,
+	dek *aconfig.DEK,
+) business.ProfileExportBusiness {
+	cfg, _ := svc.Config().(*aconfig.ProfileConfig)
+	workMan := svc.WorkManager()
+	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+
+	return business.NewProfileExportBusiness(
+		ctx,
+		cfg,
+		dek,
+		svc.EventsManager(),
+		repository.NewProfileRepository(ctx, dbPool, workMan),
+		repository.NewPropertyEntryRepository(ctx, dbPool, workMan),
+		repository.NewProfileExportRepository(ctx, dbPool, workMan),
+	)
+}
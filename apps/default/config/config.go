@@ -15,20 +15,111 @@ type ProfileConfig struct {
 	SystemAccessID string `envDefault:"c8cf0ldstmdlinc3eva0" env:"STATIC_SYSTEM_ACCESS_ID"`
 
 	DEKLookupTokenHMACSHA256Key string `envDefault:"yZ9cW4nY7Jq6B7Xr0sN9dFv2mHkP8QY1EJ5VtLxD0uM=" env:"DEK_LOOKUP_TOKEN"`
+	DEKLookupTokenKeyID         string `envDefault:"contacts-lookup-2026-01"                      env:"DEK_LOOKUP_TOKEN_KEY_ID"`
 	DEKActiveKeyID              string `envDefault:"contacts-dek-2026-01"                         env:"DEK_ACTIVE_KEY_ID"`
 	DEKActiveAES256GCMKey       string `envDefault:"GZQ8s2m1Kc1yBZzV8YvWJ0l9M5RqK3a9QY7xYb9o7Ww=" env:"DEK_ACTIVE_ENCRYPTION_TOKEN"`
+	DEKOldKeyID                 string `envDefault:""                                             env:"DEK_OLD_KEY_ID"`
 	DEKOldAES256GCMKey          string `envDefault:""                                             env:"DEK_OLD_ENCRYPTION_TOKEN"`
 
+	// The lookup token key being rotated out. Contacts are looked up by
+	// tokens of both keys until every token is recomputed with the active
+	// key and DEK_OLD_LOOKUP_TOKEN_RETIRED is set.
+	DEKOldLookupTokenHMACSHA256Key string `envDefault:""      env:"DEK_OLD_LOOKUP_TOKEN"`
+	DEKOldLookupTokenKeyID         string `envDefault:""      env:"DEK_OLD_LOOKUP_TOKEN_KEY_ID"`
+	DEKOldLookupTokenRetired       bool   `envDefault:"false" env:"DEK_OLD_LOOKUP_TOKEN_RETIRED"`
+
+	// Keys retired before DEK_OLD_KEY_ID, as KEY_ID:base64 pairs, kept so
+	// contacts still encrypted with them can be read and re-encrypted.
+	DEKHistoricAES256GCMKeys map[string]string `envDefault:"" env:"DEK_HISTORIC_ENCRYPTION_TOKENS"`
+
+	// ContactEncryptionMode is "static", encrypting contact details with the
+	// DEK_* keys above, or "envelope", encrypting each tenant's details with
+	// its own data key wrapped by a master key from MasterKeyBackend. The
+	// "local" backend reads KEY_ID:base64 lines from MasterKeyringFile, the
+	// last being active; "vault" uses a Vault transit engine key.
+	ContactEncryptionMode string `envDefault:"static"           env:"CONTACT_ENCRYPTION_MODE"`
+	MasterKeyBackend      string `envDefault:"local"            env:"MASTER_KEY_BACKEND"`
+	MasterKeyringFile     string `envDefault:""                 env:"MASTER_KEYRING_FILE"`
+	VaultAddress          string `envDefault:""                 env:"VAULT_ADDR"`
+	VaultToken            string `envDefault:""                 env:"VAULT_TOKEN"`
+	VaultTransitMount     string `envDefault:"transit"          env:"VAULT_TRANSIT_MOUNT"`
+	VaultTransitKey       string `envDefault:"profile-contacts" env:"VAULT_TRANSIT_KEY"`
+
+	// How often contacts not yet on the active key are queued for re-encryption.
+	KeyRotationSweepIntervalInSec int `envDefault:"3600" env:"KEY_ROTATION_SWEEP_INTERVAL_IN_SEC"`
+	// How often lookup tokens made with an old lookup key are recomputed.
+	LookupTokenRotationIntervalInSec int `envDefault:"3600" env:"LOOKUP_TOKEN_ROTATION_INTERVAL_IN_SEC"`
+
 	QueueRelationshipConnectName string `envDefault:"relationships.connect"               env:"QUEUE_RELATIONSHIP_CONNECT_NAME"`
 	QueueRelationshipConnectURI  string `envDefault:"mem://default.relationships.connect" env:"QUEUE_RELATIONSHIP_CONNECT_URI"`
 
 	QueueRelationshipDisConnectName string `envDefault:"relationships.disconnect"               env:"QUEUE_RELATIONSHIP_DISCONNECT_NAME"`
 	QueueRelationshipDisConnectURI  string `envDefault:"mem://default.relationships.disconnect" env:"QUEUE_RELATIONSHIP_DISCONNECT_URI"`
 
+	QueueProfileMergedName string `envDefault:"profiles.merged"               env:"QUEUE_PROFILE_MERGED_NAME"`
+	QueueProfileMergedURI  string `envDefault:"mem://default.profiles.merged" env:"QUEUE_PROFILE_MERGED_URI"`
+
+	QueueProfileErasedName string `envDefault:"profiles.erased"               env:"QUEUE_PROFILE_ERASED_NAME"`
+	QueueProfileErasedURI  string `envDefault:"mem://default.profiles.erased" env:"QUEUE_PROFILE_ERASED_URI"`
+
+	QueueProfileExportCompletedName string `envDefault:"profiles.export.completed"               env:"QUEUE_PROFILE_EXPORT_COMPLETED_NAME"`
+	QueueProfileExportCompletedURI  string `envDefault:"mem://default.profiles.export.completed" env:"QUEUE_PROFILE_EXPORT_COMPLETED_URI"`
+
+	QueueRosterMatchName string `envDefault:"rosters.matched"               env:"QUEUE_ROSTER_MATCH_NAME"`
+	QueueRosterMatchURI  string `envDefault:"mem://default.rosters.matched" env:"QUEUE_ROSTER_MATCH_URI"`
+
+	QueueContactVerificationExpiredName string `envDefault:"contacts.verification.expired"               env:"QUEUE_CONTACT_VERIFICATION_EXPIRED_NAME"`
+	QueueContactVerificationExpiredURI  string `envDefault:"mem://default.contacts.verification.expired" env:"QUEUE_CONTACT_VERIFICATION_EXPIRED_URI"`
+
+	QueueRelationshipExpiredName string `envDefault:"relationships.expired"               env:"QUEUE_RELATIONSHIP_EXPIRED_NAME"`
+	QueueRelationshipExpiredURI  string `envDefault:"mem://default.relationships.expired" env:"QUEUE_RELATIONSHIP_EXPIRED_URI"`
+
+	RelationshipExpiryIntervalInSec int `envDefault:"3600" env:"RELATIONSHIP_EXPIRY_INTERVAL_IN_SEC"`
+
+	UnmergeWindowInSec int `envDefault:"2592000" env:"UNMERGE_WINDOW_IN_SEC"`
+
+	DuplicateDetectionIntervalInSec int `envDefault:"86400" env:"DUPLICATE_DETECTION_INTERVAL_IN_SEC"`
+
+	// How often pending profile exports are picked up, and after how long a
+	// running export is considered abandoned and picked up again.
+	ProfileExportIntervalInSec int `envDefault:"30"  env:"PROFILE_EXPORT_INTERVAL_IN_SEC"`
+	ProfileExportTimeoutInSec  int `envDefault:"900" env:"PROFILE_EXPORT_TIMEOUT_IN_SEC"`
+
+	PropertyCompactionIntervalInSec int `envDefault:"86400"   env:"PROPERTY_COMPACTION_INTERVAL_IN_SEC"`
+	PropertyRetentionInSec          int `envDefault:"7776000" env:"PROPERTY_RETENTION_IN_SEC"`
+	PropertyCompactionMinEntries    int `envDefault:"100"     env:"PROPERTY_COMPACTION_MIN_ENTRIES"`
+
 	LengthOfVerificationCode       int `envDefault:"6"     env:"LENGTH_OF_VERIFICATION_CODE"`
 	VerificationPinExpiryTimeInSec int `envDefault:"86400" env:"VERIFICATION_PIN_EXPIRY_TIME_IN_SEC"`
 
+	// Attempts allowed per verification, and failed attempts allowed per
+	// contact or per IP address/device within VerificationThrottleWindowInSec.
+	// Zero disables a limit.
+	VerificationMaxAttempts         int `envDefault:"5"    env:"VERIFICATION_MAX_ATTEMPTS"`
+	VerificationMaxContactAttempts  int `envDefault:"10"   env:"VERIFICATION_MAX_CONTACT_ATTEMPTS"`
+	VerificationMaxSourceAttempts   int `envDefault:"30"   env:"VERIFICATION_MAX_SOURCE_ATTEMPTS"`
+	VerificationThrottleWindowInSec int `envDefault:"3600" env:"VERIFICATION_THROTTLE_WINDOW_IN_SEC"`
+
+	// TrustedProxyHops is how many proxies in front of the service append to
+	// X-Forwarded-For. The client address is read from the entry the
+	// outermost of them added; with none the connection's address is used.
+	TrustedProxyHops int `envDefault:"0" env:"TRUSTED_PROXY_HOPS"`
+
+	// How long a contact stays verified, per contact type as TYPE:seconds
+	// pairs. Types left out stay verified indefinitely.
+	ContactVerificationValidityInSec map[string]int `envDefault:"MSISDN:31536000" env:"CONTACT_VERIFICATION_VALIDITY_IN_SEC"`
+	VerificationExpiryIntervalInSec  int            `envDefault:"3600"            env:"VERIFICATION_EXPIRY_INTERVAL_IN_SEC"`
+
 	MessageTemplateContactVerification string `envDefault:"template.profilev1.contact.verification" env:"MESSAGE_TEMPLATE_CONTACT_VERIFICATION"`
 
+	// Email contacts of these tenants are verified by link unless a request
+	// asks for a code. VerificationLinkTemplate is the link sent, with
+	// {token} replaced by the verification token signed with
+	// VerificationTokenSigningKey, which must be set to use link mode.
+	VerificationLinkTenants                []string `envDefault:""                                             env:"VERIFICATION_LINK_TENANTS"`
+	VerificationLinkTemplate               string   `envDefault:""                                             env:"VERIFICATION_LINK_TEMPLATE"`
+	VerificationTokenSigningKey            string   `envDefault:""                                             env:"VERIFICATION_TOKEN_SIGNING_KEY"`
+	MessageTemplateContactVerificationLink string   `envDefault:"template.profilev1.contact.verification.link" env:"MESSAGE_TEMPLATE_CONTACT_VERIFICATION_LINK"`
+
 	AuditServiceURI string `envDefault:"" env:"AUDIT_SERVICE_URI"`
 }
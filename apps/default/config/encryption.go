@@ -1,9 +1,140 @@
 package config
 
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pitabwire/util"
+)
+
+// Contact encryption modes.
+const (
+	// EncryptionModeStatic encrypts contact details with the DEK_* keys.
+	EncryptionModeStatic = "static"
+	// EncryptionModeEnvelope encrypts contact details with per-tenant data
+	// keys wrapped by a master key backend.
+	EncryptionModeEnvelope = "envelope"
+)
+
+// ErrKeyShredded is returned for details encrypted with a shredded key; they
+// can no longer be decrypted.
+var ErrKeyShredded = errors.New("encryption key was shredded")
+
+// KeyProvider resolves the keys contact details are encrypted with.
+type KeyProvider interface {
+	// EncryptionKey returns the id and data of the key new details of
+	// tenantID are encrypted with.
+	EncryptionKey(ctx context.Context, tenantID string) (string, []byte, error)
+	// DecryptionKey returns the data of the key with keyID.
+	DecryptionKey(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// KeyShredder is a KeyProvider able to destroy the keys of a tenant.
+type KeyShredder interface {
+	// ShredTenant destroys every key of tenantID, returning how many were
+	// shredded. Details they encrypted then fail with ErrKeyShredded.
+	ShredTenant(ctx context.Context, tenantID string) (int64, error)
+}
+
+// DEK holds the statically configured keys. It is itself a KeyProvider,
+// encrypting with Key unless Provider is set, and decrypting with any
+// static key before asking Provider.
 type DEK struct {
 	KeyID     string
 	Key       []byte
 	OldKeyID  string
 	OldKey    []byte
 	LookUpKey []byte
+
+	// OldKeys holds keys retired before OldKeyID, by key id.
+	OldKeys map[string][]byte
+
+	// LookUpKeyID identifies LookUpKey. OldLookUpKey, while set and not
+	// retired, still matches tokens computed before LookUpKey was rotated in.
+	LookUpKeyID         string
+	OldLookUpKeyID      string
+	OldLookUpKey        []byte
+	OldLookUpKeyRetired bool
+
+	// Provider supplies envelope encryption data keys, nil in static mode.
+	Provider KeyProvider
+}
+
+func (d *DEK) EncryptionKey(ctx context.Context, tenantID string) (string, []byte, error) {
+	if d.Provider != nil {
+		return d.Provider.EncryptionKey(ctx, tenantID)
+	}
+	return d.KeyID, d.Key, nil
+}
+
+func (d *DEK) DecryptionKey(ctx context.Context, keyID string) ([]byte, error) {
+	if key, ok := d.KeyFor(keyID); ok {
+		return key, nil
+	}
+	if d.Provider != nil {
+		return d.Provider.DecryptionKey(ctx, keyID)
+	}
+	return nil, fmt.Errorf("no decryption key for key id %q", keyID)
+}
+
+// ActiveKeyID identifies what contacts are being moved onto: Key, or
+// EncryptionModeEnvelope when data keys are in use.
+func (d *DEK) ActiveKeyID() string {
+	if d.Provider != nil {
+		return EncryptionModeEnvelope
+	}
+	return d.KeyID
+}
+
+// KeyFor returns the key data for keyID from the active, old or historic keys.
+func (d *DEK) KeyFor(keyID string) ([]byte, bool) {
+	switch {
+	case keyID == "":
+		return nil, false
+	case keyID == d.KeyID:
+		return d.Key, true
+	case keyID == d.OldKeyID && len(d.OldKey) > 0:
+		return d.OldKey, true
+	}
+
+	key, ok := d.OldKeys[keyID]
+	return key, ok && len(key) > 0
+}
+
+// RetiredKeyIDs lists the ids of the static keys contacts should be moved
+// off: all but Key, and Key too once Provider supplies data keys.
+func (d *DEK) RetiredKeyIDs() []string {
+	var keyIDs []string
+	if d.Provider != nil && d.KeyID != "" {
+		keyIDs = append(keyIDs, d.KeyID)
+	}
+	if d.OldKeyID != "" && d.OldKeyID != d.KeyID {
+		keyIDs = append(keyIDs, d.OldKeyID)
+	}
+	for keyID := range d.OldKeys {
+		if keyID != d.KeyID && keyID != d.OldKeyID {
+			keyIDs = append(keyIDs, keyID)
+		}
+	}
+	return keyIDs
+}
+
+// LookUpKeys returns the active lookup key, then the old one while it is in use.
+func (d *DEK) LookUpKeys() [][]byte {
+	keys := [][]byte{d.LookUpKey}
+	if len(d.OldLookUpKey) > 0 && !d.OldLookUpKeyRetired {
+		keys = append(keys, d.OldLookUpKey)
+	}
+	return keys
+}
+
+// LookupTokens returns the lookup tokens of detail under each key of
+// LookUpKeys, so contacts are found whichever key their token was made with.
+func (d *DEK) LookupTokens(detail string) [][]byte {
+	var tokens [][]byte
+	for _, key := range d.LookUpKeys() {
+		tokens = append(tokens, util.ComputeLookupToken(key, detail))
+	}
+	return tokens
 }
@@ -0,0 +1,9 @@
+-- Contacts now record when they were verified. Backfill it from the
+-- verification that verified them so validity periods can apply.
+UPDATE contacts c
+SET verified_at = v.verified_at
+FROM verifications v
+WHERE v.id = c.verification_id
+  AND c.verification_id <> ''
+  AND c.verified_at IS NULL
+  AND v.verified_at > '0001-01-02';
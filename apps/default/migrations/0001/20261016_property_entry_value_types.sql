@@ -0,0 +1,45 @@
+-- Best-effort typing of ledger entries written before values carried a type.
+-- Values that parse as JSON numbers, booleans, objects or lists are retyped;
+-- everything else, including Go formatted maps such as map[a:1], stays a string.
+-- Integers beyond 2^53 stay strings too, as they would lose precision when
+-- read back as a float. pg_input_is_valid only exists from PostgreSQL 16, so
+-- JSON is checked by trying the cast.
+CREATE FUNCTION pg_temp.is_jsonb(value text)
+    RETURNS boolean AS $$
+BEGIN
+    PERFORM value::jsonb;
+    RETURN TRUE;
+EXCEPTION
+    WHEN others THEN
+        RETURN FALSE;
+END;
+$$ LANGUAGE plpgsql;
+
+UPDATE property_entries
+SET value_type = CASE
+        WHEN value ~ '^-?(0|[1-9][0-9]*)$' AND abs(value::numeric) > 9007199254740992 THEN 'string'
+        WHEN value ~ '^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$' THEN 'number'
+        WHEN value IN ('true', 'false') THEN 'bool'
+        WHEN left(value, 1) = '{' AND pg_temp.is_jsonb(value) THEN 'object'
+        WHEN left(value, 1) = '[' AND pg_temp.is_jsonb(value) THEN 'list'
+        ELSE 'string'
+    END
+WHERE value_type = 'string';
+
+-- Rebuild the properties cache of profiles whose latest global entries gained a type
+WITH latest AS (
+    SELECT DISTINCT ON (profile_id, key) profile_id, key, value, value_type
+    FROM property_entries
+    WHERE scoped = FALSE AND deleted_at IS NULL
+    ORDER BY profile_id, key, created_at DESC
+), typed AS (
+    SELECT profile_id,
+           jsonb_object_agg(key, CASE WHEN value_type = 'string' THEN to_jsonb(value) ELSE value::jsonb END) AS properties
+    FROM latest
+    WHERE profile_id IN (SELECT profile_id FROM latest WHERE value_type <> 'string')
+    GROUP BY profile_id
+)
+UPDATE profiles p
+SET properties = COALESCE(p.properties, '{}'::jsonb) || typed.properties
+FROM typed
+WHERE p.id = typed.profile_id;
@@ -0,0 +1,4 @@
+-- One live schema per property key within a tenant
+CREATE UNIQUE INDEX IF NOT EXISTS idx_property_schema_tenant_key
+    ON property_schemas (tenant_id, key)
+    WHERE deleted_at IS NULL;
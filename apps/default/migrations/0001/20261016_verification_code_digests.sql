@@ -0,0 +1,13 @@
+-- Verification codes are now stored as HMAC digests prefixed 'hmac-sha256:'.
+-- Digests need the lookup key, so verifications still in flight keep their
+-- plaintext code, which is compared in constant time until they expire. Codes
+-- of verifications that can no longer be used, and codes tried by past
+-- attempts, are dropped.
+UPDATE verifications
+SET code = ''
+WHERE code NOT LIKE 'hmac-sha256:%'
+  AND (expires_at < now() OR verified_at > '0001-01-02');
+
+UPDATE verification_attempts
+SET data = ''
+WHERE data NOT LIKE 'hmac-sha256:%';
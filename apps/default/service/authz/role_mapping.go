@@ -13,9 +13,13 @@ const (
 	PermissionProfileCreate       = "profile_create"
 	PermissionProfileUpdate       = "profile_update"
 	PermissionProfilesMerge       = "profile_merge"
+	PermissionProfileErase        = "profile_erase"
+	PermissionProfileExport       = "profile_export"
 	PermissionContactsManage      = "contact_manage"
 	PermissionRosterManage        = "roster_manage"
 	PermissionRelationshipsManage = "relationship_manage"
+	PermissionSchemasManage       = "schema_manage"
+	PermissionTenantShred         = "tenant_shred"
 )
 
 const (
@@ -38,13 +42,13 @@ func RolePermissions() map[string][]string {
 	return map[string][]string{
 		RoleOwner: {
 			PermissionProfileView, PermissionProfileCreate, PermissionProfileUpdate,
-			PermissionProfilesMerge, PermissionContactsManage, PermissionRosterManage,
-			PermissionRelationshipsManage,
+			PermissionProfilesMerge, PermissionProfileErase, PermissionProfileExport, PermissionContactsManage,
+			PermissionRosterManage, PermissionRelationshipsManage, PermissionSchemasManage, PermissionTenantShred,
 		},
 		RoleAdmin: {
 			PermissionProfileView, PermissionProfileCreate, PermissionProfileUpdate,
-			PermissionProfilesMerge, PermissionContactsManage, PermissionRosterManage,
-			PermissionRelationshipsManage,
+			PermissionProfilesMerge, PermissionProfileErase, PermissionProfileExport, PermissionContactsManage,
+			PermissionRosterManage, PermissionRelationshipsManage, PermissionSchemasManage,
 		},
 		RoleOperator: {
 			PermissionProfileView, PermissionProfileCreate, PermissionProfileUpdate,
@@ -58,8 +62,8 @@ func RolePermissions() map[string][]string {
 		},
 		RoleService: {
 			PermissionProfileView, PermissionProfileCreate, PermissionProfileUpdate,
-			PermissionProfilesMerge, PermissionContactsManage, PermissionRosterManage,
-			PermissionRelationshipsManage,
+			PermissionProfilesMerge, PermissionProfileErase, PermissionProfileExport, PermissionContactsManage,
+			PermissionRosterManage, PermissionRelationshipsManage, PermissionSchemasManage, PermissionTenantShred,
 		},
 	}
 }
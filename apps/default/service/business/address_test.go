@@ -67,6 +67,9 @@ func (ats *AddressTestSuite) getProfileBusiness(
 	addressRepo := repository.NewAddressRepository(ctx, dbPool, workMan)
 	addressBusiness := business.NewAddressBusiness(ctx, addressRepo)
 
+	schemaRepo := repository.NewPropertySchemaRepository(ctx, dbPool, workMan)
+	schemaBusiness := business.NewPropertySchemaBusiness(ctx, schemaRepo)
+
 	profileRepo := repository.NewProfileRepository(ctx, dbPool, workMan)
 	propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, workMan)
 	return business.NewProfileBusiness(
@@ -76,6 +79,7 @@ func (ats *AddressTestSuite) getProfileBusiness(
 		evtsMan,
 		contactBusiness,
 		addressBusiness,
+		schemaBusiness,
 		profileRepo,
 		propertyEntryRepo,
 	), addressRepo
@@ -0,0 +1,195 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/ttacon/libphonenumber"
+)
+
+// Contact types beyond those of the API. Their details carry a scheme
+// prefix, as in "whatsapp:+256757546244" or "push:fcm:<token>".
+const (
+	ContactTypeWhatsApp = "WHATSAPP"
+	ContactTypeTelegram = "TELEGRAM"
+	ContactTypePush     = "PUSH"
+	ContactTypePostal   = "POSTAL"
+)
+
+const (
+	maxPushTokenLength     = 4096
+	maxPostalAddressLength = 512
+)
+
+var (
+	telegramUsernamePattern = regexp.MustCompile("^[a-z][a-z0-9_]{4,31}$")
+	pushProviderPattern     = regexp.MustCompile("^[a-z][a-z0-9_-]{1,31}$")
+)
+
+// ContactTypeHandler recognises and normalises the details of one contact
+// type.
+type ContactTypeHandler struct {
+	// Type is the contact type stored on contacts of this handler.
+	Type string
+	// Scheme prefixes the details of this type, followed by a colon. Details
+	// without a registered scheme are offered to the handlers without one.
+	Scheme string
+	// Normalize returns the canonical spelling of value, the detail less its
+	// scheme, and whether value is a detail of this type.
+	Normalize func(value string) (string, bool)
+	// Verifiable is set for types a verification code or link can be sent
+	// to. Contacts of other types are never verified.
+	Verifiable bool
+}
+
+// ContactTypeRegistry resolves contact details to their contact type.
+type ContactTypeRegistry struct {
+	mu       sync.RWMutex
+	handlers []ContactTypeHandler
+}
+
+// NewContactTypeRegistry returns a registry of handlers. Details without a
+// scheme are matched against the schemeless handlers in the order given.
+func NewContactTypeRegistry(handlers ...ContactTypeHandler) *ContactTypeRegistry {
+	registry := &ContactTypeRegistry{}
+	for _, handler := range handlers {
+		if err := registry.Register(handler); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+// Register adds handler, failing if its type or scheme is already taken.
+func (r *ContactTypeRegistry) Register(handler ContactTypeHandler) error {
+	if handler.Type == "" || handler.Normalize == nil {
+		return errors.New("contact type handler needs a type and a normalizer")
+	}
+	handler.Scheme = strings.ToLower(handler.Scheme)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.handlers {
+		if existing.Type == handler.Type {
+			return fmt.Errorf("contact type %s is already registered", handler.Type)
+		}
+		if handler.Scheme != "" && existing.Scheme == handler.Scheme {
+			return fmt.Errorf("contact scheme %s is already registered", handler.Scheme)
+		}
+	}
+	r.handlers = append(r.handlers, handler)
+	return nil
+}
+
+// Resolve returns the contact type of detail and its normalised spelling.
+func (r *ContactTypeRegistry) Resolve(detail string) (string, string, bool) {
+	detail = strings.TrimSpace(detail)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if scheme, value, found := strings.Cut(detail, ":"); found {
+		scheme = strings.ToLower(scheme)
+		for _, handler := range r.handlers {
+			if handler.Scheme != "" && handler.Scheme == scheme {
+				normalized, ok := handler.Normalize(strings.TrimSpace(value))
+				if !ok {
+					return "", "", false
+				}
+				return handler.Type, scheme + ":" + normalized, true
+			}
+		}
+	}
+
+	for _, handler := range r.handlers {
+		if handler.Scheme != "" {
+			continue
+		}
+		if normalized, ok := handler.Normalize(detail); ok {
+			return handler.Type, normalized, true
+		}
+	}
+	return "", "", false
+}
+
+// Verifiable reports whether contacts of contactType can be verified.
+func (r *ContactTypeRegistry) Verifiable(contactType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, handler := range r.handlers {
+		if handler.Type == contactType {
+			return handler.Verifiable
+		}
+	}
+	return false
+}
+
+// ContactTypes is the registry ContactTypeFromDetail and Normalize consult.
+// Further contact types are added with ContactTypes.Register at start up.
+//
+//nolint:gochecknoglobals // registry of the process, extended at start up
+var ContactTypes = NewContactTypeRegistry(
+	ContactTypeHandler{Type: profilev1.ContactType_EMAIL.String(), Normalize: normalizeEmail, Verifiable: true},
+	ContactTypeHandler{Type: profilev1.ContactType_MSISDN.String(), Normalize: normalizePhoneNumber, Verifiable: true},
+	ContactTypeHandler{Type: ContactTypeWhatsApp, Scheme: "whatsapp", Normalize: normalizePhoneNumber, Verifiable: true},
+	ContactTypeHandler{Type: ContactTypeTelegram, Scheme: "telegram", Normalize: normalizeTelegram},
+	ContactTypeHandler{Type: ContactTypePush, Scheme: "push", Normalize: normalizePushAddress},
+	ContactTypeHandler{Type: ContactTypePostal, Scheme: "postal", Normalize: normalizePostalAddress},
+)
+
+func normalizeEmail(value string) (string, bool) {
+	value = strings.ToLower(value)
+	return value, EmailPattern.MatchString(value)
+}
+
+// normalizePhoneNumber accepts international numbers, returning them in
+// E.164.
+func normalizePhoneNumber(value string) (string, bool) {
+	number, err := libphonenumber.Parse(value, "")
+	if err != nil || !libphonenumber.IsValidNumber(number) {
+		return "", false
+	}
+	return libphonenumber.Format(number, libphonenumber.E164), true
+}
+
+// normalizeTelegram accepts a username, with or without its "@", or the
+// number of the account.
+func normalizeTelegram(value string) (string, bool) {
+	if number, ok := normalizePhoneNumber(value); ok {
+		return number, true
+	}
+	username := strings.ToLower(strings.TrimPrefix(value, "@"))
+	if !telegramUsernamePattern.MatchString(username) {
+		return "", false
+	}
+	return "@" + username, true
+}
+
+// normalizePushAddress accepts "<provider>:<token>", as in "fcm:<token>".
+// Tokens are opaque, so only the provider is lower-cased.
+func normalizePushAddress(value string) (string, bool) {
+	provider, token, found := strings.Cut(value, ":")
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	token = strings.TrimSpace(token)
+	if !found || !pushProviderPattern.MatchString(provider) ||
+		token == "" || len(token) > maxPushTokenLength || strings.ContainsFunc(token, unicode.IsSpace) {
+		return "", false
+	}
+	return provider + ":" + token, true
+}
+
+// normalizePostalAddress accepts a free-form address, collapsing its
+// whitespace and line breaks to single spaces.
+func normalizePostalAddress(value string) (string, bool) {
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" || len(value) > maxPostalAddressLength {
+		return "", false
+	}
+	return value, true
+}
@@ -0,0 +1,82 @@
+package business_test
+
+import (
+	"context"
+	"testing"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+)
+
+func TestNormalize(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := map[string]string{
+		" Test@Example.com ":               "test@example.com",
+		"+1 202 555 1234":                  "+12025551234",
+		"+44 (0) 20 7123 4567":             "+442071234567",
+		"WhatsApp:+256 757-546244":         "whatsapp:+256757546244",
+		"telegram:@Profile_Tester":         "telegram:@profile_tester",
+		"telegram:+254 701 234567":         "telegram:+254701234567",
+		"PUSH:FCM:dGVzdC1Ub2tlbg":          "push:fcm:dGVzdC1Ub2tlbg",
+		"postal:Plot 1,\n  Kampala Road  ": "postal:Plot 1, Kampala Road",
+		// Details of no known type are left lower-cased and trimmed
+		" Random-Text ": "random-text",
+	}
+	for detail, want := range testCases {
+		require.Equalf(t, want, business.Normalize(ctx, detail), "Normalize(%q)", detail)
+	}
+}
+
+func TestContactTypeRegistry(t *testing.T) {
+	registry := business.NewContactTypeRegistry(
+		business.ContactTypeHandler{
+			Type:      profilev1.ContactType_EMAIL.String(),
+			Normalize: func(value string) (string, bool) { return value, value != "" },
+		},
+	)
+
+	signal := business.ContactTypeHandler{
+		Type:      "SIGNAL",
+		Scheme:    "Signal",
+		Normalize: func(value string) (string, bool) { return value, len(value) > 3 },
+	}
+	require.NoError(t, registry.Register(signal))
+	require.Error(t, registry.Register(signal))
+	require.Error(t, registry.Register(business.ContactTypeHandler{
+		Type: "OTHER", Scheme: "signal", Normalize: signal.Normalize,
+	}))
+	require.Error(t, registry.Register(business.ContactTypeHandler{Type: "NONE"}))
+
+	contactType, normalized, ok := registry.Resolve("SIGNAL:user.01")
+	require.True(t, ok)
+	require.Equal(t, "SIGNAL", contactType)
+	require.Equal(t, "signal:user.01", normalized)
+
+	// A registered scheme is never offered to the schemeless handlers
+	_, _, ok = registry.Resolve("signal:abc")
+	require.False(t, ok)
+
+	contactType, normalized, ok = registry.Resolve(" unprefixed ")
+	require.True(t, ok)
+	require.Equal(t, profilev1.ContactType_EMAIL.String(), contactType)
+	require.Equal(t, "unprefixed", normalized)
+
+	require.False(t, registry.Verifiable("SIGNAL"))
+	require.False(t, registry.Verifiable("UNKNOWN"))
+}
+
+func TestContactTypes_Verifiable(t *testing.T) {
+	for contactType, verifiable := range map[string]bool{
+		profilev1.ContactType_EMAIL.String():  true,
+		profilev1.ContactType_MSISDN.String(): true,
+		business.ContactTypeWhatsApp:          true,
+		business.ContactTypeTelegram:          false,
+		business.ContactTypePush:              false,
+		business.ContactTypePostal:            false,
+	} {
+		require.Equalf(t, verifiable, business.ContactTypes.Verifiable(contactType), "Verifiable(%s)", contactType)
+	}
+}
@@ -3,7 +3,9 @@ package business
 import (
 	"context"
 	"errors"
+	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -11,8 +13,8 @@ import (
 	"connectrpc.com/connect"
 	"github.com/pitabwire/frame/v2/data"
 	frevents "github.com/pitabwire/frame/v2/events"
+	"github.com/pitabwire/frame/v2/security"
 	"github.com/pitabwire/util"
-	"github.com/ttacon/libphonenumber"
 
 	"github.com/antinvestor/service-profile/apps/default/config"
 	"github.com/antinvestor/service-profile/apps/default/service/events"
@@ -40,15 +42,41 @@ type ContactBusiness interface {
 	) (*models.Contact, error)
 	LinkToProfile(ctx context.Context, contact *models.Contact, profileID string) (*models.Contact, error)
 	RemoveContact(ctx context.Context, contactID, profileID string) (*models.Contact, error)
+	// VerifyContact starts a verification of the contact in the given mode,
+	// or the tenant's default mode when it is empty.
 	VerifyContact(
 		ctx context.Context,
 		contact *models.Contact,
 		verificationID string,
-		code string,
+		code, mode string,
 		duration time.Duration,
 	) (*models.Verification, error)
 	GetVerification(ctx context.Context, verificationID string) (*models.Verification, error)
 	GetVerificationAttempts(ctx context.Context, verificationID string) ([]*models.VerificationAttempt, error)
+	// CountVerificationAttempt records that the verification's code is being
+	// checked and returns how many checks it has had, this one included.
+	CountVerificationAttempt(ctx context.Context, verificationID string) (int, error)
+	// RecordVerificationAttempt stores an attempt at a verification's code.
+	RecordVerificationAttempt(ctx context.Context, attempt *models.VerificationAttempt) error
+	// SetVerificationAttemptState changes the state of a recorded attempt.
+	SetVerificationAttemptState(ctx context.Context, attempt *models.VerificationAttempt, state string) error
+	// CountFailedVerificationAttempts counts failed, not blocked, attempts
+	// made since since against the contact, and from the device or IP address.
+	CountFailedVerificationAttempts(
+		ctx context.Context,
+		contactID, deviceID, ipAddress string,
+		since time.Time,
+	) (int, int, error)
+	// LockVerification invalidates a verification after too many attempts.
+	LockVerification(ctx context.Context, verification *models.Verification) error
+	// ConfirmVerification marks the verification and its contact verified.
+	// It succeeds once per verification.
+	ConfirmVerification(ctx context.Context, verification *models.Verification) error
+	// ShredTenant makes the contact details of tenantID permanently
+	// unreadable by destroying its data keys, and removes their lookup
+	// tokens. Only possible with envelope encryption, once no contact of the
+	// tenant remains on a static key.
+	ShredTenant(ctx context.Context, tenantID string) (*models.TenantShredReport, error)
 }
 
 func NewContactBusiness(_ context.Context, cfg *config.ProfileConfig, dek *config.DEK,
@@ -71,25 +99,43 @@ type contactBusiness struct {
 	verificationRepository repository.VerificationRepository
 }
 
-func ContactTypeFromDetail(ctx context.Context, detail string) (string, error) {
-	normalizedDetail := Normalize(ctx, detail)
-
-	if EmailPattern.MatchString(normalizedDetail) {
-		return profilev1.ContactType_EMAIL.String(), nil
+// ContactTypeFromDetail returns the type of detail, as resolved by the
+// handlers of ContactTypes.
+func ContactTypeFromDetail(_ context.Context, detail string) (string, error) {
+	contactType, _, ok := ContactTypes.Resolve(detail)
+	if !ok {
+		return "", connect.NewError(connect.CodeInvalidArgument, errors.New("contact details are invalid"))
 	}
+	return contactType, nil
+}
 
-	possibleNumber, err := libphonenumber.Parse(normalizedDetail, "")
-	if err == nil && libphonenumber.IsValidNumber(possibleNumber) {
-		return profilev1.ContactType_MSISDN.String(), nil
+// Normalize returns the canonical spelling of detail: E.164 for phone
+// numbers, lower case for emails and whatever the handler of its type
+// produces otherwise. Details of no known type are lower-cased and trimmed.
+func Normalize(_ context.Context, detail string) string {
+	if _, normalized, ok := ContactTypes.Resolve(detail); ok {
+		return normalized
 	}
-
-	return "", connect.NewError(connect.CodeInvalidArgument, errors.New("contact details are invalid"))
+	return legacyNormalize(detail)
 }
 
-func Normalize(_ context.Context, detail string) string {
+// legacyNormalize is how details were normalised before each contact type
+// had its own normaliser; contacts created then are stored this way.
+func legacyNormalize(detail string) string {
 	return strings.ToLower(strings.TrimSpace(detail))
 }
 
+// detailLookupTokens returns the lookup tokens a contact with detail may be
+// stored under, including those of its legacy spelling.
+func detailLookupTokens(ctx context.Context, dek *config.DEK, detail string) [][]byte {
+	normalizedDetail := Normalize(ctx, detail)
+	tokens := dek.LookupTokens(normalizedDetail)
+	if legacyDetail := legacyNormalize(detail); legacyDetail != normalizedDetail {
+		tokens = append(tokens, dek.LookupTokens(legacyDetail)...)
+	}
+	return tokens
+}
+
 func (cb *contactBusiness) GetByID(ctx context.Context, contactID string) (*models.Contact, error) {
 	contact, err := cb.contactRepository.GetByID(ctx, contactID)
 	if err != nil {
@@ -102,10 +148,7 @@ func (cb *contactBusiness) GetByDetail(ctx context.Context, detailList ...string
 	var lookUpTokenList [][]byte
 
 	for _, detail := range detailList {
-		normalizedDetail := Normalize(ctx, detail)
-
-		token := util.ComputeLookupToken(cb.dek.LookUpKey, normalizedDetail)
-		lookUpTokenList = append(lookUpTokenList, token)
+		lookUpTokenList = append(lookUpTokenList, detailLookupTokens(ctx, cb.dek, detail)...)
 	}
 	contact, err := cb.contactRepository.GetByLookupToken(ctx, lookUpTokenList...)
 	if err != nil {
@@ -114,7 +157,8 @@ func (cb *contactBusiness) GetByDetail(ctx context.Context, detailList ...string
 	return contact, nil
 }
 
-// GetByDetailMap returns a map of detail to contact for efficient bulk lookups.
+// GetByDetailMap returns a map of detail, as given, to contact for efficient
+// bulk lookups.
 func (cb *contactBusiness) GetByDetailMap(
 	ctx context.Context,
 	detailList ...string,
@@ -124,16 +168,20 @@ func (cb *contactBusiness) GetByDetailMap(
 		return nil, err
 	}
 
-	// Create map for efficient O(1) lookups
-	contactMap := make(map[string]*models.Contact)
+	byToken := make(map[string]*models.Contact, len(contacts))
 	for _, contact := range contacts {
-		// Decrypt the detail to use as map key
-		detail, decryptErr := contact.DecryptDetail(cb.dek.KeyID, cb.dek.Key)
-		if decryptErr != nil {
-			// Skip contacts that can't be decrypted
-			continue
+		byToken[string(contact.LookUpToken)] = contact
+	}
+
+	// Key by the details asked for, which may be spelled unlike the stored ones
+	contactMap := make(map[string]*models.Contact)
+	for _, detail := range detailList {
+		for _, token := range detailLookupTokens(ctx, cb.dek, detail) {
+			if contact, found := byToken[string(token)]; found {
+				contactMap[detail] = contact
+				break
+			}
 		}
-		contactMap[detail] = contact
 	}
 
 	return contactMap, nil
@@ -207,16 +255,22 @@ func (cb *contactBusiness) CreateContact(
 
 	lookupToken := util.ComputeLookupToken(cb.dek.LookUpKey, normalizedDetail)
 
-	encryptedDetail, err := util.EncryptValue(cb.dek.Key, []byte(normalizedDetail))
+	contact := &models.Contact{
+		LookUpToken: lookupToken,
+		LookUpKeyID: cb.dek.LookUpKeyID,
+		ContactType: contactType,
+	}
+	// Tenancy is needed up front to pick the tenant's encryption key
+	contact.GenID(ctx)
+
+	keyID, key, err := cb.dek.EncryptionKey(ctx, contact.TenantID)
 	if err != nil {
 		return nil, err
 	}
-
-	contact := &models.Contact{
-		EncryptedDetail: encryptedDetail,
-		EncryptionKeyID: cb.cfg.DEKActiveKeyID,
-		LookUpToken:     lookupToken,
-		ContactType:     contactType,
+	contact.EncryptionKeyID = keyID
+	contact.EncryptedDetail, err = util.EncryptValue(key, []byte(normalizedDetail))
+	if err != nil {
+		return nil, err
 	}
 
 	contact.Properties = contact.Properties.Update(extra)
@@ -255,30 +309,43 @@ func (cb *contactBusiness) VerifyContact(
 	ctx context.Context,
 	contact *models.Contact,
 	verificationID string,
-	code string,
+	code, mode string,
 	durationToExpiry time.Duration,
 ) (*models.Verification, error) {
 	if contact == nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("no contact specified"))
 	}
+	if !ContactTypes.Verifiable(contact.ContactType) {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("contacts of type %s can't be verified", contact.ContactType))
+	}
 
 	logger := util.Log(ctx).WithField("contact_id", contact.GetID())
 
+	mode, err := cb.verificationMode(ctx, contact, mode)
+	if err != nil {
+		return nil, err
+	}
+
 	if durationToExpiry == 0 {
-		durationToExpiry = time.Duration(cb.cfg.VerificationPinExpiryTimeInSec)
+		durationToExpiry = time.Duration(cb.cfg.VerificationPinExpiryTimeInSec) * time.Second
 	}
 
 	expiryTime := time.Now().Add(durationToExpiry)
 
 	if code == "" {
-		code = util.RandomNumericString(cb.cfg.LengthOfVerificationCode)
+		if mode == models.VerificationModeLink {
+			code = util.RandomAlphaNumericString(models.VerificationTokenCodeLength)
+		} else {
+			code = util.RandomNumericString(cb.cfg.LengthOfVerificationCode)
+		}
 	}
 
 	verification := &models.Verification{
 		ProfileID: contact.ProfileID,
 		ContactID: contact.ID,
-		Code:      code,
 		ExpiresAt: expiryTime,
+		Mode:      mode,
 	}
 
 	verification.GenID(ctx)
@@ -287,7 +354,12 @@ func (cb *contactBusiness) VerifyContact(
 		verification.ID = verificationID
 	}
 
-	err := cb.eventsMan.Emit(ctx, events.VerificationEventHandlerName, verification)
+	err = verification.SealCode(cb.dek, code)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cb.eventsMan.Emit(ctx, events.VerificationEventHandlerName, verification)
 	if err != nil {
 		logger.WithError(err).Error("could not emit verification attempt event")
 	}
@@ -295,6 +367,35 @@ func (cb *contactBusiness) VerifyContact(
 	return verification, nil
 }
 
+// verificationMode resolves the mode a contact is verified in. Email contacts
+// of tenants listed in VerificationLinkTenants default to link mode.
+func (cb *contactBusiness) verificationMode(ctx context.Context, contact *models.Contact, mode string) (string, error) {
+	isEmail := contact.ContactType == profilev1.ContactType_EMAIL.String()
+
+	switch mode {
+	case models.VerificationModeCode:
+		return mode, nil
+	case models.VerificationModeLink:
+		if !isEmail {
+			return "", connect.NewError(connect.CodeInvalidArgument,
+				errors.New("only email contacts can be verified by link"))
+		}
+		if cb.cfg.VerificationTokenSigningKey == "" {
+			return "", connect.NewError(connect.CodeFailedPrecondition, models.ErrNoTokenSigningKey)
+		}
+		return mode, nil
+	case "":
+		claims := security.ClaimsFromContext(ctx)
+		if isEmail && claims != nil && slices.Contains(cb.cfg.VerificationLinkTenants, claims.GetTenantID()) {
+			return models.VerificationModeLink, nil
+		}
+		return models.VerificationModeCode, nil
+	default:
+		return "", connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("unknown verification mode %q", mode))
+	}
+}
+
 func (cb *contactBusiness) GetVerificationAttempts(
 	ctx context.Context,
 	verificationID string,
@@ -305,3 +406,133 @@ func (cb *contactBusiness) GetVerificationAttempts(
 	}
 	return attempts, nil
 }
+
+func (cb *contactBusiness) CountVerificationAttempt(ctx context.Context, verificationID string) (int, error) {
+	attempts, err := cb.verificationRepository.IncrementAttempts(ctx, verificationID)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+	return attempts, nil
+}
+
+func (cb *contactBusiness) RecordVerificationAttempt(
+	ctx context.Context,
+	attempt *models.VerificationAttempt,
+) error {
+	if err := cb.verificationRepository.SaveAttempt(ctx, attempt); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+func (cb *contactBusiness) SetVerificationAttemptState(
+	ctx context.Context,
+	attempt *models.VerificationAttempt,
+	state string,
+) error {
+	if err := cb.verificationRepository.UpdateAttemptState(ctx, attempt.GetID(), state); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	attempt.State = state
+	return nil
+}
+
+func (cb *contactBusiness) CountFailedVerificationAttempts(
+	ctx context.Context,
+	contactID, deviceID, ipAddress string,
+	since time.Time,
+) (int, int, error) {
+	byContact, err := cb.verificationRepository.CountFailedAttemptsByContact(ctx, contactID, since)
+	if err != nil {
+		return 0, 0, err
+	}
+	bySource, err := cb.verificationRepository.CountFailedAttemptsBySource(ctx, deviceID, ipAddress, since)
+	if err != nil {
+		return 0, 0, err
+	}
+	return byContact, bySource, nil
+}
+
+func (cb *contactBusiness) LockVerification(ctx context.Context, verification *models.Verification) error {
+	if !verification.LockedAt.IsZero() {
+		return nil
+	}
+	verification.LockedAt = time.Now()
+	_, err := cb.verificationRepository.Update(ctx, verification, "locked_at")
+	return err
+}
+
+func (cb *contactBusiness) ConfirmVerification(ctx context.Context, verification *models.Verification) error {
+	verifiedAt := time.Now()
+	confirmed, err := cb.verificationRepository.MarkVerified(ctx, verification.GetID(), verifiedAt)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("the verification was already used"))
+	}
+
+	contact, err := cb.contactRepository.GetByID(ctx, verification.ContactID)
+	if err != nil {
+		return err
+	}
+	// Rosters only hear of a contact the first time it is verified
+	firstVerification := contact.VerifiedAt.IsZero()
+	contact.MarkVerified(verification.GetID(), verifiedAt, cb.cfg.ContactVerificationValidityInSec)
+	_, err = cb.contactRepository.Update(ctx, contact, "verification_id", "verified_at", "verification_expires_at")
+	if err != nil || contact.ProfileID == "" || !firstVerification {
+		return err
+	}
+
+	err = cb.eventsMan.Emit(ctx, events.RosterMatchEventHandlerName, &models.RosterMatch{
+		ContactID: contact.GetID(),
+		ProfileID: contact.ProfileID,
+		MatchedAt: verifiedAt,
+	})
+	if err != nil {
+		util.Log(ctx).WithField("contact_id", contact.GetID()).WithError(err).Error("could not emit roster match")
+	}
+	return nil
+}
+
+func (cb *contactBusiness) ShredTenant(ctx context.Context, tenantID string) (*models.TenantShredReport, error) {
+	if tenantID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("tenant id is required"))
+	}
+
+	shredder, ok := cb.dek.Provider.(config.KeyShredder)
+	if !ok {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("contact data can only be shredded with envelope encryption"))
+	}
+
+	// Static keys are shared by all tenants, so contacts still on them would
+	// survive the shredding.
+	onStaticKeys, err := cb.contactRepository.CountTenantOnKeys(ctx, tenantID, cb.dek.RetiredKeyIDs())
+	if err != nil {
+		return nil, err
+	}
+	if onStaticKeys > 0 {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf(
+			"%d contacts of the tenant are still on static keys, wait for the key rotation sweep", onStaticKeys))
+	}
+
+	report := &models.TenantShredReport{TenantID: tenantID}
+	report.DataKeys, err = shredder.ShredTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	report.LookupTokens, err = cb.contactRepository.RemoveLookupTokens(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	report.ShreddedAt = time.Now()
+
+	util.Log(ctx).WithFields(map[string]any{
+		"tenant_id":     tenantID,
+		"data_keys":     report.DataKeys,
+		"lookup_tokens": report.LookupTokens,
+	}).Info("tenant contact data shredded")
+	return report, nil
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"connectrpc.com/connect"
 	"github.com/pitabwire/frame/v2"
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/frame/v2/datastore"
+	frevents "github.com/pitabwire/frame/v2/events"
 	"github.com/pitabwire/frame/v2/frametests/definition"
 	"github.com/pitabwire/util"
 	"github.com/stretchr/testify/require"
@@ -18,6 +21,8 @@ import (
 
 	"github.com/antinvestor/service-profile/apps/default/config"
 	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/encryption"
+	"github.com/antinvestor/service-profile/apps/default/service/events"
 	"github.com/antinvestor/service-profile/apps/default/service/models"
 	"github.com/antinvestor/service-profile/apps/default/service/repository"
 	"github.com/antinvestor/service-profile/apps/default/tests"
@@ -581,22 +586,24 @@ func (cts *ContactTestSuite) Test_contactBusiness_VerifyContact() {
 		require.NoError(t, err)
 
 		// Verify contact
-		verification, err := cb.VerifyContact(ctx, updated, "", "123456", 0)
+		verification, err := cb.VerifyContact(ctx, updated, "", "123456", "", 0)
 		require.NoError(t, err)
 		require.NotNil(t, verification)
-		require.Equal(t, "123456", verification.Code)
+		dek := createContactTestDEK(svc.Config().(*config.ProfileConfig))
+		require.True(t, verification.CodeMatches(dek.LookUpKey, "123456"))
+		require.NotContains(t, verification.Code, "123456")
 		require.Equal(t, profileID, verification.ProfileID)
 		require.Equal(t, updated.GetID(), verification.ContactID)
 
 		// Test with custom verification ID
 		customVerificationID := util.IDString()
-		verification2, err := cb.VerifyContact(ctx, updated, customVerificationID, "654321", 0)
+		verification2, err := cb.VerifyContact(ctx, updated, customVerificationID, "654321", "", 0)
 		require.NoError(t, err)
 		require.NotNil(t, verification2)
 		require.Equal(t, customVerificationID, verification2.GetID())
 
 		// Test with nil contact
-		_, err = cb.VerifyContact(ctx, nil, "", "123456", 0)
+		_, err = cb.VerifyContact(ctx, nil, "", "123456", "", 0)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "no contact specified")
 	})
@@ -619,7 +626,7 @@ func (cts *ContactTestSuite) Test_contactBusiness_GetVerification() {
 		updated, err := cb.UpdateContact(ctx, contact.GetID(), profileID, data.JSONMap{})
 		require.NoError(t, err)
 
-		verification, err := cb.VerifyContact(ctx, updated, "", "123456", 0)
+		verification, err := cb.VerifyContact(ctx, updated, "", "123456", "", 0)
 		require.NoError(t, err)
 
 		result, cErr := tests.WaitForConditionWithResult(ctx, func() (*models.Verification, error) {
@@ -700,14 +707,14 @@ func (cts *ContactTestSuite) Test_contactBusiness_ToAPI() {
 		contact.GenID(ctx)
 
 		// Test ToAPI conversion
-		apiContact, err := contact.ToAPI(dek, false)
+		apiContact, err := contact.ToAPI(ctx, dek, false)
 		require.NoError(t, err)
 		require.NotNil(t, apiContact)
 		require.Equal(t, detailStr, apiContact.GetDetail())
 		require.Equal(t, contact.GetID(), apiContact.GetId())
 
 		// Test with partial flag
-		apiContact, err = contact.ToAPI(dek, true)
+		apiContact, err = contact.ToAPI(ctx, dek, true)
 		require.NoError(t, err)
 		require.NotNil(t, apiContact)
 	})
@@ -871,6 +878,44 @@ func (cts *ContactTestSuite) TestContactTypeFromDetail() {
 			wantErr: require.NoError,
 		},
 
+		// Scheme Prefixed Types
+		{
+			name:    "WhatsApp number",
+			args:    args{detail: "whatsapp:+256 757 546244"},
+			want:    business.ContactTypeWhatsApp,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "Telegram username",
+			args:    args{detail: "Telegram:@Profile_Tester"},
+			want:    business.ContactTypeTelegram,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "Push device token",
+			args:    args{detail: "push:fcm:dGVzdC10b2tlbg"},
+			want:    business.ContactTypePush,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "Postal address",
+			args:    args{detail: "postal:Plot 1, Kampala Road, Kampala"},
+			want:    business.ContactTypePostal,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "WhatsApp with invalid number",
+			args:    args{detail: "whatsapp:test@example.com"},
+			want:    "",
+			wantErr: require.Error,
+		},
+		{
+			name:    "Push without provider",
+			args:    args{detail: "push:dGVzdC10b2tlbg"},
+			want:    "",
+			wantErr: require.Error,
+		},
+
 		// Invalid Email Tests
 		{
 			name:    "Invalid email - missing @",
@@ -1011,3 +1056,126 @@ func (cts *ContactTestSuite) TestContactTypeFromDetail() {
 		})
 	}
 }
+
+func (cts *ContactTestSuite) Test_contactBusiness_ShredTenant() {
+	t := cts.T()
+
+	cts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := cts.CreateService(t, dep)
+
+		cfg := svc.Config().(*config.ProfileConfig)
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+		verificationRepo := repository.NewVerificationRepository(ctx, dbPool, workMan)
+
+		// Static keys are shared by tenants and can't be shredded
+		staticBusiness, _ := cts.getContactBusiness(ctx, svc)
+		_, err := staticBusiness.ShredTenant(ctx, "tenant-id")
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		keyring, err := encryption.NewKeyring("master-1", map[string][]byte{
+			"master-1": []byte("12345678901234567890123456789012"),
+		})
+		require.NoError(t, err)
+		dek := createContactTestDEK(cfg)
+		dek.Provider = encryption.NewEnvelopeKeyProvider(
+			keyring, repository.NewDataKeyRepository(ctx, dbPool, workMan))
+		cb := business.NewContactBusiness(ctx, cfg, dek, svc.EventsManager(), contactRepo, verificationRepo)
+
+		contact, err := cb.CreateContact(ctx, "shredded@example.com", data.JSONMap{})
+		require.NoError(t, err)
+
+		_, err = cb.ShredTenant(ctx, "")
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		report, err := cb.ShredTenant(ctx, contact.TenantID)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), report.DataKeys)
+		require.Equal(t, int64(1), report.LookupTokens)
+
+		// The detail no longer decrypts nor resolves to the contact
+		stored, err := cb.GetByID(ctx, contact.GetID())
+		require.NoError(t, err)
+		_, err = stored.Detail(ctx, dek)
+		require.ErrorIs(t, err, config.ErrKeyShredded)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		// Roster entries of the contact are left out rather than failing
+		rb := business.NewRosterBusiness(ctx, cfg, dek, cb,
+			repository.NewProfileRepository(ctx, dbPool, workMan),
+			repository.NewRosterRepository(ctx, dbPool, workMan))
+		rosters, err := rb.ToAPI(ctx, []*models.Roster{{ContactID: stored.GetID(), Contact: stored}})
+		require.NoError(t, err)
+		require.Empty(t, rosters)
+
+		found, err := cb.GetByDetail(ctx, "shredded@example.com")
+		require.NoError(t, err)
+		require.Empty(t, found)
+	})
+}
+
+// recordingEventsManager notes the events emitted before handing them on.
+type recordingEventsManager struct {
+	frevents.Manager
+
+	mu      sync.Mutex
+	emitted []string
+}
+
+func (m *recordingEventsManager) Emit(ctx context.Context, name string, payload any) error {
+	m.mu.Lock()
+	m.emitted = append(m.emitted, name)
+	m.mu.Unlock()
+	return m.Manager.Emit(ctx, name, payload)
+}
+
+func (m *recordingEventsManager) count(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, emitted := range m.emitted {
+		if emitted == name {
+			count++
+		}
+	}
+	return count
+}
+
+func (cts *ContactTestSuite) Test_contactBusiness_ConfirmVerification_MatchesRostersOnce() {
+	t := cts.T()
+
+	cts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := cts.CreateService(t, dep)
+
+		cfg := svc.Config().(*config.ProfileConfig)
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+		verificationRepo := repository.NewVerificationRepository(ctx, dbPool, workMan)
+		evtsMan := &recordingEventsManager{Manager: svc.EventsManager()}
+		cb := business.NewContactBusiness(ctx, cfg, createContactTestDEK(cfg), evtsMan, contactRepo, verificationRepo)
+
+		contact, err := cb.CreateContact(ctx, "matched.once@example.com", data.JSONMap{})
+		require.NoError(t, err)
+		_, err = cb.UpdateContact(ctx, contact.GetID(), util.IDString(), nil)
+		require.NoError(t, err)
+
+		confirm := func() {
+			verification, verifyErr := cb.VerifyContact(ctx, contact, "", "", "", 0)
+			require.NoError(t, verifyErr)
+			stored, waitErr := tests.WaitForConditionWithResult(ctx, func() (*models.Verification, error) {
+				return verificationRepo.GetByID(ctx, verification.GetID())
+			}, 5*time.Second, 100*time.Millisecond)
+			require.NoError(t, waitErr)
+			require.NoError(t, cb.ConfirmVerification(ctx, stored))
+		}
+
+		confirm()
+		require.Equal(t, 1, evtsMan.count(events.RosterMatchEventHandlerName))
+
+		// Verifying the contact again tells no roster anything new
+		confirm()
+		require.Equal(t, 1, evtsMan.count(events.RosterMatchEventHandlerName))
+	})
+}
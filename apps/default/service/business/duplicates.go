@@ -0,0 +1,271 @@
+package business
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/util"
+	"github.com/ttacon/libphonenumber"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Duplicate detection signals, recorded as DuplicateCandidate.Reasons keys.
+const (
+	DuplicateReasonSharedContact = "shared_contact"
+	DuplicateReasonSameName      = "same_name"
+	DuplicateReasonSharedAddress = "shared_address"
+)
+
+// Duplicate detection defaults.
+const (
+	duplicateNameProperty     = "au_name"
+	duplicateNameGroupLimit   = 20
+	duplicateContactBatchSize = 500
+	defaultDuplicateListCount = 100
+	defaultDuplicateInterval  = 24 * time.Hour
+)
+
+// duplicateSignalWeights is how much each signal adds to a pair's score.
+// Scores are capped at 1.
+//
+//nolint:gochecknoglobals // This is a lookup table that needs to be global
+var duplicateSignalWeights = map[string]float64{
+	DuplicateReasonSharedContact: 0.6,
+	DuplicateReasonSameName:      0.3,
+	DuplicateReasonSharedAddress: 0.2,
+}
+
+// DuplicateBusiness finds profiles that probably belong to the same person.
+type DuplicateBusiness interface {
+	// RunDetection rescans all profiles and replaces the stored candidates.
+	RunDetection(ctx context.Context) error
+	Scheduler
+	ListCandidates(ctx context.Context, minScore float64, count int) ([]*models.DuplicateCandidate, error)
+}
+
+func NewDuplicateBusiness(_ context.Context, cfg *config.ProfileConfig, dek *config.DEK,
+	contactRepo repository.ContactRepository,
+	duplicateRepo repository.DuplicateCandidateRepository) DuplicateBusiness {
+	return &duplicateBusiness{
+		cfg:           cfg,
+		dek:           dek,
+		contactRepo:   contactRepo,
+		duplicateRepo: duplicateRepo,
+	}
+}
+
+type duplicateBusiness struct {
+	cfg           *config.ProfileConfig
+	dek           *config.DEK
+	contactRepo   repository.ContactRepository
+	duplicateRepo repository.DuplicateCandidateRepository
+}
+
+// StartScheduler runs RunDetection immediately, then every
+// DuplicateDetectionIntervalInSec.
+func (dup *duplicateBusiness) StartScheduler(ctx context.Context, locker repository.JobLocker) {
+	runScheduled(ctx, locker, "duplicate_detection",
+		time.Duration(dup.cfg.DuplicateDetectionIntervalInSec)*time.Second, defaultDuplicateInterval,
+		dup.RunDetection)
+}
+
+func (dup *duplicateBusiness) RunDetection(ctx context.Context) error {
+	runStartedAt := time.Now()
+
+	scores := newDuplicateScores()
+
+	if err := dup.scanSharedContacts(ctx, scores); err != nil {
+		return err
+	}
+
+	namePairs, err := dup.duplicateRepo.PairsSharingName(ctx, duplicateNameProperty, duplicateNameGroupLimit)
+	if err != nil {
+		return err
+	}
+	for _, pair := range namePairs {
+		scores.add(*pair, DuplicateReasonSameName)
+	}
+
+	addressPairs, err := dup.duplicateRepo.PairsSharingAddress(ctx)
+	if err != nil {
+		return err
+	}
+	for _, pair := range addressPairs {
+		scores.add(*pair, DuplicateReasonSharedAddress)
+	}
+
+	candidates := scores.candidates(ctx)
+	if err = dup.duplicateRepo.Upsert(ctx, candidates); err != nil {
+		return err
+	}
+
+	if err = dup.duplicateRepo.DeleteStale(ctx, runStartedAt); err != nil {
+		return err
+	}
+
+	util.Log(ctx).WithField("candidates", len(candidates)).Info("duplicate detection run complete")
+	return nil
+}
+
+// scanSharedContacts pages through verified contacts and looks up the other
+// spellings of each detail. A different spelling of the same email or phone
+// number normalises to a different lookup token, so a second profile of the
+// same tenant may hold what is really the same contact. Only contacts whose
+// verification has not gone stale count, on either side.
+func (dup *duplicateBusiness) scanSharedContacts(ctx context.Context, scores *duplicateScores) error {
+	now := time.Now()
+	afterID := ""
+	for {
+		contacts, err := dup.contactRepo.ListVerified(ctx, afterID, duplicateContactBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(contacts) == 0 {
+			return nil
+		}
+		afterID = contacts[len(contacts)-1].GetID()
+
+		owners := make(map[string]*models.Contact)
+		var lookupTokens [][]byte
+		for _, contact := range contacts {
+			if !contact.VerificationFresh(now) {
+				continue
+			}
+			detail, decryptErr := contact.Detail(ctx, dup.dek)
+			if decryptErr != nil {
+				// Encrypted with a key no longer held; nothing to compare.
+				continue
+			}
+			for _, variant := range contactDetailVariants(contact.ContactType, detail) {
+				for _, token := range dup.dek.LookupTokens(variant) {
+					owners[string(token)] = contact
+					lookupTokens = append(lookupTokens, token)
+				}
+			}
+		}
+
+		if len(lookupTokens) == 0 {
+			continue
+		}
+
+		matches, err := dup.contactRepo.GetByLookupToken(ctx, lookupTokens...)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			owner, ok := owners[string(match.LookUpToken)]
+			if !ok || match.ProfileID == "" || match.ProfileID == owner.ProfileID ||
+				match.TenantID != owner.TenantID || match.PartitionID != owner.PartitionID ||
+				!match.VerificationFresh(now) {
+				continue
+			}
+			scores.add(models.ProfilePair{
+				TenantID:       owner.TenantID,
+				PartitionID:    owner.PartitionID,
+				ProfileID:      owner.ProfileID,
+				OtherProfileID: match.ProfileID,
+			}, DuplicateReasonSharedContact)
+		}
+	}
+}
+
+// contactDetailVariants returns other normalised spellings of detail that
+// reach the same mailbox or line.
+func contactDetailVariants(contactType, detail string) []string {
+	var variants []string
+
+	switch contactType {
+	case profilev1.ContactType_EMAIL.String():
+		local, domain, found := strings.Cut(detail, "@")
+		if !found {
+			return nil
+		}
+		if base, _, tagged := strings.Cut(local, "+"); tagged {
+			variants = append(variants, base+"@"+domain)
+		}
+	case profilev1.ContactType_MSISDN.String():
+		number, err := libphonenumber.Parse(detail, "")
+		if err != nil {
+			return nil
+		}
+		variants = append(variants,
+			libphonenumber.Format(number, libphonenumber.E164),
+			strings.ToLower(libphonenumber.Format(number, libphonenumber.INTERNATIONAL)),
+		)
+	}
+
+	result := variants[:0]
+	for _, variant := range variants {
+		if variant != detail {
+			result = append(result, variant)
+		}
+	}
+	return result
+}
+
+func (dup *duplicateBusiness) ListCandidates(
+	ctx context.Context,
+	minScore float64,
+	count int,
+) ([]*models.DuplicateCandidate, error) {
+	if count <= 0 {
+		count = defaultDuplicateListCount
+	}
+	candidates, err := dup.duplicateRepo.List(ctx, minScore, count)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return candidates, nil
+}
+
+// duplicateScores accumulates signals per ordered profile pair.
+type duplicateScores struct {
+	reasons map[models.ProfilePair]data.JSONMap
+}
+
+func newDuplicateScores() *duplicateScores {
+	return &duplicateScores{reasons: make(map[models.ProfilePair]data.JSONMap)}
+}
+
+func (ds *duplicateScores) add(pair models.ProfilePair, reason string) {
+	if pair.ProfileID == pair.OtherProfileID {
+		return
+	}
+	if pair.OtherProfileID < pair.ProfileID {
+		pair.ProfileID, pair.OtherProfileID = pair.OtherProfileID, pair.ProfileID
+	}
+
+	if ds.reasons[pair] == nil {
+		ds.reasons[pair] = data.JSONMap{}
+	}
+	count, _ := ds.reasons[pair][reason].(int)
+	ds.reasons[pair][reason] = count + 1
+}
+
+func (ds *duplicateScores) candidates(ctx context.Context) []*models.DuplicateCandidate {
+	candidates := make([]*models.DuplicateCandidate, 0, len(ds.reasons))
+	for pair, reasons := range ds.reasons {
+		score := 0.0
+		for reason := range reasons {
+			score += duplicateSignalWeights[reason]
+		}
+
+		candidate := &models.DuplicateCandidate{
+			ProfileID:      pair.ProfileID,
+			OtherProfileID: pair.OtherProfileID,
+			Score:          min(score, 1),
+			Reasons:        reasons,
+		}
+		candidate.TenantID = pair.TenantID
+		candidate.PartitionID = pair.PartitionID
+		candidate.GenID(ctx)
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
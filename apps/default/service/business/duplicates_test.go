@@ -0,0 +1,101 @@
+package business_test
+
+import (
+	"testing"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/frametests/definition"
+	"github.com/pitabwire/util"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+	"github.com/antinvestor/service-profile/apps/default/tests"
+)
+
+type DuplicateTestSuite struct {
+	tests.ProfileBaseTestSuite
+}
+
+func TestDuplicateSuite(t *testing.T) {
+	suite.Run(t, new(DuplicateTestSuite))
+}
+
+func (dts *DuplicateTestSuite) Test_duplicateBusiness_RunDetection_SameName() {
+	t := dts.T()
+
+	dts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := dts.CreateService(t, dep)
+
+		evtsMan := svc.EventsManager()
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		cfg := svc.Config().(*config.ProfileConfig)
+		dek := createProfileTestDEK(cfg)
+
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+		contactBusiness := business.NewContactBusiness(ctx, cfg, dek, evtsMan,
+			contactRepo, repository.NewVerificationRepository(ctx, dbPool, workMan))
+		addressBusiness := business.NewAddressBusiness(ctx, repository.NewAddressRepository(ctx, dbPool, workMan))
+		schemaBusiness := business.NewPropertySchemaBusiness(ctx,
+			repository.NewPropertySchemaRepository(ctx, dbPool, workMan))
+		pb := business.NewProfileBusiness(ctx, cfg, dek, evtsMan, contactBusiness, addressBusiness, schemaBusiness,
+			repository.NewProfileRepository(ctx, dbPool, workMan),
+			repository.NewPropertyEntryRepository(ctx, dbPool, workMan))
+
+		var profileIDs []string
+		for contact, name := range map[string]string{
+			"jane.doe@testing.com":   "Jane  Doe",
+			"j.doe@testing.com":      "jane doe",
+			"john.smith@testing.com": "John Smith",
+		} {
+			properties := data.JSONMap{"au_name": name}
+			profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+				Type:       profilev1.ProfileType_PERSON,
+				Contact:    contact,
+				Properties: properties.ToProtoStruct(),
+			})
+			require.NoError(t, err)
+			if name != "John Smith" {
+				profileIDs = append(profileIDs, profile.GetId())
+			}
+		}
+
+		// The same name in another tenant is not a duplicate
+		otherCtx := dts.WithAuthClaims(ctx, util.IDString(), util.IDString(), util.IDString())
+		otherName := data.JSONMap{"au_name": "Jane Doe"}
+		_, err := pb.CreateProfile(otherCtx, &profilev1.CreateRequest{
+			Type:       profilev1.ProfileType_PERSON,
+			Contact:    "jane.doe.other@testing.com",
+			Properties: otherName.ToProtoStruct(),
+		})
+		require.NoError(t, err)
+
+		db := business.NewDuplicateBusiness(ctx, cfg, dek, contactRepo,
+			repository.NewDuplicateCandidateRepository(ctx, dbPool, workMan))
+
+		require.NoError(t, db.RunDetection(ctx))
+
+		candidates, err := db.ListCandidates(ctx, 0, 0)
+		require.NoError(t, err)
+		require.Len(t, candidates, 1)
+		require.ElementsMatch(t, profileIDs,
+			[]string{candidates[0].ProfileID, candidates[0].OtherProfileID})
+		require.Contains(t, candidates[0].Reasons, business.DuplicateReasonSameName)
+
+		// Candidates are only listed to their own tenant
+		otherCandidates, err := db.ListCandidates(otherCtx, 0, 0)
+		require.NoError(t, err)
+		require.Empty(t, otherCandidates)
+
+		// Rerunning refreshes rather than duplicates candidates
+		require.NoError(t, db.RunDetection(ctx))
+		candidates, err = db.ListCandidates(ctx, 0, 0)
+		require.NoError(t, err)
+		require.Len(t, candidates, 1)
+	})
+}
@@ -0,0 +1,204 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/v2/data"
+	frevents "github.com/pitabwire/frame/v2/events"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/frame/v2/telemetry"
+	"github.com/pitabwire/util"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/events"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Key rotation sweep defaults.
+const (
+	keyRotationBatchSize         = 500
+	defaultKeyRotationSweepEvery = time.Hour
+
+	keyRotationMetricsName = "service_profile"
+)
+
+// KeyRotationBusiness moves contacts encrypted with retired keys onto the
+// active key.
+type KeyRotationBusiness interface {
+	// RunSweep queues every contact not on the active key for re-encryption
+	// by the contact.key.rotation.queue handler, checkpointing after each
+	// batch so an interrupted pass resumes where it stopped. The sweep is
+	// complete once no contact remains on a retired key.
+	RunSweep(ctx context.Context) (*models.KeyRotationSweep, error)
+	// Progress reports the sweep onto the active key, with current counts.
+	Progress(ctx context.Context) (*models.KeyRotationSweep, error)
+	Scheduler
+}
+
+func NewKeyRotationBusiness(_ context.Context, cfg *config.ProfileConfig, dek *config.DEK,
+	evtMan frevents.Manager, contactRepo repository.ContactRepository,
+	sweepRepo repository.KeyRotationSweepRepository) KeyRotationBusiness {
+	metrics := telemetry.NewBusinessMetrics(keyRotationMetricsName)
+	return &keyRotationBusiness{
+		cfg:         cfg,
+		dek:         dek,
+		eventsMan:   evtMan,
+		contactRepo: contactRepo,
+		sweepRepo:   sweepRepo,
+		queued: metrics.Counter(keyRotationMetricsName+"/key_rotation/queued",
+			"Number of contacts queued for re-encryption onto the active key"),
+		remaining: metrics.Gauge(keyRotationMetricsName+"/key_rotation/remaining",
+			"Number of contacts still encrypted with a retired key"),
+	}
+}
+
+type keyRotationBusiness struct {
+	cfg         *config.ProfileConfig
+	dek         *config.DEK
+	eventsMan   frevents.Manager
+	contactRepo repository.ContactRepository
+	sweepRepo   repository.KeyRotationSweepRepository
+
+	queued    telemetry.Counter
+	remaining telemetry.Gauge
+}
+
+// StartScheduler runs RunSweep immediately, then every
+// KeyRotationSweepIntervalInSec.
+func (krb *keyRotationBusiness) StartScheduler(ctx context.Context, locker repository.JobLocker) {
+	runScheduled(ctx, locker, "key_rotation_sweep",
+		time.Duration(krb.cfg.KeyRotationSweepIntervalInSec)*time.Second, defaultKeyRotationSweepEvery,
+		func(ctx context.Context) error {
+			_, err := krb.RunSweep(ctx)
+			return err
+		})
+}
+
+func (krb *keyRotationBusiness) RunSweep(ctx context.Context) (*models.KeyRotationSweep, error) {
+	log := util.Log(ctx).WithField("key_id", krb.dek.ActiveKeyID())
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+
+	sweep, err := krb.loadSweep(unscopedCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sweep.CompletedAt.IsZero() {
+		// A completed sweep restarts only if contacts reappear on a retired
+		// key, say after a backup restore.
+		err = krb.refreshRemaining(unscopedCtx, sweep)
+		if err != nil || sweep.Remaining == 0 {
+			return sweep, err
+		}
+		sweep.CompletedAt = time.Time{}
+	}
+
+	for {
+		var contactIDs []string
+		contactIDs, err = krb.contactRepo.ListIDsOnKeys(
+			unscopedCtx, krb.dek.RetiredKeyIDs(), sweep.LastContactID, keyRotationBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, contactID := range contactIDs {
+			err = krb.eventsMan.Emit(ctx, events.ContactKeyRotationEventHandlerName, &contactID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(contactIDs) > 0 {
+			sweep.LastContactID = contactIDs[len(contactIDs)-1]
+			sweep.Queued += int64(len(contactIDs))
+			krb.queued.Add(ctx, int64(len(contactIDs)), attribute.String("key_id", krb.dek.ActiveKeyID()))
+
+			// Checkpoint so a restart resumes after this batch
+			_, err = krb.sweepRepo.Update(unscopedCtx, sweep, "last_contact_id", "queued")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(contactIDs) < keyRotationBatchSize {
+			break
+		}
+	}
+
+	// The pass is done; the next one rescans contacts the queue missed.
+	sweep.LastContactID = ""
+	sweep.Passes++
+	err = krb.refreshRemaining(unscopedCtx, sweep)
+	if err != nil {
+		return nil, err
+	}
+	if sweep.Remaining == 0 {
+		sweep.CompletedAt = time.Now()
+	}
+
+	_, err = krb.sweepRepo.Update(unscopedCtx, sweep,
+		"last_contact_id", "passes", "remaining", "remaining_by_key", "completed_at")
+	if err != nil {
+		return nil, err
+	}
+
+	if sweep.CompletedAt.IsZero() {
+		log.WithFields(map[string]any{
+			"queued":    sweep.Queued,
+			"remaining": sweep.Remaining,
+		}).Info("key rotation sweep pass complete")
+	} else {
+		log.WithField("retired_key_ids", krb.dek.RetiredKeyIDs()).
+			Info("key rotation sweep complete, retired keys are no longer in use")
+	}
+	return sweep, nil
+}
+
+func (krb *keyRotationBusiness) Progress(ctx context.Context) (*models.KeyRotationSweep, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+
+	sweep, err := krb.loadSweep(unscopedCtx)
+	if err != nil {
+		return nil, err
+	}
+	return sweep, krb.refreshRemaining(unscopedCtx, sweep)
+}
+
+// loadSweep returns the sweep onto the active key, starting one if needed.
+func (krb *keyRotationBusiness) loadSweep(ctx context.Context) (*models.KeyRotationSweep, error) {
+	sweep, err := krb.sweepRepo.GetByTargetKeyID(ctx, krb.dek.ActiveKeyID())
+	if err == nil {
+		return sweep, nil
+	}
+	if !data.ErrorIsNoRows(err) {
+		return nil, err
+	}
+
+	sweep = &models.KeyRotationSweep{TargetKeyID: krb.dek.ActiveKeyID()}
+	err = krb.sweepRepo.Create(ctx, sweep)
+	if err != nil {
+		return nil, err
+	}
+	return sweep, nil
+}
+
+// refreshRemaining counts the contacts still on each retired key. Contacts
+// on keys the DEK does not know are left out, as they can't be moved.
+func (krb *keyRotationBusiness) refreshRemaining(ctx context.Context, sweep *models.KeyRotationSweep) error {
+	counts, err := krb.contactRepo.CountByKeyID(ctx)
+	if err != nil {
+		return err
+	}
+
+	sweep.Remaining = 0
+	sweep.RemainingByKey = data.JSONMap{}
+	for _, keyID := range krb.dek.RetiredKeyIDs() {
+		sweep.Remaining += counts[keyID]
+		sweep.RemainingByKey[keyID] = counts[keyID]
+		krb.remaining.Record(ctx, counts[keyID], attribute.String("key_id", keyID))
+	}
+	return nil
+}
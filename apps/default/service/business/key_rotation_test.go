@@ -0,0 +1,154 @@
+package business_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/frametests/definition"
+	"github.com/pitabwire/util"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+	"github.com/antinvestor/service-profile/apps/default/tests"
+)
+
+type KeyRotationTestSuite struct {
+	tests.ProfileBaseTestSuite
+}
+
+func TestKeyRotationSuite(t *testing.T) {
+	suite.Run(t, new(KeyRotationTestSuite))
+}
+
+func (krts *KeyRotationTestSuite) Test_keyRotationBusiness_RunSweep() {
+	t := krts.T()
+
+	krts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := krts.CreateService(t, dep)
+
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		cfg := svc.Config().(*config.ProfileConfig)
+		dek := createContactTestDEK(cfg)
+
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+		cb := business.NewContactBusiness(ctx, cfg, dek, svc.EventsManager(),
+			contactRepo, repository.NewVerificationRepository(ctx, dbPool, workMan))
+
+		details := map[string]string{}
+		for _, detail := range []string{"rotate.one@testing.com", "rotate.two@testing.com", "current@testing.com"} {
+			contact, err := cb.CreateContact(ctx, detail, data.JSONMap{})
+			require.NoError(t, err)
+			details[contact.GetID()] = detail
+			if detail == "current@testing.com" {
+				continue
+			}
+
+			// Written before the active key was rotated in
+			contact.EncryptedDetail, err = util.EncryptValue(dek.OldKey, []byte(detail))
+			require.NoError(t, err)
+			contact.EncryptionKeyID = dek.OldKeyID
+			_, err = contactRepo.Update(ctx, contact, "encrypted_detail", "encryption_key_id")
+			require.NoError(t, err)
+		}
+
+		kb := business.NewKeyRotationBusiness(ctx, cfg, dek, svc.EventsManager(), contactRepo,
+			repository.NewKeyRotationSweepRepository(ctx, dbPool, workMan))
+
+		sweep, err := kb.RunSweep(ctx)
+		require.NoError(t, err)
+		require.Equal(t, dek.KeyID, sweep.TargetKeyID)
+		require.EqualValues(t, 2, sweep.Queued)
+		require.Empty(t, sweep.LastContactID)
+
+		require.Eventually(t, func() bool {
+			progress, progressErr := kb.Progress(ctx)
+			return progressErr == nil && progress.Remaining == 0
+		}, 10*time.Second, 200*time.Millisecond)
+
+		for contactID, detail := range details {
+			contact, getErr := contactRepo.GetByID(ctx, contactID)
+			require.NoError(t, getErr)
+			require.Equal(t, dek.KeyID, contact.EncryptionKeyID)
+			decrypted, decryptErr := contact.DecryptDetail(dek.KeyID, dek.Key)
+			require.NoError(t, decryptErr)
+			require.Equal(t, detail, decrypted)
+		}
+
+		sweep, err = kb.RunSweep(ctx)
+		require.NoError(t, err)
+		require.False(t, sweep.CompletedAt.IsZero())
+		require.Zero(t, sweep.Remaining)
+		require.EqualValues(t, 2, sweep.Queued)
+	})
+}
+
+func (krts *KeyRotationTestSuite) Test_keyRotationBusiness_RunSweep_RetiredKeys() {
+	t := krts.T()
+
+	krts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := krts.CreateService(t, dep)
+
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		cfg := svc.Config().(*config.ProfileConfig)
+		dek := createContactTestDEK(cfg)
+		dek.OldKeys = map[string][]byte{"historic-key-id": []byte("abcdefghijklmnopqrstuvwxyz123456")}
+
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+		cb := business.NewContactBusiness(ctx, cfg, dek, svc.EventsManager(),
+			contactRepo, repository.NewVerificationRepository(ctx, dbPool, workMan))
+
+		details := map[string]string{}
+		for keyID, detail := range map[string]string{
+			dek.OldKeyID:      "retired.old@testing.com",
+			"historic-key-id": "retired.historic@testing.com",
+		} {
+			contact, err := cb.CreateContact(ctx, detail, data.JSONMap{})
+			require.NoError(t, err)
+			details[contact.GetID()] = detail
+
+			key, ok := dek.KeyFor(keyID)
+			require.True(t, ok)
+			contact.EncryptedDetail, err = util.EncryptValue(key, []byte(detail))
+			require.NoError(t, err)
+			contact.EncryptionKeyID = keyID
+			_, err = contactRepo.Update(ctx, contact, "encrypted_detail", "encryption_key_id")
+			require.NoError(t, err)
+		}
+
+		// Rows on retired keys stay readable until the sweep reaches them
+		requireDetails := func(wantKeyID string) {
+			for contactID, detail := range details {
+				contact, getErr := contactRepo.GetByID(ctx, contactID)
+				require.NoError(t, getErr)
+				if wantKeyID != "" {
+					require.Equal(t, wantKeyID, contact.EncryptionKeyID)
+				}
+				decrypted, decryptErr := contact.Detail(ctx, dek)
+				require.NoError(t, decryptErr)
+				require.Equal(t, detail, decrypted)
+			}
+		}
+		requireDetails("")
+
+		kb := business.NewKeyRotationBusiness(ctx, cfg, dek, svc.EventsManager(), contactRepo,
+			repository.NewKeyRotationSweepRepository(ctx, dbPool, workMan))
+
+		sweep, err := kb.RunSweep(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, sweep.Queued)
+
+		require.Eventually(t, func() bool {
+			progress, progressErr := kb.Progress(ctx)
+			return progressErr == nil && progress.Remaining == 0
+		}, 10*time.Second, 200*time.Millisecond)
+
+		requireDetails(dek.KeyID)
+	})
+}
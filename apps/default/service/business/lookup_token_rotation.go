@@ -0,0 +1,131 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/frame/v2/telemetry"
+	"github.com/pitabwire/util"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Lookup token rotation defaults.
+const (
+	lookupTokenRotationBatchSize     = 500
+	defaultLookupTokenRotationPeriod = time.Hour
+)
+
+// LookupTokenRotationBusiness recomputes contact lookup tokens with the
+// active lookup key.
+type LookupTokenRotationBusiness interface {
+	// RunRotation recomputes the lookup token of every contact not yet on
+	// the active lookup key from its decrypted detail, and returns how many
+	// contacts are left on other keys. Contacts already done are skipped, so
+	// an interrupted run resumes where it stopped.
+	RunRotation(ctx context.Context) (int64, error)
+	Scheduler
+}
+
+func NewLookupTokenRotationBusiness(_ context.Context, cfg *config.ProfileConfig, dek *config.DEK,
+	contactRepo repository.ContactRepository) LookupTokenRotationBusiness {
+	metrics := telemetry.NewBusinessMetrics(keyRotationMetricsName)
+	return &lookupTokenRotationBusiness{
+		cfg:         cfg,
+		dek:         dek,
+		contactRepo: contactRepo,
+		rotated: metrics.Counter(keyRotationMetricsName+"/lookup_token_rotation/rotated",
+			"Number of contact lookup tokens recomputed with the active lookup key"),
+		remaining: metrics.Gauge(keyRotationMetricsName+"/lookup_token_rotation/remaining",
+			"Number of contacts whose lookup token is not on the active lookup key"),
+	}
+}
+
+type lookupTokenRotationBusiness struct {
+	cfg         *config.ProfileConfig
+	dek         *config.DEK
+	contactRepo repository.ContactRepository
+
+	rotated   telemetry.Counter
+	remaining telemetry.Gauge
+}
+
+// StartScheduler runs RunRotation immediately, then every
+// LookupTokenRotationIntervalInSec.
+func (ltr *lookupTokenRotationBusiness) StartScheduler(ctx context.Context, locker repository.JobLocker) {
+	runScheduled(ctx, locker, "lookup_token_rotation",
+		time.Duration(ltr.cfg.LookupTokenRotationIntervalInSec)*time.Second, defaultLookupTokenRotationPeriod,
+		func(ctx context.Context) error {
+			_, err := ltr.RunRotation(ctx)
+			return err
+		})
+}
+
+func (ltr *lookupTokenRotationBusiness) RunRotation(ctx context.Context) (int64, error) {
+	log := util.Log(ctx).WithField("lookup_key_id", ltr.dek.LookUpKeyID)
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	keyAttr := attribute.String("lookup_key_id", ltr.dek.LookUpKeyID)
+
+	if ltr.dek.LookUpKeyID == "" {
+		// Without an id, tokens of the active key can't be told apart
+		return 0, nil
+	}
+
+	rotated := 0
+	afterID := ""
+	for {
+		contacts, err := ltr.contactRepo.ListNotOnLookupKey(
+			unscopedCtx, ltr.dek.LookUpKeyID, afterID, lookupTokenRotationBatchSize)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, contact := range contacts {
+			afterID = contact.GetID()
+			contactLog := log.WithField("contact_id", contact.GetID())
+
+			detail, decryptErr := contact.Detail(ctx, ltr.dek)
+			if decryptErr != nil {
+				contactLog.WithError(decryptErr).Error("could not decrypt contact to recompute its lookup token")
+				continue
+			}
+
+			contact.LookUpToken = util.ComputeLookupToken(ltr.dek.LookUpKey, detail)
+			contact.LookUpKeyID = ltr.dek.LookUpKeyID
+			_, err = ltr.contactRepo.Update(unscopedCtx, contact, "look_up_token", "look_up_key_id")
+			if err != nil {
+				if !data.ErrorIsDuplicateKey(err) {
+					return 0, err
+				}
+				// Another contact already holds this detail under the active key
+				contactLog.Warn("lookup token of contact collides with another contact, left as is")
+				continue
+			}
+			rotated++
+			ltr.rotated.Add(ctx, 1, keyAttr)
+		}
+
+		if len(contacts) < lookupTokenRotationBatchSize {
+			break
+		}
+	}
+
+	remaining, err := ltr.contactRepo.CountNotOnLookupKey(unscopedCtx, ltr.dek.LookUpKeyID)
+	if err != nil {
+		return 0, err
+	}
+	ltr.remaining.Record(ctx, remaining, keyAttr)
+
+	log = log.WithFields(map[string]any{"rotated": rotated, "remaining": remaining})
+	if remaining == 0 && len(ltr.dek.OldLookUpKey) > 0 && !ltr.dek.OldLookUpKeyRetired {
+		log.WithField("old_lookup_key_id", ltr.dek.OldLookUpKeyID).
+			Info("lookup token rotation complete, the old lookup key can be retired")
+	} else {
+		log.Info("lookup token rotation run complete")
+	}
+	return remaining, nil
+}
@@ -0,0 +1,80 @@
+package business_test
+
+import (
+	"testing"
+
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/frametests/definition"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+	"github.com/antinvestor/service-profile/apps/default/tests"
+)
+
+type LookupTokenRotationTestSuite struct {
+	tests.ProfileBaseTestSuite
+}
+
+func TestLookupTokenRotationSuite(t *testing.T) {
+	suite.Run(t, new(LookupTokenRotationTestSuite))
+}
+
+func (ltrs *LookupTokenRotationTestSuite) Test_lookupTokenRotationBusiness_RunRotation() {
+	t := ltrs.T()
+
+	ltrs.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := ltrs.CreateService(t, dep)
+
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		cfg := svc.Config().(*config.ProfileConfig)
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+		verificationRepo := repository.NewVerificationRepository(ctx, dbPool, workMan)
+
+		oldDEK := createContactTestDEK(cfg)
+		oldDEK.LookUpKeyID = "lookup-old"
+
+		rotatedDEK := *oldDEK
+		rotatedDEK.LookUpKeyID = "lookup-new"
+		rotatedDEK.LookUpKey = []byte("rotated-lookup-token-hmac-key-32")
+		rotatedDEK.OldLookUpKeyID = oldDEK.LookUpKeyID
+		rotatedDEK.OldLookUpKey = oldDEK.LookUpKey
+
+		retiredDEK := rotatedDEK
+		retiredDEK.OldLookUpKeyRetired = true
+
+		oldCB := business.NewContactBusiness(ctx, cfg, oldDEK, svc.EventsManager(), contactRepo, verificationRepo)
+		rotatedCB := business.NewContactBusiness(ctx, cfg, &rotatedDEK, svc.EventsManager(),
+			contactRepo, verificationRepo)
+		retiredCB := business.NewContactBusiness(ctx, cfg, &retiredDEK, svc.EventsManager(),
+			contactRepo, verificationRepo)
+
+		detail := "rotate.lookup@testing.com"
+		contact, err := oldCB.CreateContact(ctx, detail, data.JSONMap{})
+		require.NoError(t, err)
+		require.Equal(t, "lookup-old", contact.LookUpKeyID)
+
+		// During the transition both tokens are looked up
+		found, err := rotatedCB.GetByDetail(ctx, detail)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+
+		found, err = retiredCB.GetByDetail(ctx, detail)
+		require.NoError(t, err)
+		require.Empty(t, found)
+
+		remaining, err := business.NewLookupTokenRotationBusiness(ctx, cfg, &rotatedDEK, contactRepo).RunRotation(ctx)
+		require.NoError(t, err)
+		require.Zero(t, remaining)
+
+		found, err = retiredCB.GetByDetail(ctx, detail)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, contact.GetID(), found[0].GetID())
+		require.Equal(t, "lookup-new", found[0].LookUpKeyID)
+	})
+}
@@ -0,0 +1,400 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/v2/data"
+	frevents "github.com/pitabwire/frame/v2/events"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/events"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Profile export defaults.
+const (
+	profileExportBatchSize      = 50
+	defaultProfileExportPeriod  = 30 * time.Second
+	defaultProfileExportTimeout = 15 * time.Minute
+)
+
+// ProfileExportBusiness exports everything held about a profile, for
+// data portability requests.
+type ProfileExportBusiness interface {
+	// ExportProfileData gathers the profile, its decrypted contacts,
+	// property history (global entries and the caller's scoped ones),
+	// addresses, relationships, rosters, verifications and verification
+	// attempts into a bundle.
+	ExportProfileData(ctx context.Context, profileID string) (*models.ProfileDataBundle, error)
+	// StartExport queues an export of the profile for RunExports.
+	StartExport(ctx context.Context, profileID string) (*models.ProfileExport, error)
+	// GetExport returns an export started by the caller's tenant, and its
+	// bundle once the export is ready.
+	GetExport(ctx context.Context, exportID string) (*models.ProfileExport, *models.ProfileDataBundle, error)
+	// RunExports builds and stores the bundles of queued exports, emitting a
+	// profile.export.completed event as each finishes. Exports abandoned
+	// mid-run are picked up again after ProfileExportTimeoutInSec.
+	RunExports(ctx context.Context) (int, error)
+	Scheduler
+}
+
+func NewProfileExportBusiness(_ context.Context, cfg *config.ProfileConfig, dek *config.DEK,
+	evtMan frevents.Manager, profileRepo repository.ProfileRepository,
+	propertyEntryRepo repository.PropertyEntryRepository,
+	exportRepo repository.ProfileExportRepository) ProfileExportBusiness {
+	return &profileExportBusiness{
+		cfg:               cfg,
+		dek:               dek,
+		eventsMan:         evtMan,
+		profileRepo:       profileRepo,
+		propertyEntryRepo: propertyEntryRepo,
+		exportRepo:        exportRepo,
+	}
+}
+
+type profileExportBusiness struct {
+	cfg               *config.ProfileConfig
+	dek               *config.DEK
+	eventsMan         frevents.Manager
+	profileRepo       repository.ProfileRepository
+	propertyEntryRepo repository.PropertyEntryRepository
+	exportRepo        repository.ProfileExportRepository
+}
+
+// StartScheduler runs RunExports immediately, then every
+// ProfileExportIntervalInSec.
+func (peb *profileExportBusiness) StartScheduler(ctx context.Context, locker repository.JobLocker) {
+	runScheduled(ctx, locker, "profile_export",
+		time.Duration(peb.cfg.ProfileExportIntervalInSec)*time.Second, defaultProfileExportPeriod,
+		func(ctx context.Context) error {
+			_, err := peb.RunExports(ctx)
+			return err
+		})
+}
+
+func (peb *profileExportBusiness) ExportProfileData(
+	ctx context.Context,
+	profileID string,
+) (*models.ProfileDataBundle, error) {
+	if profileID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("profile id is required"))
+	}
+	// The bundle is read across tenants, so the caller must see the profile first
+	if _, err := peb.profileRepo.GetByID(ctx, profileID); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return peb.buildBundle(ctx, profileID, callerTenantID(ctx))
+}
+
+func (peb *profileExportBusiness) StartExport(ctx context.Context, profileID string) (*models.ProfileExport, error) {
+	if profileID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("profile id is required"))
+	}
+	if _, err := peb.profileRepo.GetByID(ctx, profileID); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	export := &models.ProfileExport{
+		ProfileID:      profileID,
+		CallerTenantID: callerTenantID(ctx),
+		Status:         models.ProfileExportPending,
+	}
+	if claims := security.ClaimsFromContext(ctx); claims != nil {
+		export.RequestedBy, _ = claims.GetSubject()
+	}
+	if err := peb.exportRepo.Create(ctx, export); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return export, nil
+}
+
+func (peb *profileExportBusiness) GetExport(
+	ctx context.Context,
+	exportID string,
+) (*models.ProfileExport, *models.ProfileDataBundle, error) {
+	export, err := peb.exportRepo.GetByID(ctx, exportID)
+	if err != nil {
+		return nil, nil, data.ErrorConvertToAPI(err)
+	}
+	if export.Status != models.ProfileExportReady {
+		return export, nil, nil
+	}
+
+	key, err := peb.dek.DecryptionKey(ctx, export.EncryptionKeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	decrypted, err := util.DecryptValue(key, export.EncryptedBundle)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bundle := &models.ProfileDataBundle{}
+	if err = json.Unmarshal(decrypted, bundle); err != nil {
+		return nil, nil, err
+	}
+	return export, bundle, nil
+}
+
+func (peb *profileExportBusiness) RunExports(ctx context.Context) (int, error) {
+	timeout := time.Duration(peb.cfg.ProfileExportTimeoutInSec) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProfileExportTimeout
+	}
+	staleBefore := time.Now().Add(-timeout)
+
+	exports, err := peb.exportRepo.ListClaimable(ctx, staleBefore, profileExportBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	for _, export := range exports {
+		claimed, claimErr := peb.exportRepo.Claim(ctx, export.GetID(), staleBefore)
+		if claimErr != nil {
+			return completed, claimErr
+		}
+		if !claimed {
+			continue
+		}
+
+		if err = peb.runExport(ctx, export); err != nil {
+			return completed, err
+		}
+		completed++
+	}
+	return completed, nil
+}
+
+// runExport builds and stores the bundle of a claimed export. An export
+// that can't be built is marked failed rather than retried.
+func (peb *profileExportBusiness) runExport(ctx context.Context, export *models.ProfileExport) error {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	log := util.Log(ctx).WithFields(map[string]any{
+		"export_id":  export.GetID(),
+		"profile_id": export.ProfileID,
+	})
+
+	export.Status = models.ProfileExportReady
+	export.Error = ""
+	if buildErr := peb.storeBundle(ctx, export); buildErr != nil {
+		log.WithError(buildErr).Error("could not export profile data")
+		export.Status = models.ProfileExportFailed
+		export.Error = buildErr.Error()
+		export.EncryptedBundle = nil
+		export.EncryptionKeyID = ""
+	}
+	export.CompletedAt = time.Now()
+
+	_, err := peb.exportRepo.Update(unscopedCtx, export,
+		"status", "error", "encrypted_bundle", "encryption_key_id", "completed_at")
+	if err != nil {
+		return err
+	}
+
+	return peb.eventsMan.Emit(ctx, events.ProfileExportCompletedEventHandlerName, &models.ProfileExportCompleted{
+		ExportID:  export.GetID(),
+		ProfileID: export.ProfileID,
+		Status:    export.Status,
+	})
+}
+
+// storeBundle builds the export's bundle and sets it, encrypted with the
+// key of the tenant that asked for it.
+func (peb *profileExportBusiness) storeBundle(ctx context.Context, export *models.ProfileExport) error {
+	bundle, err := peb.buildBundle(ctx, export.ProfileID, export.CallerTenantID)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+
+	keyID, key, err := peb.dek.EncryptionKey(ctx, export.TenantID)
+	if err != nil {
+		return err
+	}
+	export.EncryptedBundle, err = util.EncryptValue(key, encoded)
+	if err != nil {
+		return err
+	}
+	export.EncryptionKeyID = keyID
+	return nil
+}
+
+func (peb *profileExportBusiness) buildBundle(
+	ctx context.Context,
+	profileID, tenantID string,
+) (*models.ProfileDataBundle, error) {
+	subject, err := peb.profileRepo.CollectSubjectData(ctx, profileID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	entries, err := peb.propertyEntryRepo.HistoryByProfile(ctx, profileID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &models.ProfileDataBundle{
+		ProfileID:  profileID,
+		ExportedAt: time.Now(),
+		Profile: data.JSONMap{
+			"id":          subject.Profile.GetID(),
+			"type":        subject.Profile.ProfileType.Name,
+			"properties":  subject.Profile.Properties,
+			"created_at":  subject.Profile.CreatedAt,
+			"modified_at": subject.Profile.ModifiedAt,
+		},
+		Contacts:             []data.JSONMap{},
+		PropertyHistory:      []data.JSONMap{},
+		Addresses:            []data.JSONMap{},
+		Relationships:        []data.JSONMap{},
+		Rosters:              []data.JSONMap{},
+		Verifications:        []data.JSONMap{},
+		VerificationAttempts: []data.JSONMap{},
+	}
+
+	for _, contact := range subject.Contacts {
+		exported, contactErr := peb.exportContact(ctx, contact)
+		if contactErr != nil {
+			return nil, contactErr
+		}
+		exported["communication_level"] = contact.CommunicationLevel
+		exported["language"] = contact.Language
+		exported["properties"] = contact.Properties
+		setTime(exported, "verified_at", contact.VerifiedAt)
+		setTime(exported, "verification_expires_at", contact.VerificationExpiresAt)
+		bundle.Contacts = append(bundle.Contacts, exported)
+	}
+
+	for _, entry := range entries {
+		bundle.PropertyHistory = append(bundle.PropertyHistory, data.JSONMap{
+			"key":        entry.Key,
+			"value":      entry.TypedValue(),
+			"removed":    entry.Tombstone,
+			"scoped":     entry.Scoped,
+			"tenant_id":  entry.TenantID,
+			"created_by": entry.CreatedBy,
+			"created_at": entry.CreatedAt,
+		})
+	}
+
+	for _, link := range subject.Addresses {
+		exported := data.JSONMap{"id": link.GetID(), "name": link.Name, "created_at": link.CreatedAt}
+		if link.Address != nil {
+			address := data.JSONMap{
+				"name":       link.Address.Name,
+				"admin_unit": link.Address.AdminUnit,
+				"properties": link.Address.Properties,
+			}
+			if link.Address.Country != nil {
+				address["country"] = link.Address.Country.Name
+			}
+			exported["address"] = address
+		}
+		bundle.Addresses = append(bundle.Addresses, exported)
+	}
+
+	for _, relationship := range subject.Relationships {
+		exported := data.JSONMap{
+			"id":         relationship.GetID(),
+			"parent":     data.JSONMap{"object": relationship.ParentObject, "id": relationship.ParentObjectID},
+			"child":      data.JSONMap{"object": relationship.ChildObject, "id": relationship.ChildObjectID},
+			"properties": relationship.Properties,
+			"created_at": relationship.CreatedAt,
+		}
+		if relationship.RelationshipType != nil {
+			exported["type"] = relationship.RelationshipType.Name
+		}
+		bundle.Relationships = append(bundle.Relationships, exported)
+	}
+
+	for _, roster := range subject.Rosters {
+		exported := data.JSONMap{
+			"id":         roster.GetID(),
+			"name":       roster.Name,
+			"properties": roster.Properties,
+			"created_at": roster.CreatedAt,
+		}
+		if roster.Contact != nil {
+			exported["contact"], err = peb.exportContact(ctx, roster.Contact)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bundle.Rosters = append(bundle.Rosters, exported)
+	}
+
+	for _, verification := range subject.Verifications {
+		// Codes are digests kept to check attempts, not data about the subject
+		exported := data.JSONMap{
+			"id":         verification.GetID(),
+			"contact_id": verification.ContactID,
+			"mode":       verification.Mode,
+			"created_at": verification.CreatedAt,
+		}
+		setTime(exported, "expires_at", verification.ExpiresAt)
+		setTime(exported, "verified_at", verification.VerifiedAt)
+		setTime(exported, "locked_at", verification.LockedAt)
+		bundle.Verifications = append(bundle.Verifications, exported)
+	}
+
+	for _, attempt := range subject.VerificationAttempts {
+		bundle.VerificationAttempts = append(bundle.VerificationAttempts, data.JSONMap{
+			"id":              attempt.GetID(),
+			"verification_id": attempt.VerificationID,
+			"state":           attempt.State,
+			"device_id":       attempt.DeviceID,
+			"ip_address":      attempt.IPAddress,
+			"created_at":      attempt.CreatedAt,
+		})
+	}
+
+	return bundle, nil
+}
+
+// exportContact returns the contact with its decrypted detail. Details whose
+// key was shredded are exported as such.
+func (peb *profileExportBusiness) exportContact(ctx context.Context, contact *models.Contact) (data.JSONMap, error) {
+	exported := data.JSONMap{
+		"id":         contact.GetID(),
+		"type":       contact.ContactType,
+		"created_at": contact.CreatedAt,
+	}
+
+	detail, err := contact.Detail(ctx, peb.dek)
+	switch {
+	case errors.Is(err, config.ErrKeyShredded):
+		exported["shredded"] = true
+	case err != nil:
+		return nil, err
+	default:
+		exported["detail"] = detail
+	}
+	return exported, nil
+}
+
+// setTime sets key on m unless t is zero.
+func setTime(m data.JSONMap, key string, t time.Time) {
+	if !t.IsZero() {
+		m[key] = t
+	}
+}
+
+// callerTenantID returns the tenant of the claims on ctx, if any.
+func callerTenantID(ctx context.Context) string {
+	if claims := security.ClaimsFromContext(ctx); claims != nil {
+		return claims.GetTenantID()
+	}
+	return ""
+}
@@ -3,7 +3,8 @@ package business
 import (
 	"context"
 	"errors"
-	"fmt"
+	"maps"
+	"reflect"
 	"strings"
 	"time"
 
@@ -25,6 +26,13 @@ import (
 // ErrContactNotFound is returned when a contact lookup finds no matching contact.
 var ErrContactNotFound = errors.New("contact not found")
 
+// ErrTooManyVerificationAttempts is returned by CheckVerification once a
+// verification is locked or its contact or caller is being throttled.
+var ErrTooManyVerificationAttempts = connect.NewError(
+	connect.CodeResourceExhausted,
+	errors.New("too many verification attempts"),
+)
+
 type ProfileBusiness interface {
 	GetByID(ctx context.Context, profileID string) (*profilev1.ProfileObject, error)
 	GetByIDAndPartition(
@@ -43,6 +51,8 @@ type ProfileBusiness interface {
 		request *profilev1.CreateRequest,
 	) (*profilev1.ProfileObject, error)
 
+	// UpdateProfile sets the request's properties; a null value removes the
+	// key, leaving a tombstone in its history.
 	UpdateProfile(
 		ctx context.Context,
 		request *profilev1.UpdateRequest,
@@ -58,11 +68,29 @@ type ProfileBusiness interface {
 		ctx context.Context,
 		profileID, key, callerTenantID string,
 	) ([]*models.PropertyEntry, error)
+	// GetProfileAsOf rebuilds the profile's global properties, overlaid with
+	// the partition's scoped ones, from ledger entries written up to asOf.
+	// Profiles with no global entries yet use their stored properties.
+	// Contacts and addresses are returned as they are now.
+	GetProfileAsOf(
+		ctx context.Context,
+		profileID, partitionID string,
+		asOf time.Time,
+	) (*profilev1.ProfileObject, error)
 
 	MergeProfile(
 		ctx context.Context,
 		request *profilev1.MergeRequest,
 	) (*profilev1.ProfileObject, error)
+	PreviewMerge(
+		ctx context.Context,
+		request *profilev1.MergeRequest,
+	) (*models.MergePreview, error)
+	UnmergeProfile(ctx context.Context, mergedID string) (*profilev1.ProfileObject, error)
+	// EraseProfile permanently deletes the profile and everything held about
+	// its subject, and announces the erasure so other services purge theirs.
+	// Erasing an erased profile announces it again and returns its record.
+	EraseProfile(ctx context.Context, profileID string) (*models.ProfileErasure, error)
 
 	AddAddress(
 		ctx context.Context,
@@ -81,12 +109,22 @@ type ProfileBusiness interface {
 
 	GetContactByID(ctx context.Context, contactID string) (*profilev1.ContactObject, error)
 
+	// VerifyContact starts a verification of the contact, see
+	// ContactBusiness.VerifyContact for mode.
 	VerifyContact(
 		ctx context.Context,
 		contactID string,
-		verificationID, code string,
+		verificationID, code, mode string,
 		expiryDuration time.Duration,
 	) (string, error)
+	// ConfirmVerificationToken verifies the contact of a link mode
+	// verification from the token its link carried, returning the
+	// verification id.
+	ConfirmVerificationToken(ctx context.Context, token string) (string, error)
+	// CheckVerification records an attempt at a verification code, returning
+	// the attempt count and whether it succeeded. Once too many attempts were
+	// made it fails with ErrTooManyVerificationAttempts and the verification
+	// is locked.
 	CheckVerification(
 		ctx context.Context,
 		verificationID string,
@@ -98,6 +136,7 @@ type ProfileBusiness interface {
 func NewProfileBusiness(_ context.Context, cfg *config.ProfileConfig, dek *config.DEK,
 	eventsMan frevents.Manager,
 	contactBusiness ContactBusiness, addressBusiness AddressBusiness,
+	schemaBusiness PropertySchemaBusiness,
 	profileRepo repository.ProfileRepository,
 	propertyEntryRepo repository.PropertyEntryRepository) ProfileBusiness {
 	return &profileBusiness{
@@ -105,6 +144,7 @@ func NewProfileBusiness(_ context.Context, cfg *config.ProfileConfig, dek *confi
 		dek:               dek,
 		contactBusiness:   contactBusiness,
 		addressBusiness:   addressBusiness,
+		schemaBusiness:    schemaBusiness,
 		profileRepo:       profileRepo,
 		propertyEntryRepo: propertyEntryRepo,
 		eventsMan:         eventsMan,
@@ -116,6 +156,7 @@ type profileBusiness struct {
 	dek             *config.DEK
 	contactBusiness ContactBusiness
 	addressBusiness AddressBusiness
+	schemaBusiness  PropertySchemaBusiness
 
 	profileRepo       repository.ProfileRepository
 	propertyEntryRepo repository.PropertyEntryRepository
@@ -137,8 +178,12 @@ func (pb *profileBusiness) ToAPI(ctx context.Context,
 		return nil, err
 	}
 	for _, c := range contactList {
-		contactObj, toAPIErr := c.ToAPI(pb.dek, true)
+		contactObj, toAPIErr := c.ToAPI(ctx, pb.dek, true)
 		if toAPIErr != nil {
+			if errors.Is(toAPIErr, config.ErrKeyShredded) {
+				// The detail is gone for good; the rest of the profile is not
+				continue
+			}
 			return nil, toAPIErr
 		}
 		contactObjects = append(contactObjects, contactObj)
@@ -189,7 +234,7 @@ func (pb *profileBusiness) GetByContact(
 		profileObject.Type = profilev1.ProfileType_PERSON
 		props := data.JSONMap{}
 		profileObject.Properties = props.ToProtoStruct()
-		contactObj, toAPIErr := contact.ToAPI(pb.dek, true)
+		contactObj, toAPIErr := contact.ToAPI(ctx, pb.dek, true)
 		if toAPIErr != nil {
 			return nil, toAPIErr
 		}
@@ -229,36 +274,199 @@ func (pb *profileBusiness) SearchProfile(ctx context.Context,
 	return result, nil
 }
 
-func (pb *profileBusiness) MergeProfile(ctx context.Context,
-	request *profilev1.MergeRequest) (*profilev1.ProfileObject, error) {
+// mergePair loads the target and merged profiles of a merge request.
+func (pb *profileBusiness) mergePair(ctx context.Context,
+	request *profilev1.MergeRequest) (*models.Profile, *models.Profile, error) {
+	if request.GetId() == request.GetMergeid() {
+		return nil, nil, connect.NewError(
+			connect.CodeInvalidArgument,
+			errors.New("a profile cannot be merged into itself"),
+		)
+	}
+
 	target, err := pb.profileRepo.GetByID(ctx, request.GetId())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	merging, err := pb.profileRepo.GetByID(ctx, request.GetMergeid())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return target, merging, nil
+}
+
+// PreviewMerge reports what MergeProfile would do for the same request:
+// conflicting global properties, taken from the latest ledger entries on
+// both sides, and the dependent rows that would move or collide.
+func (pb *profileBusiness) PreviewMerge(ctx context.Context,
+	request *profilev1.MergeRequest) (*models.MergePreview, error) {
+	target, merging, err := pb.mergePair(ctx, request)
 	if err != nil {
 		return nil, err
 	}
 
+	preview, err := pb.profileRepo.PreviewMerge(ctx, target.GetID(), merging.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	targetEntries, err := pb.propertyEntryRepo.LatestGlobalByProfile(ctx, target.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	mergedEntries, err := pb.propertyEntryRepo.LatestGlobalByProfile(ctx, merging.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	targetValues := make(map[string]*models.PropertyEntry, len(targetEntries))
+	for _, e := range targetEntries {
+		targetValues[e.Key] = e
+	}
+
+	for _, e := range mergedEntries {
+		targetEntry, ok := targetValues[e.Key]
+		if !ok || (targetEntry.Value == e.Value && targetEntry.ValueType == e.ValueType) {
+			continue
+		}
+		preview.PropertyConflicts = append(preview.PropertyConflicts, &models.PropertyConflict{
+			Key:         e.Key,
+			TargetValue: targetEntry.TypedValue(),
+			MergedValue: e.TypedValue(),
+		})
+	}
+
+	return preview, nil
+}
+
+func (pb *profileBusiness) MergeProfile(ctx context.Context,
+	request *profilev1.MergeRequest) (*profilev1.ProfileObject, error) {
+	target, merging, err := pb.mergePair(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Properties == nil {
+		target.Properties = data.JSONMap{}
+	}
+
+	// Replay the merged profile's current global properties onto the target
+	// ledger so they become the latest values, then mirror them in the cache.
+	var replayEntries []*models.PropertyEntry
 	for key, value := range merging.Properties {
-		if value == nil || target.Properties[key] == value {
+		if value == nil || reflect.DeepEqual(target.Properties[key], value) {
 			continue
 		}
+		entry := &models.PropertyEntry{
+			ProfileID: target.GetID(),
+			Key:       key,
+		}
+		if err = entry.SetValue(value); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
 		target.Properties[key] = value
+		replayEntries = append(replayEntries, entry)
 	}
 
-	_, err = pb.profileRepo.Update(ctx, target, "properties")
+	err = pb.profileRepo.Merge(ctx, target, merging, replayEntries)
 	if err != nil {
-		return nil, err
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	eventErr := pb.eventsMan.Emit(ctx, events.ProfileMergedEventHandlerName, &models.ProfileMerge{
+		TargetID: target.GetID(),
+		MergedID: merging.GetID(),
+	})
+	if eventErr != nil {
+		util.Log(ctx).WithError(eventErr).
+			WithField("target_id", target.GetID()).
+			WithField("merged_id", merging.GetID()).
+			Error("could not emit profile merged event")
+	}
+
+	return pb.ToAPI(ctx, target)
+}
+
+func (pb *profileBusiness) EraseProfile(ctx context.Context, profileID string) (*models.ProfileErasure, error) {
+	if profileID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("profile id is required"))
 	}
 
-	err = pb.profileRepo.Delete(ctx, merging.GetID())
+	erasure, err := pb.profileRepo.GetErasure(ctx, profileID)
+	if err != nil {
+		if !data.ErrorIsNoRows(err) {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+
+		if _, err = pb.profileRepo.GetByID(ctx, profileID); err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+
+		erasure = &models.ProfileErasure{ProfileID: profileID}
+		if claims := security.ClaimsFromContext(ctx); claims != nil {
+			erasure.RequestedBy, _ = claims.GetSubject()
+		}
+		if err = pb.profileRepo.Erase(ctx, erasure); err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+	}
+
+	// Unlike merges, a lost erasure event leaves personal data behind, so the
+	// caller retries until it is out.
+	err = pb.eventsMan.Emit(ctx, events.ProfileErasedEventHandlerName, &models.ProfileErased{
+		ProfileID: profileID,
+		ErasureID: erasure.GetID(),
+		ErasedAt:  erasure.CreatedAt,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return pb.ToAPI(ctx, target)
+	util.Log(ctx).WithFields(map[string]any{
+		"profile_id": profileID,
+		"erasure_id": erasure.GetID(),
+		"erased":     erasure.Erased,
+	}).Info("profile erased")
+	return erasure, nil
+}
+
+// UnmergeProfile undoes the latest merge of mergedID, provided it happened
+// within the configured unmerge window and the profile it was merged into
+// is still live, and returns the restored profile.
+func (pb *profileBusiness) UnmergeProfile(
+	ctx context.Context,
+	mergedID string) (*profilev1.ProfileObject, error) {
+	journal, err := pb.profileRepo.GetLatestMergeJournal(ctx, mergedID)
+	if err != nil {
+		if data.ErrorIsNoRows(err) {
+			return nil, connect.NewError(
+				connect.CodeNotFound,
+				errors.New("no merge of this profile to undo"),
+			)
+		}
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	window := time.Duration(pb.cfg.UnmergeWindowInSec) * time.Second
+	if time.Since(journal.CreatedAt) > window {
+		return nil, connect.NewError(
+			connect.CodeFailedPrecondition,
+			errors.New("the unmerge window for this merge has elapsed"),
+		)
+	}
+
+	err = pb.profileRepo.Unmerge(ctx, journal)
+	if err != nil {
+		if errors.Is(err, repository.ErrMergeTargetGone) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, err)
+		}
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	return pb.GetByID(ctx, mergedID)
 }
 
 func (pb *profileBusiness) UpdateProfile(
@@ -277,14 +485,30 @@ func (pb *profileBusiness) UpdateProfileProperties(
 		return nil, err
 	}
 
-	// Append property entries to the ledger
+	schemas, err := pb.schemaBusiness.Validate(ctx, properties, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Append property entries to the ledger; keys the tenant's schema marks
+	// scoped stay tenant-private even on a global update. A null value
+	// removes the key.
 	var entries []*models.PropertyEntry
 	for key, value := range properties {
+		schema, hasSchema := schemas[key]
+		entryScoped := scoped || (hasSchema && schema.Scoped)
+		if value == nil {
+			entries = append(entries, models.NewPropertyTombstone(profile.GetID(), key, entryScoped))
+			continue
+		}
+
 		entry := &models.PropertyEntry{
 			ProfileID: profile.GetID(),
 			Key:       key,
-			Value:     fmt.Sprintf("%v", value),
-			Scoped:    scoped,
+			Scoped:    entryScoped,
+		}
+		if err = entry.SetValue(value); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
 		}
 		entries = append(entries, entry)
 	}
@@ -304,7 +528,7 @@ func (pb *profileBusiness) UpdateProfileProperties(
 
 		newProps := data.JSONMap{}
 		for _, e := range latestEntries {
-			newProps[e.Key] = e.Value
+			newProps[e.Key] = e.TypedValue()
 		}
 		profile.Properties = newProps
 
@@ -337,7 +561,7 @@ func (pb *profileBusiness) GetByIDAndPartition(
 	if len(scopedEntries) > 0 {
 		merged := profileObj.GetProperties().AsMap()
 		for _, e := range scopedEntries {
-			merged[e.Key] = e.Value
+			merged[e.Key] = e.TypedValue()
 		}
 		mergedMap := data.JSONMap(merged)
 		profileObj.Properties = mergedMap.ToProtoStruct()
@@ -353,6 +577,68 @@ func (pb *profileBusiness) GetPropertyHistory(
 	return pb.propertyEntryRepo.HistoryByKey(ctx, profileID, key, callerTenantID)
 }
 
+func (pb *profileBusiness) GetProfileAsOf(
+	ctx context.Context,
+	profileID, partitionID string,
+	asOf time.Time,
+) (*profilev1.ProfileObject, error) {
+	profile, err := pb.profileRepo.GetByID(ctx, profileID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	if asOf.Before(profile.CreatedAt) {
+		return nil, connect.NewError(
+			connect.CodeNotFound,
+			errors.New("the profile did not exist at the requested time"),
+		)
+	}
+
+	entries, err := pb.propertyEntryRepo.GlobalByProfileAsOf(ctx, profileID, asOf)
+	if err != nil {
+		return nil, asOfError(err)
+	}
+
+	properties := data.JSONMap{}
+	if len(entries) == 0 {
+		// Profiles created before the ledger have no entries until their
+		// properties first change, so until then the stored ones are those
+		// they have had since creation.
+		ledgerEntries, ledgerErr := pb.propertyEntryRepo.LatestGlobalByProfile(ctx, profileID)
+		if ledgerErr != nil {
+			return nil, asOfError(ledgerErr)
+		}
+		if len(ledgerEntries) == 0 {
+			maps.Copy(properties, profile.Properties)
+		}
+	}
+
+	if partitionID != "" {
+		scopedEntries, scopedErr := pb.propertyEntryRepo.ScopedByProfileAndPartitionAsOf(
+			ctx, profileID, partitionID, asOf)
+		if scopedErr != nil {
+			return nil, asOfError(scopedErr)
+		}
+		entries = append(entries, scopedEntries...)
+	}
+
+	for _, e := range entries {
+		properties[e.Key] = e.TypedValue()
+	}
+	profile.Properties = properties
+
+	return pb.ToAPI(ctx, profile)
+}
+
+// asOfError reports reads before the compaction horizon as a failed
+// precondition rather than an internal error.
+func asOfError(err error) error {
+	if errors.Is(err, repository.ErrBeforeCompactionHorizon) {
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	}
+	return data.ErrorConvertToAPI(err)
+}
+
 // lookupContactByDetail attempts to find a contact by detail or ID.
 // Returns the contact if found, nil if not found, or an error if lookup fails.
 func (pb *profileBusiness) lookupContactByDetail(
@@ -410,6 +696,32 @@ func (pb *profileBusiness) CreateProfile(
 		return pb.GetByID(ctx, contact.ProfileID)
 	}
 
+	schemas, err := pb.schemaBusiness.Validate(ctx, p.Properties, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the initial properties in the ledger so the profile can be
+	// reconstructed from it. Keys the tenant's schema marks scoped become
+	// tenant-private entries instead of going to the global cache.
+	var initialEntries []*models.PropertyEntry
+	for key, value := range p.Properties {
+		if value == nil {
+			delete(p.Properties, key)
+			continue
+		}
+
+		schema, hasSchema := schemas[key]
+		entry := &models.PropertyEntry{Key: key, Scoped: hasSchema && schema.Scoped}
+		if err = entry.SetValue(value); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		initialEntries = append(initialEntries, entry)
+		if entry.Scoped {
+			delete(p.Properties, key)
+		}
+	}
+
 	pt, repoErr := pb.profileRepo.GetTypeByUID(ctx, request.GetType())
 	if repoErr != nil {
 		return nil, data.ErrorConvertToAPI(repoErr)
@@ -423,7 +735,15 @@ func (pb *profileBusiness) CreateProfile(
 		return nil, data.ErrorConvertToAPI(createErr)
 	}
 
-	var err error
+	if len(initialEntries) > 0 {
+		for _, entry := range initialEntries {
+			entry.ProfileID = p.GetID()
+		}
+		if err = pb.propertyEntryRepo.AppendEntries(ctx, initialEntries); err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+	}
+
 	if contact == nil {
 		contact, err = pb.contactBusiness.CreateContact(ctx, contactDetail, data.JSONMap{})
 		if err != nil {
@@ -443,12 +763,13 @@ func (pb *profileBusiness) CreateProfile(
 		return nil, err
 	}
 
-	return pb.profileFromCreated(&p, contact)
+	return pb.profileFromCreated(ctx, &p, contact)
 }
 
 // profileFromCreated builds a ProfileObject from the just-created profile and
 // its contact without any further reads (see CreateProfile for why).
 func (pb *profileBusiness) profileFromCreated(
+	ctx context.Context,
 	p *models.Profile,
 	contact *models.Contact,
 ) (*profilev1.ProfileObject, error) {
@@ -458,7 +779,7 @@ func (pb *profileBusiness) profileFromCreated(
 		Properties: p.Properties.ToProtoStruct(),
 	}
 	if contact != nil {
-		contactObj, err := contact.ToAPI(pb.dek, true)
+		contactObj, err := contact.ToAPI(ctx, pb.dek, true)
 		if err != nil {
 			return nil, err
 		}
@@ -558,9 +879,14 @@ func (pb *profileBusiness) AddContact(
 		return nil, "", linkErr
 	}
 
-	verificationID, verifyErr := pb.VerifyContact(ctx, resp.GetID(), "", "", 0)
-	if verifyErr != nil {
-		return nil, "", verifyErr
+	// Push tokens, postal addresses and the like have nowhere to send a code
+	verificationID := ""
+	if ContactTypes.Verifiable(resp.ContactType) {
+		var verifyErr error
+		verificationID, verifyErr = pb.VerifyContact(ctx, resp.GetID(), "", "", "", 0)
+		if verifyErr != nil {
+			return nil, "", verifyErr
+		}
 	}
 
 	// Return updated profile with the new contact included
@@ -586,7 +912,7 @@ func (pb *profileBusiness) GetContactByID(
 		return nil, err
 	}
 
-	contactObj, err := contact.ToAPI(pb.dek, true)
+	contactObj, err := contact.ToAPI(ctx, pb.dek, true)
 	if err != nil {
 		return nil, err
 	}
@@ -595,7 +921,7 @@ func (pb *profileBusiness) GetContactByID(
 
 func (pb *profileBusiness) VerifyContact(
 	ctx context.Context,
-	contactID, verificationID, code string,
+	contactID, verificationID, code, mode string,
 	expiryDuration time.Duration,
 ) (string, error) {
 	contact, err := pb.contactBusiness.GetByID(ctx, contactID)
@@ -608,6 +934,7 @@ func (pb *profileBusiness) VerifyContact(
 		contact,
 		verificationID,
 		code,
+		mode,
 		expiryDuration,
 	)
 	if err != nil {
@@ -617,6 +944,54 @@ func (pb *profileBusiness) VerifyContact(
 	return verification.GetID(), nil
 }
 
+func (pb *profileBusiness) ConfirmVerificationToken(ctx context.Context, token string) (string, error) {
+	invalidToken := connect.NewError(connect.CodeInvalidArgument,
+		errors.New("the verification token is invalid or has expired"))
+
+	verificationID, code, err := models.ParseVerificationToken([]byte(pb.cfg.VerificationTokenSigningKey), token)
+	if err != nil {
+		util.Log(ctx).WithError(err).Debug("rejected verification token")
+		return "", invalidToken
+	}
+
+	// The token, not the caller, identifies the verification
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+
+	verification, err := pb.contactBusiness.GetVerification(unscopedCtx, verificationID)
+	if err != nil {
+		if data.ErrorIsNoRows(err) {
+			return "", invalidToken
+		}
+		return "", data.ErrorConvertToAPI(err)
+	}
+
+	if verification.Mode != models.VerificationModeLink ||
+		verification.ExpiresAt.Before(time.Now()) ||
+		!pb.codeMatches(verification, code) {
+		return "", invalidToken
+	}
+	if !verification.LockedAt.IsZero() {
+		return "", ErrTooManyVerificationAttempts
+	}
+
+	err = pb.contactBusiness.ConfirmVerification(unscopedCtx, verification)
+	if err != nil {
+		return "", err
+	}
+	return verification.GetID(), nil
+}
+
+// codeMatches checks code against the verification under each lookup key,
+// so codes sent before a lookup key rotation still verify.
+func (pb *profileBusiness) codeMatches(verification *models.Verification, code string) bool {
+	for _, key := range pb.dek.LookUpKeys() {
+		if verification.CodeMatches(key, code) {
+			return true
+		}
+	}
+	return false
+}
+
 func (pb *profileBusiness) CheckVerification(
 	ctx context.Context,
 	verificationID string,
@@ -630,13 +1005,6 @@ func (pb *profileBusiness) CheckVerification(
 		return 0, false, verifyErr
 	}
 
-	attempts, attemptsErr := pb.contactBusiness.GetVerificationAttempts(ctx, verificationID)
-	if attemptsErr != nil {
-		return 0, false, attemptsErr
-	}
-
-	verificationAttempts := len(attempts) + 1
-
 	deviceID := ""
 	claim := security.ClaimsFromContext(ctx)
 	if claim != nil {
@@ -645,8 +1013,8 @@ func (pb *profileBusiness) CheckVerification(
 
 	newAttempt := &models.VerificationAttempt{
 		VerificationID: verificationID,
-		Data:           code,
-		State:          "Fail",
+		Data:           models.HashVerificationCode(pb.dek.LookUpKey, verificationID, code),
+		State:          models.VerificationAttemptFail,
 		DeviceID:       deviceID,
 		IPAddress:      ipAddress,
 		RequestID:      util.GetRequestID(ctx),
@@ -654,18 +1022,101 @@ func (pb *profileBusiness) CheckVerification(
 
 	newAttempt.GenID(ctx)
 
+	// blocked rejects the attempt without its code having been looked at
+	blocked := func(attempts int) (int, bool, error) {
+		logger.WithField("ip_address", ipAddress).WithField("device_id", deviceID).
+			Warn("verification attempt blocked")
+		return attempts, false, ErrTooManyVerificationAttempts
+	}
+
+	if !verification.LockedAt.IsZero() {
+		newAttempt.State = models.VerificationAttemptBlocked
+		if recordErr := pb.contactBusiness.RecordVerificationAttempt(ctx, newAttempt); recordErr != nil {
+			return 0, false, recordErr
+		}
+		return blocked(verification.Attempts)
+	}
+
+	// Recorded before the throttling counts are taken, so parallel attempts
+	// each count the others.
+	if recordErr := pb.contactBusiness.RecordVerificationAttempt(ctx, newAttempt); recordErr != nil {
+		return 0, false, recordErr
+	}
+
+	throttled, throttleErr := pb.verificationThrottled(ctx, verification, deviceID, ipAddress)
+	if throttleErr != nil {
+		return 0, false, throttleErr
+	}
+
+	verificationAttempts := verification.Attempts
+	if !throttled {
+		// Counted before the code is checked, so parallel guesses each see
+		// the attempts made before them.
+		var attemptsErr error
+		verificationAttempts, attemptsErr = pb.contactBusiness.CountVerificationAttempt(ctx, verificationID)
+		if attemptsErr != nil {
+			return 0, false, attemptsErr
+		}
+		throttled = limitReached(verificationAttempts-1, pb.cfg.VerificationMaxAttempts)
+	}
+
 	codeMatches := false
-	if verification.Code == code {
-		newAttempt.State = "Success"
+	switch {
+	case throttled:
+		// Blocked attempts aren't counted, so retrying doesn't extend a lockout
+		stateErr := pb.contactBusiness.SetVerificationAttemptState(ctx, newAttempt, models.VerificationAttemptBlocked)
+		if stateErr != nil {
+			return 0, false, stateErr
+		}
+		return blocked(verificationAttempts)
+	case pb.codeMatches(verification, code):
+		stateErr := pb.contactBusiness.SetVerificationAttemptState(ctx, newAttempt, models.VerificationAttemptSuccess)
+		if stateErr != nil {
+			return 0, false, stateErr
+		}
 		codeMatches = true
+	case limitReached(verificationAttempts, pb.cfg.VerificationMaxAttempts):
+		// This was the last attempt allowed, so the code can no longer be guessed
+		if lockErr := pb.contactBusiness.LockVerification(ctx, verification); lockErr != nil {
+			return 0, false, lockErr
+		}
 	}
 
-	eventErr := pb.eventsMan.Emit(ctx, events.VerificationAttemptEventHandlerName, newAttempt)
-	if eventErr != nil {
-		logger.WithError(eventErr).Error("could not emit verification attempt event")
+	verified := codeMatches && !verification.ExpiresAt.Before(time.Now())
+	if verified && verification.VerifiedAt.IsZero() {
+		// The conditional confirmation lets only one matching attempt verify
+		// the contact.
+		if confirmErr := pb.contactBusiness.ConfirmVerification(ctx, verification); confirmErr != nil {
+			return verificationAttempts, false, confirmErr
+		}
 	}
 
-	verificationExpired := verification.ExpiresAt.Before(time.Now())
+	return verificationAttempts, verified, nil
+}
+
+// verificationThrottled reports whether an attempt at verification, already
+// recorded, must be rejected before its code is looked at because its
+// contact or the caller is being throttled. Throttling leaves the
+// verification alone, so guesses from elsewhere can't lock out the
+// contact's owner.
+func (pb *profileBusiness) verificationThrottled(
+	ctx context.Context,
+	verification *models.Verification,
+	deviceID, ipAddress string,
+) (bool, error) {
+	since := time.Now().Add(-time.Duration(pb.cfg.VerificationThrottleWindowInSec) * time.Second)
+	byContact, bySource, err := pb.contactBusiness.CountFailedVerificationAttempts(
+		ctx, verification.ContactID, deviceID, ipAddress, since)
+	if err != nil {
+		return false, err
+	}
+	// The counts include this attempt
+	return limitReached(byContact-1, pb.cfg.VerificationMaxContactAttempts) ||
+		limitReached(bySource-1, pb.cfg.VerificationMaxSourceAttempts), nil
+}
 
-	return verificationAttempts, codeMatches && !verificationExpired, nil
+// limitReached reports whether count has reached limit; a limit of zero or
+// less is unlimited.
+func limitReached(count, limit int) bool {
+	return limit > 0 && count >= limit
 }
@@ -3,11 +3,16 @@ package business_test
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"connectrpc.com/connect"
 	"github.com/pitabwire/frame/v2"
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/frame/v2/datastore"
@@ -72,6 +77,9 @@ func (pts *ProfileTestSuite) getProfileBusiness(
 	addressRepo := repository.NewAddressRepository(ctx, dbPool, workMan)
 	addressBusiness := business.NewAddressBusiness(ctx, addressRepo)
 
+	schemaRepo := repository.NewPropertySchemaRepository(ctx, dbPool, workMan)
+	schemaBusiness := business.NewPropertySchemaBusiness(ctx, schemaRepo)
+
 	profileRepo := repository.NewProfileRepository(ctx, dbPool, workMan)
 	propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, workMan)
 	return business.NewProfileBusiness(
@@ -81,6 +89,7 @@ func (pts *ProfileTestSuite) getProfileBusiness(
 		evtsMan,
 		contactBusiness,
 		addressBusiness,
+		schemaBusiness,
 		profileRepo,
 		propertyEntryRepo,
 	), verificationRepo
@@ -504,6 +513,259 @@ func (pts *ProfileTestSuite) Test_profileBusiness_MergeProfile() {
 		if finalProps["country"] != "Kenya" {
 			t.Errorf("MergeProfile() merged property not added, got = %v", finalProps["country"])
 		}
+
+		// The merged profile's contact is re-parented to the target
+		require.Len(t, merged.GetContacts(), 2)
+
+		// The merged profile no longer exists
+		_, err = pb.GetByID(ctx, merging.GetId())
+		require.Error(t, err)
+
+		// Contacts of the merged profile now resolve to the target
+		byContact, err := pb.GetByContact(ctx, "merge@testing.com")
+		require.NoError(t, err)
+		require.Equal(t, target.GetId(), byContact.GetId())
+
+		// Merged values are replayed onto the target ledger
+		history, err := pb.GetPropertyHistory(ctx, target.GetId(), "country", "")
+		require.NoError(t, err)
+		require.NotEmpty(t, history)
+		require.Equal(t, "Kenya", history[0].Value)
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_PreviewMerge() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		profiles, err := pts.CreateTestProfiles(ctx, pb,
+			[]string{"preview.target@testing.com", "preview.merge@testing.com"})
+		require.NoError(t, err)
+		target, merging := profiles[0], profiles[1]
+
+		_, err = pb.UpdateProfileProperties(ctx, target.GetId(), data.JSONMap{"country": "Uganda"}, false)
+		require.NoError(t, err)
+		_, err = pb.UpdateProfileProperties(ctx, merging.GetId(),
+			data.JSONMap{"country": "Kenya", "age": "25"}, false)
+		require.NoError(t, err)
+
+		request := &profilev1.MergeRequest{Id: target.GetId(), Mergeid: merging.GetId()}
+		preview, err := pb.PreviewMerge(ctx, request)
+		require.NoError(t, err)
+
+		require.Len(t, preview.PropertyConflicts, 1)
+		require.Equal(t, "country", preview.PropertyConflicts[0].Key)
+		require.Equal(t, "Uganda", preview.PropertyConflicts[0].TargetValue)
+		require.Equal(t, "Kenya", preview.PropertyConflicts[0].MergedValue)
+		require.Len(t, preview.MovedContactIDs, 1)
+
+		// Nothing was written
+		_, err = pb.GetByID(ctx, merging.GetId())
+		require.NoError(t, err)
+		byContact, err := pb.GetByContact(ctx, "preview.merge@testing.com")
+		require.NoError(t, err)
+		require.Equal(t, merging.GetId(), byContact.GetId())
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_UnmergeProfile() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		profiles, err := pts.CreateTestProfiles(ctx, pb,
+			[]string{"unmerge.target@testing.com", "unmerge.merge@testing.com"})
+		require.NoError(t, err)
+		target, merging := profiles[0], profiles[1]
+
+		_, err = pb.UpdateProfileProperties(ctx, target.GetId(), data.JSONMap{"country": "Uganda"}, false)
+		require.NoError(t, err)
+		_, err = pb.UpdateProfileProperties(ctx, merging.GetId(), data.JSONMap{"country": "Kenya"}, false)
+		require.NoError(t, err)
+
+		_, err = pb.MergeProfile(ctx, &profilev1.MergeRequest{Id: target.GetId(), Mergeid: merging.GetId()})
+		require.NoError(t, err)
+
+		restored, err := pb.UnmergeProfile(ctx, merging.GetId())
+		require.NoError(t, err)
+		require.Equal(t, merging.GetId(), restored.GetId())
+		require.Equal(t, "Kenya", restored.GetProperties().AsMap()["country"])
+		require.Len(t, restored.GetContacts(), 1)
+
+		byContact, err := pb.GetByContact(ctx, "unmerge.merge@testing.com")
+		require.NoError(t, err)
+		require.Equal(t, merging.GetId(), byContact.GetId())
+
+		reverted, err := pb.GetByID(ctx, target.GetId())
+		require.NoError(t, err)
+		require.Equal(t, "Uganda", reverted.GetProperties().AsMap()["country"])
+		require.Len(t, reverted.GetContacts(), 1)
+
+		// A merge can only be undone once
+		_, err = pb.UnmergeProfile(ctx, merging.GetId())
+		require.Error(t, err)
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_UnmergeProfile_Chained() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		profiles, err := pts.CreateTestProfiles(ctx, pb,
+			[]string{"chained.a@testing.com", "chained.b@testing.com", "chained.c@testing.com"})
+		require.NoError(t, err)
+		a, b, c := profiles[0], profiles[1], profiles[2]
+
+		// A into B, then B into C
+		_, err = pb.MergeProfile(ctx, &profilev1.MergeRequest{Id: b.GetId(), Mergeid: a.GetId()})
+		require.NoError(t, err)
+		_, err = pb.MergeProfile(ctx, &profilev1.MergeRequest{Id: c.GetId(), Mergeid: b.GetId()})
+		require.NoError(t, err)
+
+		// A's rows went on to C with B, so A can't be restored from B
+		_, err = pb.UnmergeProfile(ctx, a.GetId())
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		// Undoing the later merge first lets A's be replayed
+		_, err = pb.UnmergeProfile(ctx, b.GetId())
+		require.NoError(t, err)
+		restored, err := pb.UnmergeProfile(ctx, a.GetId())
+		require.NoError(t, err)
+		require.Len(t, restored.GetContacts(), 1)
+
+		byContact, err := pb.GetByContact(ctx, "chained.a@testing.com")
+		require.NoError(t, err)
+		require.Equal(t, a.GetId(), byContact.GetId())
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_EraseProfile() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		profiles, err := pts.CreateTestProfiles(ctx, pb,
+			[]string{"erase.target@testing.com", "erase.merge@testing.com", "erase.keep@testing.com"})
+		require.NoError(t, err)
+		target, merging, kept := profiles[0], profiles[1], profiles[2]
+
+		_, err = pb.UpdateProfileProperties(ctx, target.GetId(), data.JSONMap{"country": "Uganda"}, false)
+		require.NoError(t, err)
+		_, err = pb.MergeProfile(ctx, &profilev1.MergeRequest{Id: target.GetId(), Mergeid: merging.GetId()})
+		require.NoError(t, err)
+
+		erasure, err := pb.EraseProfile(ctx, target.GetId())
+		require.NoError(t, err)
+		require.Equal(t, target.GetId(), erasure.ProfileID)
+		require.EqualValues(t, 2, erasure.Erased["profiles"])
+		require.EqualValues(t, 2, erasure.Erased["contacts"])
+
+		_, err = pb.GetByID(ctx, target.GetId())
+		require.Error(t, err)
+		_, err = pb.GetByContact(ctx, "erase.target@testing.com")
+		require.Error(t, err)
+		_, err = pb.GetByContact(ctx, "erase.merge@testing.com")
+		require.Error(t, err)
+
+		// Other profiles are untouched
+		keptProfile, err := pb.GetByID(ctx, kept.GetId())
+		require.NoError(t, err)
+		require.Len(t, keptProfile.GetContacts(), 1)
+
+		// Erasing again returns the same record
+		again, err := pb.EraseProfile(ctx, target.GetId())
+		require.NoError(t, err)
+		require.Equal(t, erasure.GetID(), again.GetID())
+
+		_, err = pb.EraseProfile(ctx, util.IDString())
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileExportBusiness_Export() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		cfg := svc.Config().(*config.ProfileConfig)
+		eb := business.NewProfileExportBusiness(ctx, cfg, createProfileTestDEK(cfg), svc.EventsManager(),
+			repository.NewProfileRepository(ctx, dbPool, workMan),
+			repository.NewPropertyEntryRepository(ctx, dbPool, workMan),
+			repository.NewProfileExportRepository(ctx, dbPool, workMan))
+
+		profiles, err := pts.CreateTestProfiles(ctx, pb, []string{"export.subject@testing.com"})
+		require.NoError(t, err)
+		subject := profiles[0]
+
+		_, err = pb.UpdateProfileProperties(ctx, subject.GetId(), data.JSONMap{"country": "Uganda"}, false)
+		require.NoError(t, err)
+
+		bundle, err := eb.ExportProfileData(ctx, subject.GetId())
+		require.NoError(t, err)
+		require.Equal(t, subject.GetId(), bundle.ProfileID)
+		require.Len(t, bundle.Contacts, 1)
+		require.Equal(t, "export.subject@testing.com", bundle.Contacts[0]["detail"])
+		require.NotEmpty(t, bundle.PropertyHistory)
+
+		export, err := eb.StartExport(ctx, subject.GetId())
+		require.NoError(t, err)
+		require.Equal(t, models.ProfileExportPending, export.Status)
+
+		completed, err := eb.RunExports(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, completed)
+
+		ready, stored, err := eb.GetExport(ctx, export.GetID())
+		require.NoError(t, err)
+		require.Equal(t, models.ProfileExportReady, ready.Status)
+		require.Equal(t, bundle.Contacts[0]["detail"], stored.Contacts[0]["detail"])
+
+		// Finished exports are not run again
+		completed, err = eb.RunExports(ctx)
+		require.NoError(t, err)
+		require.Zero(t, completed)
+
+		_, err = eb.StartExport(ctx, util.IDString())
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+		_, err = eb.ExportProfileData(ctx, "")
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		// Other tenants can't export the profile
+		otherCtx := pts.WithAuthClaims(ctx, util.IDString(), util.IDString(), util.IDString())
+		_, err = eb.ExportProfileData(otherCtx, subject.GetId())
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_MergeProfile_IntoItself() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		profiles, err := pts.CreateTestProfiles(ctx, pb, []string{"self.merge@testing.com"})
+		require.NoError(t, err)
+
+		_, err = pb.MergeProfile(ctx, &profilev1.MergeRequest{
+			Id:      profiles[0].GetId(),
+			Mergeid: profiles[0].GetId(),
+		})
+		require.Error(t, err)
 	})
 }
 
@@ -581,7 +843,7 @@ func (pts *ProfileTestSuite) Test_profileBusiness_VerifyContact() {
 		}
 
 		contactID := profile.GetContacts()[0].GetId()
-		verificationID, err := pb.VerifyContact(ctx, contactID, "", "123456", 0)
+		verificationID, err := pb.VerifyContact(ctx, contactID, "", "123456", "", 0)
 		if err != nil {
 			t.Errorf("VerifyContact() error = %v", err)
 			return
@@ -593,6 +855,65 @@ func (pts *ProfileTestSuite) Test_profileBusiness_VerifyContact() {
 	})
 }
 
+func (pts *ProfileTestSuite) Test_profileBusiness_ConfirmVerificationToken() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, verificationRepo := pts.getProfileBusiness(ctx, svc)
+		cfg := svc.Config().(*config.ProfileConfig)
+
+		profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:    profilev1.ProfileType_PERSON,
+			Contact: "magic.link@testing.com",
+		})
+		require.NoError(t, err)
+		contactID := profile.GetContacts()[0].GetId()
+
+		const code = "a-link-code-nobody-types-in"
+		verificationID, err := pb.VerifyContact(ctx, contactID, "", code, models.VerificationModeLink, 0)
+		require.NoError(t, err)
+
+		verification, err := tests.WaitForConditionWithResult(ctx, func() (*models.Verification, error) {
+			return verificationRepo.GetByID(ctx, verificationID)
+		}, 5*time.Second, 100*time.Millisecond)
+		require.NoError(t, err)
+		require.Equal(t, models.VerificationModeLink, verification.Mode)
+
+		token, err := verification.SignToken([]byte(cfg.VerificationTokenSigningKey), code)
+		require.NoError(t, err)
+
+		_, err = pb.ConfirmVerificationToken(ctx, token+"x")
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		forged, err := verification.SignToken([]byte(cfg.VerificationTokenSigningKey), "another-code")
+		require.NoError(t, err)
+		_, err = pb.ConfirmVerificationToken(ctx, forged)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		confirmedID, err := pb.ConfirmVerificationToken(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, verificationID, confirmedID)
+
+		stored, err := verificationRepo.GetByID(ctx, verificationID)
+		require.NoError(t, err)
+		require.False(t, stored.VerifiedAt.IsZero())
+
+		// Tokens are single use
+		_, err = pb.ConfirmVerificationToken(ctx, token)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		// Only email contacts can be verified by link
+		phoneProfile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:    profilev1.ProfileType_PERSON,
+			Contact: "+256700000123",
+		})
+		require.NoError(t, err)
+		_, err = pb.VerifyContact(ctx, phoneProfile.GetContacts()[0].GetId(), "", "", models.VerificationModeLink, 0)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
 func (pts *ProfileTestSuite) Test_profileBusiness_CheckVerification_Success() {
 	t := pts.T()
 
@@ -622,7 +943,7 @@ func (pts *ProfileTestSuite) Test_profileBusiness_CheckVerification_Success() {
 		}
 
 		contactID := profile.GetContacts()[0].GetId()
-		verificationID, err := pb.VerifyContact(ctx, contactID, "", "123456", 2*time.Second)
+		verificationID, err := pb.VerifyContact(ctx, contactID, "", "123456", "", 2*time.Second)
 		if err != nil {
 			t.Errorf("VerifyContact() error = %v", err)
 			return
@@ -652,6 +973,10 @@ func (pts *ProfileTestSuite) Test_profileBusiness_CheckVerification_Success() {
 		if !verified {
 			t.Errorf("CheckVerification() verified = %v, want = true", verified)
 		}
+
+		stored, err := verificationRepo.GetByID(ctx, verificationID)
+		require.NoError(t, err)
+		require.False(t, stored.VerifiedAt.IsZero())
 	})
 }
 
@@ -681,18 +1006,14 @@ func (pts *ProfileTestSuite) Test_profileBusiness_CheckVerification_WrongCode()
 		}
 
 		// Check verification with wrong code
-		// Note: Since verification attempts are processed asynchronously via events,
-		// and the event system may not be fully reliable in test environment,
-		// we expect attempts to still be 1 (as the first attempt may not be persisted yet)
 		attempts, verified, err = pb.CheckVerification(ctx, verificationID, "wrong", "192.168.1.1")
 		if err != nil {
 			t.Errorf("CheckVerification() error = %v", err)
 			return
 		}
 
-		// The attempts count may be 1 or 2 depending on whether the first attempt was processed
-		if attempts < 1 || attempts > 2 {
-			t.Errorf("CheckVerification() attempts = %v, want between 1 and 2", attempts)
+		if attempts != 2 {
+			t.Errorf("CheckVerification() attempts = %v, want = 2", attempts)
 		}
 
 		if verified {
@@ -701,6 +1022,153 @@ func (pts *ProfileTestSuite) Test_profileBusiness_CheckVerification_WrongCode()
 	})
 }
 
+func (pts *ProfileTestSuite) Test_profileBusiness_CheckVerification_Lockout() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, verificationRepo := pts.getProfileBusiness(ctx, svc)
+
+		cfg := svc.Config().(*config.ProfileConfig)
+		cfg.VerificationMaxAttempts = 3
+		cfg.VerificationMaxSourceAttempts = 5
+
+		verificationID := pts.setupVerificationForTest(ctx, t, pb, verificationRepo, "lockout@example.com")
+
+		for i := 1; i <= cfg.VerificationMaxAttempts; i++ {
+			_, verified, err := pb.CheckVerification(ctx, verificationID, "wrong", "10.0.0.1")
+			require.NoError(t, err)
+			require.False(t, verified)
+
+			// Attempts are recorded before CheckVerification returns
+			attempts, attemptsErr := verificationRepo.GetAttempts(ctx, verificationID)
+			require.NoError(t, attemptsErr)
+			require.Len(t, attempts, i)
+		}
+
+		// The last allowed failure locked the verification, so even the right code fails
+		_, verified, err := pb.CheckVerification(ctx, verificationID, "123456", "10.0.0.2")
+		require.ErrorIs(t, err, business.ErrTooManyVerificationAttempts)
+		require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+		require.False(t, verified)
+
+		verification, err := verificationRepo.GetByID(ctx, verificationID)
+		require.NoError(t, err)
+		require.False(t, verification.LockedAt.IsZero())
+		require.True(t, verification.VerifiedAt.IsZero())
+
+		// The same address is throttled on a fresh verification too
+		otherID := pts.setupVerificationForTest(ctx, t, pb, verificationRepo, "lockout.other@example.com")
+		for range 2 {
+			_, _, err = pb.CheckVerification(ctx, otherID, "wrong", "10.0.0.1")
+			require.NoError(t, err)
+		}
+
+		_, _, err = pb.CheckVerification(ctx, otherID, "123456", "10.0.0.1")
+		require.ErrorIs(t, err, business.ErrTooManyVerificationAttempts)
+
+		// Throttling rejects the caller without locking the verification
+		other, err := verificationRepo.GetByID(ctx, otherID)
+		require.NoError(t, err)
+		require.True(t, other.LockedAt.IsZero())
+		_, verified, err = pb.CheckVerification(ctx, otherID, "123456", "10.0.0.3")
+		require.NoError(t, err)
+		require.True(t, verified)
+
+		// Parallel guesses can't share a count to get past the limit
+		cfg.VerificationMaxSourceAttempts = 0
+		cfg.VerificationMaxContactAttempts = 0
+		parallelID := pts.setupVerificationForTest(ctx, t, pb, verificationRepo, "lockout.parallel@example.com")
+		const guesses = 10
+		var (
+			wg      sync.WaitGroup
+			blocked atomic.Int32
+		)
+		for i := range guesses {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, guessErr := pb.CheckVerification(ctx, parallelID, "wrong", fmt.Sprintf("10.0.1.%d", i))
+				if errors.Is(guessErr, business.ErrTooManyVerificationAttempts) {
+					blocked.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		require.EqualValues(t, guesses-cfg.VerificationMaxAttempts, blocked.Load())
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_CheckVerification_Throttle() {
+	t := pts.T()
+
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		pb, verificationRepo := pts.getProfileBusiness(ctx, svc)
+
+		cfg := svc.Config().(*config.ProfileConfig)
+		cfg.VerificationMaxAttempts = 0
+		cfg.VerificationMaxContactAttempts = 0
+		cfg.VerificationMaxSourceAttempts = 2
+		cfg.VerificationThrottleWindowInSec = 2
+
+		verificationID := pts.setupVerificationForTest(ctx, t, pb, verificationRepo, "throttle@example.com")
+		for range cfg.VerificationMaxSourceAttempts {
+			_, _, err := pb.CheckVerification(ctx, verificationID, "wrong", "10.0.2.1")
+			require.NoError(t, err)
+		}
+		windowEnds := time.Now().Add(time.Duration(cfg.VerificationThrottleWindowInSec) * time.Second)
+
+		// Retrying while throttled is recorded as blocked and doesn't extend
+		// the lockout past the window of the failed attempts
+		retries := 0
+		for time.Now().Before(windowEnds) {
+			_, _, err := pb.CheckVerification(ctx, verificationID, "wrong", "10.0.2.1")
+			require.ErrorIs(t, err, business.ErrTooManyVerificationAttempts)
+			retries++
+			time.Sleep(200 * time.Millisecond)
+		}
+		_, _, err := pb.CheckVerification(ctx, verificationID, "wrong", "10.0.2.1")
+		require.NoError(t, err)
+
+		attempts, err := verificationRepo.GetAttempts(ctx, verificationID)
+		require.NoError(t, err)
+		states := map[string]int{}
+		for _, attempt := range attempts {
+			states[attempt.State]++
+		}
+		require.Equal(t, map[string]int{
+			models.VerificationAttemptFail:    cfg.VerificationMaxSourceAttempts + 1,
+			models.VerificationAttemptBlocked: retries,
+		}, states)
+
+		// Parallel guesses from one source each count the others, so no more
+		// than the limit get their code checked
+		cfg.VerificationThrottleWindowInSec = 3600
+		parallelID := pts.setupVerificationForTest(ctx, t, pb, verificationRepo, "throttle.parallel@example.com")
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, _ = pb.CheckVerification(ctx, parallelID, "wrong", "10.0.2.2")
+			}()
+		}
+		wg.Wait()
+
+		attempts, err = verificationRepo.GetAttempts(ctx, parallelID)
+		require.NoError(t, err)
+		require.Len(t, attempts, 10)
+		failed := 0
+		for _, attempt := range attempts {
+			if attempt.State == models.VerificationAttemptFail {
+				failed++
+			}
+		}
+		require.LessOrEqual(t, failed, cfg.VerificationMaxSourceAttempts)
+	})
+}
+
 func (pts *ProfileTestSuite) setupVerificationForTest(
 	ctx context.Context,
 	t *testing.T,
@@ -730,7 +1198,7 @@ func (pts *ProfileTestSuite) setupVerificationForTest(
 	}
 
 	contactID := profile.GetContacts()[0].GetId()
-	verificationID, err := pb.VerifyContact(ctx, contactID, "", "123456", 2*time.Second)
+	verificationID, err := pb.VerifyContact(ctx, contactID, "", "123456", "", 2*time.Second)
 	if err != nil {
 		t.Errorf("VerifyContact() error = %v", err)
 		return ""
@@ -873,6 +1341,15 @@ func (pts *ProfileTestSuite) Test_profileBusiness_AddContact() {
 		require.NoError(t, err)
 		require.NotNil(t, updatedProfile2)
 		require.Empty(t, verificationID2, "should not create verification for existing contact")
+
+		// Contacts with nowhere to send a code are added unverified
+		updatedProfile3, verificationID3, err := pb.AddContact(ctx, &profilev1.AddContactRequest{
+			Id:      profile.GetId(),
+			Contact: "push:fcm:YWRkY29udGFjdC10b2tlbg",
+		})
+		require.NoError(t, err)
+		require.Empty(t, verificationID3)
+		require.Len(t, updatedProfile3.GetContacts(), 3)
 	})
 }
 
@@ -1017,6 +1494,377 @@ func (pts *ProfileTestSuite) Test_profileBusiness_UpdateProperties_Scoped() {
 	})
 }
 
+func (pts *ProfileTestSuite) Test_profileBusiness_UpdateProperties_Typed() {
+	t := pts.T()
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		tenantID := util.IDString()
+		partitionID := util.IDString()
+		ctx = pts.WithAuthClaims(ctx, tenantID, partitionID, util.IDString())
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:    profilev1.ProfileType_PERSON,
+			Contact: "props.typed@testing.com",
+		})
+		require.NoError(t, err)
+
+		updated, err := pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{
+			"age":     float64(30),
+			"active":  true,
+			"tags":    []any{"a", "b"},
+			"address": map[string]any{"city": "Kampala"},
+		}, false)
+		require.NoError(t, err)
+
+		properties := updated.GetProperties().AsMap()
+		require.InDelta(t, float64(30), properties["age"], 0)
+		require.Equal(t, true, properties["active"])
+		require.Equal(t, []any{"a", "b"}, properties["tags"])
+		require.Equal(t, map[string]any{"city": "Kampala"}, properties["address"])
+
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"limit": float64(500)}, true)
+		require.NoError(t, err)
+
+		got, err := pb.GetByIDAndPartition(ctx, profile.GetId(), partitionID)
+		require.NoError(t, err)
+		require.InDelta(t, float64(500), got.GetProperties().AsMap()["limit"], 0)
+		require.Equal(t, true, got.GetProperties().AsMap()["active"])
+
+		history, err := pb.GetPropertyHistory(ctx, profile.GetId(), "address", tenantID)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, models.PropertyValueObject, history[0].ValueType)
+		require.JSONEq(t, `{"city":"Kampala"}`, history[0].Value)
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_UpdateProperties_Schema() {
+	t := pts.T()
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		tenantID := util.IDString()
+		partitionID := util.IDString()
+		ctx = pts.WithAuthClaims(ctx, tenantID, partitionID, util.IDString())
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		schemaBusiness := business.NewPropertySchemaBusiness(ctx,
+			repository.NewPropertySchemaRepository(ctx, dbPool, svc.WorkManager()))
+
+		_, err := schemaBusiness.Save(ctx, &models.PropertySchema{
+			Key: "national_id", ValueType: models.PropertyValueString,
+			Required: true, Pattern: `^[A-Z]{2}\d{6}$`, PII: true,
+		})
+		require.NoError(t, err)
+		_, err = schemaBusiness.Save(ctx, &models.PropertySchema{
+			Key: "risk_tier", Enum: []any{"low", "high"}, Scoped: true,
+		})
+		require.NoError(t, err)
+		_, err = schemaBusiness.Save(ctx, &models.PropertySchema{Key: "age", ValueType: "decimal"})
+		require.Error(t, err)
+
+		// Required keys must be supplied on create
+		_, err = pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:    profilev1.ProfileType_PERSON,
+			Contact: "props.schema.missing@testing.com",
+		})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		require.ErrorContains(t, err, "national_id is required")
+
+		requestProps := data.JSONMap{"national_id": "UG123456"}
+		profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:       profilev1.ProfileType_PERSON,
+			Contact:    "props.schema@testing.com",
+			Properties: requestProps.ToProtoStruct(),
+		})
+		require.NoError(t, err)
+
+		// Every violating key is reported
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(),
+			data.JSONMap{"national_id": "123", "risk_tier": "medium"}, false)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		require.ErrorContains(t, err, "national_id does not match pattern")
+		require.ErrorContains(t, err, "risk_tier is not one of the allowed values")
+
+		// Schema-scoped keys stay out of the global cache
+		updated, err := pb.UpdateProfileProperties(ctx, profile.GetId(),
+			data.JSONMap{"risk_tier": "high"}, false)
+		require.NoError(t, err)
+		require.NotContains(t, updated.GetProperties().AsMap(), "risk_tier")
+
+		got, err := pb.GetByIDAndPartition(ctx, profile.GetId(), partitionID)
+		require.NoError(t, err)
+		require.Equal(t, "high", got.GetProperties().AsMap()["risk_tier"])
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_UpdateProperties_Remove() {
+	t := pts.T()
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		tenantID := util.IDString()
+		partitionID := util.IDString()
+		ctx = pts.WithAuthClaims(ctx, tenantID, partitionID, util.IDString())
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:    profilev1.ProfileType_PERSON,
+			Contact: "props.remove@testing.com",
+		})
+		require.NoError(t, err)
+
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(),
+			data.JSONMap{"nickname": "Jay", "city": "Kampala"}, false)
+		require.NoError(t, err)
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"segment": "gold"}, true)
+		require.NoError(t, err)
+
+		// Removals and sets travel in the same update
+		updateProps := data.JSONMap{"nickname": nil, "city": "Entebbe"}
+		updated, err := pb.UpdateProfile(ctx, &profilev1.UpdateRequest{
+			Id:         profile.GetId(),
+			Properties: updateProps.ToProtoStruct(),
+		})
+		require.NoError(t, err)
+		require.NotContains(t, updated.GetProperties().AsMap(), "nickname")
+		require.Equal(t, "Entebbe", updated.GetProperties().AsMap()["city"])
+
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"segment": nil}, true)
+		require.NoError(t, err)
+		got, err := pb.GetByIDAndPartition(ctx, profile.GetId(), partitionID)
+		require.NoError(t, err)
+		require.NotContains(t, got.GetProperties().AsMap(), "segment")
+
+		history, err := pb.GetPropertyHistory(ctx, profile.GetId(), "nickname", tenantID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.True(t, history[0].Tombstone)
+		require.NotEmpty(t, history[0].CreatedBy)
+		require.Equal(t, "Jay", history[1].Value)
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_GetProfileAsOf() {
+	t := pts.T()
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		tenantID := util.IDString()
+		partitionID := util.IDString()
+		ctx = pts.WithAuthClaims(ctx, tenantID, partitionID, util.IDString())
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		beforeCreate := time.Now().Add(-time.Minute)
+		createProps := data.JSONMap{"tier": "bronze"}
+		profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:       profilev1.ProfileType_PERSON,
+			Contact:    "props.asof@testing.com",
+			Properties: createProps.ToProtoStruct(),
+		})
+		require.NoError(t, err)
+
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"limit": float64(100)}, true)
+		require.NoError(t, err)
+
+		// created_at has second resolution
+		time.Sleep(1100 * time.Millisecond)
+		asOf := time.Now()
+		time.Sleep(1100 * time.Millisecond)
+
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(),
+			data.JSONMap{"tier": "gold", "vip": true}, false)
+		require.NoError(t, err)
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"limit": nil}, true)
+		require.NoError(t, err)
+
+		past, err := pb.GetProfileAsOf(ctx, profile.GetId(), partitionID, asOf)
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"tier": "bronze", "limit": float64(100)}, past.GetProperties().AsMap())
+
+		// Without a partition only global properties are replayed
+		past, err = pb.GetProfileAsOf(ctx, profile.GetId(), "", asOf)
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"tier": "bronze"}, past.GetProperties().AsMap())
+
+		now, err := pb.GetProfileAsOf(ctx, profile.GetId(), partitionID, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"tier": "gold", "vip": true}, now.GetProperties().AsMap())
+
+		_, err = pb.GetProfileAsOf(ctx, profile.GetId(), partitionID, beforeCreate)
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_GetProfileAsOf_Legacy() {
+	t := pts.T()
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		partitionID := util.IDString()
+		ctx = pts.WithAuthClaims(ctx, util.IDString(), partitionID, util.IDString())
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		createProps := data.JSONMap{"tier": "bronze"}
+		profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:       profilev1.ProfileType_PERSON,
+			Contact:    "props.legacy@testing.com",
+			Properties: createProps.ToProtoStruct(),
+		})
+		require.NoError(t, err)
+
+		// Profiles created before the ledger only have their stored properties
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		err = dbPool.DB(ctx, false).Exec("DELETE FROM property_entries WHERE profile_id = ?", profile.GetId()).Error
+		require.NoError(t, err)
+
+		past, err := pb.GetProfileAsOf(ctx, profile.GetId(), "", time.Now())
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"tier": "bronze"}, past.GetProperties().AsMap())
+
+		// Scoped entries written since still apply over them
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"limit": float64(100)}, true)
+		require.NoError(t, err)
+
+		past, err = pb.GetProfileAsOf(ctx, profile.GetId(), partitionID, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"tier": "bronze", "limit": float64(100)}, past.GetProperties().AsMap())
+
+		_, err = pb.GetProfileAsOf(ctx, profile.GetId(), partitionID, time.Now().Add(-time.Minute))
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
+func (pts *ProfileTestSuite) Test_propertyCompactionBusiness_RunCompaction() {
+	t := pts.T()
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		tenantID := util.IDString()
+		partitionID := util.IDString()
+		ctx = pts.WithAuthClaims(ctx, tenantID, partitionID, util.IDString())
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		cfg := svc.Config().(*config.ProfileConfig)
+		cfg.PropertyRetentionInSec = 1
+		cfg.PropertyCompactionMinEntries = 1
+
+		createProps := data.JSONMap{"tier": "bronze"}
+		profile, err := pb.CreateProfile(ctx, &profilev1.CreateRequest{
+			Type:       profilev1.ProfileType_PERSON,
+			Contact:    "props.compaction@testing.com",
+			Properties: createProps.ToProtoStruct(),
+		})
+		require.NoError(t, err)
+
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"tier": "silver"}, false)
+		require.NoError(t, err)
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"limit": float64(100)}, true)
+		require.NoError(t, err)
+
+		// created_at has second resolution
+		time.Sleep(1100 * time.Millisecond)
+		beforeHorizon := time.Now()
+		time.Sleep(2200 * time.Millisecond)
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, svc.WorkManager())
+		compaction := business.NewPropertyCompactionBusiness(ctx, cfg, propertyEntryRepo)
+		require.NoError(t, compaction.RunCompaction(ctx))
+
+		_, err = pb.UpdateProfileProperties(ctx, profile.GetId(), data.JSONMap{"tier": "gold"}, false)
+		require.NoError(t, err)
+
+		current, err := pb.GetProfileAsOf(ctx, profile.GetId(), partitionID, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"tier": "gold", "limit": float64(100)}, current.GetProperties().AsMap())
+
+		// Compacted values survive as a single history entry
+		history, err := pb.GetPropertyHistory(ctx, profile.GetId(), "tier", tenantID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, "gold", history[0].Value)
+		require.Equal(t, "silver", history[1].Value)
+
+		_, err = pb.GetProfileAsOf(ctx, profile.GetId(), partitionID, beforeHorizon)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+	})
+}
+
+func (pts *ProfileTestSuite) Test_profileBusiness_MergeProfile_CompactedProperties() {
+	t := pts.T()
+	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := pts.CreateService(t, dep)
+		partitionID := util.IDString()
+		ctx = pts.WithAuthClaims(ctx, util.IDString(), partitionID, util.IDString())
+		pb, _ := pts.getProfileBusiness(ctx, svc)
+
+		cfg := svc.Config().(*config.ProfileConfig)
+		cfg.PropertyRetentionInSec = 1
+		cfg.PropertyCompactionMinEntries = 1
+
+		profiles, err := pts.CreateTestProfiles(ctx, pb,
+			[]string{"compacted.target@testing.com", "compacted.merge@testing.com"})
+		require.NoError(t, err)
+		target, merging := profiles[0], profiles[1]
+
+		_, err = pb.UpdateProfileProperties(ctx, target.GetId(),
+			data.JSONMap{"city": "Kampala", "tier": "bronze"}, false)
+		require.NoError(t, err)
+		_, err = pb.UpdateProfileProperties(ctx, merging.GetId(),
+			data.JSONMap{"nickname": "Jo", "tier": "gold"}, false)
+		require.NoError(t, err)
+
+		// created_at has second resolution
+		time.Sleep(3300 * time.Millisecond)
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, svc.WorkManager())
+		compaction := business.NewPropertyCompactionBusiness(ctx, cfg, propertyEntryRepo)
+		require.NoError(t, compaction.RunCompaction(ctx))
+
+		// The merged profile's entry stays in the ledger while target is
+		// compacted past it
+		_, err = pb.UpdateProfileProperties(ctx, merging.GetId(), data.JSONMap{"motto": "onwards"}, false)
+		require.NoError(t, err)
+		time.Sleep(1100 * time.Millisecond)
+		_, err = pb.UpdateProfileProperties(ctx, target.GetId(), data.JSONMap{"city": "Entebbe"}, false)
+		require.NoError(t, err)
+		_, err = pb.UpdateProfileProperties(ctx, target.GetId(), data.JSONMap{"tier": "silver"}, false)
+		require.NoError(t, err)
+		time.Sleep(2200 * time.Millisecond)
+
+		cfg.PropertyCompactionMinEntries = 2
+		require.NoError(t, compaction.RunCompaction(ctx))
+
+		_, err = pb.MergeProfile(ctx, &profilev1.MergeRequest{Id: target.GetId(), Mergeid: merging.GetId()})
+		require.NoError(t, err)
+
+		merged, err := pb.GetProfileAsOf(ctx, target.GetId(), partitionID, time.Now())
+		require.NoError(t, err)
+		properties := merged.GetProperties().AsMap()
+		require.Equal(t, "Entebbe", properties["city"])
+		require.Equal(t, "gold", properties["tier"])
+		require.Equal(t, "Jo", properties["nickname"])
+		require.Equal(t, "onwards", properties["motto"])
+
+		_, err = pb.UnmergeProfile(ctx, merging.GetId())
+		require.NoError(t, err)
+
+		restored, err := pb.GetProfileAsOf(ctx, merging.GetId(), partitionID, time.Now())
+		require.NoError(t, err)
+		properties = restored.GetProperties().AsMap()
+		require.Equal(t, "gold", properties["tier"])
+		require.Equal(t, "Jo", properties["nickname"])
+		require.Equal(t, "onwards", properties["motto"])
+
+		reverted, err := pb.GetProfileAsOf(ctx, target.GetId(), partitionID, time.Now())
+		require.NoError(t, err)
+		properties = reverted.GetProperties().AsMap()
+		require.Equal(t, "Entebbe", properties["city"])
+		require.Equal(t, "silver", properties["tier"])
+		require.NotContains(t, properties, "nickname")
+		require.NotContains(t, properties, "motto")
+	})
+}
+
 func (pts *ProfileTestSuite) Test_profileBusiness_GetPropertyHistory() {
 	t := pts.T()
 	pts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
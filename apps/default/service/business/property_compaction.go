@@ -0,0 +1,90 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Property compaction defaults.
+const (
+	compactionScopeBatchSize  = 200
+	defaultCompactionInterval = 24 * time.Hour
+	defaultPropertyRetention  = 90 * 24 * time.Hour
+)
+
+// PropertyCompactionBusiness folds old property ledger entries into
+// per-profile snapshots so reads do not scan a profile's full history.
+type PropertyCompactionBusiness interface {
+	// RunCompaction folds entries older than the retention window for every
+	// profile holding at least PropertyCompactionMinEntries of them.
+	RunCompaction(ctx context.Context) error
+	Scheduler
+}
+
+func NewPropertyCompactionBusiness(_ context.Context, cfg *config.ProfileConfig,
+	propertyEntryRepo repository.PropertyEntryRepository) PropertyCompactionBusiness {
+	return &propertyCompactionBusiness{
+		cfg:               cfg,
+		propertyEntryRepo: propertyEntryRepo,
+	}
+}
+
+type propertyCompactionBusiness struct {
+	cfg               *config.ProfileConfig
+	propertyEntryRepo repository.PropertyEntryRepository
+}
+
+// StartScheduler runs RunCompaction immediately, then every
+// PropertyCompactionIntervalInSec.
+func (pcb *propertyCompactionBusiness) StartScheduler(ctx context.Context, locker repository.JobLocker) {
+	runScheduled(ctx, locker, "property_compaction",
+		time.Duration(pcb.cfg.PropertyCompactionIntervalInSec)*time.Second, defaultCompactionInterval,
+		pcb.RunCompaction)
+}
+
+func (pcb *propertyCompactionBusiness) RunCompaction(ctx context.Context) error {
+	log := util.Log(ctx)
+
+	retention := time.Duration(pcb.cfg.PropertyRetentionInSec) * time.Second
+	if retention <= 0 {
+		retention = defaultPropertyRetention
+	}
+	now := time.Now()
+	horizon := now.Add(-retention)
+	unmergeableBefore := now.Add(-time.Duration(pcb.cfg.UnmergeWindowInSec) * time.Second)
+
+	totalFolded := 0
+	for {
+		scopes, err := pcb.propertyEntryRepo.CompactionCandidates(
+			ctx, horizon, unmergeableBefore, pcb.cfg.PropertyCompactionMinEntries, compactionScopeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		compacted := 0
+		for _, scope := range scopes {
+			folded, compactErr := pcb.propertyEntryRepo.Compact(ctx, scope, horizon)
+			if compactErr != nil {
+				// Left for the next run.
+				log.WithError(compactErr).
+					WithField("profile_id", scope.ProfileID).
+					Error("could not compact property entries")
+				continue
+			}
+			compacted++
+			totalFolded += folded
+		}
+
+		if len(scopes) < compactionScopeBatchSize || compacted == 0 {
+			break
+		}
+	}
+
+	log.WithField("entries", totalFolded).Info("property compaction run complete")
+	return nil
+}
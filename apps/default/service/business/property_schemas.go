@@ -0,0 +1,176 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/v2/data"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// PropertySchemaBusiness manages the caller tenant's property schemas and
+// checks profile properties against them.
+type PropertySchemaBusiness interface {
+	List(ctx context.Context) ([]*models.PropertySchema, error)
+	// Save creates or replaces the schema for schema.Key.
+	Save(ctx context.Context, schema *models.PropertySchema) (*models.PropertySchema, error)
+	Delete(ctx context.Context, key string) error
+
+	// Validate checks properties against the tenant's schemas and returns
+	// the schemas that apply, by key. When creating, required keys absent
+	// from properties are reported too. Violations are returned together
+	// as an InvalidArgument error carrying one field violation per key.
+	Validate(
+		ctx context.Context,
+		properties data.JSONMap,
+		creating bool,
+	) (map[string]*models.PropertySchema, error)
+}
+
+func NewPropertySchemaBusiness(_ context.Context,
+	schemaRepo repository.PropertySchemaRepository) PropertySchemaBusiness {
+	return &propertySchemaBusiness{
+		schemaRepo: schemaRepo,
+	}
+}
+
+type propertySchemaBusiness struct {
+	schemaRepo repository.PropertySchemaRepository
+}
+
+func (psb *propertySchemaBusiness) List(ctx context.Context) ([]*models.PropertySchema, error) {
+	schemas, err := psb.schemaRepo.List(ctx)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return schemas, nil
+}
+
+func (psb *propertySchemaBusiness) Save(
+	ctx context.Context,
+	schema *models.PropertySchema,
+) (*models.PropertySchema, error) {
+	if err := checkPropertySchema(schema); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	existing, err := psb.schemaRepo.GetByKey(ctx, schema.Key)
+	if err != nil {
+		if !data.ErrorIsNoRows(err) {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+
+		if err = psb.schemaRepo.Create(ctx, schema); err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+		return schema, nil
+	}
+
+	existing.ValueType = schema.ValueType
+	existing.Required = schema.Required
+	existing.Pattern = schema.Pattern
+	existing.Enum = schema.Enum
+	existing.PII = schema.PII
+	existing.Scoped = schema.Scoped
+
+	_, err = psb.schemaRepo.Update(ctx, existing,
+		"value_type", "required", "pattern", "enum", "pii", "scoped")
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return existing, nil
+}
+
+func (psb *propertySchemaBusiness) Delete(ctx context.Context, key string) error {
+	schema, err := psb.schemaRepo.GetByKey(ctx, key)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+
+	if err = psb.schemaRepo.Delete(ctx, schema.GetID()); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+func (psb *propertySchemaBusiness) Validate(
+	ctx context.Context,
+	properties data.JSONMap,
+	creating bool,
+) (map[string]*models.PropertySchema, error) {
+	schemas, err := psb.schemaRepo.List(ctx)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	applicable := make(map[string]*models.PropertySchema)
+	badRequest := &errdetails.BadRequest{}
+	for _, schema := range schemas {
+		value, present := properties[schema.Key]
+		if !present && !creating {
+			continue
+		}
+		if present {
+			applicable[schema.Key] = schema
+		}
+
+		if reason := schema.Check(value); reason != "" {
+			badRequest.FieldViolations = append(badRequest.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{Field: schema.Key, Description: reason})
+		}
+	}
+
+	if len(badRequest.GetFieldViolations()) == 0 {
+		return applicable, nil
+	}
+
+	violations := make([]string, 0, len(badRequest.GetFieldViolations()))
+	for _, violation := range badRequest.GetFieldViolations() {
+		violations = append(violations, violation.GetField()+" "+violation.GetDescription())
+	}
+	connectErr := connect.NewError(connect.CodeInvalidArgument,
+		fmt.Errorf("invalid properties: %s", strings.Join(violations, "; ")))
+	if detail, detailErr := connect.NewErrorDetail(badRequest); detailErr == nil {
+		connectErr.AddDetail(detail)
+	}
+	return nil, connectErr
+}
+
+// checkPropertySchema rejects schemas that no value could satisfy
+// consistently.
+func checkPropertySchema(schema *models.PropertySchema) error {
+	schema.Key = strings.TrimSpace(schema.Key)
+	if schema.Key == "" {
+		return errors.New("schema key is required")
+	}
+
+	validTypes := []string{
+		models.PropertyValueString, models.PropertyValueNumber, models.PropertyValueBool,
+		models.PropertyValueList, models.PropertyValueObject,
+	}
+	if schema.ValueType != "" && !slices.Contains(validTypes, schema.ValueType) {
+		return fmt.Errorf("unsupported value type %q", schema.ValueType)
+	}
+
+	if schema.Pattern != "" {
+		if _, err := regexp.Compile(schema.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+
+	probe := &models.PropertySchema{ValueType: schema.ValueType, Pattern: schema.Pattern}
+	for _, allowed := range schema.Enum {
+		if reason := probe.Check(allowed); reason != "" {
+			return fmt.Errorf("enum value %v %s", allowed, reason)
+		}
+	}
+	return nil
+}
@@ -3,6 +3,9 @@ package business
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
 	"connectrpc.com/connect"
@@ -20,13 +23,25 @@ const (
 	DefaultListLimit        = 20
 	MaxListLimit            = 100
 	ProfilePeerName         = "Profile"
+
+	// DefaultRelationshipDepth is how many hops graph queries follow unless
+	// told otherwise, MaxRelationshipDepth the most they may be told to.
+	DefaultRelationshipDepth = 3
+	MaxRelationshipDepth     = 10
 )
 
 type RelationshipBusiness interface {
+	// ListRelationships returns a page of the peer's relationships that
+	// filter keeps.
 	ListRelationships(
 		ctx context.Context,
 		request *profilev1.ListRelationshipRequest,
+		filter models.RelationshipFilter,
 	) ([]*models.Relationship, error)
+	// CreateRelationship takes the relationship's role and validity bounds
+	// from the role, valid_from and valid_until properties, the bounds in
+	// RFC 3339. A relationship already linking the entries in that role is
+	// returned rather than a second one created.
 	CreateRelationship(
 		ctx context.Context,
 		request *profilev1.AddRelationshipRequest,
@@ -35,6 +50,24 @@ type RelationshipBusiness interface {
 		ctx context.Context,
 		request *profilev1.DeleteRelationshipRequest,
 	) (*profilev1.RelationshipObject, error)
+	// TraverseRelationships returns the relationships below the peer within
+	// maxDepth hops, or above it if inverted. Given types, only relationships
+	// of those types are returned, as when listing the members of an
+	// institution and of all its branches.
+	TraverseRelationships(
+		ctx context.Context,
+		peerName, peerID string,
+		inverseRelation bool,
+		maxDepth int,
+		types ...profilev1.RelationshipType,
+	) ([]*models.RelationshipEdge, error)
+	// ShortestRelationshipPath returns the fewest relationships linking two
+	// entries within maxDepth hops, failing with NotFound if there are none.
+	ShortestRelationshipPath(
+		ctx context.Context,
+		fromName, fromID, toName, toID string,
+		maxDepth int,
+	) ([]*models.Relationship, error)
 
 	ToAPI(
 		ctx context.Context,
@@ -62,6 +95,7 @@ type relationshipBusiness struct {
 func (rb *relationshipBusiness) ListRelationships(
 	ctx context.Context,
 	request *profilev1.ListRelationshipRequest,
+	filter models.RelationshipFilter,
 ) ([]*models.Relationship, error) {
 	if request.GetPeerName() == ProfilePeerName {
 		profileObj, err := rb.profileBusiness.GetByID(ctx, request.GetPeerId())
@@ -82,6 +116,7 @@ func (rb *relationshipBusiness) ListRelationships(
 		request.GetRelatedChildrenId(),
 		request.GetLastRelationshipId(),
 		int(request.GetCount()),
+		filter,
 	)
 	if err != nil {
 		return nil, err
@@ -99,6 +134,14 @@ func (rb *relationshipBusiness) CreateRelationship(
 		"child_id":  request.GetChildId(),
 	})
 
+	requestProperties := data.JSONMap{}
+	requestProperties = requestProperties.FromProtoStruct(request.GetProperties())
+
+	role, validFrom, validUntil, err := relationshipBounds(requestProperties)
+	if err != nil {
+		return nil, err
+	}
+
 	relationships, err := rb.relationshipRepo.List(
 		ctx,
 		request.GetParent(),
@@ -107,6 +150,7 @@ func (rb *relationshipBusiness) CreateRelationship(
 		[]string{request.GetChildId()},
 		"",
 		MaxRelationshipsToCheck,
+		models.RelationshipFilter{Roles: []string{role}},
 	)
 	if err != nil {
 		logger.WithError(err).Warn("get existing relationship error")
@@ -116,10 +160,11 @@ func (rb *relationshipBusiness) CreateRelationship(
 		}
 	}
 
-	if len(relationships) > 0 {
-		relationship := relationships[0]
-
-		return relationship.ToAPI(), nil
+	// A lapsed relationship is renewed by creating it afresh
+	for _, relationship := range relationships {
+		if relationship.ValidUntil == nil || relationship.ValidUntil.After(time.Now()) {
+			return relationship.ToAPI(), nil
+		}
 	}
 
 	relationshipType, err := rb.relationshipRepo.RelationshipType(ctx, request.GetType())
@@ -127,8 +172,6 @@ func (rb *relationshipBusiness) CreateRelationship(
 		return nil, err
 	}
 
-	requestProperties := data.JSONMap{}
-
 	relationship := models.Relationship{
 		ParentObject:       request.GetParent(),
 		ParentObjectID:     request.GetParentId(),
@@ -136,7 +179,10 @@ func (rb *relationshipBusiness) CreateRelationship(
 		RelationshipType:   relationshipType,
 		ChildObject:        request.GetChild(),
 		ChildObjectID:      request.GetChildId(),
-		Properties:         requestProperties.FromProtoStruct(request.GetProperties()),
+		Role:               role,
+		ValidFrom:          validFrom,
+		ValidUntil:         validUntil,
+		Properties:         requestProperties,
 	}
 	relationship.GenID(ctx)
 	if relationship.ValidXID(request.GetId()) {
@@ -178,6 +224,120 @@ func (rb *relationshipBusiness) DeleteRelationship(
 	return relationshipObject, nil
 }
 
+func (rb *relationshipBusiness) TraverseRelationships(
+	ctx context.Context,
+	peerName, peerID string,
+	inverseRelation bool,
+	maxDepth int,
+	types ...profilev1.RelationshipType,
+) ([]*models.RelationshipEdge, error) {
+	maxDepth, err := relationshipDepth(maxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	if peerName == ProfilePeerName {
+		profileObj, profileErr := rb.profileBusiness.GetByID(ctx, peerID)
+		if profileErr != nil {
+			return nil, profileErr
+		}
+		if profileObj == nil {
+			return nil, connect.NewError(connect.CodeNotFound, errors.New("profile does not exist"))
+		}
+	}
+
+	typeIDs := make([]string, 0, len(types))
+	for _, relationshipType := range types {
+		relationshipTypeObj, typeErr := rb.relationshipRepo.RelationshipType(ctx, relationshipType)
+		if typeErr != nil {
+			return nil, data.ErrorConvertToAPI(typeErr)
+		}
+		typeIDs = append(typeIDs, relationshipTypeObj.GetID())
+	}
+
+	edges, err := rb.relationshipRepo.Traverse(ctx, peerName, peerID, inverseRelation, maxDepth, typeIDs...)
+	if err != nil {
+		return nil, relationshipWalkError(err)
+	}
+	return edges, nil
+}
+
+func (rb *relationshipBusiness) ShortestRelationshipPath(
+	ctx context.Context,
+	fromName, fromID, toName, toID string,
+	maxDepth int,
+) ([]*models.Relationship, error) {
+	maxDepth, err := relationshipDepth(maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	if fromName == toName && fromID == toID {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("path needs two different entries"))
+	}
+
+	path, err := rb.relationshipRepo.ShortestPath(ctx, fromName, fromID, toName, toID, maxDepth)
+	if err != nil {
+		return nil, relationshipWalkError(err)
+	}
+	if len(path) == 0 {
+		return nil, connect.NewError(connect.CodeNotFound,
+			fmt.Errorf("entries are not related within %d hops", maxDepth))
+	}
+	return path, nil
+}
+
+// relationshipBounds takes the role and validity bounds out of a new
+// relationship's properties.
+func relationshipBounds(properties data.JSONMap) (string, *time.Time, *time.Time, error) {
+	role := strings.TrimSpace(properties.GetString(models.RelationshipRoleProperty))
+	delete(properties, models.RelationshipRoleProperty)
+
+	var bounds [2]*time.Time
+	for i, key := range []string{models.RelationshipValidFromProperty, models.RelationshipValidUntilProperty} {
+		value := properties.GetString(key)
+		delete(properties, key)
+		if value == "" {
+			continue
+		}
+		bound, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return "", nil, nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("%s must be an RFC 3339 time: %w", key, err))
+		}
+		bounds[i] = &bound
+	}
+
+	validFrom, validUntil := bounds[0], bounds[1]
+	if validFrom != nil && validUntil != nil && !validUntil.After(*validFrom) {
+		return "", nil, nil, connect.NewError(connect.CodeInvalidArgument,
+			errors.New("valid_until must be after valid_from"))
+	}
+	return role, validFrom, validUntil, nil
+}
+
+// relationshipWalkError reports walks reading too many relationships as
+// invalid arguments, to be asked again with fewer hops.
+func relationshipWalkError(err error) error {
+	if errors.Is(err, repository.ErrRelationshipWalkTooLarge) {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return data.ErrorConvertToAPI(err)
+}
+
+// relationshipDepth applies the default to an unset depth and rejects one
+// beyond MaxRelationshipDepth.
+func relationshipDepth(depth int) (int, error) {
+	switch {
+	case depth <= 0:
+		return DefaultRelationshipDepth, nil
+	case depth > MaxRelationshipDepth:
+		return 0, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("depth may be at most %d", MaxRelationshipDepth))
+	default:
+		return depth, nil
+	}
+}
+
 // Define sentinel errors.
 var (
 	ErrNilRelationship = connect.NewError(connect.CodeInvalidArgument, errors.New("relationship is nil"))
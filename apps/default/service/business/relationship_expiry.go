@@ -0,0 +1,112 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	frevents "github.com/pitabwire/frame/v2/events"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/events"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Relationship expiry defaults.
+const (
+	relationshipExpiryBatchSize     = 500
+	defaultRelationshipExpiryPeriod = time.Hour
+)
+
+// RelationshipExpiryBusiness announces relationships whose validity lapsed.
+type RelationshipExpiryBusiness interface {
+	// RunExpiry emits a relationship.expired event for each relationship
+	// whose ValidUntil passed since the last run. The relationships are
+	// kept; listings asking for those active now leave them out.
+	RunExpiry(ctx context.Context) error
+	Scheduler
+}
+
+func NewRelationshipExpiryBusiness(_ context.Context, cfg *config.ProfileConfig,
+	evtMan frevents.Manager, relationshipRepo repository.RelationshipRepository) RelationshipExpiryBusiness {
+	return &relationshipExpiryBusiness{
+		cfg:              cfg,
+		eventsMan:        evtMan,
+		relationshipRepo: relationshipRepo,
+	}
+}
+
+type relationshipExpiryBusiness struct {
+	cfg              *config.ProfileConfig
+	eventsMan        frevents.Manager
+	relationshipRepo repository.RelationshipRepository
+}
+
+// StartScheduler runs RunExpiry immediately, then every
+// RelationshipExpiryIntervalInSec.
+func (reb *relationshipExpiryBusiness) StartScheduler(ctx context.Context, locker repository.JobLocker) {
+	runScheduled(ctx, locker, "relationship_expiry",
+		time.Duration(reb.cfg.RelationshipExpiryIntervalInSec)*time.Second, defaultRelationshipExpiryPeriod,
+		reb.RunExpiry)
+}
+
+func (reb *relationshipExpiryBusiness) RunExpiry(ctx context.Context) error {
+	log := util.Log(ctx)
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+
+	now := time.Now()
+	expired := 0
+	for {
+		relationships, err := reb.relationshipRepo.ListLapsed(unscopedCtx, now, relationshipExpiryBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, relationship := range relationships {
+			expiry := &models.RelationshipExpiry{
+				RelationshipID: relationship.GetID(),
+				Role:           relationship.Role,
+				ParentObject:   relationship.ParentObject,
+				ParentObjectID: relationship.ParentObjectID,
+				ChildObject:    relationship.ChildObject,
+				ChildObjectID:  relationship.ChildObjectID,
+				ValidUntil:     *relationship.ValidUntil,
+			}
+			if relationship.RelationshipType != nil {
+				expiry.RelationshipType = models.RelationshipTypeIDToEnum(relationship.RelationshipType.UID).String()
+			}
+
+			// Only the run that marks the relationship expired reports it
+			claimed, claimErr := reb.relationshipRepo.ClaimLapsed(unscopedCtx, relationship.GetID(), now)
+			if claimErr != nil {
+				return claimErr
+			}
+			if !claimed {
+				continue
+			}
+
+			err = reb.eventsMan.Emit(ctx, events.RelationshipExpiredEventHandlerName, expiry)
+			if err != nil {
+				log.WithError(err).WithField("relationship_id", relationship.GetID()).
+					Error("could not emit relationship expiry")
+				// Cleared so the next run reports it
+				releaseErr := reb.relationshipRepo.ReleaseLapsed(unscopedCtx, relationship.GetID(), now)
+				if releaseErr != nil {
+					log.WithError(releaseErr).WithField("relationship_id", relationship.GetID()).
+						Error("could not clear unreported relationship expiry")
+				}
+				return err
+			}
+			expired++
+		}
+
+		if len(relationships) < relationshipExpiryBatchSize {
+			break
+		}
+	}
+
+	log.WithField("relationships", expired).Info("relationship expiry run complete")
+	return nil
+}
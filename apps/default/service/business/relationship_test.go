@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/base64"
 	"testing"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
 	"github.com/pitabwire/frame/v2"
+	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/frame/v2/datastore"
 	"github.com/pitabwire/frame/v2/frametests/definition"
 	"github.com/stretchr/testify/require"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/antinvestor/service-profile/apps/default/config"
 	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
 	"github.com/antinvestor/service-profile/apps/default/service/repository"
 	"github.com/antinvestor/service-profile/apps/default/tests"
 )
@@ -66,6 +69,9 @@ func (rts *RelationshipTestSuite) getRelationshipBusiness(
 	addressRepo := repository.NewAddressRepository(ctx, dbPool, workMan)
 	addressBusiness := business.NewAddressBusiness(ctx, addressRepo)
 
+	schemaRepo := repository.NewPropertySchemaRepository(ctx, dbPool, workMan)
+	schemaBusiness := business.NewPropertySchemaBusiness(ctx, schemaRepo)
+
 	profileRepo := repository.NewProfileRepository(ctx, dbPool, workMan)
 	propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, workMan)
 	profileBusiness := business.NewProfileBusiness(
@@ -75,6 +81,7 @@ func (rts *RelationshipTestSuite) getRelationshipBusiness(
 		evtsMan,
 		contactBusiness,
 		addressBusiness,
+		schemaBusiness,
 		profileRepo,
 		propertyEntryRepo,
 	)
@@ -351,7 +358,7 @@ func (rts *RelationshipTestSuite) Test_relationshipBusiness_ListRelationships()
 		}
 		for _, tt := range testcases {
 			t.Run(tt.name, func(t *testing.T) {
-				got, err0 := relationshipBusiness.ListRelationships(ctx, tt.args.request)
+				got, err0 := relationshipBusiness.ListRelationships(ctx, tt.args.request, models.RelationshipFilter{})
 				tt.wantErr(t, err0)
 				if len(got) != tt.wantCount {
 					t.Errorf("ListRelationships() got = %v, want %v", len(got), tt.wantCount)
@@ -360,3 +367,218 @@ func (rts *RelationshipTestSuite) Test_relationshipBusiness_ListRelationships()
 		}
 	})
 }
+
+func (rts *RelationshipTestSuite) Test_relationshipBusiness_Graph() {
+	t := rts.T()
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+
+		relationshipBusiness, profileBiz := rts.getRelationshipBusiness(ctx, svc)
+
+		testProfiles, err := rts.CreateTestProfiles(
+			ctx,
+			profileBiz,
+			[]string{
+				"graph.institution@ant.com",
+				"graph.branch@ant.com",
+				"graph.member.1@ant.com",
+				"graph.member.2@ant.com",
+				"graph.member.3@ant.com",
+			},
+		)
+		require.NoError(t, err)
+		institution, branch := testProfiles[0].GetId(), testProfiles[1].GetId()
+
+		// institution → branch → members 1 and 3, institution → member 2 and
+		// member 1 → institution closing a cycle
+		for _, link := range []struct {
+			parent, child int
+			relationType  profilev1.RelationshipType
+		}{
+			{0, 1, profilev1.RelationshipType_AFFILIATED},
+			{1, 2, profilev1.RelationshipType_MEMBER},
+			{1, 4, profilev1.RelationshipType_MEMBER},
+			{0, 3, profilev1.RelationshipType_MEMBER},
+			{2, 0, profilev1.RelationshipType_AFFILIATED},
+		} {
+			_, err = relationshipBusiness.CreateRelationship(ctx, &profilev1.AddRelationshipRequest{
+				Parent:   business.ProfilePeerName,
+				ParentId: testProfiles[link.parent].GetId(),
+				Child:    business.ProfilePeerName,
+				ChildId:  testProfiles[link.child].GetId(),
+				Type:     link.relationType,
+			})
+			require.NoError(t, err)
+		}
+
+		descendants, err := relationshipBusiness.TraverseRelationships(
+			ctx, business.ProfilePeerName, institution, false, 5)
+		require.NoError(t, err)
+		depths := map[string]int{}
+		for _, edge := range descendants {
+			depths[edge.Relationship.ChildObjectID] = edge.Depth
+		}
+		require.Equal(t, map[string]int{
+			branch:                  1,
+			testProfiles[3].GetId(): 1,
+			testProfiles[2].GetId(): 2,
+			testProfiles[4].GetId(): 2,
+		}, depths)
+
+		members, err := relationshipBusiness.TraverseRelationships(
+			ctx, business.ProfilePeerName, institution, false, 5, profilev1.RelationshipType_MEMBER)
+		require.NoError(t, err)
+		require.Len(t, members, 3)
+
+		direct, err := relationshipBusiness.TraverseRelationships(ctx, business.ProfilePeerName, institution, false, 1)
+		require.NoError(t, err)
+		require.Len(t, direct, 2)
+
+		ancestors, err := relationshipBusiness.TraverseRelationships(
+			ctx, business.ProfilePeerName, testProfiles[2].GetId(), true, 5)
+		require.NoError(t, err)
+		require.Len(t, ancestors, 2)
+		require.Equal(t, branch, ancestors[0].Relationship.ParentObjectID)
+		require.Equal(t, institution, ancestors[1].Relationship.ParentObjectID)
+
+		path, err := relationshipBusiness.ShortestRelationshipPath(ctx,
+			business.ProfilePeerName, testProfiles[3].GetId(), business.ProfilePeerName, testProfiles[4].GetId(), 5)
+		require.NoError(t, err)
+		require.Len(t, path, 3)
+		require.Equal(t, testProfiles[3].GetId(), path[0].ChildObjectID)
+		require.Equal(t, testProfiles[4].GetId(), path[2].ChildObjectID)
+
+		_, err = relationshipBusiness.ShortestRelationshipPath(ctx,
+			business.ProfilePeerName, testProfiles[3].GetId(), business.ProfilePeerName, testProfiles[4].GetId(), 2)
+		require.Error(t, err)
+
+		_, err = relationshipBusiness.TraverseRelationships(
+			ctx, business.ProfilePeerName, institution, false, business.MaxRelationshipDepth+1)
+		require.Error(t, err)
+	})
+}
+
+func (rts *RelationshipTestSuite) Test_relationshipBusiness_RoleAndValidity() {
+	t := rts.T()
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+
+		relationshipBusiness, profileBiz := rts.getRelationshipBusiness(ctx, svc)
+
+		testProfiles, err := rts.CreateTestProfiles(
+			ctx,
+			profileBiz,
+			[]string{"validity.org@ant.com", "validity.member.1@ant.com", "validity.member.2@ant.com"},
+		)
+		require.NoError(t, err)
+		org := testProfiles[0].GetId()
+
+		now := time.Now()
+		lapsedAt := now.Add(-time.Hour)
+
+		addRelationship := func(child int, properties data.JSONMap) (*profilev1.RelationshipObject, error) {
+			return relationshipBusiness.CreateRelationship(ctx, &profilev1.AddRelationshipRequest{
+				Parent:     business.ProfilePeerName,
+				ParentId:   org,
+				Child:      business.ProfilePeerName,
+				ChildId:    testProfiles[child].GetId(),
+				Type:       profilev1.RelationshipType_AFFILIATED,
+				Properties: properties.ToProtoStruct(),
+			})
+		}
+
+		lapsed, err := addRelationship(1, data.JSONMap{
+			"role":        "director",
+			"valid_from":  now.Add(-2 * time.Hour).Format(time.RFC3339),
+			"valid_until": lapsedAt.Format(time.RFC3339),
+			"note":        "former director",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "director", lapsed.GetProperties().AsMap()["role"])
+		require.Equal(t, "former director", lapsed.GetProperties().AsMap()["note"])
+
+		// The same entries in another role are a separate relationship
+		guardian, err := addRelationship(1, data.JSONMap{"role": "guardian"})
+		require.NoError(t, err)
+		require.NotEqual(t, lapsed.GetId(), guardian.GetId())
+
+		current, err := addRelationship(2, data.JSONMap{
+			"role":        "director",
+			"valid_until": now.Add(time.Hour).Format(time.RFC3339),
+		})
+		require.NoError(t, err)
+
+		_, err = addRelationship(2, data.JSONMap{"role": "auditor", "valid_until": "next week"})
+		require.Error(t, err)
+		_, err = addRelationship(2, data.JSONMap{
+			"role":        "auditor",
+			"valid_from":  now.Format(time.RFC3339),
+			"valid_until": now.Add(-time.Minute).Format(time.RFC3339),
+		})
+		require.Error(t, err)
+
+		listIDs := func(filter models.RelationshipFilter) []string {
+			relationships, listErr := relationshipBusiness.ListRelationships(ctx, &profilev1.ListRelationshipRequest{
+				PeerName: business.ProfilePeerName,
+				PeerId:   org,
+				Count:    10,
+			}, filter)
+			require.NoError(t, listErr)
+			ids := make([]string, 0, len(relationships))
+			for _, relationship := range relationships {
+				ids = append(ids, relationship.GetID())
+			}
+			return ids
+		}
+
+		require.Len(t, listIDs(models.RelationshipFilter{}), 3)
+		require.ElementsMatch(t, []string{lapsed.GetId(), current.GetId()},
+			listIDs(models.RelationshipFilter{Roles: []string{"director"}}))
+		require.ElementsMatch(t, []string{guardian.GetId(), current.GetId()},
+			listIDs(models.RelationshipFilter{ActiveAt: now}))
+		require.Equal(t, []string{current.GetId()},
+			listIDs(models.RelationshipFilter{Roles: []string{"director"}, ActiveAt: now}))
+		require.ElementsMatch(t, []string{lapsed.GetId(), current.GetId()},
+			listIDs(models.RelationshipFilter{Roles: []string{"director"}, ActiveAt: lapsedAt.Add(-time.Minute)}))
+
+		relationshipRepo := repository.NewRelationshipRepository(
+			ctx, svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName), svc.WorkManager())
+		expiryBusiness := business.NewRelationshipExpiryBusiness(
+			ctx, svc.Config().(*config.ProfileConfig), svc.EventsManager(), relationshipRepo)
+		require.NoError(t, expiryBusiness.RunExpiry(ctx))
+
+		expired, err := relationshipRepo.GetByID(ctx, lapsed.GetId())
+		require.NoError(t, err)
+		require.NotNil(t, expired.ExpiredAt)
+		expiredAt := *expired.ExpiredAt
+
+		for _, id := range []string{guardian.GetId(), current.GetId()} {
+			active, getErr := relationshipRepo.GetByID(ctx, id)
+			require.NoError(t, getErr)
+			require.Nil(t, active.ExpiredAt)
+		}
+
+		// A lapse is announced once
+		require.NoError(t, expiryBusiness.RunExpiry(ctx))
+		expired, err = relationshipRepo.GetByID(ctx, lapsed.GetId())
+		require.NoError(t, err)
+		require.True(t, expiredAt.Equal(*expired.ExpiredAt))
+
+		// Lapsed relationships are kept, only left out of active listings
+		require.Len(t, listIDs(models.RelationshipFilter{}), 3)
+
+		claimed, err := relationshipRepo.ClaimLapsed(ctx, lapsed.GetId(), now)
+		require.NoError(t, err)
+		require.False(t, claimed)
+
+		// Adding a lapsed relationship again renews it, a current one is kept
+		renewed, err := addRelationship(1, data.JSONMap{"role": "director"})
+		require.NoError(t, err)
+		require.NotEqual(t, lapsed.GetId(), renewed.GetId())
+		kept, err := addRelationship(2, data.JSONMap{"role": "director"})
+		require.NoError(t, err)
+		require.Equal(t, current.GetId(), kept.GetId())
+		require.ElementsMatch(t, []string{renewed.GetId(), current.GetId()},
+			listIDs(models.RelationshipFilter{Roles: []string{"director"}, ActiveAt: time.Now()}))
+	})
+}
@@ -3,6 +3,8 @@ package business
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
 	"connectrpc.com/connect"
@@ -10,6 +12,7 @@ import (
 	"github.com/pitabwire/frame/v2/security"
 	"github.com/pitabwire/frame/v2/workerpool"
 	"github.com/pitabwire/util"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/antinvestor/service-profile/apps/default/config"
 	"github.com/antinvestor/service-profile/apps/default/service/models"
@@ -24,27 +27,55 @@ type RosterBusiness interface {
 	GetByID(ctx context.Context, rosterID string) (*models.Roster, error)
 	CreateRoster(ctx context.Context, request *profilev1.AddRosterRequest) ([]*profilev1.RosterObject, error)
 	RemoveRoster(ctx context.Context, rosterID string) (*profilev1.RosterObject, error)
+	// ToAPI converts rosters, flagging in their extra the entries whose
+	// contact belongs to a verified profile, as that profile's roster
+	// visibility allows.
+	ToAPI(ctx context.Context, rosters []*models.Roster) ([]*profilev1.RosterObject, error)
+	// SyncRoster applies the changes a client made to the caller's roster
+	// since its last sync and returns the entries changed since then.
+	SyncRoster(ctx context.Context, request *models.RosterSyncRequest) (*models.RosterSyncResult, error)
+	// ImportRoster adds the contacts of an address book in format to the
+	// caller's roster name, reporting those that could not be added. Phone
+	// numbers without a country code are read as numbers of region, if set.
+	ImportRoster(
+		ctx context.Context,
+		name, format, region string,
+		payload io.Reader,
+	) (*models.RosterImportResult, error)
 }
 
+// rosterMatchedFilter selects roster entries whose contact belongs to a
+// verified profile that is not hidden from rosters. Expiries at or before
+// verified_at are unset ones.
+const rosterMatchedFilter = `contacts.verification_id <> ''
+	AND NOT coalesce(contacts.verification_expires_at > contacts.verified_at
+		AND contacts.verification_expires_at <= ?, false)
+	AND EXISTS (SELECT 1 FROM profiles WHERE profiles.id = contacts.profile_id AND profiles.deleted_at IS NULL
+		AND coalesce(profiles.properties->>'` + models.ProfileRosterVisibilityProperty + `', '') <> '` +
+	models.RosterVisibilityHidden + `')`
+
 func NewRosterBusiness(
 	_ context.Context,
 	cfg *config.ProfileConfig, dek *config.DEK,
 	contactBusiness ContactBusiness,
+	profileRepo repository.ProfileRepository,
 	rosterRepo repository.RosterRepository,
 ) RosterBusiness {
 	return &rosterBusiness{
-		cfg:              cfg,
-		dek:              dek,
-		rosterRepository: rosterRepo,
-		contactBusiness:  contactBusiness,
+		cfg:               cfg,
+		dek:               dek,
+		profileRepository: profileRepo,
+		rosterRepository:  rosterRepo,
+		contactBusiness:   contactBusiness,
 	}
 }
 
 type rosterBusiness struct {
-	cfg              *config.ProfileConfig
-	dek              *config.DEK
-	rosterRepository repository.RosterRepository
-	contactBusiness  ContactBusiness
+	cfg               *config.ProfileConfig
+	dek               *config.DEK
+	profileRepository repository.ProfileRepository
+	rosterRepository  repository.RosterRepository
+	contactBusiness   ContactBusiness
 }
 
 func (rb *rosterBusiness) GetByID(ctx context.Context, rosterID string) (*models.Roster, error) {
@@ -67,10 +98,7 @@ func (rb *rosterBusiness) Search(ctx context.Context,
 
 	var orSearchFilter = make(map[string]any)
 	if request.GetQuery() != "" {
-		orSearchFilter["contacts.look_up_token = ?"] = util.ComputeLookupToken(
-			rb.dek.LookUpKey,
-			Normalize(ctx, request.GetQuery()),
-		)
+		orSearchFilter["contacts.look_up_token IN ?"] = detailLookupTokens(ctx, rb.dek, request.GetQuery())
 		orSearchFilter["rosters.searchable  @@ websearch_to_tsquery( 'english', ?) "] = request.GetQuery()
 	}
 
@@ -78,6 +106,10 @@ func (rb *rosterBusiness) Search(ctx context.Context,
 	if request.GetName() != "" {
 		andFilters["rosters.name"] = request.GetName()
 	}
+	// Extras {"matched": true} keeps the entries ToAPI flags as matched
+	if matched, _ := request.GetExtras().AsMap()[models.RosterMatchedExtra].(bool); matched {
+		andFilters[rosterMatchedFilter] = time.Now()
+	}
 
 	query := data.NewSearchQuery(
 
@@ -118,6 +150,15 @@ func (rb *rosterBusiness) CreateRoster(
 		name = "default"
 	}
 
+	return rb.createRosterBatches(ctx, profileID, name, newRosterList)
+}
+
+// createRosterBatches adds newRosterList to the roster name, in batches.
+func (rb *rosterBusiness) createRosterBatches(
+	ctx context.Context,
+	profileID, name string,
+	newRosterList []*profilev1.RawContact,
+) ([]*profilev1.RosterObject, error) {
 	// Pre-allocate result slice for better memory efficiency
 	rosterObjectList := make([]*profilev1.RosterObject, 0, len(newRosterList))
 
@@ -168,7 +209,11 @@ func (rb *rosterBusiness) processRosterBatch(
 	allContacts := rb.createUnifiedContactMap(existingContactMap, newContactsMap)
 	contactDetails := rb.buildContactDetails(batch, allContacts)
 
-	// Step 5: Get existing rosters for this name
+	// Step 5: Get existing rosters for this name, bringing back removed ones
+	err = rb.rosterRepository.RestoreByContactIDsAndProfileIDAndName(ctx, contactDetails, profileID, name)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
 	existingRosters, err := rb.rosterRepository.GetByContactIDsAndProfileIDAndName(ctx, contactDetails, profileID, name)
 	if err != nil {
 		return nil, data.ErrorConvertToAPI(err)
@@ -188,7 +233,7 @@ func (rb *rosterBusiness) processRosterBatch(
 	}
 
 	// Step 7: Build final result preserving input order
-	return rb.buildRosterObjects(batch, allContacts, existingRosterMap), nil
+	return rb.buildRosterObjects(ctx, batch, allContacts, existingRosterMap)
 }
 
 // deduplicateContacts creates a set of unique contact details from the batch.
@@ -307,11 +352,12 @@ func (rb *rosterBusiness) findRostersToCreate(
 
 // buildRosterObjects creates the final roster objects preserving input order.
 func (rb *rosterBusiness) buildRosterObjects(
+	ctx context.Context,
 	batch []*profilev1.RawContact,
 	allContacts map[string]*models.Contact,
 	existingRosterMap map[string]*models.Roster,
-) []*profilev1.RosterObject {
-	rosterObjectList := make([]*profilev1.RosterObject, 0, len(batch))
+) ([]*profilev1.RosterObject, error) {
+	rosters := make([]*models.Roster, 0, len(batch))
 
 	for _, rawContact := range batch {
 		detail := rawContact.GetContact()
@@ -323,20 +369,68 @@ func (rb *rosterBusiness) buildRosterObjects(
 
 		// Direct lookup for roster using contact ID
 		roster, exists := existingRosterMap[contact.GetID()]
-		if !exists {
+		if !exists || roster == nil {
 			continue
 		}
+		rosters = append(rosters, roster)
+	}
+	return rb.ToAPI(ctx, rosters)
+}
+
+func (rb *rosterBusiness) ToAPI(ctx context.Context, rosters []*models.Roster) ([]*profilev1.RosterObject, error) {
+	now := time.Now()
+
+	var matchedProfileIDs []string
+	for _, roster := range rosters {
+		if contactMatched(roster.Contact, now) {
+			matchedProfileIDs = append(matchedProfileIDs, roster.Contact.ProfileID)
+		}
+	}
+
+	visibility := map[string]string{}
+	if len(matchedProfileIDs) > 0 {
+		var err error
+		visibility, err = rb.profileRepository.GetRosterVisibility(ctx, matchedProfileIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rosterObjectList := make([]*profilev1.RosterObject, 0, len(rosters))
+	for _, roster := range rosters {
+		rosterObj, err := roster.ToAPI(ctx, rb.dek)
+		if err != nil {
+			if errors.Is(err, config.ErrKeyShredded) {
+				// The contact detail is gone for good; the other entries are not
+				continue
+			}
+			return nil, err
+		}
 
-		// Add a check to ensure roster is not nil before calling ToAPI
-		if roster != nil {
-			rosterObj, apiErr := roster.ToAPI(rb.dek)
-			if apiErr != nil {
-				return nil // Will be handled by the caller
+		matched, matchedProfileID := false, ""
+		if contactMatched(roster.Contact, now) {
+			switch visibility[roster.Contact.ProfileID] {
+			case models.RosterVisibilityProfile:
+				matched, matchedProfileID = true, roster.Contact.ProfileID
+			case models.RosterVisibilityMatched:
+				matched = true
 			}
-			rosterObjectList = append(rosterObjectList, rosterObj)
 		}
+
+		rosterObj.Extra.Fields[models.RosterMatchedExtra] = structpb.NewBoolValue(matched)
+		delete(rosterObj.Extra.Fields, models.RosterMatchedProfileIDExtra)
+		if matchedProfileID != "" {
+			rosterObj.Extra.Fields[models.RosterMatchedProfileIDExtra] = structpb.NewStringValue(matchedProfileID)
+		}
+		rosterObjectList = append(rosterObjectList, rosterObj)
 	}
-	return rosterObjectList
+	return rosterObjectList, nil
+}
+
+// contactMatched reports whether contact belongs to a profile and holds a
+// verification that has not gone stale.
+func contactMatched(contact *models.Contact, now time.Time) bool {
+	return contact != nil && contact.ProfileID != "" && contact.VerificationFresh(now)
 }
 
 // batchCreateContacts creates multiple contacts in a batch for better performance.
@@ -383,9 +477,13 @@ func (rb *rosterBusiness) RemoveRoster(ctx context.Context, rosterID string) (*p
 		return nil, err
 	}
 
-	rosterObj, err := roster.ToAPI(rb.dek)
+	rosterObjs, err := rb.ToAPI(ctx, []*models.Roster{roster})
 	if err != nil {
 		return nil, err
 	}
-	return rosterObj, nil
+	if len(rosterObjs) == 0 {
+		// Its contact was shredded
+		return &profilev1.RosterObject{Id: roster.GetID(), ProfileId: roster.ProfileID, Name: roster.Name}, nil
+	}
+	return rosterObjs[0], nil
 }
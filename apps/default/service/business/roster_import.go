@@ -0,0 +1,517 @@
+package business
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/ttacon/libphonenumber"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+// Address book formats ImportRoster reads.
+const (
+	RosterImportVCard = "vcard"
+	RosterImportCSV   = "csv"
+)
+
+const (
+	// maxRosterImportEntries caps the contacts a single import may add.
+	maxRosterImportEntries = 10000
+	// maxVCardLineLength bounds an unfolded vCard line, embedded photos
+	// included.
+	maxVCardLineLength = 1 << 20
+)
+
+// RosterImportEntry is a contact read from an address book, with the
+// extras its roster entry gets. Contact is empty for a card or row holding
+// no phone number or email.
+type RosterImportEntry struct {
+	Row     int
+	Contact string
+	Extras  data.JSONMap
+}
+
+// addressBookCard gathers what an address book holds about one person.
+type addressBookCard struct {
+	row          int
+	name         string
+	givenName    string
+	familyName   string
+	organization string
+	contacts     []addressBookContact
+}
+
+type addressBookContact struct {
+	value string
+	label string
+}
+
+// entries returns an entry per contact of the card, a single empty one if
+// it has none.
+func (c *addressBookCard) entries(source string) []*RosterImportEntry {
+	name := c.name
+	if name == "" {
+		name = strings.TrimSpace(c.givenName + " " + c.familyName)
+	}
+
+	extras := func(label string) data.JSONMap {
+		extras := data.JSONMap{"source": source}
+		for key, value := range map[string]string{
+			"name":         name,
+			"given_name":   c.givenName,
+			"family_name":  c.familyName,
+			"organization": c.organization,
+			"label":        label,
+		} {
+			if value != "" {
+				extras[key] = value
+			}
+		}
+		return extras
+	}
+
+	if len(c.contacts) == 0 {
+		return []*RosterImportEntry{{Row: c.row, Extras: extras("")}}
+	}
+
+	entries := make([]*RosterImportEntry, 0, len(c.contacts))
+	for _, contact := range c.contacts {
+		entries = append(entries, &RosterImportEntry{Row: c.row, Contact: contact.value, Extras: extras(contact.label)})
+	}
+	return entries
+}
+
+// ParseVCards reads the contacts of a vCard 3 or 4 file: the name,
+// organisation and every labelled phone number and email of each card.
+// Entries are numbered by card.
+func ParseVCards(r io.Reader) ([]*RosterImportEntry, error) {
+	lines, err := unfoldVCardLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries     []*RosterImportEntry
+		card        *addressBookCard
+		groupLabels map[string]string
+		groupOf     []string
+		cardCount   int
+	)
+	for _, line := range lines {
+		group, name, params, value, ok := splitVCardLine(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			cardCount++
+			card = &addressBookCard{row: cardCount}
+			groupLabels, groupOf = map[string]string{}, nil
+			continue
+		case card == nil:
+			continue
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			// Labels set on a group, as Apple exports them, win over types
+			for i, contactGroup := range groupOf {
+				if label := groupLabels[contactGroup]; contactGroup != "" && label != "" {
+					card.contacts[i].label = label
+				}
+			}
+			entries = append(entries, card.entries(RosterImportVCard)...)
+			card = nil
+			continue
+		}
+
+		switch name {
+		case "FN":
+			card.name = unescapeVCardValue(value)
+		case "N":
+			components := splitVCardValue(value, ';')
+			if len(components) > 0 {
+				card.familyName = unescapeVCardValue(components[0])
+			}
+			if len(components) > 1 {
+				card.givenName = unescapeVCardValue(components[1])
+			}
+		case "ORG":
+			card.organization = unescapeVCardValue(splitVCardValue(value, ';')[0])
+		case "TEL", "EMAIL":
+			value = unescapeVCardValue(value)
+			value = strings.TrimPrefix(strings.TrimPrefix(value, "tel:"), "mailto:")
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			card.contacts = append(card.contacts, addressBookContact{value: value, label: vCardTypeLabel(params)})
+			groupOf = append(groupOf, group)
+		case "X-ABLABEL":
+			groupLabels[group] = strings.TrimSuffix(strings.TrimPrefix(unescapeVCardValue(value), "_$!<"), ">!$_")
+		}
+	}
+
+	if cardCount == 0 {
+		return nil, errors.New("no vCards found")
+	}
+	return entries, nil
+}
+
+// unfoldVCardLines returns the logical lines of a vCard file, joining the
+// continuation lines that start with a space or tab.
+func unfoldVCardLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxVCardLineLength)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// splitVCardLine splits "[group.]NAME;param=value:value" into its parts,
+// the name upper-cased.
+func splitVCardLine(line string) (string, string, []string, string, bool) {
+	head, value, found := cutOutsideQuotes(line, ':')
+	if !found {
+		return "", "", nil, "", false
+	}
+
+	params := splitVCardValue(head, ';')
+	group, name, grouped := strings.Cut(params[0], ".")
+	if !grouped {
+		group, name = "", params[0]
+	}
+	return strings.ToLower(group), strings.ToUpper(strings.TrimSpace(name)), params[1:], value, true
+}
+
+// vCardTypeLabel returns the types of a TEL or EMAIL property as a label,
+// leaving out those saying nothing about the contact.
+func vCardTypeLabel(params []string) string {
+	var types []string
+	for _, param := range params {
+		key, value, found := strings.Cut(param, "=")
+		if !found {
+			// vCard 2.1 lists types bare, as in TEL;CELL:
+			key, value = "TYPE", param
+		}
+		if !strings.EqualFold(key, "TYPE") {
+			continue
+		}
+		for _, typ := range strings.Split(strings.Trim(value, `"`), ",") {
+			typ = strings.ToLower(strings.TrimSpace(typ))
+			switch typ {
+			case "", "pref", "voice", "internet", "x400":
+				continue
+			}
+			types = append(types, typ)
+		}
+	}
+	return strings.Join(types, ",")
+}
+
+// splitVCardValue splits value at each sep that is neither escaped nor
+// quoted.
+func splitVCardValue(value string, sep byte) []string {
+	var parts []string
+	for {
+		part, rest, found := cutOutsideQuotes(value, sep)
+		parts = append(parts, part)
+		if !found {
+			return parts
+		}
+		value = rest
+	}
+}
+
+func cutOutsideQuotes(value string, sep byte) (string, string, bool) {
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				return value[:i], value[i+1:], true
+			}
+		}
+	}
+	return value, "", false
+}
+
+func unescapeVCardValue(value string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			unescaped.WriteByte('\n')
+		default:
+			unescaped.WriteByte(value[i])
+		}
+	}
+	return strings.TrimSpace(unescaped.String())
+}
+
+// ParseContactsCSV reads the contacts of a CSV file whose first line names
+// its columns. Columns named after a phone or an email hold contacts,
+// labelled by the column name, several to a cell when separated by ":::".
+// Google's "Phone 1 - Value" columns take their label from the matching
+// "Phone 1 - Type" column. Entries are numbered by line.
+func ParseContactsCSV(r io.Reader) ([]*RosterImportEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read csv header: %w", err)
+	}
+
+	columns := classifyCSVColumns(header)
+	contactColumns := 0
+	for _, column := range columns {
+		if column.kind == csvContactColumn {
+			contactColumns++
+		}
+	}
+	if contactColumns == 0 {
+		return nil, errors.New("csv has no phone or email column")
+	}
+
+	var entries []*RosterImportEntry
+	for {
+		record, readErr := reader.Read()
+		if errors.Is(readErr, io.EOF) {
+			return entries, nil
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		row, _ := reader.FieldPos(0)
+		card := &addressBookCard{row: row}
+		for i, cell := range record {
+			cell = strings.TrimSpace(cell)
+			if i >= len(columns) || cell == "" {
+				continue
+			}
+
+			column := columns[i]
+			switch column.kind {
+			case csvNameColumn:
+				card.name = cell
+			case csvGivenNameColumn:
+				card.givenName = cell
+			case csvFamilyNameColumn:
+				card.familyName = cell
+			case csvOrganizationColumn:
+				card.organization = cell
+			case csvContactColumn:
+				label := column.label
+				if column.typeColumn >= 0 && column.typeColumn < len(record) {
+					if typ := strings.TrimSpace(record[column.typeColumn]); typ != "" {
+						label = strings.ToLower(strings.TrimPrefix(typ, "* "))
+					}
+				}
+				for _, value := range strings.Split(cell, ":::") {
+					if value = strings.TrimSpace(value); value != "" {
+						card.contacts = append(card.contacts, addressBookContact{value: value, label: label})
+					}
+				}
+			}
+		}
+		entries = append(entries, card.entries(RosterImportCSV)...)
+	}
+}
+
+type csvColumnKind int
+
+const (
+	csvIgnoredColumn csvColumnKind = iota
+	csvNameColumn
+	csvGivenNameColumn
+	csvFamilyNameColumn
+	csvOrganizationColumn
+	csvContactColumn
+)
+
+type csvColumn struct {
+	kind  csvColumnKind
+	label string
+	// typeColumn is the index of the column labelling this one, or -1.
+	typeColumn int
+}
+
+func classifyCSVColumns(header []string) []csvColumn {
+	names := make([]string, len(header))
+	typeColumns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		names[i] = name
+		if prefix, found := strings.CutSuffix(name, " - type"); found {
+			typeColumns[prefix] = i
+		}
+	}
+
+	columns := make([]csvColumn, len(names))
+	for i, name := range names {
+		columns[i] = csvColumn{kind: csvIgnoredColumn, typeColumn: -1}
+		switch name {
+		case "name", "full name", "display name":
+			columns[i].kind = csvNameColumn
+			continue
+		case "first name", "given name":
+			columns[i].kind = csvGivenNameColumn
+			continue
+		case "last name", "family name", "surname":
+			columns[i].kind = csvFamilyNameColumn
+			continue
+		case "company", "organization", "organisation", "organization 1 - name":
+			columns[i].kind = csvOrganizationColumn
+			continue
+		}
+
+		if strings.HasSuffix(name, " - type") || !isCSVContactColumn(name) {
+			continue
+		}
+		columns[i].kind = csvContactColumn
+		columns[i].label = name
+		if prefix, found := strings.CutSuffix(name, " - value"); found {
+			columns[i].label = prefix
+			if typeColumn, ok := typeColumns[prefix]; ok {
+				columns[i].typeColumn = typeColumn
+			}
+		}
+	}
+	return columns
+}
+
+// isCSVContactColumn reports whether the lower-cased header name is one
+// known to hold phone numbers or email addresses. Headers are matched
+// whole, so columns such as "E-mail Type" or "Hotel" are left out.
+func isCSVContactColumn(name string) bool {
+	// Numbered columns, as in "E-mail 2 Address" or "Phone 1 - Value", are
+	// matched less their number
+	fields := slices.DeleteFunc(strings.Fields(name), func(field string) bool {
+		_, err := strconv.Atoi(field)
+		return err == nil
+	})
+
+	switch strings.Join(fields, " ") {
+	case "phone", "telephone", "tel", "mobile", "cell", "email", "e-mail",
+		"phone number", "mobile number", "email address", "e-mail address",
+		"mobile phone", "cell phone", "home phone", "work phone", "business phone", "other phone",
+		"primary phone", "car phone", "company main phone", "assistant's phone", "radio phone",
+		"phone - value", "email - value", "e-mail - value":
+		return true
+	}
+	return false
+}
+
+func (rb *rosterBusiness) ImportRoster(
+	ctx context.Context,
+	name, format, region string,
+	payload io.Reader,
+) (*models.RosterImportResult, error) {
+	claims := security.ClaimsFromContext(ctx)
+	if claims == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("no claims found in context"))
+	}
+
+	profileID, err := claims.GetSubject()
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	if name == "" {
+		name = "default"
+	}
+
+	var entries []*RosterImportEntry
+	switch format {
+	case RosterImportVCard:
+		entries, err = ParseVCards(payload)
+	case RosterImportCSV:
+		entries, err = ParseContactsCSV(payload)
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown import format %q", format))
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if len(entries) > maxRosterImportEntries {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("an import may carry at most %d contacts", maxRosterImportEntries))
+	}
+
+	result := &models.RosterImportResult{Failures: []*models.RosterImportFailure{}}
+	rawContacts := make([]*profilev1.RawContact, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Contact == "" {
+			result.Failures = append(result.Failures, &models.RosterImportFailure{
+				Row:   entry.Row,
+				Error: "no phone number or email",
+			})
+			continue
+		}
+
+		detail, detailErr := importedContactDetail(ctx, entry.Contact, region)
+		if detailErr != nil {
+			result.Failures = append(result.Failures, &models.RosterImportFailure{
+				Row:     entry.Row,
+				Contact: entry.Contact,
+				Error:   detailErr.Error(),
+			})
+			continue
+		}
+		rawContacts = append(rawContacts, &profilev1.RawContact{Contact: detail, Extras: entry.Extras.ToProtoStruct()})
+	}
+
+	result.Imported, err = rb.createRosterBatches(ctx, profileID, name, rawContacts)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// importedContactDetail checks detail is a contact, reading phone numbers
+// without a country code as numbers of region.
+func importedContactDetail(ctx context.Context, detail, region string) (string, error) {
+	_, err := ContactTypeFromDetail(ctx, detail)
+	if err == nil || region == "" {
+		return detail, err
+	}
+
+	number, parseErr := libphonenumber.Parse(detail, strings.ToUpper(region))
+	if parseErr != nil || !libphonenumber.IsValidNumber(number) {
+		return "", err
+	}
+	return libphonenumber.Format(number, libphonenumber.E164), nil
+}
@@ -0,0 +1,138 @@
+package business_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+)
+
+func TestParseVCards(t *testing.T) {
+	vcards := strings.Join([]string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"N:Doe;Jane;;;",
+		"FN:Jane Doe",
+		"ORG:Acme\\, Inc.;Sales",
+		"TEL;TYPE=CELL,VOICE:+256 757 546244",
+		"TEL;TYPE=\"work\":+254 701",
+		" 234567",
+		"EMAIL;TYPE=INTERNET,HOME:jane@example.com",
+		"item1.EMAIL:jane@work.example.com",
+		"item1.X-ABLabel:_$!<Work>!$_",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+		"FN:No Contacts",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+		"N:Smith;John;;;",
+		"TEL;VALUE=uri;TYPE=home:tel:+1-202-555-1234",
+		"END:VCARD",
+	}, "\r\n")
+
+	entries, err := business.ParseVCards(strings.NewReader(vcards))
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+
+	jane := data.JSONMap{
+		"source":       business.RosterImportVCard,
+		"name":         "Jane Doe",
+		"given_name":   "Jane",
+		"family_name":  "Doe",
+		"organization": "Acme, Inc.",
+	}
+	expected := []struct {
+		row     int
+		contact string
+		label   string
+	}{
+		{1, "+256 757 546244", "cell"},
+		{1, "+254 701234567", "work"},
+		{1, "jane@example.com", "home"},
+		{1, "jane@work.example.com", "Work"},
+	}
+	for i, want := range expected {
+		require.Equal(t, want.row, entries[i].Row)
+		require.Equal(t, want.contact, entries[i].Contact)
+		require.Equal(t, want.label, entries[i].Extras["label"])
+		for key, value := range jane {
+			require.Equal(t, value, entries[i].Extras[key], key)
+		}
+	}
+
+	require.Equal(t, 2, entries[4].Row)
+	require.Empty(t, entries[4].Contact)
+	require.Equal(t, "No Contacts", entries[4].Extras["name"])
+
+	require.Equal(t, 3, entries[5].Row)
+	require.Equal(t, "+1-202-555-1234", entries[5].Contact)
+	require.Equal(t, "John Smith", entries[5].Extras["name"])
+	require.Equal(t, "home", entries[5].Extras["label"])
+
+	_, err = business.ParseVCards(strings.NewReader("not a vcard"))
+	require.Error(t, err)
+}
+
+func TestParseContactsCSV(t *testing.T) {
+	t.Run("plain columns", func(t *testing.T) {
+		csv := "\ufeffFirst Name,Last Name,Company,Mobile Phone,E-mail Address\n" +
+			"Jane,Doe,Acme,+256757546244,jane@example.com\n" +
+			"John,,,,\n"
+
+		entries, err := business.ParseContactsCSV(strings.NewReader(csv))
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+
+		require.Equal(t, 2, entries[0].Row)
+		require.Equal(t, "+256757546244", entries[0].Contact)
+		require.Equal(t, "mobile phone", entries[0].Extras["label"])
+		require.Equal(t, "Jane Doe", entries[0].Extras["name"])
+		require.Equal(t, "Acme", entries[0].Extras["organization"])
+		require.Equal(t, business.RosterImportCSV, entries[0].Extras["source"])
+		require.Equal(t, "jane@example.com", entries[1].Contact)
+		require.Equal(t, "e-mail address", entries[1].Extras["label"])
+
+		require.Equal(t, 3, entries[2].Row)
+		require.Empty(t, entries[2].Contact)
+	})
+
+	t.Run("google export", func(t *testing.T) {
+		csv := "Name,Phone 1 - Type,Phone 1 - Value,E-mail 1 - Type,E-mail 1 - Value\n" +
+			"Jane Doe,Mobile,+256757546244 ::: +254701234567,* Work,jane@example.com\n"
+
+		entries, err := business.ParseContactsCSV(strings.NewReader(csv))
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+
+		require.Equal(t, "+256757546244", entries[0].Contact)
+		require.Equal(t, "mobile", entries[0].Extras["label"])
+		require.Equal(t, "+254701234567", entries[1].Contact)
+		require.Equal(t, "jane@example.com", entries[2].Contact)
+		require.Equal(t, "work", entries[2].Extras["label"])
+	})
+
+	t.Run("outlook export", func(t *testing.T) {
+		csv := "First Name,E-mail Address,E-mail Type,E-mail Display Name," +
+			"E-mail 2 Address,Hotel,Business Phone 2,Business Fax\n" +
+			"Jane,jane@example.com,SMTP,Jane (jane@example.com),jane@work.example.com,Serena,+256757546244,+256414000000\n"
+
+		entries, err := business.ParseContactsCSV(strings.NewReader(csv))
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+
+		require.Equal(t, "jane@example.com", entries[0].Contact)
+		require.Equal(t, "jane@work.example.com", entries[1].Contact)
+		require.Equal(t, "e-mail 2 address", entries[1].Extras["label"])
+		require.Equal(t, "+256757546244", entries[2].Contact)
+	})
+
+	t.Run("no contact columns", func(t *testing.T) {
+		_, err := business.ParseContactsCSV(strings.NewReader("Name,Company,Hotel,Telex\nJane,Acme,Serena,123\n"))
+		require.Error(t, err)
+	})
+}
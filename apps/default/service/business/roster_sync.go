@@ -0,0 +1,260 @@
+package business
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/security"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+// maxRosterSyncEntries caps the contacts a single sync may send.
+const maxRosterSyncEntries = 5000
+
+// rosterSyncClockMargin is how far before a sync the next one starts, so
+// changes stamped by instances whose clocks run behind are not missed.
+const rosterSyncClockMargin = time.Minute
+
+// rosterSyncToken is the decoded form of the opaque sync token handed to
+// clients. Tying it to the roster name stops a token from one roster being
+// replayed against another.
+type rosterSyncToken struct {
+	Name     string `json:"n"`
+	SyncedAt int64  `json:"t"`
+}
+
+// rosterSyncTokenMAC signs payload for the roster of profileID.
+func rosterSyncTokenMAC(key []byte, profileID string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("roster_sync:" + profileID + ":"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// encodeRosterSyncToken returns the token of a sync of the roster name of
+// profileID, signed so clients can't move it back or forth.
+func (rb *rosterBusiness) encodeRosterSyncToken(profileID, name string, syncedAt time.Time) string {
+	raw, _ := json.Marshal(rosterSyncToken{Name: name, SyncedAt: syncedAt.UnixMicro()})
+	return base64.RawURLEncoding.EncodeToString(raw) + "." +
+		base64.RawURLEncoding.EncodeToString(rosterSyncTokenMAC(rb.dek.LookUpKey, profileID, raw))
+}
+
+// decodeRosterSyncToken returns the time token was issued at for the
+// roster name of profileID, the zero time for an empty token.
+func (rb *rosterBusiness) decodeRosterSyncToken(token, profileID, name string) (time.Time, error) {
+	if token == "" {
+		return time.Time{}, nil
+	}
+
+	malformed := errors.New("malformed sync token")
+	encoded, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return time.Time{}, malformed
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return time.Time{}, malformed
+	}
+	tokenMAC, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return time.Time{}, malformed
+	}
+	// Tokens signed before the lookup key was rotated stay valid
+	signed := slices.ContainsFunc(rb.dek.LookUpKeys(), func(key []byte) bool {
+		return hmac.Equal(tokenMAC, rosterSyncTokenMAC(key, profileID, raw))
+	})
+	if !signed {
+		return time.Time{}, errors.New("sync token is not valid for this roster")
+	}
+
+	var decoded rosterSyncToken
+	if err = json.Unmarshal(raw, &decoded); err != nil || decoded.SyncedAt <= 0 {
+		return time.Time{}, malformed
+	}
+	if decoded.Name != name {
+		return time.Time{}, fmt.Errorf("sync token was not issued for roster %s", name)
+	}
+	return time.UnixMicro(decoded.SyncedAt), nil
+}
+
+func (rb *rosterBusiness) SyncRoster(
+	ctx context.Context,
+	request *models.RosterSyncRequest,
+) (*models.RosterSyncResult, error) {
+	claims := security.ClaimsFromContext(ctx)
+	if claims == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("no claims found in context"))
+	}
+
+	profileID, err := claims.GetSubject()
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	if len(request.Added)+len(request.Updated)+len(request.Removed) > maxRosterSyncEntries {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("a sync may carry at most %d contacts", maxRosterSyncEntries))
+	}
+
+	name := request.Name
+	if name == "" {
+		name = "default"
+	}
+
+	since, err := rb.decodeRosterSyncToken(request.SyncToken, profileID, name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	// The next sync starts a margin before this one's writes, so it misses
+	// no change made meanwhile, even one stamped by an instance whose clock
+	// lags. Changes near the start come back again; clients apply them by
+	// roster id.
+	syncedAt := time.Now().Add(-rosterSyncClockMargin)
+
+	err = rb.removeSyncedContacts(ctx, profileID, name, request.Removed)
+	if err != nil {
+		return nil, err
+	}
+
+	notListed, err := rb.updateSyncedContacts(ctx, profileID, name, request.Updated)
+	if err != nil {
+		return nil, err
+	}
+
+	added := make([]*profilev1.RawContact, 0, len(request.Added)+len(notListed))
+	for _, entry := range slices.Concat(request.Added, notListed) {
+		added = append(added, &profilev1.RawContact{Contact: entry.Contact, Extras: entry.Extras.ToProtoStruct()})
+	}
+	if _, err = rb.createRosterBatches(ctx, profileID, name, added); err != nil {
+		return nil, err
+	}
+
+	changed, err := rb.rosterRepository.GetChangedSince(ctx, profileID, name, since)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	result := &models.RosterSyncResult{
+		SyncToken: rb.encodeRosterSyncToken(profileID, name, syncedAt),
+		Removed:   []string{},
+	}
+	current := make([]*models.Roster, 0, len(changed))
+	for _, roster := range changed {
+		if roster.DeletedAt.Valid {
+			result.Removed = append(result.Removed, roster.GetID())
+			continue
+		}
+		current = append(current, roster)
+	}
+
+	result.Changed, err = rb.ToAPI(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// listedRosters returns the entries of the roster name listing details,
+// keyed by the detail as given.
+func (rb *rosterBusiness) listedRosters(
+	ctx context.Context,
+	profileID, name string,
+	details []string,
+) (map[string]*models.Roster, error) {
+	listed := map[string]*models.Roster{}
+	if len(details) == 0 {
+		return listed, nil
+	}
+
+	contactMap, err := rb.contactBusiness.GetByDetailMap(ctx, details...)
+	if err != nil {
+		return nil, err
+	}
+	if len(contactMap) == 0 {
+		return listed, nil
+	}
+
+	contactIDs := make([]string, 0, len(contactMap))
+	for _, contact := range contactMap {
+		contactIDs = append(contactIDs, contact.GetID())
+	}
+	rosters, err := rb.rosterRepository.GetByContactIDsAndProfileIDAndName(ctx, contactIDs, profileID, name)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	rosterMap := rb.createRosterLookupMap(rosters)
+
+	for detail, contact := range contactMap {
+		if roster, ok := rosterMap[contact.GetID()]; ok {
+			listed[detail] = roster
+		}
+	}
+	return listed, nil
+}
+
+// removeSyncedContacts removes details from the roster name, ignoring
+// those it does not list.
+func (rb *rosterBusiness) removeSyncedContacts(ctx context.Context, profileID, name string, details []string) error {
+	listed, err := rb.listedRosters(ctx, profileID, name, details)
+	if err != nil {
+		return err
+	}
+
+	rosterIDs := make([]string, 0, len(listed))
+	for _, roster := range listed {
+		rosterIDs = append(rosterIDs, roster.GetID())
+	}
+	if err = rb.rosterRepository.DeleteBatch(ctx, rosterIDs); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+// updateSyncedContacts replaces the extras of the entries listing the
+// contacts of entries and returns the entries the roster does not list.
+func (rb *rosterBusiness) updateSyncedContacts(
+	ctx context.Context,
+	profileID, name string,
+	entries []*models.RosterSyncEntry,
+) ([]*models.RosterSyncEntry, error) {
+	details := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		details = append(details, entry.Contact)
+	}
+
+	listed, err := rb.listedRosters(ctx, profileID, name, details)
+	if err != nil {
+		return nil, err
+	}
+
+	var notListed []*models.RosterSyncEntry
+	for _, entry := range entries {
+		roster, ok := listed[entry.Contact]
+		if !ok {
+			notListed = append(notListed, entry)
+			continue
+		}
+
+		roster.Properties = entry.Extras
+		if roster.Properties == nil {
+			roster.Properties = data.JSONMap{}
+		}
+		if _, err = rb.rosterRepository.Update(ctx, roster, "properties", "modified_at"); err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+	}
+	return notListed, nil
+}
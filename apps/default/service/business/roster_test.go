@@ -4,16 +4,21 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"connectrpc.com/connect"
 	"github.com/pitabwire/frame/v2"
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/frame/v2/datastore"
 	"github.com/pitabwire/frame/v2/frametests/definition"
 	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/util"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/types/known/structpb"
 	"gorm.io/gorm"
 
 	"github.com/antinvestor/service-profile/apps/default/config"
@@ -93,7 +98,8 @@ func (rts *RosterTestSuite) getRosterBusiness(ctx context.Context, svc *frame.Se
 	)
 
 	rosterRepo := repository.NewRosterRepository(ctx, dbPool, workMan)
-	return business.NewRosterBusiness(ctx, cfg, createRosterContactTestDEK(cfg), contactBusiness, rosterRepo)
+	return business.NewRosterBusiness(ctx, cfg, createRosterContactTestDEK(cfg), contactBusiness,
+		repository.NewProfileRepository(ctx, dbPool, workMan), rosterRepo)
 }
 
 func (rts *RosterTestSuite) getContactBusiness(
@@ -174,7 +180,7 @@ func (rts *RosterTestSuite) TestRosterBusiness_ToApi() {
 
 		// Use the same DEK that was used to create the contact
 		dek := createRosterTestDEK(svc.Config().(*config.ProfileConfig))
-		result, err := roster.ToAPI(dek)
+		result, err := roster.ToAPI(ctx, dek)
 		require.NoError(t, err)
 		require.Equal(t, "profile123", result.GetProfileId(), "Profile ID should match")
 	})
@@ -454,6 +460,219 @@ func (rts *RosterTestSuite) TestRosterBusiness_Search() {
 	})
 }
 
+func (rts *RosterTestSuite) TestRosterBusiness_Matches() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		rb := rts.getRosterBusiness(ctx, svc)
+		cb, _ := rts.getContactBusiness(ctx, svc)
+
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		profileRepo := repository.NewProfileRepository(ctx, dbPool, workMan)
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+
+		ctx = security.ClaimsFromMap(map[string]string{
+			"sub":          "matchOwner",
+			"tenant_id":    "tenantx",
+			"partition_id": "party",
+		}).ClaimsToContext(ctx)
+
+		profileType, err := profileRepo.GetTypeByUID(ctx, profilev1.ProfileType_PERSON)
+		require.NoError(t, err)
+
+		// Contacts verified for profiles of each roster visibility
+		joined := map[string]string{}
+		for detail, visibility := range map[string]string{
+			"joined.visible@test.com":  models.RosterVisibilityProfile,
+			"joined.withheld@test.com": models.RosterVisibilityMatched,
+			"joined.hidden@test.com":   models.RosterVisibilityHidden,
+			"joined.default@test.com":  "",
+		} {
+			profile := &models.Profile{Properties: data.JSONMap{}, ProfileTypeID: profileType.GetID()}
+			if visibility != "" {
+				profile.Properties[models.ProfileRosterVisibilityProperty] = visibility
+			}
+			profile.GenID(ctx)
+			require.NoError(t, profileRepo.Create(ctx, profile))
+
+			contact, err := cb.CreateContact(ctx, detail, data.JSONMap{})
+			require.NoError(t, err)
+			contact.ProfileID = profile.GetID()
+			contact.MarkVerified(util.IDString(), time.Now(), nil)
+			_, err = contactRepo.Update(ctx, contact, "profile_id", "verification_id", "verified_at")
+			require.NoError(t, err)
+			joined[detail] = profile.GetID()
+		}
+
+		rosters, err := rts.createRoster(ctx, rb, "matchOwner", map[string]data.JSONMap{
+			"joined.visible@test.com":  {"name": "Visible"},
+			"joined.withheld@test.com": {"name": "Withheld"},
+			"joined.hidden@test.com":   {"name": "Hidden"},
+			"joined.default@test.com":  {"name": "Default"},
+			"not.joined@test.com":      {"name": "Stranger"},
+		})
+		require.NoError(t, err)
+
+		visible := rosters["joined.visible@test.com"].GetExtra().AsMap()
+		require.Equal(t, true, visible[models.RosterMatchedExtra])
+		require.Equal(t, joined["joined.visible@test.com"], visible[models.RosterMatchedProfileIDExtra])
+
+		// Profiles that didn't choose a visibility are matched without their ID
+		for _, detail := range []string{"joined.withheld@test.com", "joined.default@test.com"} {
+			extra := rosters[detail].GetExtra().AsMap()
+			require.Equal(t, true, extra[models.RosterMatchedExtra], detail)
+			require.NotContains(t, extra, models.RosterMatchedProfileIDExtra, detail)
+		}
+
+		for _, detail := range []string{"joined.hidden@test.com", "not.joined@test.com"} {
+			extra := rosters[detail].GetExtra().AsMap()
+			require.Equal(t, false, extra[models.RosterMatchedExtra], detail)
+			require.NotContains(t, extra, models.RosterMatchedProfileIDExtra, detail)
+		}
+
+		matchedOnly, err := structpb.NewStruct(map[string]any{models.RosterMatchedExtra: true})
+		require.NoError(t, err)
+		jobResult, err := rb.Search(ctx, &profilev1.SearchRosterRequest{Extras: matchedOnly})
+		require.NoError(t, err)
+
+		var matched []*models.Roster
+		for result := range jobResult.ResultChan() {
+			if result == nil || result.IsError() {
+				break
+			}
+			matched = append(matched, result.Item()...)
+		}
+		require.Len(t, matched, 3)
+	})
+}
+
+func (rts *RosterTestSuite) TestRosterBusiness_SyncRoster() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		rb := rts.getRosterBusiness(ctx, svc)
+
+		ctx = security.ClaimsFromMap(map[string]string{
+			"sub":          "syncOwner",
+			"tenant_id":    "tenantx",
+			"partition_id": "party",
+		}).ClaimsToContext(ctx)
+
+		byDetail := func(rosters []*profilev1.RosterObject) map[string]*profilev1.RosterObject {
+			result := map[string]*profilev1.RosterObject{}
+			for _, roster := range rosters {
+				result[roster.GetContact().GetDetail()] = roster
+			}
+			return result
+		}
+
+		// A first sync sends the whole contact book
+		first, err := rb.SyncRoster(ctx, &models.RosterSyncRequest{
+			Name: "phone",
+			Added: []*models.RosterSyncEntry{
+				{Contact: "sync.a@test.com", Extras: data.JSONMap{"name": "A"}},
+				{Contact: "sync.b@test.com", Extras: data.JSONMap{"name": "B"}},
+				{Contact: "sync.c@test.com", Extras: data.JSONMap{"name": "C"}},
+			},
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, first.SyncToken)
+		require.Len(t, first.Changed, 3)
+		require.Empty(t, first.Removed)
+		firstRosters := byDetail(first.Changed)
+
+		second, err := rb.SyncRoster(ctx, &models.RosterSyncRequest{
+			Name:      "phone",
+			SyncToken: first.SyncToken,
+			Added:     []*models.RosterSyncEntry{{Contact: "sync.d@test.com"}},
+			Updated:   []*models.RosterSyncEntry{{Contact: "sync.b@test.com", Extras: data.JSONMap{"name": "Bee"}}},
+			Removed:   []string{"sync.c@test.com"},
+		})
+		require.NoError(t, err)
+		// Entries of the first sync may come back, as it ran within the clock margin
+		changed := byDetail(second.Changed)
+		require.Contains(t, changed, "sync.d@test.com")
+		require.NotContains(t, changed, "sync.c@test.com")
+		require.Equal(t, "Bee", changed["sync.b@test.com"].GetExtra().AsMap()["name"])
+		require.Equal(t, []string{firstRosters["sync.c@test.com"].GetId()}, second.Removed)
+
+		// Without a token the roster is sent whole, less removed entries
+		full, err := rb.SyncRoster(ctx, &models.RosterSyncRequest{Name: "phone"})
+		require.NoError(t, err)
+		require.Len(t, full.Changed, 3)
+		require.Empty(t, full.Removed)
+
+		// Adding a removed contact again brings its entry back
+		readded, err := rb.SyncRoster(ctx, &models.RosterSyncRequest{
+			Name:      "phone",
+			SyncToken: second.SyncToken,
+			Added:     []*models.RosterSyncEntry{{Contact: "sync.c@test.com"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, firstRosters["sync.c@test.com"].GetId(), byDetail(readded.Changed)["sync.c@test.com"].GetId())
+
+		_, err = rb.SyncRoster(ctx, &models.RosterSyncRequest{Name: "work", SyncToken: first.SyncToken})
+		require.Error(t, err)
+		_, err = rb.SyncRoster(ctx, &models.RosterSyncRequest{Name: "phone", SyncToken: "not-a-token"})
+		require.Error(t, err)
+
+		// Tokens are signed for the roster owner
+		payload, _, _ := strings.Cut(first.SyncToken, ".")
+		_, err = rb.SyncRoster(ctx, &models.RosterSyncRequest{Name: "phone", SyncToken: payload + ".AAAA"})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		otherCtx := security.ClaimsFromMap(map[string]string{
+			"sub":          "otherSyncOwner",
+			"tenant_id":    "tenantx",
+			"partition_id": "party",
+		}).ClaimsToContext(ctx)
+		_, err = rb.SyncRoster(otherCtx, &models.RosterSyncRequest{Name: "phone", SyncToken: first.SyncToken})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
+func (rts *RosterTestSuite) TestRosterBusiness_ImportRoster() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		rb := rts.getRosterBusiness(ctx, svc)
+
+		ctx = security.ClaimsFromMap(map[string]string{
+			"sub":          "importOwner",
+			"tenant_id":    "tenantx",
+			"partition_id": "party",
+		}).ClaimsToContext(ctx)
+
+		csv := "Name,Mobile Phone,Email\n" +
+			"Jane Doe,0757 546244,import.jane@test.com\n" +
+			"No Contacts,,\n" +
+			"Bad Detail,,not-an-email\n"
+
+		result, err := rb.ImportRoster(ctx, "imported", business.RosterImportCSV, "UG", strings.NewReader(csv))
+		require.NoError(t, err)
+
+		imported := map[string]*profilev1.RosterObject{}
+		for _, roster := range result.Imported {
+			imported[roster.GetContact().GetDetail()] = roster
+		}
+		require.Len(t, imported, 2)
+		require.Contains(t, imported, "+256757546244")
+		require.Equal(t, "Jane Doe", imported["import.jane@test.com"].GetExtra().AsMap()["name"])
+		require.Equal(t, "imported", imported["import.jane@test.com"].GetName())
+
+		require.Len(t, result.Failures, 2)
+		require.Equal(t, 3, result.Failures[0].Row)
+		require.Equal(t, 4, result.Failures[1].Row)
+		require.Equal(t, "not-an-email", result.Failures[1].Contact)
+
+		_, err = rb.ImportRoster(ctx, "imported", "xml", "", strings.NewReader(csv))
+		require.Error(t, err)
+	})
+}
+
 //nolint:gocognit // multi-scenario integration test; splitting hurts readability
 func (rts *RosterTestSuite) TestRosterBusiness_MultiList() {
 	t := rts.T()
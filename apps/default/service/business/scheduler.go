@@ -0,0 +1,66 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Scheduler is a background job run periodically.
+type Scheduler interface {
+	// StartScheduler runs the job immediately and then periodically, one
+	// replica at a time. Blocks until ctx is cancelled.
+	StartScheduler(ctx context.Context, locker repository.JobLocker)
+}
+
+// runScheduled runs job immediately and then every interval, or
+// defaultInterval when that is unset, until ctx is cancelled. Each run takes
+// the job's lock first and is skipped while another replica holds it.
+func runScheduled(
+	ctx context.Context,
+	locker repository.JobLocker,
+	name string,
+	interval, defaultInterval time.Duration,
+	job func(ctx context.Context) error,
+) {
+	log := util.Log(ctx).WithField("job", name)
+
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	run := func() {
+		release, locked, err := locker.TryLock(ctx, name)
+		if err != nil {
+			log.WithError(err).Error("could not lock scheduled job")
+			return
+		}
+		if !locked {
+			log.Debug("scheduled job is running elsewhere")
+			return
+		}
+		defer release()
+
+		if err = job(ctx); err != nil {
+			log.WithError(err).Error("scheduled job run failed")
+		}
+	}
+
+	run()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("job scheduler stopped")
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package business //nolint:testpackage // tests access the unexported scheduler loop
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeJobLocker struct {
+	mu       sync.Mutex
+	held     bool
+	attempts int
+	released int
+}
+
+func (l *fakeJobLocker) TryLock(_ context.Context, _ string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts++
+	if l.held {
+		return nil, false, nil
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.released++
+	}, true, nil
+}
+
+func TestRunScheduled(t *testing.T) {
+	locker := &fakeJobLocker{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runs := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runScheduled(ctx, locker, "job", 10*time.Millisecond, time.Hour, func(context.Context) error {
+			runs <- struct{}{}
+			return errors.New("failed runs are retried on the next tick")
+		})
+	}()
+
+	// The job runs immediately and again on the interval, releasing the lock
+	// after each run
+	<-runs
+	<-runs
+	cancel()
+	<-done
+
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	require.GreaterOrEqual(t, locker.released, 2)
+	require.Equal(t, locker.attempts, locker.released)
+}
+
+func TestRunScheduled_LockedElsewhere(t *testing.T) {
+	locker := &fakeJobLocker{held: true}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var ran atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runScheduled(ctx, locker, "job", 5*time.Millisecond, time.Hour, func(context.Context) error {
+			ran.Store(true)
+			return nil
+		})
+	}()
+
+	require.Eventually(t, func() bool {
+		locker.mu.Lock()
+		defer locker.mu.Unlock()
+		return locker.attempts >= 2
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	require.False(t, ran.Load())
+	require.Zero(t, locker.released)
+}
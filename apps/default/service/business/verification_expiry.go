@@ -0,0 +1,124 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	frevents "github.com/pitabwire/frame/v2/events"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/events"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+// Verification expiry defaults.
+const (
+	verificationExpiryBatchSize     = 500
+	defaultVerificationExpiryPeriod = time.Hour
+)
+
+// VerificationExpiryBusiness ends contact verifications that outlived the
+// validity period of their contact type.
+type VerificationExpiryBusiness interface {
+	// RunExpiry applies ContactVerificationValidityInSec to verified contacts
+	// and unverifies those whose verification went stale, emitting a
+	// contact.verification.expired event for each.
+	RunExpiry(ctx context.Context) error
+	Scheduler
+}
+
+func NewVerificationExpiryBusiness(_ context.Context, cfg *config.ProfileConfig,
+	evtMan frevents.Manager, contactRepo repository.ContactRepository) VerificationExpiryBusiness {
+	return &verificationExpiryBusiness{
+		cfg:         cfg,
+		eventsMan:   evtMan,
+		contactRepo: contactRepo,
+	}
+}
+
+type verificationExpiryBusiness struct {
+	cfg         *config.ProfileConfig
+	eventsMan   frevents.Manager
+	contactRepo repository.ContactRepository
+}
+
+// StartScheduler runs RunExpiry immediately, then every
+// VerificationExpiryIntervalInSec.
+func (veb *verificationExpiryBusiness) StartScheduler(ctx context.Context, locker repository.JobLocker) {
+	runScheduled(ctx, locker, "verification_expiry",
+		time.Duration(veb.cfg.VerificationExpiryIntervalInSec)*time.Second, defaultVerificationExpiryPeriod,
+		veb.RunExpiry)
+}
+
+func (veb *verificationExpiryBusiness) RunExpiry(ctx context.Context) error {
+	log := util.Log(ctx)
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+
+	// Contacts verified before a validity applied to their type get one now
+	for contactType, validity := range veb.cfg.ContactVerificationValidityInSec {
+		if validity <= 0 {
+			continue
+		}
+		_, err := veb.contactRepo.ApplyVerificationValidity(
+			unscopedCtx, contactType, time.Duration(validity)*time.Second)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	expired := 0
+	for {
+		contacts, err := veb.contactRepo.ListStaleVerifications(unscopedCtx, now, verificationExpiryBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, contact := range contacts {
+			expiry := &models.ContactVerificationExpiry{
+				ContactID:      contact.GetID(),
+				ProfileID:      contact.ProfileID,
+				ContactType:    contact.ContactType,
+				VerificationID: contact.VerificationID,
+				VerifiedAt:     contact.VerifiedAt,
+				ExpiredAt:      contact.VerificationExpiresAt,
+			}
+
+			// Only the run that clears the verification reports its expiry,
+			// and a contact verified again meanwhile is left alone.
+			claimed, claimErr := veb.contactRepo.ClaimStaleVerification(
+				unscopedCtx, contact.GetID(), contact.VerificationID, now)
+			if claimErr != nil {
+				return claimErr
+			}
+			if !claimed {
+				continue
+			}
+
+			err = veb.eventsMan.Emit(ctx, events.ContactVerificationExpiredEventHandlerName, expiry)
+			if err != nil {
+				log.WithError(err).WithField("contact_id", contact.GetID()).
+					Error("could not emit contact verification expiry")
+				// Restored so the next run reports it
+				releaseErr := veb.contactRepo.ReleaseStaleVerification(
+					unscopedCtx, contact.GetID(), expiry.VerificationID)
+				if releaseErr != nil {
+					log.WithError(releaseErr).WithField("contact_id", contact.GetID()).
+						Error("could not restore unreported verification")
+				}
+				return err
+			}
+			expired++
+		}
+
+		if len(contacts) < verificationExpiryBatchSize {
+			break
+		}
+	}
+
+	log.WithField("contacts", expired).Info("verification expiry run complete")
+	return nil
+}
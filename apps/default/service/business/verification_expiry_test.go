@@ -0,0 +1,82 @@
+package business_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/frametests/definition"
+	"github.com/pitabwire/util"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+	"github.com/antinvestor/service-profile/apps/default/tests"
+)
+
+type VerificationExpiryTestSuite struct {
+	tests.ProfileBaseTestSuite
+}
+
+func TestVerificationExpirySuite(t *testing.T) {
+	suite.Run(t, new(VerificationExpiryTestSuite))
+}
+
+func (vets *VerificationExpiryTestSuite) Test_verificationExpiryBusiness_RunExpiry() {
+	t := vets.T()
+
+	vets.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := vets.CreateService(t, dep)
+
+		workMan := svc.WorkManager()
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		cfg := svc.Config().(*config.ProfileConfig)
+		cfg.ContactVerificationValidityInSec = map[string]int{"EMAIL": 3600}
+
+		contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
+		cb := business.NewContactBusiness(ctx, cfg, createContactTestDEK(cfg), svc.EventsManager(),
+			contactRepo, repository.NewVerificationRepository(ctx, dbPool, workMan))
+
+		verify := func(detail string, verifiedAt time.Time, validity map[string]int) string {
+			contact, err := cb.CreateContact(ctx, detail, data.JSONMap{})
+			require.NoError(t, err)
+			contact.MarkVerified(util.IDString(), verifiedAt, validity)
+			_, err = contactRepo.Update(ctx, contact, "verification_id", "verified_at", "verification_expires_at")
+			require.NoError(t, err)
+			return contact.GetID()
+		}
+
+		stale := verify("stale@testing.com", time.Now().Add(-2*time.Hour), cfg.ContactVerificationValidityInSec)
+		fresh := verify("fresh@testing.com", time.Now(), cfg.ContactVerificationValidityInSec)
+		// Verified before the validity applied to its type
+		legacy := verify("legacy@testing.com", time.Now().Add(-2*time.Hour), nil)
+
+		expiry := business.NewVerificationExpiryBusiness(ctx, cfg, svc.EventsManager(), contactRepo)
+		require.NoError(t, expiry.RunExpiry(ctx))
+
+		for contactID, verified := range map[string]bool{stale: false, fresh: true, legacy: false} {
+			contact, err := contactRepo.GetByID(ctx, contactID)
+			require.NoError(t, err)
+			require.Equal(t, verified, contact.VerificationFresh(time.Now()), contactID)
+			require.Equal(t, verified, contact.VerificationID != "", contactID)
+			require.False(t, contact.VerifiedAt.IsZero())
+			require.False(t, contact.VerificationExpiresAt.IsZero())
+		}
+
+		// An expiry is claimed once, and only for the verification listed
+		verificationID := util.IDString()
+		require.NoError(t, contactRepo.ReleaseStaleVerification(ctx, stale, verificationID))
+		claimed, err := contactRepo.ClaimStaleVerification(ctx, stale, util.IDString(), time.Now())
+		require.NoError(t, err)
+		require.False(t, claimed)
+		claimed, err = contactRepo.ClaimStaleVerification(ctx, stale, verificationID, time.Now())
+		require.NoError(t, err)
+		require.True(t, claimed)
+		claimed, err = contactRepo.ClaimStaleVerification(ctx, stale, verificationID, time.Now())
+		require.NoError(t, err)
+		require.False(t, claimed)
+	})
+}
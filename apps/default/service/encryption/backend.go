@@ -0,0 +1,41 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+)
+
+// Master key backends.
+const (
+	BackendLocal = "local"
+	BackendVault = "vault"
+)
+
+const vaultRequestTimeout = 10 * time.Second
+
+// MasterKeyBackend wraps and unwraps data keys with master keys it holds.
+// Cloud KMS services plug in by implementing it.
+type MasterKeyBackend interface {
+	// Wrap encrypts dataKey with the active master key, returning the id of
+	// that master key with the wrapped data key.
+	Wrap(ctx context.Context, dataKey []byte) (string, []byte, error)
+	// Unwrap decrypts a data key wrapped by the master key masterKeyID.
+	Unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error)
+}
+
+// NewMasterKeyBackend builds the backend named by cfg.MasterKeyBackend.
+func NewMasterKeyBackend(cfg *config.ProfileConfig) (MasterKeyBackend, error) {
+	switch cfg.MasterKeyBackend {
+	case BackendLocal:
+		return LoadKeyringFile(cfg.MasterKeyringFile)
+	case BackendVault:
+		return NewVaultTransit(cfg.VaultAddress, cfg.VaultToken, cfg.VaultTransitMount, cfg.VaultTransitKey,
+			&http.Client{Timeout: vaultRequestTimeout})
+	default:
+		return nil, fmt.Errorf("unsupported master key backend %q", cfg.MasterKeyBackend)
+	}
+}
@@ -0,0 +1,282 @@
+package encryption_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/util"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/encryption"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+// memoryDataKeyStore keeps data keys in memory, in creation order.
+type memoryDataKeyStore struct {
+	mu   sync.Mutex
+	keys []*models.DataKey
+}
+
+func (s *memoryDataKeyStore) GetByKeyID(_ context.Context, keyID string) (*models.DataKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dataKey := range s.keys {
+		if dataKey.GetID() == keyID {
+			return dataKey, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryDataKeyStore) GetLatest(_ context.Context, tenantID string) (*models.DataKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if s.keys[i].TenantID == tenantID && !s.keys[i].IsShredded() {
+			return s.keys[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryDataKeyStore) Create(_ context.Context, dataKey *models.DataKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dataKey.ID = util.IDString()
+	s.keys = append(s.keys, dataKey)
+	return nil
+}
+
+func (s *memoryDataKeyStore) Shred(_ context.Context, tenantID string, at time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var shredded int64
+	for i, dataKey := range s.keys {
+		if dataKey.TenantID == tenantID && !dataKey.IsShredded() {
+			// Replace rather than mutate, as providers may hold the old value
+			s.keys[i] = &models.DataKey{BaseModel: dataKey.BaseModel, MasterKeyID: dataKey.MasterKeyID, ShreddedAt: at}
+			shredded++
+		}
+	}
+	return shredded, nil
+}
+
+func TestKeyring_WrapUnwrap(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := encryption.NewKeyring("master-1", map[string][]byte{
+		"master-1": []byte("12345678901234567890123456789012"),
+	})
+	require.NoError(t, err)
+
+	masterKeyID, wrapped, err := keyring.Wrap(ctx, []byte("data-key"))
+	require.NoError(t, err)
+	require.Equal(t, "master-1", masterKeyID)
+	require.NotEqual(t, []byte("data-key"), wrapped)
+
+	dataKey, err := keyring.Unwrap(ctx, masterKeyID, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data-key"), dataKey)
+
+	_, err = keyring.Unwrap(ctx, "master-0", wrapped)
+	require.Error(t, err)
+
+	_, err = encryption.NewKeyring("missing", map[string][]byte{})
+	require.Error(t, err)
+}
+
+func TestLoadKeyringFile(t *testing.T) {
+	ctx := context.Background()
+	oldKey := []byte("abcdefghijklmnopqrstuvwxyz123456")
+	newKey := []byte("12345678901234567890123456789012")
+
+	path := filepath.Join(t.TempDir(), "keyring")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join([]string{
+		"# master keys, newest last",
+		"master-1:" + base64.StdEncoding.EncodeToString(oldKey),
+		"",
+		"master-2:" + base64.StdEncoding.EncodeToString(newKey),
+	}, "\n")), 0o600))
+
+	keyring, err := encryption.LoadKeyringFile(path)
+	require.NoError(t, err)
+
+	masterKeyID, _, err := keyring.Wrap(ctx, []byte("data-key"))
+	require.NoError(t, err)
+	require.Equal(t, "master-2", masterKeyID)
+
+	// Data keys wrapped before the master key rotated still unwrap
+	wrapped, err := util.EncryptValue(oldKey, []byte("old-data-key"))
+	require.NoError(t, err)
+	dataKey, err := keyring.Unwrap(ctx, "master-1", wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("old-data-key"), dataKey)
+
+	_, err = encryption.LoadKeyringFile(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestVaultTransit_WrapUnwrap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "vault-token" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var body map[string]string
+		_ = json.NewDecoder(req.Body).Decode(&body)
+
+		var result map[string]string
+		switch req.URL.Path {
+		case "/v1/transit/encrypt/contacts":
+			result = map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}
+		case "/v1/transit/decrypt/contacts":
+			result = map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(map[string]any{"data": result})
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	vault, err := encryption.NewVaultTransit(server.URL, "vault-token", "transit", "contacts", server.Client())
+	require.NoError(t, err)
+
+	masterKeyID, wrapped, err := vault.Wrap(ctx, []byte("data-key"))
+	require.NoError(t, err)
+	require.Equal(t, "vault:transit/contacts", masterKeyID)
+	require.True(t, strings.HasPrefix(string(wrapped), "vault:v1:"))
+
+	dataKey, err := vault.Unwrap(ctx, masterKeyID, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data-key"), dataKey)
+
+	denied, err := encryption.NewVaultTransit(server.URL, "wrong-token", "transit", "contacts", server.Client())
+	require.NoError(t, err)
+	_, _, err = denied.Wrap(ctx, []byte("data-key"))
+	require.Error(t, err)
+}
+
+func TestEnvelopeKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := encryption.NewKeyring("master-1", map[string][]byte{
+		"master-1": []byte("12345678901234567890123456789012"),
+	})
+	require.NoError(t, err)
+
+	store := &memoryDataKeyStore{}
+	provider := encryption.NewEnvelopeKeyProvider(keyring, store)
+
+	keyID, key, err := provider.EncryptionKey(ctx, "tenant-a")
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+
+	// The tenant keeps its data key
+	sameID, sameKey, err := provider.EncryptionKey(ctx, "tenant-a")
+	require.NoError(t, err)
+	require.Equal(t, keyID, sameID)
+	require.Equal(t, key, sameKey)
+
+	otherID, otherKey, err := provider.EncryptionKey(ctx, "tenant-b")
+	require.NoError(t, err)
+	require.NotEqual(t, keyID, otherID)
+	require.NotEqual(t, key, otherKey)
+	require.Len(t, store.keys, 2)
+
+	// Another instance unwraps the stored key
+	restarted := encryption.NewEnvelopeKeyProvider(keyring, store)
+	unwrapped, err := restarted.DecryptionKey(ctx, keyID)
+	require.NoError(t, err)
+	require.Equal(t, key, unwrapped)
+
+	_, err = restarted.DecryptionKey(ctx, "unknown")
+	require.Error(t, err)
+}
+
+func TestContact_DetailWithEnvelopeKeys(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := encryption.NewKeyring("master-1", map[string][]byte{
+		"master-1": []byte("12345678901234567890123456789012"),
+	})
+	require.NoError(t, err)
+
+	dek := &config.DEK{
+		KeyID:    "static-key",
+		Key:      []byte("abcdefghijklmnopqrstuvwxyz123456"),
+		Provider: encryption.NewEnvelopeKeyProvider(keyring, &memoryDataKeyStore{}),
+	}
+
+	keyID, key, err := dek.EncryptionKey(ctx, "tenant-a")
+	require.NoError(t, err)
+	require.NotEqual(t, dek.KeyID, keyID)
+	require.Equal(t, []string{"static-key"}, dek.RetiredKeyIDs())
+
+	for contactKeyID, contactKey := range map[string][]byte{keyID: key, dek.KeyID: dek.Key} {
+		encryptedDetail, encryptErr := util.EncryptValue(contactKey, []byte("test@example.com"))
+		require.NoError(t, encryptErr)
+
+		contact := &models.Contact{EncryptedDetail: encryptedDetail, EncryptionKeyID: contactKeyID}
+		detail, detailErr := contact.Detail(ctx, dek)
+		require.NoError(t, detailErr)
+		require.Equal(t, "test@example.com", detail)
+	}
+}
+
+func TestEnvelopeKeyProvider_ShredTenant(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := encryption.NewKeyring("master-1", map[string][]byte{
+		"master-1": []byte("12345678901234567890123456789012"),
+	})
+	require.NoError(t, err)
+
+	store := &memoryDataKeyStore{}
+	provider := encryption.NewEnvelopeKeyProvider(keyring, store)
+
+	keyID, _, err := provider.EncryptionKey(ctx, "tenant-a")
+	require.NoError(t, err)
+	otherKeyID, otherKey, err := provider.EncryptionKey(ctx, "tenant-b")
+	require.NoError(t, err)
+
+	shredded, err := provider.ShredTenant(ctx, "tenant-a")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), shredded)
+
+	_, err = provider.DecryptionKey(ctx, keyID)
+	require.ErrorIs(t, err, config.ErrKeyShredded)
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+	_, err = encryption.NewEnvelopeKeyProvider(keyring, store).DecryptionKey(ctx, keyID)
+	require.ErrorIs(t, err, config.ErrKeyShredded)
+
+	// Other tenants keep their keys
+	key, err := provider.DecryptionKey(ctx, otherKeyID)
+	require.NoError(t, err)
+	require.Equal(t, otherKey, key)
+
+	// New details of the tenant get a fresh key
+	newKeyID, _, err := provider.EncryptionKey(ctx, "tenant-a")
+	require.NoError(t, err)
+	require.NotEqual(t, keyID, newKeyID)
+
+	shredded, err = provider.ShredTenant(ctx, "tenant-c")
+	require.NoError(t, err)
+	require.Zero(t, shredded)
+
+	// Shredding through the DEK surfaces the shredded error from Contact.Detail
+	dek := &config.DEK{KeyID: "static-key", Key: []byte("abcdefghijklmnopqrstuvwxyz123456"), Provider: provider}
+	contact := &models.Contact{EncryptedDetail: []byte("gone"), EncryptionKeyID: keyID}
+	_, err = contact.Detail(ctx, dek)
+	require.ErrorIs(t, err, config.ErrKeyShredded)
+}
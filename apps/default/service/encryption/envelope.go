@@ -0,0 +1,180 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/security"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+const dataKeySize = 32
+
+// DataKeyCacheTTL bounds how long an unwrapped data key is used before it is
+// read again, and so how long other instances keep using a shredded key.
+const DataKeyCacheTTL = 5 * time.Minute
+
+// DataKeyStore persists wrapped data keys.
+type DataKeyStore interface {
+	GetByKeyID(ctx context.Context, keyID string) (*models.DataKey, error)
+	GetLatest(ctx context.Context, tenantID string) (*models.DataKey, error)
+	Create(ctx context.Context, dataKey *models.DataKey) error
+	Shred(ctx context.Context, tenantID string, at time.Time) (int64, error)
+}
+
+// cachedKey is an unwrapped data key held in memory.
+type cachedKey struct {
+	tenantID  string
+	key       []byte
+	expiresAt time.Time
+}
+
+// EnvelopeKeyProvider is a config.KeyProvider encrypting each tenant's
+// contact details with its own data key. Data keys are stored wrapped by
+// the backend's master key and kept unwrapped in memory for DataKeyCacheTTL.
+type EnvelopeKeyProvider struct {
+	backend MasterKeyBackend
+	store   DataKeyStore
+
+	// createMu keeps concurrent first writes of a tenant from each creating
+	// a data key. Instances may still race; either key then works.
+	createMu sync.Mutex
+	keys     sync.Map // data key id -> *cachedKey
+	latest   sync.Map // tenant id -> data key id
+}
+
+func NewEnvelopeKeyProvider(backend MasterKeyBackend, store DataKeyStore) *EnvelopeKeyProvider {
+	return &EnvelopeKeyProvider{backend: backend, store: store}
+}
+
+// EncryptionKey returns the newest data key of tenantID, creating one if
+// the tenant has none or all of its keys were shredded.
+func (p *EnvelopeKeyProvider) EncryptionKey(ctx context.Context, tenantID string) (string, []byte, error) {
+	if keyID, ok := p.latest.Load(tenantID); ok {
+		if key, cached := p.cached(keyID.(string)); cached {
+			return keyID.(string), key, nil
+		}
+	}
+
+	p.createMu.Lock()
+	defer p.createMu.Unlock()
+
+	dataKey, err := p.store.GetLatest(ctx, tenantID)
+	if err != nil {
+		if !data.ErrorIsNoRows(err) {
+			return "", nil, err
+		}
+		dataKey, err = p.createDataKey(ctx, tenantID)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	key, err := p.unwrap(ctx, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	p.latest.Store(tenantID, dataKey.GetID())
+	return dataKey.GetID(), key, nil
+}
+
+// DecryptionKey returns the data key with keyID, of any tenant, or
+// config.ErrKeyShredded if it was shredded. The error carries
+// connect.CodeFailedPrecondition, so every API reports it alike.
+func (p *EnvelopeKeyProvider) DecryptionKey(ctx context.Context, keyID string) ([]byte, error) {
+	if key, ok := p.cached(keyID); ok {
+		return key, nil
+	}
+
+	dataKey, err := p.store.GetByKeyID(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return p.unwrap(ctx, dataKey)
+}
+
+// ShredTenant destroys the stored data keys of tenantID and drops them from
+// memory. Other instances stop using them once their cache entries expire.
+func (p *EnvelopeKeyProvider) ShredTenant(ctx context.Context, tenantID string) (int64, error) {
+	p.createMu.Lock()
+	defer p.createMu.Unlock()
+
+	shredded, err := p.store.Shred(ctx, tenantID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	p.latest.Delete(tenantID)
+	p.keys.Range(func(keyID, value any) bool {
+		if value.(*cachedKey).tenantID == tenantID {
+			p.keys.Delete(keyID)
+		}
+		return true
+	})
+	return shredded, nil
+}
+
+func (p *EnvelopeKeyProvider) cached(keyID string) ([]byte, bool) {
+	value, ok := p.keys.Load(keyID)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(*cachedKey)
+	if time.Now().After(entry.expiresAt) {
+		p.keys.CompareAndDelete(keyID, value)
+		return nil, false
+	}
+	return entry.key, true
+}
+
+func (p *EnvelopeKeyProvider) remember(dataKey *models.DataKey, key []byte) {
+	p.keys.Store(dataKey.GetID(), &cachedKey{
+		tenantID:  dataKey.TenantID,
+		key:       key,
+		expiresAt: time.Now().Add(DataKeyCacheTTL),
+	})
+}
+
+func (p *EnvelopeKeyProvider) createDataKey(ctx context.Context, tenantID string) (*models.DataKey, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	masterKeyID, wrapped, err := p.backend.Wrap(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := &models.DataKey{MasterKeyID: masterKeyID, WrappedKey: wrapped}
+	dataKey.TenantID = tenantID
+	// The key belongs to tenantID whoever's request first needed it
+	if err = p.store.Create(security.SkipTenancyChecksOnClaims(ctx), dataKey); err != nil {
+		return nil, err
+	}
+	p.remember(dataKey, key)
+	return dataKey, nil
+}
+
+func (p *EnvelopeKeyProvider) unwrap(ctx context.Context, dataKey *models.DataKey) ([]byte, error) {
+	if dataKey.IsShredded() {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, config.ErrKeyShredded)
+	}
+	if key, ok := p.cached(dataKey.GetID()); ok {
+		return key, nil
+	}
+
+	key, err := p.backend.Unwrap(ctx, dataKey.MasterKeyID, dataKey.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	p.remember(dataKey, key)
+	return key, nil
+}
@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pitabwire/util"
+)
+
+// Keyring is a MasterKeyBackend holding its master keys in memory.
+type Keyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewKeyring returns a keyring wrapping with the key activeID of keys.
+func NewKeyring(activeID string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys[activeID]) == 0 {
+		return nil, fmt.Errorf("active master key %q is not in the keyring", activeID)
+	}
+	return &Keyring{activeID: activeID, keys: keys}, nil
+}
+
+// LoadKeyringFile reads a keyring of KEY_ID:base64 lines, the last of which
+// is the active key. Blank lines and lines starting with # are skipped.
+func LoadKeyringFile(path string) (*Keyring, error) {
+	if path == "" {
+		return nil, errors.New("no master keyring file configured")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	activeID := ""
+	keys := map[string][]byte{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyID, encodedKey, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("malformed master keyring line for %q", keyID)
+		}
+		keys[keyID], err = base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+		if err != nil {
+			return nil, fmt.Errorf("master key %s: %w", keyID, err)
+		}
+		activeID = keyID
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewKeyring(activeID, keys)
+}
+
+func (k *Keyring) Wrap(_ context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := util.EncryptValue(k.keys[k.activeID], dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return k.activeID, wrapped, nil
+}
+
+func (k *Keyring) Unwrap(_ context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q is not in the keyring", masterKeyID)
+	}
+	return util.DecryptValue(key, wrapped)
+}
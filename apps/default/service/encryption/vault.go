@@ -0,0 +1,101 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// VaultTransit is a MasterKeyBackend backed by a key of a HashiCorp Vault
+// transit secrets engine. The master key never leaves Vault, and Vault
+// keeps track of its versions inside the wrapped data keys.
+type VaultTransit struct {
+	address string
+	token   string
+	mount   string
+	keyName string
+	client  *http.Client
+}
+
+// NewVaultTransit returns a backend using the transit key keyName mounted
+// at mount on the Vault server at address.
+func NewVaultTransit(address, token, mount, keyName string, client *http.Client) (*VaultTransit, error) {
+	if address == "" || token == "" || keyName == "" {
+		return nil, errors.New("vault address, token and transit key are required")
+	}
+	return &VaultTransit{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		mount:   strings.Trim(mount, "/"),
+		keyName: keyName,
+		client:  client,
+	}, nil
+}
+
+func (v *VaultTransit) masterKeyID() string {
+	return "vault:" + v.mount + "/" + v.keyName
+}
+
+func (v *VaultTransit) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	var result struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := v.call(ctx, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	}, &result)
+	if err != nil {
+		return "", nil, err
+	}
+	return v.masterKeyID(), []byte(result.Ciphertext), nil
+}
+
+func (v *VaultTransit) Unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	if masterKeyID != v.masterKeyID() {
+		return nil, fmt.Errorf("master key %q is not held by this vault transit key", masterKeyID)
+	}
+
+	var result struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := v.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Plaintext)
+}
+
+// call posts body to the transit operation and decodes its data into result.
+func (v *VaultTransit) call(ctx context.Context, operation string, body map[string]string, result any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", v.address, v.mount, operation, v.keyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault transit %s failed with status %d", operation, resp.StatusCode)
+	}
+
+	envelope := struct {
+		Data any `json:"data"`
+	}{Data: result}
+	return json.NewDecoder(resp.Body).Decode(&envelope)
+}
@@ -3,11 +3,11 @@ package events
 import (
 	"context"
 	"errors"
+	"slices"
 
 	"github.com/pitabwire/util"
 
 	"github.com/antinvestor/service-profile/apps/default/config"
-	"github.com/antinvestor/service-profile/apps/default/service/models"
 	"github.com/antinvestor/service-profile/apps/default/service/repository"
 )
 
@@ -34,7 +34,7 @@ func (vq *ContactKeyRotationQueue) Name() string {
 }
 
 func (vq *ContactKeyRotationQueue) PayloadType() any {
-	return &models.Contact{}
+	return new(string)
 }
 
 func (vq *ContactKeyRotationQueue) Validate(_ context.Context, payload any) error {
@@ -64,28 +64,33 @@ func (vq *ContactKeyRotationQueue) Execute(ctx context.Context, payload any) err
 		return err
 	}
 
-	if vq.dek.OldKeyID != contact.EncryptionKeyID {
+	if !slices.Contains(vq.dek.RetiredKeyIDs(), contact.EncryptionKeyID) {
 		return nil
 	}
 
-	contactDetail, err := contact.DecryptDetail(vq.dek.OldKeyID, vq.dek.OldKey)
+	contactDetail, err := contact.Detail(ctx, vq.dek)
 	if err != nil {
 		return err
 	}
 
-	contact.EncryptedDetail, err = util.EncryptValue(vq.dek.Key, []byte(contactDetail))
+	keyID, key, err := vq.dek.EncryptionKey(ctx, contact.TenantID)
 	if err != nil {
 		return err
 	}
 
-	contact.EncryptionKeyID = vq.dek.KeyID
+	contact.EncryptedDetail, err = util.EncryptValue(key, []byte(contactDetail))
+	if err != nil {
+		return err
+	}
+
+	contact.EncryptionKeyID = keyID
 
 	_, err = vq.contactRepo.Update(ctx, contact, "encrypted_detail", "encryption_key_id")
 	if err != nil {
 		return err
 	}
 
-	logger.Debug("key rotation processed")
+	logger.WithField("key_id", keyID).Debug("key rotation processed")
 
 	return nil
 }
@@ -20,7 +20,7 @@ func TestContactKeyRotationQueue_PayloadType(t *testing.T) {
 	queue := events.NewContactKeyRotationQueue(&config.ProfileConfig{}, &config.DEK{}, nil)
 	payload := queue.PayloadType()
 	require.NotNil(t, payload)
-	_, ok := payload.(*models.Contact)
+	_, ok := payload.(*string)
 	require.True(t, ok)
 }
 
@@ -3,7 +3,6 @@ package events
 import (
 	"context"
 	"errors"
-	"time"
 
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/util"
@@ -14,20 +13,22 @@ import (
 
 const VerificationAttemptEventHandlerName = "contact.verification.attempt.queue"
 
+// ContactVerificationAttemptedQueue records verification attempts queued to
+// it. CheckVerification records its attempts directly, as throttling counts
+// them, so this only stores attempts queued by replicas predating that. It
+// only keeps the record, so nothing in a queued attempt is trusted.
 type ContactVerificationAttemptedQueue struct {
-	ContactRepo      repository.ContactRepository
 	VerificationRepo repository.VerificationRepository
 }
 
 func NewContactVerificationAttemptedQueue(
-	contactRepo repository.ContactRepository,
 	verificationRepo repository.VerificationRepository,
 ) *ContactVerificationAttemptedQueue {
 	return &ContactVerificationAttemptedQueue{
-		ContactRepo:      contactRepo,
 		VerificationRepo: verificationRepo,
 	}
 }
+
 func (vaq *ContactVerificationAttemptedQueue) Name() string {
 	return VerificationAttemptEventHandlerName
 }
@@ -69,36 +70,6 @@ func (vaq *ContactVerificationAttemptedQueue) Execute(ctx context.Context, paylo
 		return err
 	}
 
-	verification, err := vaq.VerificationRepo.GetByID(ctx, attempt.VerificationID)
-	if err != nil {
-		logger.WithError(err).Error("failed to get verification")
-		return nil
-	}
-
-	if verification.Code != attempt.Data || verification.ExpiresAt.Before(time.Now()) {
-		return nil
-	}
-
-	contact, err := vaq.ContactRepo.GetByID(ctx, verification.ContactID)
-	if err != nil {
-		logger.WithField("contact_id", verification.ContactID).WithError(err).Error("failed to get contact")
-		return nil
-	}
-
-	verification.VerifiedAt = time.Now()
-	_, err = vaq.VerificationRepo.Update(ctx, verification, "verified_at")
-	if err != nil {
-		logger.WithError(err).Error("failed to save verification")
-		return err
-	}
-
-	contact.VerificationID = verification.ID
-
-	_, err = vaq.ContactRepo.Update(ctx, contact, "verification_id")
-	if err != nil {
-		logger.WithField("contact_id", contact.GetID()).WithError(err).Error("failed to save contact")
-		return nil
-	}
-
+	logger.WithField("state", attempt.State).Debug("recorded verification attempt")
 	return nil
 }
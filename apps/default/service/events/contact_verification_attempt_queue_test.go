@@ -28,10 +28,9 @@ func (cvaqts *ContactVerificationAttemptQueueTestSuite) getVerificationAttemptEv
 	workMan := svc.WorkManager()
 	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
 
-	contactRepo := repository.NewContactRepository(ctx, dbPool, workMan)
 	verificationRepo := repository.NewVerificationRepository(ctx, dbPool, workMan)
 
-	return events.NewContactVerificationAttemptedQueue(contactRepo, verificationRepo), verificationRepo
+	return events.NewContactVerificationAttemptedQueue(verificationRepo), verificationRepo
 }
 
 func TestContactVerificationAttemptQueueSuite(t *testing.T) {
@@ -132,6 +131,11 @@ func (cvaqts *ContactVerificationAttemptQueueTestSuite) TestContactVerificationA
 		require.NoError(t, err)
 		require.Len(t, attemptList, 1)
 		require.Equal(t, attempt.GetID(), attemptList[0].GetID())
+
+		// A queued attempt claiming success is only recorded
+		stored, err := verificationRepo.GetByID(ctx, verification.GetID())
+		require.NoError(t, err)
+		require.True(t, stored.VerifiedAt.IsZero())
 	})
 }
 
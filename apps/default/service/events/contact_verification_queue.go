@@ -3,6 +3,8 @@ package events
 import (
 	"context"
 	"errors"
+	"net/url"
+	"strings"
 
 	commonv1 "buf.build/gen/go/antinvestor/common/protocolbuffers/go/common/v1"
 	"buf.build/gen/go/antinvestor/notification/connectrpc/go/notification/v1/notificationv1connect"
@@ -21,17 +23,19 @@ const VerificationEventHandlerName = "contact.verification.queue"
 
 type ContactVerificationQueue struct {
 	cfg              *config.ProfileConfig
+	dek              *config.DEK
 	contactRepo      repository.ContactRepository
 	verificationRepo repository.VerificationRepository
 	notificationCli  notificationv1connect.NotificationServiceClient
 }
 
 func NewContactVerificationQueue(
-	cfg *config.ProfileConfig, contactRepo repository.ContactRepository,
+	cfg *config.ProfileConfig, dek *config.DEK, contactRepo repository.ContactRepository,
 	verificationRepo repository.VerificationRepository, notificationCli notificationv1connect.NotificationServiceClient,
 ) *ContactVerificationQueue {
 	return &ContactVerificationQueue{
 		cfg:              cfg,
+		dek:              dek,
 		contactRepo:      contactRepo,
 		verificationRepo: verificationRepo,
 		notificationCli:  notificationCli,
@@ -74,6 +78,13 @@ func (vq *ContactVerificationQueue) Execute(ctx context.Context, payload any) er
 		return err
 	}
 
+	// Only the digest is stored, the code itself goes to the notification
+	code, err := verification.UnsealCode(vq.dek)
+	if err != nil {
+		logger.WithError(err).Error("failed to unseal verification code")
+		return err
+	}
+
 	err = vq.verificationRepo.Create(ctx, verification)
 	if err != nil {
 		if data.ErrorIsDuplicateKey(err) {
@@ -86,9 +97,22 @@ func (vq *ContactVerificationQueue) Execute(ctx context.Context, payload any) er
 
 	variables := make(data.JSONMap)
 	variables["verification_id"] = verification.GetID()
-	variables["code"] = verification.Code
 	variables["expiryDate"] = verification.ExpiresAt.String()
 
+	template := vq.cfg.MessageTemplateContactVerification
+	if verification.Mode == models.VerificationModeLink {
+		token, tokenErr := verification.SignToken([]byte(vq.cfg.VerificationTokenSigningKey), code)
+		if tokenErr != nil {
+			logger.WithError(tokenErr).Error("failed to sign verification token")
+			return tokenErr
+		}
+		variables["token"] = token
+		variables["link"] = strings.ReplaceAll(vq.cfg.VerificationLinkTemplate, "{token}", url.QueryEscape(token))
+		template = vq.cfg.MessageTemplateContactVerificationLink
+	} else {
+		variables["code"] = code
+	}
+
 	variablePayload, _ := structpb.NewStruct(variables)
 
 	recipient := &commonv1.ContactLink{
@@ -101,7 +125,7 @@ func (vq *ContactVerificationQueue) Execute(ctx context.Context, payload any) er
 		Recipient:   recipient,
 		Payload:     variablePayload,
 		Language:    contact.Language,
-		Template:    vq.cfg.MessageTemplateContactVerification,
+		Template:    template,
 		OutBound:    true,
 		AutoRelease: true,
 	}
@@ -2,6 +2,7 @@ package events_test
 
 import (
 	"context"
+	"encoding/base64"
 	"testing"
 	"time"
 
@@ -27,6 +28,12 @@ func TestContactVerificationQueueSuite(t *testing.T) {
 	suite.Run(t, new(ContactVerificationQueueTestSuite))
 }
 
+func verificationTestDEK(cfg *config.ProfileConfig) *config.DEK {
+	key, _ := base64.StdEncoding.DecodeString(cfg.DEKActiveAES256GCMKey)
+	lookupKey, _ := base64.StdEncoding.DecodeString(cfg.DEKLookupTokenHMACSHA256Key)
+	return &config.DEK{KeyID: cfg.DEKActiveKeyID, Key: key, LookUpKey: lookupKey}
+}
+
 func (cvqts *ContactVerificationQueueTestSuite) getVerificationEvtQ(
 	ctx context.Context,
 	t *testing.T,
@@ -42,6 +49,7 @@ func (cvqts *ContactVerificationQueueTestSuite) getVerificationEvtQ(
 
 	return events.NewContactVerificationQueue(
 		cfg,
+		verificationTestDEK(cfg),
 		contactRepo,
 		verificationRepo,
 		cvqts.GetNotificationCli(t),
@@ -132,6 +140,40 @@ func (cvqts *ContactVerificationQueueTestSuite) TestContactVerificationQueue_Exe
 	})
 }
 
+func (cvqts *ContactVerificationQueueTestSuite) TestContactVerificationQueue_Execute_StoresDigest() {
+	t := cvqts.T()
+
+	cvqts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := cvqts.CreateService(t, dep)
+		queue, contactRepo := cvqts.getVerificationEvtQ(ctx, t, svc)
+		dek := verificationTestDEK(svc.Config().(*config.ProfileConfig))
+
+		contact := &models.Contact{
+			ContactType: "EMAIL",
+		}
+		contact.GenID(ctx)
+		require.NoError(t, contactRepo.Create(ctx, contact))
+
+		verification := &models.Verification{
+			ProfileID: util.IDString(),
+			ContactID: contact.GetID(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+		verification.GenID(ctx)
+		require.NoError(t, verification.SealCode(dek, "123456"))
+
+		require.NoError(t, queue.Execute(ctx, verification))
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		stored, err := repository.NewVerificationRepository(ctx, dbPool, svc.WorkManager()).
+			GetByID(ctx, verification.GetID())
+		require.NoError(t, err)
+		require.NotContains(t, stored.Code, "123456")
+		require.True(t, stored.CodeMatches(dek.LookUpKey, "123456"))
+		require.False(t, stored.CodeMatches(dek.LookUpKey, "654321"))
+	})
+}
+
 func (cvqts *ContactVerificationQueueTestSuite) TestContactVerificationQueue_Execute_InvalidPayload() {
 	t := cvqts.T()
 
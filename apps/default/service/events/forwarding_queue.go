@@ -0,0 +1,198 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pitabwire/frame/v2/queue"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+const (
+	ProfileMergedEventHandlerName              = "profile.merged"
+	ProfileErasedEventHandlerName              = "profile.erased"
+	ProfileExportCompletedEventHandlerName     = "profile.export.completed"
+	ContactVerificationExpiredEventHandlerName = "contact.verification.expired"
+	RelationshipExpiredEventHandlerName        = "relationship.expired"
+)
+
+// ForwardingQueue handles an event by publishing its payload, a *T,
+// unchanged to a topic for peripheral services to act on.
+type ForwardingQueue[T any] struct {
+	queueMan queue.Manager
+
+	name      string
+	topicName string
+	// check rejects payloads missing what subscribers key on
+	check func(payload *T) error
+	// fields identify the payload in logs
+	fields func(payload *T) map[string]any
+}
+
+// NewForwardingQueue returns a handler named name forwarding payloads that
+// pass check to the topicName topic.
+func NewForwardingQueue[T any](
+	queueMan queue.Manager,
+	name, topicName string,
+	check func(payload *T) error,
+	fields func(payload *T) map[string]any,
+) *ForwardingQueue[T] {
+	return &ForwardingQueue[T]{
+		queueMan:  queueMan,
+		name:      name,
+		topicName: topicName,
+		check:     check,
+		fields:    fields,
+	}
+}
+
+func (fq *ForwardingQueue[T]) Name() string {
+	return fq.name
+}
+
+func (fq *ForwardingQueue[T]) PayloadType() any {
+	return new(T)
+}
+
+func (fq *ForwardingQueue[T]) Validate(_ context.Context, payload any) error {
+	typed, err := fq.typed(payload)
+	if err != nil {
+		return err
+	}
+	return fq.check(typed)
+}
+
+func (fq *ForwardingQueue[T]) Execute(ctx context.Context, payload any) error {
+	typed, err := fq.typed(payload)
+	if err != nil {
+		return err
+	}
+
+	logger := util.Log(ctx).WithFields(fq.fields(typed)).WithField("type", fq.name)
+
+	topic, err := fq.queueMan.GetPublisher(fq.topicName)
+	if err != nil {
+		logger.WithError(err).Error("could not get publisher")
+		return err
+	}
+
+	err = topic.Publish(ctx, typed)
+	if err != nil {
+		logger.WithError(err).Error("could not publish event")
+		return err
+	}
+
+	logger.Debug("queued event")
+
+	return nil
+}
+
+func (fq *ForwardingQueue[T]) typed(payload any) (*T, error) {
+	typed, ok := payload.(*T)
+	if !ok || typed == nil {
+		return nil, fmt.Errorf("invalid payload type, expected %T", typed)
+	}
+	return typed, nil
+}
+
+// NewProfileMergedQueue forwards merge notifications so peripheral services
+// (devices, geolocation) can re-point records held against the merged
+// profile ID.
+func NewProfileMergedQueue(
+	cfg *config.ProfileConfig,
+	queueMan queue.Manager,
+) *ForwardingQueue[models.ProfileMerge] {
+	return NewForwardingQueue(queueMan, ProfileMergedEventHandlerName, cfg.QueueProfileMergedName,
+		func(merge *models.ProfileMerge) error {
+			if merge.TargetID == "" || merge.MergedID == "" {
+				return errors.New("profile merge requires both target and merged ids")
+			}
+			return nil
+		},
+		func(merge *models.ProfileMerge) map[string]any {
+			return map[string]any{"target_id": merge.TargetID, "merged_id": merge.MergedID}
+		})
+}
+
+// NewProfileErasedQueue forwards erasure notifications so peripheral
+// services (devices, geolocation) can purge the device logs and location
+// points held against the erased profile ID.
+func NewProfileErasedQueue(
+	cfg *config.ProfileConfig,
+	queueMan queue.Manager,
+) *ForwardingQueue[models.ProfileErased] {
+	return NewForwardingQueue(queueMan, ProfileErasedEventHandlerName, cfg.QueueProfileErasedName,
+		func(erased *models.ProfileErased) error {
+			if erased.ProfileID == "" {
+				return errors.New("profile erasure requires a profile id")
+			}
+			return nil
+		},
+		func(erased *models.ProfileErased) map[string]any {
+			return map[string]any{"profile_id": erased.ProfileID, "erasure_id": erased.ErasureID}
+		})
+}
+
+// NewProfileExportCompletedQueue forwards notices of finished profile
+// exports so whoever asked for one can fetch it.
+func NewProfileExportCompletedQueue(
+	cfg *config.ProfileConfig,
+	queueMan queue.Manager,
+) *ForwardingQueue[models.ProfileExportCompleted] {
+	return NewForwardingQueue(queueMan, ProfileExportCompletedEventHandlerName, cfg.QueueProfileExportCompletedName,
+		func(completed *models.ProfileExportCompleted) error {
+			if completed.ExportID == "" || completed.ProfileID == "" {
+				return errors.New("profile export notice requires both export and profile ids")
+			}
+			return nil
+		},
+		func(completed *models.ProfileExportCompleted) map[string]any {
+			return map[string]any{
+				"export_id":  completed.ExportID,
+				"profile_id": completed.ProfileID,
+				"status":     completed.Status,
+			}
+		})
+}
+
+// NewContactVerificationExpiredQueue forwards notices of stale contact
+// verifications so services can prompt the owner to verify again.
+func NewContactVerificationExpiredQueue(
+	cfg *config.ProfileConfig,
+	queueMan queue.Manager,
+) *ForwardingQueue[models.ContactVerificationExpiry] {
+	return NewForwardingQueue(queueMan, ContactVerificationExpiredEventHandlerName,
+		cfg.QueueContactVerificationExpiredName,
+		func(expiry *models.ContactVerificationExpiry) error {
+			if expiry.ContactID == "" {
+				return errors.New("contact verification expiry requires a contact id")
+			}
+			return nil
+		},
+		func(expiry *models.ContactVerificationExpiry) map[string]any {
+			return map[string]any{"contact_id": expiry.ContactID}
+		})
+}
+
+// NewRelationshipExpiredQueue forwards notices of lapsed relationships so
+// services can revoke what the relationship granted, such as an
+// affiliation's access.
+func NewRelationshipExpiredQueue(
+	cfg *config.ProfileConfig,
+	queueMan queue.Manager,
+) *ForwardingQueue[models.RelationshipExpiry] {
+	return NewForwardingQueue(queueMan, RelationshipExpiredEventHandlerName, cfg.QueueRelationshipExpiredName,
+		func(expiry *models.RelationshipExpiry) error {
+			if expiry.RelationshipID == "" {
+				return errors.New("relationship expiry requires a relationship id")
+			}
+			return nil
+		},
+		func(expiry *models.RelationshipExpiry) map[string]any {
+			return map[string]any{"relationship_id": expiry.RelationshipID}
+		})
+}
@@ -0,0 +1,144 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pitabwire/frame/v2/queue"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/events"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+type failingPublisherManager struct {
+	queue.Manager
+}
+
+func (m *failingPublisherManager) GetPublisher(string) (queue.Publisher, error) {
+	return nil, errors.New("no such topic")
+}
+
+type forwardingPayload struct {
+	ID string
+}
+
+func newForwardingPayloadQueue(queueMan queue.Manager) *events.ForwardingQueue[forwardingPayload] {
+	return events.NewForwardingQueue(queueMan, "payload.forwarded", "payloads",
+		func(payload *forwardingPayload) error {
+			if payload.ID == "" {
+				return errors.New("payload requires an id")
+			}
+			return nil
+		},
+		func(payload *forwardingPayload) map[string]any {
+			return map[string]any{"id": payload.ID}
+		})
+}
+
+func TestForwardingQueue(t *testing.T) {
+	ctx := context.Background()
+	publisher := &recordingPublisher{}
+	queueMan := &publisherManager{publisher: publisher}
+	forwarding := newForwardingPayloadQueue(queueMan)
+
+	require.Equal(t, "payload.forwarded", forwarding.Name())
+	_, ok := forwarding.PayloadType().(*forwardingPayload)
+	require.True(t, ok)
+
+	require.NoError(t, forwarding.Validate(ctx, &forwardingPayload{ID: "id"}))
+	require.Error(t, forwarding.Validate(ctx, &forwardingPayload{}))
+	for _, payload := range []any{"id", nil, (*forwardingPayload)(nil), forwardingPayload{ID: "id"}} {
+		err := forwarding.Validate(ctx, payload)
+		require.ErrorContains(t, err, "invalid payload type")
+		err = forwarding.Execute(ctx, payload)
+		require.ErrorContains(t, err, "invalid payload type")
+	}
+	require.Empty(t, queueMan.topics)
+
+	payload := &forwardingPayload{ID: "id"}
+	require.NoError(t, forwarding.Execute(ctx, payload))
+	require.Equal(t, []string{"payloads"}, queueMan.topics)
+	require.Equal(t, []any{payload}, publisher.published)
+
+	require.Error(t, newForwardingPayloadQueue(&failingPublisherManager{}).Execute(ctx, payload))
+}
+
+func TestForwardingQueues(t *testing.T) {
+	cfg := &config.ProfileConfig{
+		QueueProfileMergedName:              "profiles.merged",
+		QueueProfileErasedName:              "profiles.erased",
+		QueueProfileExportCompletedName:     "profiles.export.completed",
+		QueueContactVerificationExpiredName: "contacts.verification.expired",
+		QueueRelationshipExpiredName:        "relationships.expired",
+	}
+
+	type forwarder interface {
+		Name() string
+		Validate(ctx context.Context, payload any) error
+		Execute(ctx context.Context, payload any) error
+	}
+
+	tests := []struct {
+		name    string
+		queue   func(queue.Manager) forwarder
+		topic   string
+		valid   any
+		invalid any
+	}{
+		{
+			events.ProfileMergedEventHandlerName,
+			func(m queue.Manager) forwarder { return events.NewProfileMergedQueue(cfg, m) },
+			"profiles.merged",
+			&models.ProfileMerge{TargetID: "target", MergedID: "merged"},
+			&models.ProfileMerge{TargetID: "target"},
+		},
+		{
+			events.ProfileErasedEventHandlerName,
+			func(m queue.Manager) forwarder { return events.NewProfileErasedQueue(cfg, m) },
+			"profiles.erased",
+			&models.ProfileErased{ProfileID: "profile", ErasureID: "erasure"},
+			&models.ProfileErased{ErasureID: "erasure"},
+		},
+		{
+			events.ProfileExportCompletedEventHandlerName,
+			func(m queue.Manager) forwarder { return events.NewProfileExportCompletedQueue(cfg, m) },
+			"profiles.export.completed",
+			&models.ProfileExportCompleted{ExportID: "export", ProfileID: "profile", Status: "completed"},
+			&models.ProfileExportCompleted{ProfileID: "profile"},
+		},
+		{
+			events.ContactVerificationExpiredEventHandlerName,
+			func(m queue.Manager) forwarder { return events.NewContactVerificationExpiredQueue(cfg, m) },
+			"contacts.verification.expired",
+			&models.ContactVerificationExpiry{ContactID: "contact", VerificationID: "verification"},
+			&models.ContactVerificationExpiry{VerificationID: "verification"},
+		},
+		{
+			events.RelationshipExpiredEventHandlerName,
+			func(m queue.Manager) forwarder { return events.NewRelationshipExpiredQueue(cfg, m) },
+			"relationships.expired",
+			&models.RelationshipExpiry{RelationshipID: "relationship"},
+			&models.RelationshipExpiry{Role: "director"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			publisher := &recordingPublisher{}
+			queueMan := &publisherManager{publisher: publisher}
+			forwarding := tt.queue(queueMan)
+
+			require.Equal(t, tt.name, forwarding.Name())
+			require.NoError(t, forwarding.Validate(ctx, tt.valid))
+			require.Error(t, forwarding.Validate(ctx, tt.invalid))
+
+			require.NoError(t, forwarding.Execute(ctx, tt.valid))
+			require.Equal(t, []string{tt.topic}, queueMan.topics)
+			require.Equal(t, []any{tt.valid}, publisher.published)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pitabwire/frame/v2/queue"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+const RosterMatchEventHandlerName = "roster.match"
+
+// RosterMatchQueue handles a contact newly verified for a profile: it
+// forwards a match for each roster entry listing the contact to the roster
+// match topic, so the notification service can tell the roster's owner
+// that their contact joined. Profiles hidden from rosters are not matched.
+type RosterMatchQueue struct {
+	queueMan    queue.Manager
+	profileRepo repository.ProfileRepository
+	rosterRepo  repository.RosterRepository
+
+	rosterMatchTopicName string
+}
+
+func NewRosterMatchQueue(
+	cfg *config.ProfileConfig,
+	queueMan queue.Manager,
+	profileRepo repository.ProfileRepository,
+	rosterRepo repository.RosterRepository,
+) *RosterMatchQueue {
+	return &RosterMatchQueue{
+		queueMan:             queueMan,
+		profileRepo:          profileRepo,
+		rosterRepo:           rosterRepo,
+		rosterMatchTopicName: cfg.QueueRosterMatchName,
+	}
+}
+
+func (rmq *RosterMatchQueue) Name() string {
+	return RosterMatchEventHandlerName
+}
+
+func (rmq *RosterMatchQueue) PayloadType() any {
+	return &models.RosterMatch{}
+}
+
+func (rmq *RosterMatchQueue) Validate(_ context.Context, payload any) error {
+	match, ok := payload.(*models.RosterMatch)
+	if !ok {
+		return errors.New("invalid payload type, expected *models.RosterMatch")
+	}
+
+	if match.ContactID == "" || match.ProfileID == "" {
+		return errors.New("roster match requires a contact id and a profile id")
+	}
+
+	return nil
+}
+
+func (rmq *RosterMatchQueue) Execute(ctx context.Context, payload any) error {
+	match, ok := payload.(*models.RosterMatch)
+	if !ok {
+		return errors.New("invalid payload type, expected *models.RosterMatch")
+	}
+
+	logger := util.Log(ctx).WithFields(map[string]any{
+		"contact_id": match.ContactID,
+		"profile_id": match.ProfileID,
+		"type":       rmq.Name(),
+	})
+
+	// Rosters of every tenant may list the contact
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+
+	visibility, err := rmq.profileRepo.GetRosterVisibility(unscopedCtx, []string{match.ProfileID})
+	if err != nil {
+		logger.WithError(err).Error("could not get roster visibility")
+		return err
+	}
+	matchedProfileID := match.ProfileID
+	switch visibility[match.ProfileID] {
+	case models.RosterVisibilityProfile:
+	case models.RosterVisibilityMatched:
+		matchedProfileID = ""
+	default:
+		// Hidden, or the profile is gone
+		return nil
+	}
+
+	rosters, err := rmq.rosterRepo.GetByContactID(unscopedCtx, match.ContactID)
+	if err != nil {
+		logger.WithError(err).Error("could not get rosters of contact")
+		return err
+	}
+	if len(rosters) == 0 {
+		return nil
+	}
+
+	rosterMatchTopic, err := rmq.queueMan.GetPublisher(rmq.rosterMatchTopicName)
+	if err != nil {
+		logger.WithError(err).Error("could not get publisher")
+		return err
+	}
+
+	for _, roster := range rosters {
+		if roster.ProfileID == match.ProfileID {
+			// Nobody is told they joined themselves
+			continue
+		}
+
+		err = rosterMatchTopic.Publish(ctx, &models.RosterMatch{
+			ContactID:      match.ContactID,
+			ProfileID:      matchedProfileID,
+			RosterID:       roster.GetID(),
+			OwnerProfileID: roster.ProfileID,
+			MatchedAt:      match.MatchedAt,
+		})
+		if err != nil {
+			logger.WithField("roster_id", roster.GetID()).WithError(err).Error("could not publish roster match")
+			return err
+		}
+	}
+
+	logger.WithField("rosters", len(rosters)).Debug("queued roster matches")
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/queue"
+	"github.com/stretchr/testify/require"
+
+	"github.com/antinvestor/service-profile/apps/default/config"
+	"github.com/antinvestor/service-profile/apps/default/service/events"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+	"github.com/antinvestor/service-profile/apps/default/service/repository"
+)
+
+type visibilityProfileRepo struct {
+	repository.ProfileRepository
+	visibility map[string]string
+}
+
+func (r *visibilityProfileRepo) GetRosterVisibility(_ context.Context, ids []string) (map[string]string, error) {
+	visibility := map[string]string{}
+	for _, id := range ids {
+		if value, ok := r.visibility[id]; ok {
+			visibility[id] = value
+		}
+	}
+	return visibility, nil
+}
+
+type contactRosterRepo struct {
+	repository.RosterRepository
+	rosters []*models.Roster
+}
+
+func (r *contactRosterRepo) GetByContactID(_ context.Context, _ string) ([]*models.Roster, error) {
+	return r.rosters, nil
+}
+
+type recordingPublisher struct {
+	queue.Publisher
+	published []any
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, payload any, _ ...map[string]string) error {
+	p.published = append(p.published, payload)
+	return nil
+}
+
+type publisherManager struct {
+	queue.Manager
+	publisher *recordingPublisher
+	topics    []string
+}
+
+func (m *publisherManager) GetPublisher(name string) (queue.Publisher, error) {
+	m.topics = append(m.topics, name)
+	return m.publisher, nil
+}
+
+func TestRosterMatchQueue_Name(t *testing.T) {
+	queue := events.NewRosterMatchQueue(&config.ProfileConfig{}, nil, nil, nil)
+	require.Equal(t, events.RosterMatchEventHandlerName, queue.Name())
+}
+
+func TestRosterMatchQueue_PayloadType(t *testing.T) {
+	queue := events.NewRosterMatchQueue(&config.ProfileConfig{}, nil, nil, nil)
+	_, ok := queue.PayloadType().(*models.RosterMatch)
+	require.True(t, ok)
+}
+
+func TestRosterMatchQueue_Validate(t *testing.T) {
+	queue := events.NewRosterMatchQueue(&config.ProfileConfig{}, nil, nil, nil)
+	ctx := context.Background()
+
+	require.NoError(t, queue.Validate(ctx, &models.RosterMatch{ContactID: "contact", ProfileID: "profile"}))
+	require.Error(t, queue.Validate(ctx, &models.RosterMatch{ContactID: "contact"}))
+	require.Error(t, queue.Validate(ctx, &models.RosterMatch{ProfileID: "profile"}))
+	require.Error(t, queue.Validate(ctx, "contact"))
+	require.Error(t, queue.Validate(ctx, nil))
+}
+
+func TestRosterMatchQueue_Execute(t *testing.T) {
+	ctx := context.Background()
+	rosters := &contactRosterRepo{rosters: []*models.Roster{
+		{BaseModel: data.BaseModel{ID: "roster-a"}, ProfileID: "owner-a", ContactID: "contact"},
+		{BaseModel: data.BaseModel{ID: "roster-b"}, ProfileID: "owner-b", ContactID: "contact"},
+		// The joining profile's own roster listing its contact
+		{BaseModel: data.BaseModel{ID: "roster-c"}, ProfileID: "joined", ContactID: "contact"},
+	}}
+
+	testCases := []struct {
+		name       string
+		visibility map[string]string
+		published  int
+		profileID  string
+	}{
+		{"visible profile", map[string]string{"joined": models.RosterVisibilityProfile}, 2, "joined"},
+		{"profile id withheld", map[string]string{"joined": models.RosterVisibilityMatched}, 2, ""},
+		{"hidden profile", map[string]string{"joined": models.RosterVisibilityHidden}, 0, ""},
+		{"missing profile", map[string]string{}, 0, ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &recordingPublisher{}
+			queue := events.NewRosterMatchQueue(&config.ProfileConfig{}, &publisherManager{publisher: publisher},
+				&visibilityProfileRepo{visibility: tt.visibility}, rosters)
+
+			err := queue.Execute(ctx, &models.RosterMatch{ContactID: "contact", ProfileID: "joined"})
+			require.NoError(t, err)
+			require.Len(t, publisher.published, tt.published)
+
+			for i, payload := range publisher.published {
+				match, ok := payload.(*models.RosterMatch)
+				require.True(t, ok)
+				require.Equal(t, rosters.rosters[i].GetID(), match.RosterID)
+				require.Equal(t, rosters.rosters[i].ProfileID, match.OwnerProfileID)
+				require.Equal(t, tt.profileID, match.ProfileID)
+			}
+		})
+	}
+}
+
+func TestRosterMatchQueue_Execute_InvalidPayload(t *testing.T) {
+	queue := events.NewRosterMatchQueue(&config.ProfileConfig{}, nil, nil, nil)
+
+	err := queue.Execute(context.Background(), "not a match")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid payload type")
+}
@@ -3,6 +3,9 @@ package handlers
 import (
 	"context"
 	"math"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"buf.build/gen/go/antinvestor/notification/connectrpc/go/notification/v1/notificationv1connect"
@@ -19,6 +22,7 @@ import (
 
 	"github.com/antinvestor/service-profile/apps/default/config"
 	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
 	"github.com/antinvestor/service-profile/apps/default/service/repository"
 	"github.com/antinvestor/service-profile/pkg/errorutil"
 )
@@ -27,6 +31,10 @@ import (
 const (
 	// MaxBatchSize defines the maximum number of items to process in a single batch.
 	MaxBatchSize = 50
+
+	// VerificationModeHeader selects the mode of a CreateContactVerification
+	// request, "code" or "link". The tenant's default applies when absent.
+	VerificationModeHeader = "Verification-Mode"
 )
 
 type ProfileServer struct {
@@ -38,6 +46,12 @@ type ProfileServer struct {
 	contactBusiness      business.ContactBusiness
 	rosterBusiness       business.RosterBusiness
 	relationshipBusiness business.RelationshipBusiness
+	duplicateBusiness    business.DuplicateBusiness
+	schemaBusiness       business.PropertySchemaBusiness
+	keyRotationBusiness  business.KeyRotationBusiness
+	exportBusiness       business.ProfileExportBusiness
+
+	trustedProxyHops int
 
 	profilev1connect.UnimplementedProfileServiceHandler
 }
@@ -71,6 +85,9 @@ func NewProfileServer(
 	addressRepo := repository.NewAddressRepository(ctx, dbPool, workMan)
 	addressBusiness := business.NewAddressBusiness(ctx, addressRepo)
 
+	schemaRepo := repository.NewPropertySchemaRepository(ctx, dbPool, workMan)
+	schemaBusiness := business.NewPropertySchemaBusiness(ctx, schemaRepo)
+
 	profileRepo := repository.NewProfileRepository(ctx, dbPool, workMan)
 	propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, workMan)
 	profileBusiness := business.NewProfileBusiness(
@@ -80,25 +97,41 @@ func NewProfileServer(
 		evtsMan,
 		contactBusiness,
 		addressBusiness,
+		schemaBusiness,
 		profileRepo,
 		propertyEntryRepo,
 	)
 
 	rosterRepo := repository.NewRosterRepository(ctx, dbPool, workMan)
-	rosterBusiness := business.NewRosterBusiness(ctx, cfg, dek, contactBusiness, rosterRepo)
+	rosterBusiness := business.NewRosterBusiness(ctx, cfg, dek, contactBusiness, profileRepo, rosterRepo)
 
 	relationshipRepo := repository.NewRelationshipRepository(ctx, dbPool, workMan)
 	relationshipBusiness := business.NewRelationshipBusiness(ctx, profileBusiness, relationshipRepo)
 
+	duplicateRepo := repository.NewDuplicateCandidateRepository(ctx, dbPool, workMan)
+	duplicateBusiness := business.NewDuplicateBusiness(ctx, cfg, dek, contactRepo, duplicateRepo)
+
+	keyRotationRepo := repository.NewKeyRotationSweepRepository(ctx, dbPool, workMan)
+	keyRotationBusiness := business.NewKeyRotationBusiness(ctx, cfg, dek, evtsMan, contactRepo, keyRotationRepo)
+
+	exportRepo := repository.NewProfileExportRepository(ctx, dbPool, workMan)
+	exportBusiness := business.NewProfileExportBusiness(
+		ctx, cfg, dek, evtsMan, profileRepo, propertyEntryRepo, exportRepo)
+
 	return &ProfileServer{
 		Service:              svc,
 		DEK:                  dek,
 		NotificationCli:      notificationCli,
 		checker:              checker,
+		trustedProxyHops:     cfg.TrustedProxyHops,
 		profileBusiness:      profileBusiness,
 		contactBusiness:      contactBusiness,
 		rosterBusiness:       rosterBusiness,
 		relationshipBusiness: relationshipBusiness,
+		duplicateBusiness:    duplicateBusiness,
+		schemaBusiness:       schemaBusiness,
+		keyRotationBusiness:  keyRotationBusiness,
+		exportBusiness:       exportBusiness,
 	}
 }
 
@@ -258,6 +291,8 @@ func (ps *ProfileServer) PropertyHistory(
 		return nil, errorutil.CleanErr(err)
 	}
 
+	// Non-string values are returned in their JSON encoding, so a removal
+	// reads as null.
 	var protoEntries []*profilev1.PropertyEntryObject
 	for _, e := range entries {
 		protoEntries = append(protoEntries, &profilev1.PropertyEntryObject{
@@ -347,7 +382,7 @@ func (ps *ProfileServer) CreateContact(
 	}
 
 	if len(contactList) > 0 {
-		contact, decryptErr := contactList[0].ToAPI(ps.DEK, true)
+		contact, decryptErr := contactList[0].ToAPI(ctx, ps.DEK, true)
 		if decryptErr != nil {
 			return nil, errorutil.CleanErr(decryptErr)
 		}
@@ -364,7 +399,7 @@ func (ps *ProfileServer) CreateContact(
 		return nil, errorutil.CleanErr(err)
 	}
 
-	contactObj, err := contact.ToAPI(ps.DEK, true)
+	contactObj, err := contact.ToAPI(ctx, ps.DEK, true)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
 	}
@@ -386,6 +421,7 @@ func (ps *ProfileServer) CreateContactVerification(
 		request.Msg.GetContactId(),
 		request.Msg.GetId(),
 		request.Msg.GetCode(),
+		request.Header().Get(VerificationModeHeader),
 		expiryDuration,
 	)
 	if err != nil {
@@ -406,7 +442,7 @@ func (ps *ProfileServer) CheckVerification(
 		ctx,
 		request.Msg.GetId(),
 		request.Msg.GetCode(),
-		request.Peer().Addr,
+		clientIP(request.Peer().Addr, request.Header(), ps.trustedProxyHops),
 	)
 
 	if err != nil {
@@ -424,6 +460,29 @@ func (ps *ProfileServer) CheckVerification(
 		}), nil
 }
 
+// clientIP returns the address of the client behind peerAddr. Behind
+// trustedHops proxies it is the X-Forwarded-For entry the outermost proxy
+// added; entries before it are the client's to choose and are ignored.
+func clientIP(peerAddr string, header http.Header, trustedHops int) string {
+	addr := peerAddr
+	if trustedHops > 0 {
+		var forwarded []string
+		for _, value := range header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(value, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(entry))
+			}
+		}
+		if len(forwarded) >= trustedHops {
+			addr = forwarded[len(forwarded)-trustedHops]
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
 func (ps *ProfileServer) RemoveContact(
 	ctx context.Context,
 	request *connect.Request[profilev1.RemoveContactRequest],
@@ -477,15 +536,9 @@ func (ps *ProfileServer) SearchRoster(
 			return errorutil.CleanErr(result.Error())
 		}
 
-		// Preallocate slice to optimize memory allocation.
-		rosterList := make([]*profilev1.RosterObject, 0, len(result.Item()))
-		for _, roster := range result.Item() {
-			rosterObj, rosterErr := roster.ToAPI(ps.DEK)
-			if rosterErr != nil {
-				return errorutil.CleanErr(rosterErr)
-			}
-
-			rosterList = append(rosterList, rosterObj)
+		rosterList, rosterErr := ps.rosterBusiness.ToAPI(ctx, result.Item())
+		if rosterErr != nil {
+			return errorutil.CleanErr(rosterErr)
 		}
 
 		sErr := stream.Send(&profilev1.SearchRosterResponse{Data: rosterList})
@@ -599,7 +652,7 @@ func (ps *ProfileServer) ListRelationships(
 			request.Msg.Count = int32(remainingCount) // #nosec G115 -- bounds checked above
 		}
 
-		relationships, err := ps.relationshipBusiness.ListRelationships(ctx, request.Msg)
+		relationships, err := ps.relationshipBusiness.ListRelationships(ctx, request.Msg, models.RelationshipFilter{})
 		if err != nil {
 			return errorutil.CleanErr(err)
 		}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"connectrpc.com/connect"
+
+	"github.com/antinvestor/service-profile/apps/default/service/authz"
+)
+
+// RestEraseProfileEndpoint permanently erases the profile in the "id" query
+// parameter and returns the erasure record. Repeating the call for an erased
+// profile announces the erasure again.
+func (ps *ProfileServer) RestEraseProfileEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionProfileErase); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	erasure, err := ps.profileBusiness.EraseProfile(ctx, req.URL.Query().Get("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch connect.CodeOf(err) {
+		case connect.CodeInvalidArgument:
+			status = http.StatusBadRequest
+		case connect.CodeNotFound:
+			status = http.StatusNotFound
+		default:
+		}
+		ps.writeError(ctx, rw, err, status)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(erasure)
+}
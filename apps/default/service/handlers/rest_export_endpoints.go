@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/antinvestor/service-profile/apps/default/service/authz"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+// RestExportProfileEndpoint returns the data bundle of the profile in the
+// "id" query parameter, built while the caller waits.
+func (ps *ProfileServer) RestExportProfileEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionProfileExport); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	bundle, err := ps.exportBusiness.ExportProfileData(ctx, req.URL.Query().Get("id"))
+	if err != nil {
+		ps.writeError(ctx, rw, err, errorStatus(err))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(bundle)
+}
+
+// RestStartProfileExportEndpoint queues an export of the profile in the "id"
+// query parameter and returns it, to be polled on GET /profile/exports.
+func (ps *ProfileServer) RestStartProfileExportEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionProfileExport); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	export, err := ps.exportBusiness.StartExport(ctx, req.URL.Query().Get("id"))
+	if err != nil {
+		ps.writeError(ctx, rw, err, errorStatus(err))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(rw).Encode(exportResponse(export, nil))
+}
+
+// RestGetProfileExportEndpoint returns the export in the "id" query
+// parameter, with its bundle once ready.
+func (ps *ProfileServer) RestGetProfileExportEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionProfileExport); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	export, bundle, err := ps.exportBusiness.GetExport(ctx, req.URL.Query().Get("id"))
+	if err != nil {
+		ps.writeError(ctx, rw, err, errorStatus(err))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(exportResponse(export, bundle))
+}
+
+func exportResponse(export *models.ProfileExport, bundle *models.ProfileDataBundle) map[string]any {
+	response := map[string]any{
+		"id":         export.GetID(),
+		"profile_id": export.ProfileID,
+		"status":     export.Status,
+		"created_at": export.CreatedAt,
+	}
+	if export.Error != "" {
+		response["error"] = export.Error
+	}
+	if !export.CompletedAt.IsZero() {
+		response["completed_at"] = export.CompletedAt
+	}
+	if bundle != nil {
+		response["bundle"] = bundle
+	}
+	return response
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pitabwire/frame/v2/security"
+
+	"github.com/antinvestor/service-profile/apps/default/service/authz"
+)
+
+// RestGetProfileAsOfEndpoint returns the profile in the "id" query parameter
+// with its properties as they stood at the RFC 3339 time in "at". Scoped
+// properties of the "partition_id" partition are included when given.
+func (ps *ProfileServer) RestGetProfileAsOfEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	urlQuery := req.URL.Query()
+	profileID := urlQuery.Get("id")
+
+	claims := security.ClaimsFromContext(ctx)
+	if sub, _ := claims.GetSubject(); sub != profileID {
+		if err := ps.checker.Check(ctx, authz.PermissionProfileView); err != nil {
+			ps.writeError(ctx, rw, err, http.StatusForbidden)
+			return
+		}
+	}
+
+	asOf, err := time.Parse(time.RFC3339, urlQuery.Get("at"))
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
+	profileObj, err := ps.profileBusiness.GetProfileAsOf(ctx, profileID, urlQuery.Get("partition_id"), asOf)
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(profileObj)
+}
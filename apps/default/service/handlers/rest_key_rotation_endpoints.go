@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pitabwire/frame/v2/data"
+
+	"github.com/antinvestor/service-profile/apps/default/service/authz"
+)
+
+// RestKeyRotationProgressEndpoint reports how far contacts have been
+// re-encrypted onto the active key. Retired keys can be removed from
+// config once "completed" is true. Keys are shared by every tenant, so the
+// progress is only shown to callers who may shred tenant keys.
+func (ps *ProfileServer) RestKeyRotationProgressEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionTenantShred); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	sweep, err := ps.keyRotationBusiness.Progress(ctx)
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	progress := data.JSONMap{
+		"target_key_id":    sweep.TargetKeyID,
+		"passes":           sweep.Passes,
+		"queued":           sweep.Queued,
+		"remaining":        sweep.Remaining,
+		"remaining_by_key": sweep.RemainingByKey,
+		"completed":        sweep.Remaining == 0,
+	}
+	if !sweep.CompletedAt.IsZero() {
+		progress["completed_at"] = sweep.CompletedAt
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(progress)
+}
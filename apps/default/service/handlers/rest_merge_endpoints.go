@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/pitabwire/frame/v2/data"
+)
+
+// RestPreviewMergeEndpoint reports what merging the profile in the "mergeid"
+// query parameter into the one in "id" would do, without writing anything.
+func (ps *ProfileServer) RestPreviewMergeEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, "profile_merge"); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	urlQuery := req.URL.Query()
+	preview, err := ps.profileBusiness.PreviewMerge(ctx, &profilev1.MergeRequest{
+		Id:      urlQuery.Get("id"),
+		Mergeid: urlQuery.Get("mergeid"),
+	})
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(preview)
+}
+
+// RestUnmergeEndpoint undoes the latest merge of the profile in the
+// "mergeid" query parameter and returns the restored profile.
+func (ps *ProfileServer) RestUnmergeEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, "profile_merge"); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	profileObj, err := ps.profileBusiness.UnmergeProfile(ctx, req.URL.Query().Get("mergeid"))
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(profileObj)
+}
+
+// RestListDuplicateCandidatesEndpoint lists profile pairs the duplicate
+// detection job flagged, highest score first. "min_score" and "count" query
+// parameters narrow the list.
+func (ps *ProfileServer) RestListDuplicateCandidatesEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, "profile_merge"); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	urlQuery := req.URL.Query()
+	minScore, _ := strconv.ParseFloat(urlQuery.Get("min_score"), 64)
+	count, _ := strconv.Atoi(urlQuery.Get("count"))
+
+	candidates, err := ps.duplicateBusiness.ListCandidates(ctx, minScore, count)
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	candidateList := make([]data.JSONMap, 0, len(candidates))
+	for _, c := range candidates {
+		candidateList = append(candidateList, data.JSONMap{
+			"id":               c.GetID(),
+			"profile_id":       c.ProfileID,
+			"other_profile_id": c.OtherProfileID,
+			"score":            c.Score,
+			"reasons":          c.Reasons,
+			"detected_at":      c.ModifiedAt,
+		})
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(data.JSONMap{
+		"candidates": candidateList,
+		"count":      len(candidateList),
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/pitabwire/frame/v2/data"
+	"github.com/pitabwire/frame/v2/security"
+
+	"github.com/antinvestor/service-profile/apps/default/service/authz"
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+)
+
+// RestRelationshipGraphEndpoint returns the relationships below the entry
+// in the "peer_name" and "peer_id" query parameters, the caller's profile by
+// default, within "depth" hops. With "inverse" set it returns those above
+// the entry instead. Repeated "type" parameters, as in "MEMBER", keep only
+// relationships of those types.
+func (ps *ProfileServer) RestRelationshipGraphEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	urlQuery := req.URL.Query()
+
+	peerName := urlQuery.Get("peer_name")
+	if peerName == "" {
+		peerName = business.ProfilePeerName
+	}
+	subject, _ := security.ClaimsFromContext(ctx).GetSubject()
+	peerID := urlQuery.Get("peer_id")
+	if peerID == "" {
+		peerID = subject
+	}
+	if subject != peerID {
+		if err := ps.checker.Check(ctx, authz.PermissionRelationshipsManage); err != nil {
+			ps.writeError(ctx, rw, err, http.StatusForbidden)
+			return
+		}
+	}
+
+	var types []profilev1.RelationshipType
+	for _, name := range urlQuery["type"] {
+		value, ok := profilev1.RelationshipType_value[strings.ToUpper(name)]
+		if !ok {
+			ps.writeError(ctx, rw, fmt.Errorf("unknown relationship type %q", name), http.StatusBadRequest)
+			return
+		}
+		types = append(types, profilev1.RelationshipType(value))
+	}
+	inverse, _ := strconv.ParseBool(urlQuery.Get("inverse"))
+	depth, _ := strconv.Atoi(urlQuery.Get("depth"))
+
+	edges, err := ps.relationshipBusiness.TraverseRelationships(ctx, peerName, peerID, inverse, depth, types...)
+	if err != nil {
+		ps.writeError(ctx, rw, err, errorStatus(err))
+		return
+	}
+
+	relationshipList := make([]data.JSONMap, 0, len(edges))
+	for _, edge := range edges {
+		relationshipList = append(relationshipList, data.JSONMap{
+			"depth":        edge.Depth,
+			"relationship": edge.Relationship.ToAPI(),
+		})
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(data.JSONMap{
+		"relationships": relationshipList,
+		"count":         len(relationshipList),
+	})
+}
+
+// RestRelationshipPathEndpoint returns the fewest relationships linking the
+// entry in the "from_name" and "from_id" query parameters to the one in
+// "to_name" and "to_id", within "depth" hops. Names default to profiles.
+// Paths need the relationship manage permission unless both ends are the
+// caller's profile; see relationshipPathNeedsManage.
+func (ps *ProfileServer) RestRelationshipPathEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	urlQuery := req.URL.Query()
+
+	fromName, toName := urlQuery.Get("from_name"), urlQuery.Get("to_name")
+	if fromName == "" {
+		fromName = business.ProfilePeerName
+	}
+	if toName == "" {
+		toName = business.ProfilePeerName
+	}
+	fromID, toID := urlQuery.Get("from_id"), urlQuery.Get("to_id")
+
+	subject, _ := security.ClaimsFromContext(ctx).GetSubject()
+	if relationshipPathNeedsManage(subject, fromName, fromID, toName, toID) {
+		if err := ps.checker.Check(ctx, authz.PermissionRelationshipsManage); err != nil {
+			ps.writeError(ctx, rw, err, http.StatusForbidden)
+			return
+		}
+	}
+	depth, _ := strconv.Atoi(urlQuery.Get("depth"))
+
+	path, err := ps.relationshipBusiness.ShortestRelationshipPath(ctx, fromName, fromID, toName, toID, depth)
+	if err != nil {
+		ps.writeError(ctx, rw, err, errorStatus(err))
+		return
+	}
+
+	pathList := make([]*profilev1.RelationshipObject, 0, len(path))
+	for _, relationship := range path {
+		pathList = append(pathList, relationship.ToAPI())
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(data.JSONMap{
+		"path": pathList,
+		"hops": len(pathList),
+	})
+}
+
+// relationshipPathNeedsManage reports whether a path between two entries
+// needs the relationship manage permission. Being one end of a path is not
+// enough to skip it: a path from the caller's own profile still reveals
+// every entry and relationship linking it to the other end, which the
+// caller may have no access to. Only a path kept within the caller's own
+// profile is exempt.
+func relationshipPathNeedsManage(subject, fromName, fromID, toName, toID string) bool {
+	ownsFrom := fromName == business.ProfilePeerName && fromID == subject
+	ownsTo := toName == business.ProfilePeerName && toID == subject
+	return subject == "" || !ownsFrom || !ownsTo
+}
@@ -0,0 +1,35 @@
+package handlers //nolint:testpackage // tests access unexported handler helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+)
+
+func TestRelationshipPathNeedsManage(t *testing.T) {
+	const caller, other = "caller", "other"
+
+	tests := []struct {
+		name             string
+		subject          string
+		fromName, fromID string
+		toName, toID     string
+		needsManage      bool
+	}{
+		{"From the caller", caller, business.ProfilePeerName, caller, business.ProfilePeerName, other, true},
+		{"To the caller", caller, business.ProfilePeerName, other, business.ProfilePeerName, caller, true},
+		{"Between others", caller, business.ProfilePeerName, other, business.ProfilePeerName, "third", true},
+		{"Non profile entry named like the caller", caller, "Group", caller, business.ProfilePeerName, caller, true},
+		{"Anonymous caller", "", business.ProfilePeerName, "", business.ProfilePeerName, "", true},
+		{"Within the caller", caller, business.ProfilePeerName, caller, business.ProfilePeerName, caller, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.needsManage,
+				relationshipPathNeedsManage(tt.subject, tt.fromName, tt.fromID, tt.toName, tt.toID))
+		})
+	}
+}
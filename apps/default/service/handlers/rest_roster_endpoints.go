@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/antinvestor/service-profile/apps/default/service/business"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+const (
+	// maxRosterSyncBodyBytes caps the JSON body of a roster sync.
+	maxRosterSyncBodyBytes = 8 << 20
+	// maxRosterImportBodyBytes caps an imported address book.
+	maxRosterImportBodyBytes = 16 << 20
+)
+
+// RestSyncRosterEndpoint applies the roster changes in the JSON request body
+// to the caller's roster and returns the entries changed since the body's
+// sync token, with the token to send on the next sync.
+func (ps *ProfileServer) RestSyncRosterEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	request := &models.RosterSyncRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxRosterSyncBodyBytes)).Decode(request)
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := ps.rosterBusiness.SyncRoster(ctx, request)
+	if err != nil {
+		ps.writeError(ctx, rw, err, errorStatus(err))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(result)
+}
+
+// RestImportRosterEndpoint adds the contacts of the address book in the
+// request body to the caller's roster in the "name" query parameter. The
+// "format" parameter, "vcard" or "csv", defaults from the content type.
+// Phone numbers without a country code are read as numbers of the "region"
+// parameter, as in "UG", when given.
+func (ps *ProfileServer) RestImportRosterEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	urlQuery := req.URL.Query()
+
+	format := urlQuery.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/vcard", "text/x-vcard", "text/directory":
+			format = business.RosterImportVCard
+		case "text/csv":
+			format = business.RosterImportCSV
+		}
+	}
+
+	result, err := ps.rosterBusiness.ImportRoster(ctx, urlQuery.Get("name"), format, urlQuery.Get("region"),
+		http.MaxBytesReader(rw, req.Body, maxRosterImportBodyBytes))
+	if err != nil {
+		ps.writeError(ctx, rw, err, errorStatus(err))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(result)
+}
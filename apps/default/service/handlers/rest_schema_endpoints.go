@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pitabwire/frame/v2/data"
+
+	"github.com/antinvestor/service-profile/apps/default/service/authz"
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+// RestListPropertySchemasEndpoint lists the caller tenant's property schemas.
+func (ps *ProfileServer) RestListPropertySchemasEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionProfileView); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	schemas, err := ps.schemaBusiness.List(ctx)
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(data.JSONMap{
+		"schemas": schemas,
+		"count":   len(schemas),
+	})
+}
+
+// RestSavePropertySchemaEndpoint creates or replaces the schema for the key
+// in the JSON request body.
+func (ps *ProfileServer) RestSavePropertySchemaEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionSchemasManage); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	schema := &models.PropertySchema{}
+	if err := json.NewDecoder(req.Body).Decode(schema); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
+	saved, err := ps.schemaBusiness.Save(ctx, &models.PropertySchema{
+		Key:       schema.Key,
+		ValueType: schema.ValueType,
+		Required:  schema.Required,
+		Pattern:   schema.Pattern,
+		Enum:      schema.Enum,
+		PII:       schema.PII,
+		Scoped:    schema.Scoped,
+	})
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(saved)
+}
+
+// RestDeletePropertySchemaEndpoint removes the schema for the "key" query
+// parameter.
+func (ps *ProfileServer) RestDeletePropertySchemaEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionSchemasManage); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	if err := ps.schemaBusiness.Delete(ctx, req.URL.Query().Get("key")); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/v2/security"
+
+	"github.com/antinvestor/service-profile/apps/default/service/authz"
+)
+
+// RestShredTenantEndpoint crypto-shreds the contact data of the caller's
+// tenant. The JSON body must repeat the tenant id as {"tenant_id": "..."}
+// to confirm, since the data can't be recovered afterwards.
+func (ps *ProfileServer) RestShredTenantEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := ps.checker.Check(ctx, authz.PermissionTenantShred); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusForbidden)
+		return
+	}
+
+	claims := security.ClaimsFromContext(ctx)
+	if claims == nil || claims.GetTenantID() == "" {
+		ps.writeError(ctx, rw, errors.New("claims can not be empty"), http.StatusForbidden)
+		return
+	}
+
+	var confirmation struct {
+		TenantID string `json:"tenant_id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&confirmation); err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+	if confirmation.TenantID != claims.GetTenantID() {
+		ps.writeError(ctx, rw, errors.New("tenant_id does not match the caller's tenant"), http.StatusBadRequest)
+		return
+	}
+
+	report, err := ps.contactBusiness.ShredTenant(ctx, claims.GetTenantID())
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch connect.CodeOf(err) {
+		case connect.CodeInvalidArgument:
+			status = http.StatusBadRequest
+		case connect.CodeFailedPrecondition:
+			status = http.StatusConflict
+		default:
+		}
+		ps.writeError(ctx, rw, err, status)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(report)
+}
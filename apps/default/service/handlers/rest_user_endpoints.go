@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"connectrpc.com/connect"
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/frame/v2/security"
 
@@ -37,6 +39,18 @@ func (ps *ProfileServer) writeError(ctx context.Context, w http.ResponseWriter,
 	}
 }
 
+// errorStatus maps the connect code of err to an HTTP status.
+func errorStatus(err error) int {
+	switch connect.CodeOf(err) {
+	case connect.CodeInvalidArgument:
+		return http.StatusBadRequest
+	case connect.CodeNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // RestListRelationshipsEndpoint handles listing relationships via REST API.
 func (ps *ProfileServer) RestListRelationshipsEndpoint(rw http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
@@ -56,8 +70,14 @@ func (ps *ProfileServer) RestListRelationshipsEndpoint(rw http.ResponseWriter, r
 	// Extract parameters and build request
 	request := ps.buildRelationshipListRequest(urlQuery, claims)
 
+	filter, err := buildRelationshipFilter(urlQuery)
+	if err != nil {
+		ps.writeError(ctx, rw, err, http.StatusBadRequest)
+		return
+	}
+
 	// Fetch relationships
-	relationshipObjectList, lastRelID, err := ps.fetchRelationships(ctx, request)
+	relationshipObjectList, lastRelID, err := ps.fetchRelationships(ctx, request, filter)
 	if err != nil {
 		ps.writeError(ctx, rw, err, http.StatusInternalServerError)
 		return
@@ -124,13 +144,30 @@ func (ps *ProfileServer) buildRelationshipListRequest(
 	return request
 }
 
+// buildRelationshipFilter extracts the Role and ActiveAt URL parameters.
+// Role may be repeated; ActiveAt is an RFC 3339 time.
+func buildRelationshipFilter(urlQuery url.Values) (models.RelationshipFilter, error) {
+	filter := models.RelationshipFilter{Roles: urlQuery["Role"]}
+
+	if urlQuery.Has("ActiveAt") {
+		activeAt, err := time.Parse(time.RFC3339, urlQuery.Get("ActiveAt"))
+		if err != nil {
+			return filter, fmt.Errorf("ActiveAt must be an RFC 3339 time: %w", err)
+		}
+		filter.ActiveAt = activeAt
+	}
+
+	return filter, nil
+}
+
 // fetchRelationships fetches relationships based on the request.
 func (ps *ProfileServer) fetchRelationships(
 	ctx context.Context,
 	request *profilev1.ListRelationshipRequest,
+	filter models.RelationshipFilter,
 ) ([]*profilev1.RelationshipObject, string, error) {
 	// Get relationships from business layer
-	relationships, err := ps.relationshipBusiness.ListRelationships(ctx, request)
+	relationships, err := ps.relationshipBusiness.ListRelationships(ctx, request, filter)
 	if err != nil {
 		if !data.ErrorIsNoRows(err) {
 			return nil, "", err
@@ -216,6 +253,29 @@ func (ps *ProfileServer) NewSecureRouterV1() *http.ServeMux {
 	userServeMux.HandleFunc("/user/info", ps.RestUserInfo)
 
 	userServeMux.HandleFunc("/user/relations", ps.RestListRelationshipsEndpoint)
+	userServeMux.HandleFunc("GET /relationships/graph", ps.RestRelationshipGraphEndpoint)
+	userServeMux.HandleFunc("GET /relationships/path", ps.RestRelationshipPathEndpoint)
+
+	userServeMux.HandleFunc("GET /profile/asof", ps.RestGetProfileAsOfEndpoint)
+
+	userServeMux.HandleFunc("GET /profile/merge/preview", ps.RestPreviewMergeEndpoint)
+	userServeMux.HandleFunc("POST /profile/unmerge", ps.RestUnmergeEndpoint)
+	userServeMux.HandleFunc("GET /profile/duplicates", ps.RestListDuplicateCandidatesEndpoint)
+
+	userServeMux.HandleFunc("POST /profile/erase", ps.RestEraseProfileEndpoint)
+	userServeMux.HandleFunc("GET /profile/export", ps.RestExportProfileEndpoint)
+	userServeMux.HandleFunc("POST /profile/exports", ps.RestStartProfileExportEndpoint)
+	userServeMux.HandleFunc("GET /profile/exports", ps.RestGetProfileExportEndpoint)
+
+	userServeMux.HandleFunc("GET /profile/schemas", ps.RestListPropertySchemasEndpoint)
+	userServeMux.HandleFunc("PUT /profile/schemas", ps.RestSavePropertySchemaEndpoint)
+	userServeMux.HandleFunc("DELETE /profile/schemas", ps.RestDeletePropertySchemaEndpoint)
+
+	userServeMux.HandleFunc("POST /roster/sync", ps.RestSyncRosterEndpoint)
+	userServeMux.HandleFunc("POST /roster/import", ps.RestImportRosterEndpoint)
+
+	userServeMux.HandleFunc("GET /profile/keys/rotation", ps.RestKeyRotationProgressEndpoint)
+	userServeMux.HandleFunc("POST /profile/tenant/shred", ps.RestShredTenantEndpoint)
 
 	return userServeMux
 }
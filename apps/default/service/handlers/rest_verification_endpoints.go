@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+)
+
+// RestConfirmVerificationTokenEndpoint verifies a contact from the token
+// carried by a verification link, sent as {"token": "..."}. It needs no
+// authentication.
+func (ps *ProfileServer) RestConfirmVerificationTokenEndpoint(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Token == "" {
+		ps.writeError(ctx, rw, errors.New("a verification token is required"), http.StatusBadRequest)
+		return
+	}
+
+	verificationID, err := ps.profileBusiness.ConfirmVerificationToken(ctx, body.Token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch connect.CodeOf(err) {
+		case connect.CodeInvalidArgument:
+			status = http.StatusBadRequest
+		case connect.CodeFailedPrecondition:
+			status = http.StatusConflict
+		case connect.CodeResourceExhausted:
+			status = http.StatusTooManyRequests
+		default:
+		}
+		ps.writeError(ctx, rw, err, status)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(map[string]any{
+		"id":      verificationID,
+		"success": true,
+	})
+}
@@ -1,13 +1,24 @@
 package models
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/util"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/antinvestor/service-profile/apps/default/config"
 )
@@ -63,15 +74,449 @@ type Profile struct {
 	ProfileType   ProfileType
 }
 
+// Property value types recorded on PropertyEntry.ValueType.
+const (
+	PropertyValueString = "string"
+	PropertyValueNumber = "number"
+	PropertyValueBool   = "bool"
+	PropertyValueList   = "list"
+	PropertyValueObject = "object"
+	PropertyValueNull   = "null"
+)
+
 // PropertyEntry is an append-only ledger of property changes on a profile.
 // The latest entry per (profile_id, key) determines the current value.
 // Scoped entries are tenant-private and excluded from the JSONB cache.
+// String values are stored as is; every other type is stored as JSON with
+// ValueType telling them apart. A Tombstone entry unsets the key.
 type PropertyEntry struct {
 	data.BaseModel
 	ProfileID string `gorm:"type:varchar(50);not null;index:idx_prop_profile,priority:1"`
 	Key       string `gorm:"type:varchar(255);not null;index:idx_prop_profile_key,priority:1"`
 	Value     string `gorm:"type:text;not null"`
+	ValueType string `gorm:"type:varchar(10);not null;default:'string'"`
 	Scoped    bool   `gorm:"not null;default:false;index:idx_prop_tenant_scoped"`
+	Tombstone bool   `gorm:"not null;default:false"`
+}
+
+// NewPropertyTombstone returns an entry that unsets key on profileID.
+func NewPropertyTombstone(profileID, key string, scoped bool) *PropertyEntry {
+	return &PropertyEntry{
+		ProfileID: profileID,
+		Key:       key,
+		Value:     "null",
+		ValueType: PropertyValueNull,
+		Scoped:    scoped,
+		Tombstone: true,
+	}
+}
+
+// SetValue encodes value into Value and ValueType.
+func (e *PropertyEntry) SetValue(value any) error {
+	if str, ok := value.(string); ok {
+		e.Value = str
+		e.ValueType = PropertyValueString
+		return nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	e.Value = string(encoded)
+	switch encoded[0] {
+	case '{':
+		e.ValueType = PropertyValueObject
+	case '[':
+		e.ValueType = PropertyValueList
+	case 't', 'f':
+		e.ValueType = PropertyValueBool
+	case 'n':
+		e.ValueType = PropertyValueNull
+	case '"':
+		// Named string types marshal as JSON strings.
+		e.ValueType = PropertyValueString
+		return json.Unmarshal(encoded, &e.Value)
+	default:
+		e.ValueType = PropertyValueNumber
+		// Integers a float64 can't hold exactly would change when read back
+		if !exactInFloat(value) {
+			e.ValueType = PropertyValueString
+		}
+	}
+	return nil
+}
+
+// maxExactFloatInt is the largest magnitude up to which every integer has
+// an exact float64.
+const maxExactFloatInt = 1 << 53
+
+// exactInFloat reports whether the number reads back as a float64 without
+// losing precision. Floats, as every number arriving in a proto Struct is,
+// are already what they read back as; only wider integers can lose digits.
+func exactInFloat(number any) bool {
+	switch n := number.(type) {
+	case int:
+		return n >= -maxExactFloatInt && n <= maxExactFloatInt
+	case int64:
+		return n >= -maxExactFloatInt && n <= maxExactFloatInt
+	case uint:
+		return n <= maxExactFloatInt
+	case uint64:
+		return n <= maxExactFloatInt
+	case json.Number:
+		if strings.ContainsAny(n.String(), ".eE") {
+			return true
+		}
+		i, err := n.Int64()
+		return err == nil && i >= -maxExactFloatInt && i <= maxExactFloatInt
+	default:
+		return true
+	}
+}
+
+// TypedValue decodes the stored value back to its JSON type. Values that no
+// longer parse are returned as the raw string.
+func (e *PropertyEntry) TypedValue() any {
+	if e.ValueType == "" || e.ValueType == PropertyValueString {
+		return e.Value
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(e.Value), &value); err != nil {
+		return e.Value
+	}
+	return value
+}
+
+// ProfileMerge is the payload announcing that MergedID was folded into
+// TargetID, so services keyed by profile ID can re-point their records.
+type ProfileMerge struct {
+	TargetID string `json:"target_id"`
+	MergedID string `json:"merged_id"`
+}
+
+// ProfileErasure is the audit record of a profile erased on request. It
+// holds no personal data: the erased profile's id, who asked for the
+// erasure and how many rows were removed from each table.
+type ProfileErasure struct {
+	data.BaseModel
+	ProfileID   string `gorm:"type:varchar(50);not null;uniqueIndex"`
+	RequestedBy string `gorm:"type:varchar(50)"`
+	// Erased maps each table to the number of rows removed from it.
+	Erased data.JSONMap
+}
+
+// ProfileErased is the payload announcing that a profile was erased, so
+// services keyed by profile ID can purge what they hold for the subject.
+type ProfileErased struct {
+	ProfileID string    `json:"profile_id"`
+	ErasureID string    `json:"erasure_id"`
+	ErasedAt  time.Time `json:"erased_at"`
+}
+
+// SubjectData is every row held about a profile, gathered for export.
+// Property history is gathered separately, as it depends on the caller.
+type SubjectData struct {
+	Profile              *Profile
+	Contacts             []*Contact
+	Addresses            []*ProfileAddress
+	Relationships        []*Relationship
+	Rosters              []*Roster
+	Verifications        []*Verification
+	VerificationAttempts []*VerificationAttempt
+}
+
+// ProfileDataBundle is the machine-readable export of everything held
+// about a profile, contact details decrypted.
+type ProfileDataBundle struct {
+	ProfileID            string         `json:"profile_id"`
+	ExportedAt           time.Time      `json:"exported_at"`
+	Profile              data.JSONMap   `json:"profile"`
+	Contacts             []data.JSONMap `json:"contacts"`
+	PropertyHistory      []data.JSONMap `json:"property_history"`
+	Addresses            []data.JSONMap `json:"addresses"`
+	Relationships        []data.JSONMap `json:"relationships"`
+	Rosters              []data.JSONMap `json:"rosters"`
+	Verifications        []data.JSONMap `json:"verifications"`
+	VerificationAttempts []data.JSONMap `json:"verification_attempts"`
+}
+
+// Profile export states.
+const (
+	ProfileExportPending = "pending"
+	ProfileExportRunning = "running"
+	ProfileExportReady   = "ready"
+	ProfileExportFailed  = "failed"
+)
+
+// ProfileExport is an asynchronous export of a profile's data. Once ready,
+// the bundle is stored encrypted the way contact details are.
+type ProfileExport struct {
+	data.BaseModel
+	ProfileID string `gorm:"type:varchar(50);not null;index"`
+	// CallerTenantID selects whose scoped property entries are exported.
+	CallerTenantID string `gorm:"type:varchar(50)"`
+	RequestedBy    string `gorm:"type:varchar(50)"`
+	Status         string `gorm:"type:varchar(10);not null;default:'pending';index"`
+
+	EncryptedBundle []byte `gorm:"type:bytea"`
+	EncryptionKeyID string `gorm:"type:varchar(255)"`
+
+	Error       string `gorm:"type:text"`
+	CompletedAt time.Time
+}
+
+// ProfileExportCompleted is the payload announcing that an export
+// finished, successfully or not.
+type ProfileExportCompleted struct {
+	ExportID  string `json:"export_id"`
+	ProfileID string `json:"profile_id"`
+	Status    string `json:"status"`
+}
+
+// Merge journal actions.
+const (
+	// MergeActionMoved marks a row re-parented from the merged profile to the target.
+	MergeActionMoved = "moved"
+	// MergeActionDropped marks a colliding row soft-deleted by the merge.
+	MergeActionDropped = "dropped"
+	// MergeActionAppended marks a property entry replayed onto the target ledger.
+	MergeActionAppended = "appended"
+)
+
+// MergeJournal records a merge so it can be undone within the unmerge
+// window. TargetProperties is the target's properties cache before the merge.
+type MergeJournal struct {
+	data.BaseModel
+	TargetID string `gorm:"type:varchar(50);index:idx_merge_journal_target"`
+	MergedID string `gorm:"type:varchar(50);index:idx_merge_journal_merged"`
+
+	TargetProperties data.JSONMap
+
+	UnmergedAt *time.Time
+}
+
+// MergeJournalEntry is a single row a merge moved, dropped or appended.
+type MergeJournalEntry struct {
+	data.BaseModel
+	JournalID   string `gorm:"type:varchar(50);not null;index:idx_merge_journal_entry"`
+	RecordTable string `gorm:"type:varchar(50);not null"`
+	RecordID    string `gorm:"type:varchar(50);not null"`
+	Action      string `gorm:"type:varchar(10);not null"`
+}
+
+// PropertySnapshot holds ledger entries folded away by compaction for one
+// profile, global or for one partition's scoped entries. Entries maps each
+// key to the latest folded entry, tombstones included, so reads at or after
+// Horizon need only the snapshot and the entries still in the ledger.
+// A merge moves the merged profile's snapshots over with MergedFrom set, so
+// a scope may have several until compaction absorbs them into its own.
+type PropertySnapshot struct {
+	data.BaseModel
+	ProfileID      string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_property_snapshot_scope,priority:1"`
+	Scoped         bool      `gorm:"not null;default:false;uniqueIndex:idx_property_snapshot_scope,priority:2"`
+	ScopePartition string    `gorm:"type:varchar(50);not null;default:'';uniqueIndex:idx_property_snapshot_scope,priority:3"`
+	MergedFrom     string    `gorm:"type:varchar(50);not null;default:'';uniqueIndex:idx_property_snapshot_scope,priority:4"`
+	Horizon        time.Time `gorm:"not null"`
+	Entries        data.JSONMap
+}
+
+// Absorb folds other's entries into the snapshot, which then reaches the
+// later of the two horizons.
+func (s *PropertySnapshot) Absorb(other *PropertySnapshot) {
+	for key := range other.Entries {
+		if entry, ok := other.Entry(key); ok {
+			s.Fold(entry)
+		}
+	}
+	if other.Horizon.After(s.Horizon) {
+		s.Horizon = other.Horizon
+	}
+}
+
+// Fold records e in the snapshot unless a later entry for its key is
+// already there.
+func (s *PropertySnapshot) Fold(e *PropertyEntry) {
+	if s.Entries == nil {
+		s.Entries = data.JSONMap{}
+	}
+	if folded, ok := s.Entry(e.Key); ok && folded.CreatedAt.After(e.CreatedAt) {
+		return
+	}
+	s.Entries[e.Key] = map[string]any{
+		"value":      e.Value,
+		"value_type": e.ValueType,
+		"tombstone":  e.Tombstone,
+		"tenant_id":  e.TenantID,
+		"created_by": e.CreatedBy,
+		"created_at": e.CreatedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// Entry returns the folded entry for key.
+func (s *PropertySnapshot) Entry(key string) (*PropertyEntry, bool) {
+	folded, ok := s.Entries[key].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	entry := &PropertyEntry{
+		ProfileID: s.ProfileID,
+		Key:       key,
+		Scoped:    s.Scoped,
+	}
+	entry.PartitionID = s.ScopePartition
+	entry.Value, _ = folded["value"].(string)
+	entry.ValueType, _ = folded["value_type"].(string)
+	entry.Tombstone, _ = folded["tombstone"].(bool)
+	entry.TenantID, _ = folded["tenant_id"].(string)
+	entry.CreatedBy, _ = folded["created_by"].(string)
+	createdAt, _ := folded["created_at"].(string)
+	entry.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	return entry, true
+}
+
+// PropertyConflict is a global property both sides of a merge hold with
+// different latest values. The merged value wins on merge.
+type PropertyConflict struct {
+	Key         string `json:"key"`
+	TargetValue any    `json:"target_value"`
+	MergedValue any    `json:"merged_value"`
+}
+
+// MergePreview describes what folding MergedID into TargetID would do,
+// without writing anything. Moved rows are re-parented to the target;
+// colliding rows duplicate something the target already holds and are
+// dropped, as are relationships that would become self-referencing.
+type MergePreview struct {
+	TargetID string `json:"target_id"`
+	MergedID string `json:"merged_id"`
+
+	PropertyConflicts []*PropertyConflict `json:"property_conflicts"`
+
+	MovedContactIDs []string `json:"moved_contact_ids"`
+
+	MovedRosterIDs     []string `json:"moved_roster_ids"`
+	CollidingRosterIDs []string `json:"colliding_roster_ids"`
+
+	MovedAddressLinkIDs     []string `json:"moved_address_link_ids"`
+	CollidingAddressLinkIDs []string `json:"colliding_address_link_ids"`
+
+	MovedRelationshipIDs           []string `json:"moved_relationship_ids"`
+	CollidingRelationshipIDs       []string `json:"colliding_relationship_ids"`
+	SelfReferencingRelationshipIDs []string `json:"self_referencing_relationship_ids"`
+}
+
+// DuplicateCandidate is a pair of profiles the duplicate detection job
+// believes belong to the same person. ProfileID sorts before OtherProfileID
+// so each pair is stored once; Reasons records the signals behind Score.
+type DuplicateCandidate struct {
+	data.BaseModel
+	ProfileID      string  `gorm:"type:varchar(50);not null;uniqueIndex:idx_duplicate_pair,priority:1"`
+	OtherProfileID string  `gorm:"type:varchar(50);not null;uniqueIndex:idx_duplicate_pair,priority:2"`
+	Score          float64 `gorm:"not null;index:idx_duplicate_score"`
+	Reasons        data.JSONMap
+}
+
+// KeyRotationSweep checkpoints the re-encryption of contacts onto
+// TargetKeyID. LastContactID is where the current pass resumes, and
+// CompletedAt is set once no contact remains on another key.
+type KeyRotationSweep struct {
+	data.BaseModel
+	TargetKeyID   string `gorm:"type:varchar(255);not null;uniqueIndex"`
+	LastContactID string `gorm:"type:varchar(50);not null;default:''"`
+	Passes        int    `gorm:"not null;default:0"`
+	Queued        int64  `gorm:"not null;default:0"`
+	Remaining     int64  `gorm:"not null;default:0"`
+	// RemainingByKey counts contacts per key id still to be re-encrypted.
+	RemainingByKey data.JSONMap
+	CompletedAt    time.Time
+}
+
+// DataKey is a tenant's envelope encryption key, stored wrapped by the
+// master key MasterKeyID. Its id is the EncryptionKeyID of the contacts it
+// encrypts, and the tenant's newest data key encrypts new contacts.
+// Shredding a key empties WrappedKey, leaving its contacts unreadable.
+type DataKey struct {
+	data.BaseModel
+	MasterKeyID string `gorm:"type:varchar(255);not null"`
+	WrappedKey  []byte `gorm:"type:bytea;not null"`
+	// ShreddedAt is when the key was shredded, zero while it is usable.
+	ShreddedAt time.Time
+}
+
+// IsShredded reports whether the key was shredded.
+func (dk *DataKey) IsShredded() bool {
+	return !dk.ShreddedAt.IsZero() || len(dk.WrappedKey) == 0
+}
+
+// TenantShredReport records the crypto-shredding of a tenant's contact data.
+type TenantShredReport struct {
+	TenantID string `json:"tenant_id"`
+	// DataKeys is how many data keys were shredded.
+	DataKeys int64 `json:"data_keys"`
+	// LookupTokens is how many contacts had their lookup token removed.
+	LookupTokens int64     `json:"lookup_tokens"`
+	ShreddedAt   time.Time `json:"shredded_at"`
+}
+
+// PropertySchema constrains one property key for a tenant. An empty
+// ValueType accepts any type; Pattern applies to string values only.
+// PII marks keys holding personal data, and Scoped makes writes to the key
+// tenant-private even when the update is global.
+type PropertySchema struct {
+	data.BaseModel
+	Key       string `gorm:"type:varchar(255);not null" json:"key"`
+	ValueType string `gorm:"type:varchar(10)" json:"value_type,omitempty"`
+	Required  bool   `gorm:"not null;default:false" json:"required"`
+	Pattern   string `gorm:"type:text" json:"pattern,omitempty"`
+	Enum      []any  `gorm:"type:jsonb;serializer:json" json:"enum,omitempty"`
+	PII       bool   `gorm:"column:pii;not null;default:false" json:"pii"`
+	Scoped    bool   `gorm:"not null;default:false" json:"scoped"`
+}
+
+// Check returns why value does not satisfy the schema, or "" if it does.
+func (s *PropertySchema) Check(value any) string {
+	if value == nil || value == "" {
+		if s.Required {
+			return "is required"
+		}
+		return ""
+	}
+
+	entry := &PropertyEntry{}
+	if err := entry.SetValue(value); err != nil {
+		return "is not a valid JSON value"
+	}
+	if s.ValueType != "" && entry.ValueType != s.ValueType {
+		return "must be of type " + s.ValueType
+	}
+
+	if str, ok := value.(string); ok && s.Pattern != "" {
+		matched, err := regexp.MatchString(s.Pattern, str)
+		if err != nil || !matched {
+			return "does not match pattern " + s.Pattern
+		}
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(allowed any) bool {
+		return reflect.DeepEqual(allowed, entry.TypedValue())
+	}) {
+		return "is not one of the allowed values"
+	}
+
+	return ""
+}
+
+// ProfilePair is an unordered pair of profile ids found by a duplicate
+// signal, both of the tenant and partition it names.
+type ProfilePair struct {
+	TenantID       string
+	PartitionID    string
+	ProfileID      string
+	OtherProfileID string
 }
 
 type Contact struct {
@@ -80,6 +525,9 @@ type Contact struct {
 	LookUpToken     []byte `gorm:"type:bytea;uniqueIndex"`
 	EncryptedDetail []byte `gorm:"type:bytea"`
 	EncryptionKeyID string `gorm:"type:varchar(255)"`
+	// LookUpKeyID identifies the key LookUpToken was computed with; empty
+	// on contacts created before lookup keys were versioned.
+	LookUpKeyID string `gorm:"type:varchar(255);index"`
 
 	ContactType        string `gorm:"type:varchar(50)"`
 	CommunicationLevel string `gorm:"type:varchar(50)"`
@@ -91,6 +539,42 @@ type Contact struct {
 	Properties data.JSONMap
 
 	VerificationID string `gorm:"type:varchar(50)"`
+	// VerifiedAt is when the contact was last verified.
+	VerifiedAt time.Time
+	// VerificationExpiresAt is when the contact must be verified again, zero
+	// if its verification never goes stale.
+	VerificationExpiresAt time.Time `gorm:"index:contact_verification_expires_at"`
+}
+
+// ContactVerificationExpiry is the payload announcing that a contact's
+// verification went stale and the contact is no longer verified.
+type ContactVerificationExpiry struct {
+	ContactID      string    `json:"contact_id"`
+	ProfileID      string    `json:"profile_id"`
+	ContactType    string    `json:"contact_type"`
+	VerificationID string    `json:"verification_id"`
+	VerifiedAt     time.Time `json:"verified_at"`
+	ExpiredAt      time.Time `json:"expired_at"`
+}
+
+// MarkVerified records verificationID as the contact's verification, valid
+// for the seconds validityInSec holds for the contact's type.
+func (c *Contact) MarkVerified(verificationID string, at time.Time, validityInSec map[string]int) {
+	c.VerificationID = verificationID
+	c.VerifiedAt = at
+	c.VerificationExpiresAt = time.Time{}
+	if validity := validityInSec[c.ContactType]; validity > 0 {
+		c.VerificationExpiresAt = at.Add(time.Duration(validity) * time.Second)
+	}
+}
+
+// VerificationFresh reports whether the contact holds a verification that
+// has not gone stale by now.
+func (c *Contact) VerificationFresh(now time.Time) bool {
+	if c.VerificationID == "" {
+		return false
+	}
+	return c.VerificationExpiresAt.IsZero() || now.Before(c.VerificationExpiresAt)
 }
 
 func (c *Contact) DecryptDetail(decryptionKeyID string, decryptionKeyData []byte) (string, error) {
@@ -105,8 +589,18 @@ func (c *Contact) DecryptDetail(decryptionKeyID string, decryptionKeyData []byte
 	return string(detailBytes), nil
 }
 
-func (c *Contact) ToAPI(dek *config.DEK, partial bool) (*profilev1.ContactObject, error) {
-	contactDetail, err := c.DecryptDetail(dek.KeyID, dek.Key)
+// Detail decrypts the contact detail with the key keys resolves for its
+// EncryptionKeyID.
+func (c *Contact) Detail(ctx context.Context, keys config.KeyProvider) (string, error) {
+	key, err := keys.DecryptionKey(ctx, c.EncryptionKeyID)
+	if err != nil {
+		return "", err
+	}
+	return c.DecryptDetail(c.EncryptionKeyID, key)
+}
+
+func (c *Contact) ToAPI(ctx context.Context, keys config.KeyProvider, partial bool) (*profilev1.ContactObject, error) {
+	contactDetail, err := c.Detail(ctx, keys)
 	if err != nil {
 		return nil, err
 	}
@@ -116,9 +610,12 @@ func (c *Contact) ToAPI(dek *config.DEK, partial bool) (*profilev1.ContactObject
 		Detail: contactDetail,
 	}
 
+	extra := map[string]any{}
 	contactTypeID, ok := profilev1.ContactType_value[c.ContactType]
 	if !ok {
+		// Types the API has no value for are named in Extra
 		contactTypeID = int32(profilev1.ContactType_EMAIL)
+		extra["contact_type"] = c.ContactType
 	}
 	contactObject.Type = profilev1.ContactType(contactTypeID)
 
@@ -130,7 +627,23 @@ func (c *Contact) ToAPI(dek *config.DEK, partial bool) (*profilev1.ContactObject
 
 	contactObject.Verified = false
 	if !partial {
-		contactObject.Verified = c.VerificationID != ""
+		contactObject.Verified = c.VerificationFresh(time.Now())
+
+		if c.VerificationID != "" {
+			if !c.VerifiedAt.IsZero() {
+				extra["verified_at"] = c.VerifiedAt.Format(time.RFC3339)
+			}
+			if !c.VerificationExpiresAt.IsZero() {
+				extra["verification_expires_at"] = c.VerificationExpiresAt.Format(time.RFC3339)
+			}
+		}
+	}
+
+	if len(extra) > 0 || (!partial && c.VerificationID != "") {
+		contactObject.Extra, err = structpb.NewStruct(extra)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &contactObject, nil
@@ -147,8 +660,8 @@ type Roster struct {
 	Properties data.JSONMap `gorm:"type:JSONB"`
 }
 
-func (r *Roster) ToAPI(dek *config.DEK) (*profilev1.RosterObject, error) {
-	contactObj, err := r.Contact.ToAPI(dek, true)
+func (r *Roster) ToAPI(ctx context.Context, keys config.KeyProvider) (*profilev1.RosterObject, error) {
+	contactObj, err := r.Contact.ToAPI(ctx, keys, true)
 	if err != nil {
 		return nil, err
 	}
@@ -162,15 +675,234 @@ func (r *Roster) ToAPI(dek *config.DEK) (*profilev1.RosterObject, error) {
 	}, nil
 }
 
+// ProfileRosterVisibilityProperty is the profile property choosing what
+// people holding one of the profile's contacts in their roster learn of it.
+const ProfileRosterVisibilityProperty = "roster_visibility"
+
+// Roster visibilities of a profile.
+const (
+	// RosterVisibilityProfile flags roster entries of the profile's contacts
+	// and shows its ID. Profiles must opt in to it.
+	RosterVisibilityProfile = "profile"
+	// RosterVisibilityMatched flags the entries without showing the ID.
+	// Profiles without the property are visible this way.
+	RosterVisibilityMatched = "matched"
+	// RosterVisibilityHidden leaves the entries unflagged.
+	RosterVisibilityHidden = "hidden"
+)
+
+// Keys set on the extra of roster entries whose contact belongs to a
+// profile, see RosterVisibilityProfile.
+const (
+	RosterMatchedExtra          = "matched"
+	RosterMatchedProfileIDExtra = "matched_profile_id"
+)
+
+// RosterMatch is the payload announcing that a contact in a roster now
+// belongs to a verified profile. ProfileID is left empty if the profile
+// does not show its ID.
+type RosterMatch struct {
+	ContactID      string    `json:"contact_id"`
+	ProfileID      string    `json:"profile_id,omitempty"`
+	RosterID       string    `json:"roster_id,omitempty"`
+	OwnerProfileID string    `json:"owner_profile_id,omitempty"`
+	MatchedAt      time.Time `json:"matched_at"`
+}
+
+// RosterSyncEntry is a contact sent in a roster sync, with the extras of
+// its roster entry.
+type RosterSyncEntry struct {
+	Contact string       `json:"contact"`
+	Extras  data.JSONMap `json:"extras,omitempty"`
+}
+
+// RosterSyncRequest carries the changes a client made to the roster Name
+// since SyncToken, the token of its previous sync. Without a token the
+// client holds nothing yet and gets the whole roster back.
+type RosterSyncRequest struct {
+	Name      string             `json:"name"`
+	SyncToken string             `json:"sync_token,omitempty"`
+	Added     []*RosterSyncEntry `json:"added,omitempty"`
+	Updated   []*RosterSyncEntry `json:"updated,omitempty"`
+	Removed   []string           `json:"removed,omitempty"`
+}
+
+// RosterSyncResult carries the roster entries changed since the request's
+// token, the client's own changes included, and the token to sync from
+// next. Removed lists the IDs of entries removed since.
+type RosterSyncResult struct {
+	SyncToken string                    `json:"sync_token"`
+	Changed   []*profilev1.RosterObject `json:"changed"`
+	Removed   []string                  `json:"removed"`
+}
+
+// RosterImportFailure reports a contact of an imported address book that
+// was not added. Row is the card number of a vCard file or the line of a
+// CSV file.
+type RosterImportFailure struct {
+	Row     int    `json:"row"`
+	Contact string `json:"contact,omitempty"`
+	Error   string `json:"error"`
+}
+
+// RosterImportResult carries the roster entries of an imported address book
+// and the contacts that could not be added.
+type RosterImportResult struct {
+	Imported []*profilev1.RosterObject `json:"imported"`
+	Failures []*RosterImportFailure    `json:"failures"`
+}
+
 type Verification struct {
 	data.BaseModel
-	ProfileID  string    `gorm:"type:varchar(50);index:profile_id" json:"profile_id"`
-	ContactID  string    `gorm:"type:varchar(50);index:contact_id" json:"contact_id"`
-	Code       string    `gorm:"type:varchar(255)"                 json:"code"`
-	ExpiresAt  time.Time `                                         json:"expires_at"`
-	VerifiedAt time.Time `                                         json:"verified_at"`
+	ProfileID string `gorm:"type:varchar(50);index:profile_id" json:"profile_id"`
+	ContactID string `gorm:"type:varchar(50);index:contact_id" json:"contact_id"`
+	// Code is the HMAC digest of the code, see HashVerificationCode.
+	// Verifications created before codes were hashed hold it in plaintext.
+	Code       string    `gorm:"type:varchar(255)" json:"code"`
+	ExpiresAt  time.Time `                         json:"expires_at"`
+	VerifiedAt time.Time `                         json:"verified_at"`
+	// LockedAt is set once too many attempts were made; a locked verification
+	// can no longer succeed.
+	LockedAt time.Time `json:"locked_at"`
+	// Attempts counts the checks made against the code. It is incremented
+	// before each check so concurrent guesses can't share a count.
+	Attempts int `gorm:"not null;default:0" json:"attempts"`
+	// Mode is how the code is delivered, see VerificationModeCode.
+	Mode string `gorm:"type:varchar(10);not null;default:'code'" json:"mode"`
+	// SealedCode carries the code, encrypted with the DEK, to the
+	// notification that delivers it. It is never stored.
+	SealedCode []byte `gorm:"-" json:"sealed_code,omitempty"`
 }
 
+// Verification modes.
+const (
+	// VerificationModeCode sends the code for the user to type back in.
+	VerificationModeCode = "code"
+	// VerificationModeLink sends a link carrying a signed token, confirmed
+	// with ConfirmVerificationToken. Only email contacts can use it.
+	VerificationModeLink = "link"
+)
+
+// VerificationTokenCodeLength is the length of the codes of link mode
+// verifications, which are never typed in.
+const VerificationTokenCodeLength = 32
+
+const (
+	verificationCodeHashPrefix = "hmac-sha256:"
+	verificationTokenAudience  = "contact_verification"
+)
+
+type verificationTokenClaims struct {
+	jwt.RegisteredClaims
+
+	Code string `json:"code"`
+}
+
+// ErrNoTokenSigningKey is returned when link mode verification tokens are
+// used without a signing key configured.
+var ErrNoTokenSigningKey = errors.New("no verification token signing key is configured")
+
+// SignToken returns the token for a link mode verification, carrying its id
+// and code and expiring with it.
+func (v *Verification) SignToken(signingKey []byte, code string) (string, error) {
+	if len(signingKey) == 0 {
+		return "", ErrNoTokenSigningKey
+	}
+	claims := verificationTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        v.GetID(),
+			Subject:   v.ContactID,
+			Audience:  jwt.ClaimStrings{verificationTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(v.ExpiresAt),
+		},
+		Code: code,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey)
+}
+
+// ParseVerificationToken checks a token made by SignToken, returning the
+// verification id and code it carries.
+func ParseVerificationToken(signingKey []byte, token string) (string, string, error) {
+	if len(signingKey) == 0 {
+		return "", "", ErrNoTokenSigningKey
+	}
+	claims := &verificationTokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return signingKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(verificationTokenAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return "", "", err
+	}
+	if claims.ID == "" || claims.Code == "" {
+		return "", "", errors.New("verification token is incomplete")
+	}
+	return claims.ID, claims.Code, nil
+}
+
+// HashVerificationCode returns the digest stored for a verification code,
+// keyed with hmacKey and salted with the verification id.
+func HashVerificationCode(hmacKey []byte, verificationID, code string) string {
+	token := util.ComputeLookupToken(hmacKey, verificationID+":"+code)
+	return verificationCodeHashPrefix + hex.EncodeToString(token)
+}
+
+// SealCode stores the digest of code, keeping the code itself encrypted in
+// SealedCode. The verification id must already be set.
+func (v *Verification) SealCode(dek *config.DEK, code string) error {
+	sealed, err := util.EncryptValue(dek.Key, []byte(code))
+	if err != nil {
+		return err
+	}
+	v.Code = HashVerificationCode(dek.LookUpKey, v.GetID(), code)
+	v.SealedCode = sealed
+	return nil
+}
+
+// UnsealCode returns the code carried in SealedCode. Verifications queued
+// before codes were hashed carry it in Code, which is then hashed in place.
+func (v *Verification) UnsealCode(dek *config.DEK) (string, error) {
+	if len(v.SealedCode) == 0 {
+		if v.Code == "" || strings.HasPrefix(v.Code, verificationCodeHashPrefix) {
+			return "", errors.New("verification code is not available")
+		}
+		code := v.Code
+		v.Code = HashVerificationCode(dek.LookUpKey, v.GetID(), code)
+		return code, nil
+	}
+
+	code, err := util.DecryptValue(dek.Key, v.SealedCode)
+	if err != nil && len(dek.OldKey) > 0 {
+		// Sealed before the active key was rotated in
+		code, err = util.DecryptValue(dek.OldKey, v.SealedCode)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(code), nil
+}
+
+// CodeMatches reports in constant time whether code is the verification's.
+func (v *Verification) CodeMatches(hmacKey []byte, code string) bool {
+	if !strings.HasPrefix(v.Code, verificationCodeHashPrefix) {
+		return subtle.ConstantTimeCompare([]byte(v.Code), []byte(code)) == 1
+	}
+	return hmac.Equal([]byte(v.Code), []byte(HashVerificationCode(hmacKey, v.GetID(), code)))
+}
+
+// Verification attempt states.
+const (
+	VerificationAttemptSuccess = "Success"
+	VerificationAttemptFail    = "Fail"
+	// VerificationAttemptBlocked marks an attempt rejected by lockout or
+	// throttling without its code being checked.
+	VerificationAttemptBlocked = "Blocked"
+)
+
 type VerificationAttempt struct {
 	data.BaseModel
 	VerificationID string `gorm:"type:varchar(50);index:verification_id"`
@@ -178,8 +910,8 @@ type VerificationAttempt struct {
 	Data  string `gorm:"type:varchar(250)"`
 	State string `gorm:"type:varchar(10)"`
 
-	DeviceID  string `gorm:"type:varchar(50)"`
-	IPAddress string `gorm:"type:varchar(50)"`
+	DeviceID  string `gorm:"type:varchar(50);index:verification_attempt_device_id"`
+	IPAddress string `gorm:"type:varchar(50);index:verification_attempt_ip_address"`
 	RequestID string `gorm:"type:varchar(50)"`
 }
 
@@ -246,9 +978,60 @@ type Relationship struct {
 	RelationshipTypeID string `gorm:"type:varchar(50);index:relationship_type_id"`
 	RelationshipType   *RelationshipType
 
+	// Role qualifies the relationship, as in "director" or "guardian".
+	Role string `gorm:"type:varchar(100);index:relationship_role"`
+	// ValidFrom and ValidUntil bound when the relationship holds. A nil
+	// bound leaves that end open.
+	ValidFrom  *time.Time
+	ValidUntil *time.Time `gorm:"index:relationship_valid_until"`
+	// ExpiredAt is when the lapse of ValidUntil was announced.
+	ExpiredAt *time.Time
+
 	Properties data.JSONMap
 }
 
+// Keys of the relationship properties the API carries a relationship's
+// role and validity bounds in, the bounds in RFC 3339.
+const (
+	RelationshipRoleProperty       = "role"
+	RelationshipValidFromProperty  = "valid_from"
+	RelationshipValidUntilProperty = "valid_until"
+)
+
+// ActiveAt reports whether the relationship holds at t.
+func (r *Relationship) ActiveAt(t time.Time) bool {
+	return (r.ValidFrom == nil || !t.Before(*r.ValidFrom)) && (r.ValidUntil == nil || t.Before(*r.ValidUntil))
+}
+
+// RelationshipFilter narrows a listing of relationships.
+type RelationshipFilter struct {
+	// Roles keeps relationships holding one of the roles, "" standing for
+	// no role. Empty keeps every role.
+	Roles []string
+	// ActiveAt keeps relationships holding at that time, unless zero.
+	ActiveAt time.Time
+}
+
+// RelationshipExpiry is the payload announcing that a relationship's
+// validity lapsed.
+type RelationshipExpiry struct {
+	RelationshipID   string    `json:"relationship_id"`
+	RelationshipType string    `json:"relationship_type"`
+	Role             string    `json:"role,omitempty"`
+	ParentObject     string    `json:"parent_object"`
+	ParentObjectID   string    `json:"parent_object_id"`
+	ChildObject      string    `json:"child_object"`
+	ChildObjectID    string    `json:"child_object_id"`
+	ValidUntil       time.Time `json:"valid_until"`
+}
+
+// RelationshipEdge is a relationship reached by a graph query, Depth hops
+// from the entry the query started at.
+type RelationshipEdge struct {
+	Relationship *Relationship
+	Depth        int
+}
+
 func (r *Relationship) ToAPI() *profilev1.RelationshipObject {
 	// Safe conversion from uint to int32
 	var relationshipTypeValue int32
@@ -258,10 +1041,24 @@ func (r *Relationship) ToAPI() *profilev1.RelationshipObject {
 		relationshipTypeValue = math.MaxInt32
 	}
 
+	properties := data.JSONMap{}
+	for key, value := range r.Properties {
+		properties[key] = value
+	}
+	if r.Role != "" {
+		properties[RelationshipRoleProperty] = r.Role
+	}
+	if r.ValidFrom != nil {
+		properties[RelationshipValidFromProperty] = r.ValidFrom.Format(time.RFC3339)
+	}
+	if r.ValidUntil != nil {
+		properties[RelationshipValidUntilProperty] = r.ValidUntil.Format(time.RFC3339)
+	}
+
 	relationshipObj := &profilev1.RelationshipObject{
 		Id:         r.GetID(),
 		Type:       profilev1.RelationshipType(relationshipTypeValue),
-		Properties: r.Properties.ToProtoStruct(),
+		Properties: properties.ToProtoStruct(),
 		ChildEntry: &profilev1.EntryItem{
 			ObjectName: r.ChildObject,
 			ObjectId:   r.ChildObjectID,
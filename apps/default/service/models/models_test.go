@@ -1,12 +1,15 @@
 package models_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/util"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/antinvestor/service-profile/apps/default/config"
 	"github.com/antinvestor/service-profile/apps/default/service/models"
@@ -66,6 +69,47 @@ func TestRelationshipTypeIDToEnum(t *testing.T) {
 	}
 }
 
+func TestRelationship_ActiveAt(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(24 * time.Hour)
+
+	tests := []struct {
+		name         string
+		relationship models.Relationship
+		at           time.Time
+		expected     bool
+	}{
+		{"Unbounded", models.Relationship{}, from, true},
+		{"Before start", models.Relationship{ValidFrom: &from}, from.Add(-time.Second), false},
+		{"At start", models.Relationship{ValidFrom: &from, ValidUntil: &until}, from, true},
+		{"Before end", models.Relationship{ValidFrom: &from, ValidUntil: &until}, until.Add(-time.Second), true},
+		{"At end", models.Relationship{ValidUntil: &until}, until, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.relationship.ActiveAt(tt.at))
+		})
+	}
+}
+
+func TestRelationship_ToAPIBounds(t *testing.T) {
+	until := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	relationship := models.Relationship{
+		Role:             "guardian",
+		ValidUntil:       &until,
+		Properties:       data.JSONMap{"note": "court order"},
+		RelationshipType: &models.RelationshipType{UID: models.RelationshipTypeAffiliatedID},
+	}
+
+	properties := relationship.ToAPI().GetProperties().AsMap()
+	require.Equal(t, "guardian", properties[models.RelationshipRoleProperty])
+	require.Equal(t, "2024-01-02T00:00:00Z", properties[models.RelationshipValidUntilProperty])
+	require.Equal(t, "court order", properties["note"])
+	require.NotContains(t, properties, models.RelationshipValidFromProperty)
+	require.Len(t, relationship.Properties, 1)
+}
+
 func TestContact_DecryptDetail(t *testing.T) {
 	// Create a test encryption key (32 bytes for AES-256)
 	key := []byte("12345678901234567890123456789012")
@@ -132,6 +176,47 @@ func TestContact_DecryptDetail(t *testing.T) {
 	}
 }
 
+func TestContact_Detail(t *testing.T) {
+	dek := &config.DEK{
+		KeyID:    "active-key-id",
+		Key:      []byte("12345678901234567890123456789012"),
+		OldKeyID: "old-key-id",
+		OldKey:   []byte("abcdefghijklmnopqrstuvwxyz123456"),
+		OldKeys:  map[string][]byte{"historic-key-id": []byte("1234567890123456")},
+	}
+
+	for _, keyID := range []string{"active-key-id", "old-key-id", "historic-key-id"} {
+		t.Run(keyID, func(t *testing.T) {
+			key, ok := dek.KeyFor(keyID)
+			require.True(t, ok)
+			encryptedDetail, err := util.EncryptValue(key, []byte("test@example.com"))
+			require.NoError(t, err)
+
+			contact := &models.Contact{EncryptedDetail: encryptedDetail, EncryptionKeyID: keyID}
+			detail, err := contact.Detail(context.Background(), dek)
+			require.NoError(t, err)
+			require.Equal(t, "test@example.com", detail)
+		})
+	}
+
+	_, err := (&models.Contact{EncryptionKeyID: "unknown-key-id"}).Detail(context.Background(), dek)
+	require.Error(t, err)
+	require.ElementsMatch(t, []string{"old-key-id", "historic-key-id"}, dek.RetiredKeyIDs())
+}
+
+func TestDEK_LookupTokens(t *testing.T) {
+	dek := &config.DEK{LookUpKey: []byte("new-lookup-key"), OldLookUpKey: []byte("old-lookup-key")}
+
+	tokens := dek.LookupTokens("test@example.com")
+	require.Equal(t, [][]byte{
+		util.ComputeLookupToken(dek.LookUpKey, "test@example.com"),
+		util.ComputeLookupToken(dek.OldLookUpKey, "test@example.com"),
+	}, tokens)
+
+	dek.OldLookUpKeyRetired = true
+	require.Equal(t, tokens[:1], dek.LookupTokens("test@example.com"))
+}
+
 func TestContact_ToAPI(t *testing.T) {
 	// Create a test encryption key (32 bytes for AES-256)
 	key := []byte("12345678901234567890123456789012")
@@ -208,7 +293,7 @@ func TestContact_ToAPI(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, toAPIErr := tt.contact.ToAPI(dek, tt.partial)
+			result, toAPIErr := tt.contact.ToAPI(context.Background(), dek, tt.partial)
 			if tt.wantErr {
 				require.Error(t, toAPIErr)
 				return
@@ -255,7 +340,7 @@ func TestRoster_ToAPI(t *testing.T) {
 		Properties: data.JSONMap{"au_name": "Test User"},
 	}
 
-	result, err := roster.ToAPI(dek)
+	result, err := roster.ToAPI(context.Background(), dek)
 	require.NoError(t, err)
 	require.NotNil(t, result)
 	require.Equal(t, "roster-1", result.GetId())
@@ -334,3 +419,184 @@ func TestRelationshipTypeIDMap(t *testing.T) {
 		models.RelationshipTypeIDMap[profilev1.RelationshipType_BLACK_LISTED],
 	)
 }
+
+func TestPropertyEntry_SetValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     any
+		valueType string
+		stored    string
+		typed     any
+	}{
+		{"String", "Kampala", models.PropertyValueString, "Kampala", "Kampala"},
+		{"Numeric string", "25", models.PropertyValueString, "25", "25"},
+		{"Number", float64(25), models.PropertyValueNumber, "25", float64(25)},
+		{"Large integer", int64(9007199254740993), models.PropertyValueString, "9007199254740993", "9007199254740993"},
+		{"Largest exact integer", int64(1 << 53), models.PropertyValueNumber, "9007199254740992", float64(1 << 53)},
+		{
+			"Large float from a struct",
+			structpb.NewNumberValue(1e20).AsInterface(),
+			models.PropertyValueNumber,
+			"100000000000000000000",
+			float64(1e20),
+		},
+		{"Large unsigned integer", uint64(1<<53 + 1), models.PropertyValueString, "9007199254740993", "9007199254740993"},
+		{"Bool", true, models.PropertyValueBool, "true", true},
+		{"List", []any{"a", float64(1)}, models.PropertyValueList, `["a",1]`, []any{"a", float64(1)}},
+		{
+			"Object",
+			map[string]any{"a": float64(1)},
+			models.PropertyValueObject,
+			`{"a":1}`,
+			map[string]any{"a": float64(1)},
+		},
+		{"Null", nil, models.PropertyValueNull, "null", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &models.PropertyEntry{}
+			require.NoError(t, entry.SetValue(tt.value))
+			require.Equal(t, tt.valueType, entry.ValueType)
+			require.Equal(t, tt.stored, entry.Value)
+			require.Equal(t, tt.typed, entry.TypedValue())
+		})
+	}
+}
+
+func TestPropertyEntry_TypedValue_Untyped(t *testing.T) {
+	entry := &models.PropertyEntry{Value: "map[a:1]", ValueType: models.PropertyValueObject}
+	require.Equal(t, "map[a:1]", entry.TypedValue())
+
+	entry = &models.PropertyEntry{Value: "42"}
+	require.Equal(t, "42", entry.TypedValue())
+}
+
+func TestPropertySchema_Check(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema models.PropertySchema
+		value  any
+		valid  bool
+	}{
+		{"Untyped accepts anything", models.PropertySchema{}, []any{"a"}, true},
+		{"Missing optional", models.PropertySchema{}, nil, true},
+		{"Missing required", models.PropertySchema{Required: true}, nil, false},
+		{"Empty required", models.PropertySchema{Required: true}, "", false},
+		{"Type match", models.PropertySchema{ValueType: models.PropertyValueNumber}, float64(3), true},
+		{"Type mismatch", models.PropertySchema{ValueType: models.PropertyValueNumber}, "3", false},
+		{"Pattern match", models.PropertySchema{Pattern: `^[A-Z]{2}\d{6}$`}, "UG123456", true},
+		{"Pattern mismatch", models.PropertySchema{Pattern: `^[A-Z]{2}\d{6}$`}, "123", false},
+		{"Enum match", models.PropertySchema{Enum: []any{"en", "sw"}}, "sw", true},
+		{"Enum mismatch", models.PropertySchema{Enum: []any{"en", "sw"}}, "fr", false},
+		{"Numeric enum", models.PropertySchema{Enum: []any{float64(1), float64(2)}}, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.schema.Check(tt.value)
+			require.Equal(t, tt.valid, reason == "", reason)
+		})
+	}
+}
+
+func TestVerification_SealCode(t *testing.T) {
+	dek := &config.DEK{
+		Key:       []byte("0123456789abcdef0123456789abcdef"),
+		OldKey:    []byte("fedcba9876543210fedcba9876543210"),
+		LookUpKey: []byte("lookup-key"),
+	}
+
+	verification := &models.Verification{}
+	verification.ID = util.IDString()
+	require.NoError(t, verification.SealCode(dek, "123456"))
+	require.NotContains(t, verification.Code, "123456")
+	require.True(t, verification.CodeMatches(dek.LookUpKey, "123456"))
+	require.False(t, verification.CodeMatches(dek.LookUpKey, "123457"))
+
+	// The same code hashes differently for another verification
+	other := &models.Verification{}
+	other.ID = util.IDString()
+	require.NoError(t, other.SealCode(dek, "123456"))
+	require.NotEqual(t, verification.Code, other.Code)
+
+	code, err := verification.UnsealCode(dek)
+	require.NoError(t, err)
+	require.Equal(t, "123456", code)
+
+	// Codes sealed before a key rotation are still readable
+	rotated := &config.DEK{Key: []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), OldKey: dek.Key, LookUpKey: dek.LookUpKey}
+	code, err = verification.UnsealCode(rotated)
+	require.NoError(t, err)
+	require.Equal(t, "123456", code)
+
+	// Stored verifications carry no code to unseal
+	stored := &models.Verification{Code: verification.Code}
+	stored.ID = verification.ID
+	_, err = stored.UnsealCode(dek)
+	require.Error(t, err)
+}
+
+func TestVerification_LegacyPlaintextCode(t *testing.T) {
+	lookUpKey := []byte("lookup-key")
+
+	verification := &models.Verification{Code: "654321"}
+	verification.ID = util.IDString()
+	require.True(t, verification.CodeMatches(lookUpKey, "654321"))
+	require.False(t, verification.CodeMatches(lookUpKey, "65432"))
+
+	// Unsealing a verification queued with a plaintext code hashes it in place
+	code, err := verification.UnsealCode(&config.DEK{LookUpKey: lookUpKey})
+	require.NoError(t, err)
+	require.Equal(t, "654321", code)
+	require.NotEqual(t, "654321", verification.Code)
+	require.True(t, verification.CodeMatches(lookUpKey, "654321"))
+}
+
+func TestVerification_SignToken(t *testing.T) {
+	key := []byte("token-signing-key")
+
+	verification := &models.Verification{ContactID: util.IDString(), ExpiresAt: time.Now().Add(time.Hour)}
+	verification.ID = util.IDString()
+
+	token, err := verification.SignToken(key, "link-code")
+	require.NoError(t, err)
+
+	verificationID, code, err := models.ParseVerificationToken(key, token)
+	require.NoError(t, err)
+	require.Equal(t, verification.ID, verificationID)
+	require.Equal(t, "link-code", code)
+
+	_, _, err = models.ParseVerificationToken([]byte("another-key"), token)
+	require.Error(t, err)
+
+	verification.ExpiresAt = time.Now().Add(-time.Minute)
+	expired, err := verification.SignToken(key, "link-code")
+	require.NoError(t, err)
+	_, _, err = models.ParseVerificationToken(key, expired)
+	require.Error(t, err)
+
+	_, err = verification.SignToken(nil, "link-code")
+	require.ErrorIs(t, err, models.ErrNoTokenSigningKey)
+	_, _, err = models.ParseVerificationToken(nil, token)
+	require.ErrorIs(t, err, models.ErrNoTokenSigningKey)
+}
+
+func TestContact_MarkVerified(t *testing.T) {
+	verifiedAt := time.Now().Add(-2 * time.Hour)
+	validity := map[string]int{"MSISDN": 3600}
+
+	phone := &models.Contact{ContactType: "MSISDN"}
+	phone.MarkVerified("verification-1", verifiedAt, validity)
+	require.Equal(t, verifiedAt.Add(time.Hour), phone.VerificationExpiresAt)
+	require.False(t, phone.VerificationFresh(time.Now()))
+	require.True(t, phone.VerificationFresh(verifiedAt.Add(time.Minute)))
+
+	// Types without a validity stay verified
+	email := &models.Contact{ContactType: "EMAIL"}
+	email.MarkVerified("verification-2", verifiedAt, validity)
+	require.True(t, email.VerificationExpiresAt.IsZero())
+	require.True(t, email.VerificationFresh(time.Now()))
+
+	require.False(t, (&models.Contact{}).VerificationFresh(time.Now()))
+}
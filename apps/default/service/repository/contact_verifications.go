@@ -3,15 +3,24 @@ package repository
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/pitabwire/frame/v2/datastore"
 	"github.com/pitabwire/frame/v2/datastore/pool"
+	"github.com/pitabwire/frame/v2/security"
 	"github.com/pitabwire/frame/v2/workerpool"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
 	"github.com/antinvestor/service-profile/apps/default/service/models"
 )
 
+// uncountedAttemptStates are left out of throttling counts: successes, and
+// blocked attempts so retrying during a lockout doesn't prolong it.
+//
+//nolint:gochecknoglobals // read-only query argument
+var uncountedAttemptStates = []string{models.VerificationAttemptSuccess, models.VerificationAttemptBlocked}
+
 type verificationRepository struct {
 	datastore.BaseRepository[*models.Verification]
 }
@@ -73,3 +82,82 @@ func (vr *verificationRepository) SaveAttempt(
 	}
 	return nil
 }
+
+func (vr *verificationRepository) UpdateAttemptState(ctx context.Context, attemptID, state string) error {
+	return vr.Pool().DB(ctx, false).
+		Model(&models.VerificationAttempt{}).
+		Where("id = ?", attemptID).
+		Update("state", state).Error
+}
+
+func (vr *verificationRepository) CountFailedAttemptsByContact(
+	ctx context.Context,
+	contactID string,
+	since time.Time,
+) (int, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var count int64
+	err := vr.Pool().DB(unscopedCtx, true).
+		Model(&models.VerificationAttempt{}).
+		Joins("JOIN verifications v ON v.id = verification_attempts.verification_id").
+		Where("v.contact_id = ? AND verification_attempts.state NOT IN ? AND verification_attempts.created_at >= ?",
+			contactID, uncountedAttemptStates, since).
+		Count(&count).Error
+	return int(count), err
+}
+
+func (vr *verificationRepository) CountFailedAttemptsBySource(
+	ctx context.Context,
+	deviceID, ipAddress string,
+	since time.Time,
+) (int, error) {
+	if deviceID == "" && ipAddress == "" {
+		return 0, nil
+	}
+
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	query := vr.Pool().DB(unscopedCtx, true).
+		Model(&models.VerificationAttempt{}).
+		Where("state NOT IN ? AND created_at >= ?", uncountedAttemptStates, since)
+	switch {
+	case deviceID == "":
+		query = query.Where("ip_address = ?", ipAddress)
+	case ipAddress == "":
+		query = query.Where("device_id = ?", deviceID)
+	default:
+		query = query.Where("(device_id = ? OR ip_address = ?)", deviceID, ipAddress)
+	}
+
+	var count int64
+	err := query.Count(&count).Error
+	return int(count), err
+}
+
+func (vr *verificationRepository) IncrementAttempts(ctx context.Context, verificationID string) (int, error) {
+	var attempts []int
+	err := vr.Pool().DB(ctx, false).
+		Raw("UPDATE verifications SET attempts = attempts + 1 WHERE id = ? RETURNING attempts", verificationID).
+		Scan(&attempts).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(attempts) == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return attempts[0], nil
+}
+
+func (vr *verificationRepository) MarkVerified(
+	ctx context.Context,
+	verificationID string,
+	at time.Time,
+) (bool, error) {
+	// Unset timestamps are stored as the zero time, or NULL on rows older
+	// than the column.
+	result := vr.Pool().DB(ctx, false).
+		Model(&models.Verification{}).
+		Where("id = ? AND (verified_at IS NULL OR verified_at <= ?) AND (locked_at IS NULL OR locked_at <= ?)",
+			verificationID, time.Time{}, time.Time{}).
+		Update("verified_at", at)
+	return result.RowsAffected == 1, result.Error
+}
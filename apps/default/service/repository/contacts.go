@@ -3,12 +3,14 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/pitabwire/frame/v2/datastore"
 	"github.com/pitabwire/frame/v2/datastore/pool"
 	"github.com/pitabwire/frame/v2/security"
 	"github.com/pitabwire/frame/v2/workerpool"
+	"gorm.io/gorm"
 
 	"github.com/antinvestor/service-profile/apps/default/service/models"
 )
@@ -62,6 +64,176 @@ func (cr *contactRepository) GetByIDFromPrimary(ctx context.Context, id string)
 	return contact, err
 }
 
+func (cr *contactRepository) ListVerified(
+	ctx context.Context,
+	afterID string,
+	limit int,
+) ([]*models.Contact, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var contactList []*models.Contact
+	err := cr.Pool().DB(unscopedCtx, true).
+		Where("id > ? AND profile_id <> '' AND verification_id <> ''", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&contactList).Error
+	return contactList, err
+}
+
+func (cr *contactRepository) ApplyVerificationValidity(
+	ctx context.Context,
+	contactType string,
+	validity time.Duration,
+) (int64, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	// Unset expiries are the zero time, or NULL on rows older than the column
+	result := cr.Pool().DB(unscopedCtx, false).
+		Model(&models.Contact{}).
+		Where(`contact_type = ? AND verification_id <> '' AND verified_at > ?
+			AND coalesce(verification_expires_at, ?) <= ?`,
+			contactType, time.Time{}, time.Time{}, time.Time{}).
+		Update("verification_expires_at",
+			gorm.Expr("verified_at + ? * interval '1 second'", int64(validity/time.Second)))
+	return result.RowsAffected, result.Error
+}
+
+func (cr *contactRepository) ListStaleVerifications(
+	ctx context.Context,
+	at time.Time,
+	limit int,
+) ([]*models.Contact, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var contactList []*models.Contact
+	// Read from the primary, a lagging replica would list claimed contacts again
+	err := cr.Pool().DB(unscopedCtx, false).
+		Where("verification_id <> '' AND verification_expires_at > ? AND verification_expires_at <= ?",
+			time.Time{}, at).
+		Order("verification_expires_at").
+		Limit(limit).
+		Find(&contactList).Error
+	return contactList, err
+}
+
+func (cr *contactRepository) ClaimStaleVerification(
+	ctx context.Context,
+	contactID, verificationID string,
+	at time.Time,
+) (bool, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	result := cr.Pool().DB(unscopedCtx, false).
+		Model(&models.Contact{}).
+		Where("id = ? AND verification_id = ? AND verification_expires_at > ? AND verification_expires_at <= ?",
+			contactID, verificationID, time.Time{}, at).
+		Update("verification_id", "")
+	return result.RowsAffected == 1, result.Error
+}
+
+func (cr *contactRepository) ReleaseStaleVerification(
+	ctx context.Context,
+	contactID, verificationID string,
+) error {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	return cr.Pool().DB(unscopedCtx, false).
+		Model(&models.Contact{}).
+		Where("id = ? AND verification_id = ''", contactID).
+		Update("verification_id", verificationID).Error
+}
+
+func (cr *contactRepository) ListIDsOnKeys(
+	ctx context.Context,
+	keyIDs []string,
+	afterID string,
+	limit int,
+) ([]string, error) {
+	if len(keyIDs) == 0 {
+		return nil, nil
+	}
+
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var contactIDs []string
+	err := cr.Pool().DB(unscopedCtx, true).
+		Model(&models.Contact{}).
+		Where("id > ? AND encryption_key_id IN ?", afterID, keyIDs).
+		Order("id").
+		Limit(limit).
+		Pluck("id", &contactIDs).Error
+	return contactIDs, err
+}
+
+func (cr *contactRepository) CountByKeyID(ctx context.Context) (map[string]int64, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var rows []struct {
+		EncryptionKeyID string
+		Total           int64
+	}
+	err := cr.Pool().DB(unscopedCtx, true).
+		Model(&models.Contact{}).
+		Select("encryption_key_id, count(*) AS total").
+		Group("encryption_key_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.EncryptionKeyID] = row.Total
+	}
+	return counts, nil
+}
+
+func (cr *contactRepository) ListNotOnLookupKey(
+	ctx context.Context,
+	lookUpKeyID, afterID string,
+	limit int,
+) ([]*models.Contact, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var contactList []*models.Contact
+	// Contacts older than the column have a NULL key id
+	err := cr.Pool().DB(unscopedCtx, true).
+		Where("id > ? AND look_up_token IS NOT NULL AND coalesce(look_up_key_id, '') <> ?", afterID, lookUpKeyID).
+		Order("id").
+		Limit(limit).
+		Find(&contactList).Error
+	return contactList, err
+}
+
+func (cr *contactRepository) CountNotOnLookupKey(ctx context.Context, lookUpKeyID string) (int64, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var count int64
+	err := cr.Pool().DB(unscopedCtx, true).
+		Model(&models.Contact{}).
+		Where("look_up_token IS NOT NULL AND coalesce(look_up_key_id, '') <> ?", lookUpKeyID).
+		Count(&count).Error
+	return count, err
+}
+
+func (cr *contactRepository) CountTenantOnKeys(
+	ctx context.Context,
+	tenantID string,
+	keyIDs []string,
+) (int64, error) {
+	if len(keyIDs) == 0 {
+		return 0, nil
+	}
+
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var count int64
+	err := cr.Pool().DB(unscopedCtx, true).
+		Model(&models.Contact{}).
+		Where("tenant_id = ? AND encryption_key_id IN ?", tenantID, keyIDs).
+		Count(&count).Error
+	return count, err
+}
+
+func (cr *contactRepository) RemoveLookupTokens(ctx context.Context, tenantID string) (int64, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	result := cr.Pool().DB(unscopedCtx, false).
+		Model(&models.Contact{}).
+		Where("tenant_id = ? AND look_up_token IS NOT NULL", tenantID).
+		Updates(map[string]any{"look_up_token": nil, "look_up_key_id": ""})
+	return result.RowsAffected, result.Error
+}
+
 func (cr *contactRepository) GetByLookupToken(
 	ctx context.Context,
 	lookupTokenList ...[]byte,
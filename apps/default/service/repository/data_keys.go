@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/datastore/pool"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/frame/v2/workerpool"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+type dataKeyRepository struct {
+	datastore.BaseRepository[*models.DataKey]
+}
+
+func NewDataKeyRepository(ctx context.Context, dbPool pool.Pool, workMan workerpool.Manager) DataKeyRepository {
+	return &dataKeyRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.DataKey](
+			ctx, dbPool, workMan, func() *models.DataKey { return &models.DataKey{} },
+		),
+	}
+}
+
+func (r *dataKeyRepository) GetByKeyID(ctx context.Context, keyID string) (*models.DataKey, error) {
+	// Contacts are read across tenants, so their keys must be too
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	dataKey := &models.DataKey{}
+	err := r.Pool().DB(unscopedCtx, true).First(dataKey, "id = ?", keyID).Error
+	return dataKey, err
+}
+
+func (r *dataKeyRepository) GetLatest(ctx context.Context, tenantID string) (*models.DataKey, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	dataKey := &models.DataKey{}
+	// Read the primary so a key created moments ago is not missed and duplicated
+	err := r.Pool().DB(unscopedCtx, false).
+		Where("tenant_id = ? AND (shredded_at IS NULL OR shredded_at = ?)", tenantID, time.Time{}).
+		Order("id DESC").
+		First(dataKey).Error
+	return dataKey, err
+}
+
+func (r *dataKeyRepository) Shred(ctx context.Context, tenantID string, at time.Time) (int64, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	result := r.Pool().DB(unscopedCtx, false).
+		Model(&models.DataKey{}).
+		Where("tenant_id = ? AND (shredded_at IS NULL OR shredded_at = ?)", tenantID, time.Time{}).
+		Updates(map[string]any{"wrapped_key": []byte{}, "shredded_at": at})
+	return result.RowsAffected, result.Error
+}
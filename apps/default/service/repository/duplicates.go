@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/datastore/pool"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/frame/v2/workerpool"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+const upsertBatchSize = 500
+
+type duplicateCandidateRepository struct {
+	datastore.BaseRepository[*models.DuplicateCandidate]
+}
+
+func NewDuplicateCandidateRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) DuplicateCandidateRepository {
+	return &duplicateCandidateRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.DuplicateCandidate](
+			ctx, dbPool, workMan, func() *models.DuplicateCandidate { return &models.DuplicateCandidate{} },
+		),
+	}
+}
+
+// PairsSharingAddress returns pairs of live profiles of one tenant and
+// partition linked to the same address.
+func (r *duplicateCandidateRepository) PairsSharingAddress(ctx context.Context) ([]*models.ProfilePair, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var pairs []*models.ProfilePair
+	err := r.Pool().DB(unscopedCtx, true).
+		Raw(`SELECT DISTINCT pa.tenant_id, pa.partition_id, a.profile_id, b.profile_id AS other_profile_id
+			 FROM profile_addresses a
+			 JOIN profile_addresses b ON b.address_id = a.address_id AND b.profile_id > a.profile_id
+			 JOIN profiles pa ON pa.id = a.profile_id AND pa.deleted_at IS NULL
+			 JOIN profiles pb ON pb.id = b.profile_id AND pb.deleted_at IS NULL
+				AND pb.tenant_id = pa.tenant_id AND pb.partition_id = pa.partition_id
+			 WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL`).
+		Scan(&pairs).Error
+	return pairs, err
+}
+
+// PairsSharingName returns pairs of live profiles of one tenant and
+// partition whose propertyKey value is equal once case and whitespace are
+// normalised. Names shared by more than
+// maxGroupSize profiles are too common to be a signal and are skipped.
+func (r *duplicateCandidateRepository) PairsSharingName(
+	ctx context.Context,
+	propertyKey string,
+	maxGroupSize int,
+) ([]*models.ProfilePair, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var pairs []*models.ProfilePair
+	err := r.Pool().DB(unscopedCtx, true).
+		Raw(`WITH names AS (
+				SELECT id, tenant_id, partition_id,
+					lower(regexp_replace(trim(properties->>?), '\s+', ' ', 'g')) AS name
+				FROM profiles
+				WHERE deleted_at IS NULL AND coalesce(trim(properties->>?), '') <> ''
+			 ), shared AS (
+				SELECT tenant_id, partition_id, name FROM names
+				GROUP BY tenant_id, partition_id, name HAVING count(*) BETWEEN 2 AND ?
+			 )
+			 SELECT a.tenant_id, a.partition_id, a.id AS profile_id, b.id AS other_profile_id
+			 FROM names a
+			 JOIN names b ON b.name = a.name AND b.id > a.id
+				AND b.tenant_id = a.tenant_id AND b.partition_id = a.partition_id
+			 WHERE (a.tenant_id, a.partition_id, a.name) IN (SELECT tenant_id, partition_id, name FROM shared)`,
+			propertyKey, propertyKey, maxGroupSize).
+		Scan(&pairs).Error
+	return pairs, err
+}
+
+// Upsert stores candidates, refreshing the score and reasons of pairs
+// already known.
+func (r *duplicateCandidateRepository) Upsert(ctx context.Context, candidates []*models.DuplicateCandidate) error {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	return r.Pool().DB(unscopedCtx, false).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "profile_id"}, {Name: "other_profile_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"score", "reasons", "modified_at"}),
+		}).
+		CreateInBatches(&candidates, upsertBatchSize).Error
+}
+
+// DeleteStale removes candidates the latest detection run did not refresh.
+func (r *duplicateCandidateRepository) DeleteStale(ctx context.Context, before time.Time) error {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	return r.Pool().DB(unscopedCtx, false).
+		Unscoped().
+		Where("modified_at < ?", before).
+		Delete(&models.DuplicateCandidate{}).Error
+}
+
+// List returns the caller's tenant's candidates scoring at least minScore,
+// highest first, whose profiles both still exist.
+func (r *duplicateCandidateRepository) List(
+	ctx context.Context,
+	minScore float64,
+	count int,
+) ([]*models.DuplicateCandidate, error) {
+	var candidates []*models.DuplicateCandidate
+	err := r.Pool().DB(ctx, true).
+		Where(`score >= ?
+			AND EXISTS (SELECT 1 FROM profiles p WHERE p.id = duplicate_candidates.profile_id AND p.deleted_at IS NULL)
+			AND EXISTS (SELECT 1 FROM profiles p WHERE p.id = duplicate_candidates.other_profile_id
+				AND p.deleted_at IS NULL)`, minScore).
+		Order("score DESC, id").
+		Limit(count).
+		Find(&candidates).Error
+	return candidates, err
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
 	"github.com/pitabwire/frame/v2/data"
@@ -23,6 +24,34 @@ type ProfileRepository interface {
 		ctx context.Context,
 		profileType profilev1.ProfileType,
 	) (*models.ProfileType, error)
+
+	// Merge folds merging into target, re-parenting every dependent record,
+	// appending the replayed property entries and deleting merging.
+	Merge(
+		ctx context.Context,
+		target, merging *models.Profile,
+		replayEntries []*models.PropertyEntry,
+	) error
+	// PreviewMerge reports the rows Merge would move or drop without
+	// writing anything.
+	PreviewMerge(ctx context.Context, targetID, mergedID string) (*models.MergePreview, error)
+
+	GetLatestMergeJournal(ctx context.Context, mergedID string) (*models.MergeJournal, error)
+	// Unmerge reverses the merge recorded in journal, failing with
+	// ErrMergeTargetGone if its target has since been merged or erased.
+	Unmerge(ctx context.Context, journal *models.MergeJournal) error
+
+	// Erase permanently deletes the profile erasure.ProfileID and every row
+	// held about its subject, then records erasure.
+	Erase(ctx context.Context, erasure *models.ProfileErasure) error
+	// GetErasure returns the erasure of profileID, or a not found error.
+	GetErasure(ctx context.Context, profileID string) (*models.ProfileErasure, error)
+	// CollectSubjectData reads everything held about profileID but its
+	// property history.
+	CollectSubjectData(ctx context.Context, profileID string) (*models.SubjectData, error)
+	// GetRosterVisibility returns the roster visibility of each existing
+	// profile of profileIDs, RosterVisibilityMatched unless set otherwise.
+	GetRosterVisibility(ctx context.Context, profileIDs []string) (map[string]string, error)
 }
 
 type ContactRepository interface {
@@ -34,6 +63,42 @@ type ContactRepository interface {
 	// GetByIDFromPrimary reads from the primary connection for
 	// read-your-writes (e.g. linking a just-created contact to a profile).
 	GetByIDFromPrimary(ctx context.Context, id string) (*models.Contact, error)
+
+	// ListVerified pages through verified contacts linked to a profile,
+	// ordered by id and starting after afterID.
+	ListVerified(ctx context.Context, afterID string, limit int) ([]*models.Contact, error)
+
+	// ApplyVerificationValidity gives verified contacts of contactType that
+	// have no expiry one validity after they were verified.
+	ApplyVerificationValidity(ctx context.Context, contactType string, validity time.Duration) (int64, error)
+	// ListStaleVerifications returns up to limit verified contacts whose
+	// verification expired by at, oldest expiry first.
+	ListStaleVerifications(ctx context.Context, at time.Time, limit int) ([]*models.Contact, error)
+	// ClaimStaleVerification clears the verification of a contact if it is
+	// still verificationID and expired by at, reporting whether it did.
+	ClaimStaleVerification(ctx context.Context, contactID, verificationID string, at time.Time) (bool, error)
+	// ReleaseStaleVerification restores a verification cleared by
+	// ClaimStaleVerification, unless the contact was verified again since.
+	ReleaseStaleVerification(ctx context.Context, contactID, verificationID string) error
+
+	// ListIDsOnKeys pages through the ids of contacts encrypted with any of
+	// keyIDs, ordered by id and starting after afterID.
+	ListIDsOnKeys(ctx context.Context, keyIDs []string, afterID string, limit int) ([]string, error)
+	// CountByKeyID counts contacts per encryption key id.
+	CountByKeyID(ctx context.Context) (map[string]int64, error)
+
+	// ListNotOnLookupKey pages through contacts whose lookup token was not
+	// computed with lookUpKeyID, ordered by id and starting after afterID.
+	ListNotOnLookupKey(ctx context.Context, lookUpKeyID, afterID string, limit int) ([]*models.Contact, error)
+	// CountNotOnLookupKey counts contacts whose lookup token was not
+	// computed with lookUpKeyID.
+	CountNotOnLookupKey(ctx context.Context, lookUpKeyID string) (int64, error)
+
+	// CountTenantOnKeys counts the contacts of tenantID encrypted with any of keyIDs.
+	CountTenantOnKeys(ctx context.Context, tenantID string, keyIDs []string) (int64, error)
+	// RemoveLookupTokens clears the lookup tokens of the contacts of
+	// tenantID, so their details no longer resolve to them.
+	RemoveLookupTokens(ctx context.Context, tenantID string) (int64, error)
 }
 
 type VerificationRepository interface {
@@ -41,6 +106,20 @@ type VerificationRepository interface {
 
 	GetAttempts(ctx context.Context, verificationID string) ([]*models.VerificationAttempt, error)
 	SaveAttempt(ctx context.Context, verificationAttempt *models.VerificationAttempt) error
+	// UpdateAttemptState sets the state of a recorded attempt.
+	UpdateAttemptState(ctx context.Context, attemptID, state string) error
+	// CountFailedAttemptsByContact counts failed attempts, not blocked ones,
+	// made since since against any verification of the contact.
+	CountFailedAttemptsByContact(ctx context.Context, contactID string, since time.Time) (int, error)
+	// CountFailedAttemptsBySource counts failed attempts, not blocked ones,
+	// made since since from the device or IP address, across tenants.
+	CountFailedAttemptsBySource(ctx context.Context, deviceID, ipAddress string, since time.Time) (int, error)
+	// IncrementAttempts adds one to the verification's attempts and returns
+	// the new count.
+	IncrementAttempts(ctx context.Context, verificationID string) (int, error)
+	// MarkVerified sets verified_at on an unverified, unlocked verification,
+	// reporting false if it was already verified or locked.
+	MarkVerified(ctx context.Context, verificationID string, at time.Time) (bool, error)
 }
 
 type RosterRepository interface {
@@ -49,6 +128,9 @@ type RosterRepository interface {
 		ctx context.Context,
 		profileID, contactID string,
 	) (*models.Roster, error)
+	// GetByContactID returns the roster entries of every profile listing
+	// contactID.
+	GetByContactID(ctx context.Context, contactID string) ([]*models.Roster, error)
 	GetByContactIDsAndProfileID(
 		ctx context.Context,
 		contactIDs []string,
@@ -63,6 +145,17 @@ type RosterRepository interface {
 		contactIDs []string,
 		profileID, name string,
 	) ([]*models.Roster, error)
+	// RestoreByContactIDsAndProfileIDAndName brings back the removed entries
+	// of the roster name listing contactIDs.
+	RestoreByContactIDsAndProfileIDAndName(
+		ctx context.Context,
+		contactIDs []string,
+		profileID, name string,
+	) error
+	// GetChangedSince returns the entries of the roster name modified,
+	// removed or whose contact was verified after since, removed ones
+	// included. A zero since returns the entries not removed.
+	GetChangedSince(ctx context.Context, profileID, name string, since time.Time) ([]*models.Roster, error)
 	Search(
 		ctx context.Context,
 		query *data.SearchQuery,
@@ -95,10 +188,77 @@ type PropertyEntryRepository interface {
 		ctx context.Context,
 		profileID, partitionID string,
 	) ([]*models.PropertyEntry, error)
+	GlobalByProfileAsOf(ctx context.Context, profileID string, asOf time.Time) ([]*models.PropertyEntry, error)
+	ScopedByProfileAndPartitionAsOf(
+		ctx context.Context,
+		profileID, partitionID string,
+		asOf time.Time,
+	) ([]*models.PropertyEntry, error)
 	HistoryByKey(
 		ctx context.Context,
 		profileID, key, callerTenantID string,
 	) ([]*models.PropertyEntry, error)
+	// HistoryByProfile returns the history of every key, global entries and
+	// the scoped ones of callerTenantID, newest first.
+	HistoryByProfile(ctx context.Context, profileID, callerTenantID string) ([]*models.PropertyEntry, error)
+
+	CompactionCandidates(
+		ctx context.Context,
+		horizon, unmergeableBefore time.Time,
+		minEntries, limit int,
+	) ([]*models.PropertySnapshot, error)
+	Compact(ctx context.Context, scope *models.PropertySnapshot, horizon time.Time) (int, error)
+}
+
+// JobLocker keeps background jobs to one replica at a time.
+type JobLocker interface {
+	// TryLock takes the lock named name unless another replica holds it,
+	// reporting whether it did. A taken lock is held until release is
+	// called.
+	TryLock(ctx context.Context, name string) (release func(), locked bool, err error)
+}
+
+type DuplicateCandidateRepository interface {
+	datastore.BaseRepository[*models.DuplicateCandidate]
+	PairsSharingAddress(ctx context.Context) ([]*models.ProfilePair, error)
+	PairsSharingName(ctx context.Context, propertyKey string, maxGroupSize int) ([]*models.ProfilePair, error)
+	Upsert(ctx context.Context, candidates []*models.DuplicateCandidate) error
+	DeleteStale(ctx context.Context, before time.Time) error
+	List(ctx context.Context, minScore float64, count int) ([]*models.DuplicateCandidate, error)
+}
+
+type KeyRotationSweepRepository interface {
+	datastore.BaseRepository[*models.KeyRotationSweep]
+	// GetByTargetKeyID returns the sweep onto targetKeyID, or a
+	// not found error if none has started.
+	GetByTargetKeyID(ctx context.Context, targetKeyID string) (*models.KeyRotationSweep, error)
+}
+
+type DataKeyRepository interface {
+	datastore.BaseRepository[*models.DataKey]
+	// GetByKeyID returns the data key with keyID, whatever its tenant.
+	GetByKeyID(ctx context.Context, keyID string) (*models.DataKey, error)
+	// GetLatest returns the newest data key of tenantID that is not shredded.
+	GetLatest(ctx context.Context, tenantID string) (*models.DataKey, error)
+	// Shred destroys the wrapped copies of every data key of tenantID,
+	// marking them shredded at.
+	Shred(ctx context.Context, tenantID string, at time.Time) (int64, error)
+}
+
+type ProfileExportRepository interface {
+	datastore.BaseRepository[*models.ProfileExport]
+	// ListClaimable returns up to limit exports waiting to run, and running
+	// ones last touched before staleBefore, oldest first.
+	ListClaimable(ctx context.Context, staleBefore time.Time, limit int) ([]*models.ProfileExport, error)
+	// Claim marks a claimable export running, reporting false if another
+	// instance claimed it first.
+	Claim(ctx context.Context, exportID string, staleBefore time.Time) (bool, error)
+}
+
+type PropertySchemaRepository interface {
+	datastore.BaseRepository[*models.PropertySchema]
+	List(ctx context.Context) ([]*models.PropertySchema, error)
+	GetByKey(ctx context.Context, key string) (*models.PropertySchema, error)
 }
 
 type RelationshipRepository interface {
@@ -107,6 +267,38 @@ type RelationshipRepository interface {
 		peerName string, peerID string,
 		inverseRelation bool, relatedChildrenIDs []string,
 		lastRelationshipID string, count int,
+		filter models.RelationshipFilter,
+	) ([]*models.Relationship, error)
+	// ListLapsed returns up to limit relationships whose ValidUntil passed
+	// by now and whose lapse has not been announced, earliest lapsed first.
+	ListLapsed(ctx context.Context, now time.Time, limit int) ([]*models.Relationship, error)
+	// ClaimLapsed marks the lapse of a relationship announced at at, unless
+	// it already was, reporting whether it did.
+	ClaimLapsed(ctx context.Context, relationshipID string, at time.Time) (bool, error)
+	// ReleaseLapsed undoes a ClaimLapsed made at at, to announce it again.
+	ReleaseLapsed(ctx context.Context, relationshipID string, at time.Time) error
+
+	// Traverse returns the relationships reachable from an entry within
+	// maxDepth hops, towards its descendants or, inverted, its ancestors,
+	// keeping only those of relationshipTypeIDs when any are given. Each is
+	// returned once, at the fewest hops it is reached in; relationships
+	// leading back to the entry are not. Walks returning more than
+	// maxRelationshipWalkRows rows fail with ErrRelationshipWalkTooLarge.
+	Traverse(
+		ctx context.Context,
+		peerName, peerID string,
+		inverseRelation bool,
+		maxDepth int,
+		relationshipTypeIDs ...string,
+	) ([]*models.RelationshipEdge, error)
+	// ShortestPath returns the fewest relationships linking two entries,
+	// followed either way, in order from the first entry. It returns none
+	// if they are not linked within maxDepth hops, and fails with
+	// ErrRelationshipWalkTooLarge like Traverse.
+	ShortestPath(
+		ctx context.Context,
+		fromName, fromID, toName, toID string,
+		maxDepth int,
 	) ([]*models.Relationship, error)
 
 	RelationshipType(
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/v2/datastore/pool"
+	"github.com/pitabwire/frame/v2/security"
+)
+
+// jobLockNamespace keys this service's background job locks apart from
+// other advisory locks taken on the same database.
+const jobLockNamespace = 20261017
+
+// jobUnlockTimeout bounds releasing a lock after its job's context ended.
+const jobUnlockTimeout = 5 * time.Second
+
+type jobLocker struct {
+	dbPool pool.Pool
+}
+
+func NewJobLocker(dbPool pool.Pool) JobLocker {
+	return &jobLocker{dbPool: dbPool}
+}
+
+// TryLock takes a session level Postgres advisory lock keyed by name on a
+// connection pinned until release, so the lock lives exactly as long as
+// the run holding it, or the session if the replica dies mid-run.
+func (jl *jobLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	sqlDB, err := jl.dbPool.DB(unscopedCtx, false).DB()
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := sqlDB.Conn(unscopedCtx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(unscopedCtx,
+		"SELECT pg_try_advisory_lock($1, hashtext($2))", jobLockNamespace, name).Scan(&locked)
+	if err != nil || !locked {
+		_ = conn.Close()
+		return nil, false, err
+	}
+
+	return func() {
+		unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(unscopedCtx), jobUnlockTimeout)
+		defer cancel()
+		_, _ = conn.ExecContext(unlockCtx, "SELECT pg_advisory_unlock($1, hashtext($2))", jobLockNamespace, name)
+		_ = conn.Close()
+	}, true, nil
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/datastore/pool"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/frame/v2/workerpool"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+type keyRotationSweepRepository struct {
+	datastore.BaseRepository[*models.KeyRotationSweep]
+}
+
+func NewKeyRotationSweepRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) KeyRotationSweepRepository {
+	return &keyRotationSweepRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.KeyRotationSweep](
+			ctx, dbPool, workMan, func() *models.KeyRotationSweep { return &models.KeyRotationSweep{} },
+		),
+	}
+}
+
+func (r *keyRotationSweepRepository) GetByTargetKeyID(
+	ctx context.Context,
+	targetKeyID string,
+) (*models.KeyRotationSweep, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	sweep := &models.KeyRotationSweep{}
+	err := r.Pool().DB(unscopedCtx, false).First(sweep, "target_key_id = ?", targetKeyID).Error
+	return sweep, err
+}
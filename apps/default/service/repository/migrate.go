@@ -15,5 +15,8 @@ func Migrate(ctx context.Context, dbManager datastore.Manager, migrationPath str
 		&models.ProfileType{}, &models.Profile{}, &models.PropertyEntry{}, &models.Contact{}, &models.Country{},
 		&models.Address{}, &models.ProfileAddress{}, &models.Verification{}, &models.VerificationAttempt{},
 		&models.RelationshipType{}, &models.Relationship{}, &models.Roster{},
+		&models.MergeJournal{}, &models.MergeJournalEntry{}, &models.DuplicateCandidate{},
+		&models.PropertySchema{}, &models.PropertySnapshot{}, &models.KeyRotationSweep{},
+		&models.DataKey{}, &models.ProfileErasure{}, &models.ProfileExport{},
 	)
 }
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pitabwire/frame/v2/security"
+	"gorm.io/gorm"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+// Tables an erasure removes rows from, besides those a merge touches.
+const (
+	profilesTable             = "profiles"
+	verificationAttemptsTable = "verification_attempts"
+	addressesTable            = "addresses"
+	propertySnapshotsTable    = "property_snapshots"
+	duplicateCandidatesTable  = "duplicate_candidates"
+	mergeJournalsTable        = "merge_journals"
+	mergeJournalEntriesTable  = "merge_journal_entries"
+	profileExportsTable       = "profile_exports"
+)
+
+// erasureScope is what an erasure removes: the erased profile, the profiles
+// merged into it and the contacts and verifications of them all.
+type erasureScope struct {
+	profileIDs      []string
+	contactIDs      []string
+	verificationIDs []string
+	journalIDs      []string
+}
+
+// Erase permanently deletes, in a single transaction, the profile
+// erasure.ProfileID and everything held about its subject: property ledger
+// and snapshots, contacts with their lookup tokens, verifications and their
+// attempts, rosters it owns or that list its contacts, address links and
+// addresses no one else uses, relationships, duplicate candidates, merge
+// journals and data exports. Profiles merged into it, kept soft-deleted for unmerging, are
+// erased with it. Rows are hard deleted, soft-deleted ones included, and the
+// counts recorded on erasure, which is then created.
+func (pr *profileRepository) Erase(ctx context.Context, erasure *models.ProfileErasure) error {
+	// A person's rows are written under whichever tenant created them
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+
+	return pr.Pool().DB(unscopedCtx, false).Transaction(func(tx *gorm.DB) error {
+		scope, err := planErasure(tx, erasure.ProfileID)
+		if err != nil {
+			return err
+		}
+
+		erased := map[string]int64{}
+		remove := func(table string, query *gorm.DB, model any) error {
+			result := query.Delete(model)
+			erased[table] += result.RowsAffected
+			return result.Error
+		}
+
+		steps := []struct {
+			table string
+			query *gorm.DB
+			model any
+		}{
+			{verificationAttemptsTable, tx.Unscoped().Where("verification_id IN ?", scope.verificationIDs),
+				&models.VerificationAttempt{}},
+			{verificationsTable, tx.Unscoped().Where("id IN ?", scope.verificationIDs),
+				&models.Verification{}},
+			{rostersTable, tx.Unscoped().Where("profile_id IN ? OR contact_id IN ?",
+				scope.profileIDs, scope.contactIDs), &models.Roster{}},
+			{contactsTable, tx.Unscoped().Where("id IN ?", scope.contactIDs),
+				&models.Contact{}},
+			{relationshipsTable, tx.Unscoped().Where(
+				"(parent_object = ? AND parent_object_id IN ?) OR (child_object = ? AND child_object_id IN ?)",
+				profileObjectName, scope.profileIDs, profileObjectName, scope.profileIDs),
+				&models.Relationship{}},
+			{propertyEntriesTable, tx.Unscoped().Where("profile_id IN ?", scope.profileIDs),
+				&models.PropertyEntry{}},
+			{propertySnapshotsTable, tx.Unscoped().Where("profile_id IN ?", scope.profileIDs),
+				&models.PropertySnapshot{}},
+			{duplicateCandidatesTable, tx.Unscoped().Where("profile_id IN ? OR other_profile_id IN ?",
+				scope.profileIDs, scope.profileIDs), &models.DuplicateCandidate{}},
+			{mergeJournalEntriesTable, tx.Unscoped().Where("journal_id IN ?", scope.journalIDs),
+				&models.MergeJournalEntry{}},
+			{mergeJournalsTable, tx.Unscoped().Where("id IN ?", scope.journalIDs),
+				&models.MergeJournal{}},
+			{profileExportsTable, tx.Unscoped().Where("profile_id IN ?", scope.profileIDs),
+				&models.ProfileExport{}},
+		}
+		for _, step := range steps {
+			if err = remove(step.table, step.query, step.model); err != nil {
+				return err
+			}
+		}
+
+		if err = eraseAddresses(tx, scope.profileIDs, erased); err != nil {
+			return err
+		}
+
+		err = remove(profilesTable, tx.Unscoped().Where("id IN ?", scope.profileIDs), &models.Profile{})
+		if err != nil {
+			return err
+		}
+
+		erasure.Erased = make(map[string]any, len(erased))
+		for table, count := range erased {
+			erasure.Erased[table] = count
+		}
+		return tx.Create(erasure).Error
+	})
+}
+
+// planErasure collects the ids of the rows held about profileID before
+// anything is deleted.
+func planErasure(tx *gorm.DB, profileID string) (*erasureScope, error) {
+	scope := &erasureScope{profileIDs: []string{profileID}}
+
+	// Profiles merged into this one and not unmerged are the same person
+	var mergedIDs []string
+	err := tx.Model(&models.MergeJournal{}).
+		Where("target_id = ? AND unmerged_at IS NULL", profileID).
+		Pluck("merged_id", &mergedIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	scope.profileIDs = append(scope.profileIDs, mergedIDs...)
+
+	err = tx.Unscoped().Model(&models.MergeJournal{}).
+		Where("target_id IN ? OR merged_id IN ?", scope.profileIDs, scope.profileIDs).
+		Pluck("id", &scope.journalIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Unscoped().Model(&models.Contact{}).
+		Where("profile_id IN ?", scope.profileIDs).
+		Pluck("id", &scope.contactIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Unscoped().Model(&models.Verification{}).
+		Where("profile_id IN ? OR contact_id IN ?", scope.profileIDs, scope.contactIDs).
+		Pluck("id", &scope.verificationIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
+
+// eraseAddresses removes the address links of profileIDs, then the linked
+// addresses that no other profile links to and no address nests under.
+func eraseAddresses(tx *gorm.DB, profileIDs []string, erased map[string]int64) error {
+	var addressIDs []string
+	err := tx.Unscoped().Model(&models.ProfileAddress{}).
+		Where("profile_id IN ?", profileIDs).
+		Pluck("address_id", &addressIDs).Error
+	if err != nil {
+		return err
+	}
+
+	result := tx.Unscoped().Where("profile_id IN ?", profileIDs).Delete(&models.ProfileAddress{})
+	if result.Error != nil {
+		return result.Error
+	}
+	erased[profileAddressesTable] = result.RowsAffected
+
+	result = tx.Unscoped().
+		Where(`id IN ? AND NOT EXISTS (SELECT 1 FROM profile_addresses pa WHERE pa.address_id = addresses.id)
+			AND NOT EXISTS (SELECT 1 FROM addresses c WHERE c.parent_id = addresses.id)`, addressIDs).
+		Delete(&models.Address{})
+	erased[addressesTable] = result.RowsAffected
+	return result.Error
+}
+
+func (pr *profileRepository) GetErasure(ctx context.Context, profileID string) (*models.ProfileErasure, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	erasure := &models.ProfileErasure{}
+	err := pr.Pool().DB(unscopedCtx, false).First(erasure, "profile_id = ?", profileID).Error
+	return erasure, err
+}
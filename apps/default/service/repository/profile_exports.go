@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/datastore/pool"
+	"github.com/pitabwire/frame/v2/security"
+	"github.com/pitabwire/frame/v2/workerpool"
+	"gorm.io/gorm"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+type profileExportRepository struct {
+	datastore.BaseRepository[*models.ProfileExport]
+}
+
+func NewProfileExportRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) ProfileExportRepository {
+	return &profileExportRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.ProfileExport](
+			ctx, dbPool, workMan, func() *models.ProfileExport { return &models.ProfileExport{} },
+		),
+	}
+}
+
+// claimable selects exports waiting to run, and running ones abandoned
+// before staleBefore.
+func claimable(db *gorm.DB, staleBefore time.Time) *gorm.DB {
+	return db.Where("status = ? OR (status = ? AND modified_at < ?)",
+		models.ProfileExportPending, models.ProfileExportRunning, staleBefore)
+}
+
+func (r *profileExportRepository) ListClaimable(
+	ctx context.Context,
+	staleBefore time.Time,
+	limit int,
+) ([]*models.ProfileExport, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var exports []*models.ProfileExport
+	err := claimable(r.Pool().DB(unscopedCtx, false), staleBefore).
+		Order("id").
+		Limit(limit).
+		Find(&exports).Error
+	return exports, err
+}
+
+func (r *profileExportRepository) Claim(ctx context.Context, exportID string, staleBefore time.Time) (bool, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	result := claimable(r.Pool().DB(unscopedCtx, false).Model(&models.ProfileExport{}), staleBefore).
+		Where("id = ?", exportID).
+		Updates(map[string]any{"status": models.ProfileExportRunning, "modified_at": time.Now()})
+	return result.RowsAffected == 1, result.Error
+}
+
+// CollectSubjectData reads everything held about profileID, across tenants.
+func (pr *profileRepository) CollectSubjectData(ctx context.Context, profileID string) (*models.SubjectData, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	db := pr.Pool().DB(unscopedCtx, true)
+
+	subject := &models.SubjectData{Profile: &models.Profile{}}
+	err := db.Preload("ProfileType").First(subject.Profile, "id = ?", profileID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	queries := []struct {
+		dest  any
+		query *gorm.DB
+	}{
+		{&subject.Contacts, db.Where("profile_id = ?", profileID)},
+		{&subject.Addresses, db.Preload("Address.Country").Where("profile_id = ?", profileID)},
+		{&subject.Relationships, db.Preload("RelationshipType").Where(
+			"(parent_object = ? AND parent_object_id = ?) OR (child_object = ? AND child_object_id = ?)",
+			profileObjectName, profileID, profileObjectName, profileID)},
+		{&subject.Rosters, db.Preload("Contact").Where("profile_id = ?", profileID)},
+		{&subject.Verifications, db.Where("profile_id = ?", profileID)},
+	}
+	for _, q := range queries {
+		if err = q.query.Find(q.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	verificationIDs := make([]string, 0, len(subject.Verifications))
+	for _, verification := range subject.Verifications {
+		verificationIDs = append(verificationIDs, verification.GetID())
+	}
+	err = db.Where("verification_id IN ?", verificationIDs).
+		Order("created_at").
+		Find(&subject.VerificationAttempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return subject, nil
+}
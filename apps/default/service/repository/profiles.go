@@ -2,17 +2,30 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
+	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/frame/v2/datastore"
 	"github.com/pitabwire/frame/v2/datastore/pool"
 	"github.com/pitabwire/frame/v2/security"
 	"github.com/pitabwire/frame/v2/workerpool"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
 	"github.com/antinvestor/service-profile/apps/default/service/models"
 )
 
+// profileObjectName is the object name relationships use to reference
+// profiles (see business.ProfilePeerName).
+const profileObjectName = "Profile"
+
+// ErrMergeTargetGone is returned for unmerges whose target has since been
+// merged into another profile or erased, so its journal no longer
+// describes where the merged rows are.
+var ErrMergeTargetGone = errors.New("the merge target has since been merged again or erased")
+
 type profileRepository struct {
 	datastore.BaseRepository[*models.Profile]
 }
@@ -57,6 +70,36 @@ func (pr *profileRepository) GetByID(ctx context.Context, id string) (*models.Pr
 	return profile, err
 }
 
+func (pr *profileRepository) GetRosterVisibility(
+	ctx context.Context,
+	profileIDs []string,
+) (map[string]string, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var rows []struct {
+		ID         string
+		Visibility string
+	}
+	err := pr.Pool().DB(unscopedCtx, true).
+		Model(&models.Profile{}).
+		Select("id, coalesce(properties->>?, '') AS visibility", models.ProfileRosterVisibilityProperty).
+		Where("id IN ?", profileIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	visibility := make(map[string]string, len(rows))
+	for _, row := range rows {
+		switch row.Visibility {
+		case models.RosterVisibilityProfile, models.RosterVisibilityHidden:
+			visibility[row.ID] = row.Visibility
+		default:
+			visibility[row.ID] = models.RosterVisibilityMatched
+		}
+	}
+	return visibility, nil
+}
+
 func (pr *profileRepository) Save(ctx context.Context, tenant *models.Profile) error {
 	return pr.Pool().DB(ctx, false).Save(tenant).Error
 }
@@ -68,3 +111,573 @@ func (pr *profileRepository) Delete(ctx context.Context, id string) error {
 	}
 	return pr.Pool().DB(ctx, false).Delete(profile).Error
 }
+
+// Predicates selecting the merged profile's rows that collide with what the
+// target already holds. Each takes (mergedID, targetID) in that order.
+const (
+	rosterCollision = `profile_id = ? AND EXISTS (
+		SELECT 1 FROM rosters t WHERE t.profile_id = ? AND t.contact_id = rosters.contact_id
+		AND t.name = rosters.name AND t.deleted_at IS NULL)`
+
+	profileAddressCollision = `profile_id = ? AND EXISTS (
+		SELECT 1 FROM profile_addresses t WHERE t.profile_id = ?
+		AND t.address_id = profile_addresses.address_id AND t.deleted_at IS NULL)`
+
+	relationshipParentCollision = `parent_object = '` + profileObjectName + `' AND parent_object_id = ? AND EXISTS (
+		SELECT 1 FROM relationships t WHERE t.parent_object = relationships.parent_object
+		AND t.parent_object_id = ? AND t.child_object = relationships.child_object
+		AND t.child_object_id = relationships.child_object_id
+		AND t.relationship_type_id = relationships.relationship_type_id AND t.deleted_at IS NULL)`
+
+	relationshipChildCollision = `child_object = '` + profileObjectName + `' AND child_object_id = ? AND EXISTS (
+		SELECT 1 FROM relationships t WHERE t.child_object = relationships.child_object
+		AND t.child_object_id = ? AND t.parent_object = relationships.parent_object
+		AND t.parent_object_id = relationships.parent_object_id
+		AND t.relationship_type_id = relationships.relationship_type_id AND t.deleted_at IS NULL)`
+)
+
+// relationshipSelfReference selects relationships between the two profiles,
+// which would reference target on both ends once merged.
+func relationshipSelfReference(tx *gorm.DB, targetID, mergedID string) *gorm.DB {
+	return tx.Where(`parent_object = ? AND child_object = ? AND (
+			(parent_object_id = ? AND child_object_id IN (?, ?)) OR
+			(child_object_id = ? AND parent_object_id IN (?, ?)))`,
+		profileObjectName, profileObjectName,
+		mergedID, mergedID, targetID,
+		mergedID, mergedID, targetID)
+}
+
+// Tables a merge touches, as recorded in the merge journal.
+const (
+	contactsTable         = "contacts"
+	verificationsTable    = "verifications"
+	rostersTable          = "rosters"
+	profileAddressesTable = "profile_addresses"
+	relationshipsTable    = "relationships"
+	propertyEntriesTable  = "property_entries"
+)
+
+// Merge folds merging into target in a single transaction. Every row that
+// references the merged profile is re-parented to target; rows that would
+// collide with what target already holds are dropped instead. The replayed
+// property entries are appended after the merged ledger has been moved so
+// they become the latest values, target's properties cache is persisted and
+// the merged profile is deleted. Everything done is journaled so Unmerge
+// can reverse it.
+func (pr *profileRepository) Merge(
+	ctx context.Context,
+	target, merging *models.Profile,
+	replayEntries []*models.PropertyEntry,
+) error {
+	// Merges span tenants: a person's contacts, rosters and ledger entries
+	// are written under whichever tenant created them.
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	targetID, mergedID := target.GetID(), merging.GetID()
+
+	return pr.Pool().DB(unscopedCtx, false).Transaction(func(tx *gorm.DB) error {
+		journal, journalEntries, err := journalMerge(ctx, tx, targetID, mergedID)
+		if err != nil {
+			return err
+		}
+
+		steps := []func(tx *gorm.DB, targetID, mergedID string) error{
+			mergeContacts,
+			mergeVerifications,
+			mergeRosters,
+			mergeProfileAddresses,
+			mergeRelationships,
+			mergePropertyEntries,
+			mergePropertySnapshots,
+		}
+		for _, step := range steps {
+			if err = step(tx, targetID, mergedID); err != nil {
+				return err
+			}
+		}
+
+		shadowed, err := shadowedProperties(tx, target, replayEntries)
+		if err != nil {
+			return err
+		}
+		replayEntries = append(replayEntries, shadowed...)
+
+		if len(replayEntries) > 0 {
+			if err = tx.Create(&replayEntries).Error; err != nil {
+				return err
+			}
+			replayIDs := make([]string, 0, len(replayEntries))
+			for _, e := range replayEntries {
+				replayIDs = append(replayIDs, e.GetID())
+			}
+			journalEntries = appendJournalEntries(journalEntries, journal,
+				propertyEntriesTable, models.MergeActionAppended, replayIDs)
+		}
+
+		if err = tx.Model(target).Update("properties", target.Properties).Error; err != nil {
+			return err
+		}
+
+		if err = tx.Delete(merging).Error; err != nil {
+			return err
+		}
+
+		if err = tx.Create(journal).Error; err != nil {
+			return err
+		}
+		if len(journalEntries) == 0 {
+			return nil
+		}
+		return tx.Create(&journalEntries).Error
+	})
+}
+
+// journalMerge captures, before anything is written, the target's current
+// properties and every row the merge is about to move or drop.
+func journalMerge(
+	ctx context.Context,
+	tx *gorm.DB,
+	targetID, mergedID string,
+) (*models.MergeJournal, []*models.MergeJournalEntry, error) {
+	previous := &models.Profile{}
+	err := tx.Select("properties").First(previous, "id = ?", targetID).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	journal := &models.MergeJournal{
+		TargetID:         targetID,
+		MergedID:         mergedID,
+		TargetProperties: previous.Properties,
+	}
+	journal.GenID(ctx)
+
+	plan, err := planMerge(tx, targetID, mergedID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var verificationIDs, propertyEntryIDs, propertySnapshotIDs []string
+	err = tx.Model(&models.Verification{}).
+		Where("profile_id = ?", mergedID).
+		Pluck("id", &verificationIDs).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Model(&models.PropertyEntry{}).
+		Where("profile_id = ?", mergedID).
+		Pluck("id", &propertyEntryIDs).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Model(&models.PropertySnapshot{}).
+		Where("profile_id = ?", mergedID).
+		Pluck("id", &propertySnapshotIDs).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []*models.MergeJournalEntry
+	for _, set := range []struct {
+		table, action string
+		ids           []string
+	}{
+		{contactsTable, models.MergeActionMoved, plan.MovedContactIDs},
+		{verificationsTable, models.MergeActionMoved, verificationIDs},
+		{rostersTable, models.MergeActionMoved, plan.MovedRosterIDs},
+		{rostersTable, models.MergeActionDropped, plan.CollidingRosterIDs},
+		{profileAddressesTable, models.MergeActionMoved, plan.MovedAddressLinkIDs},
+		{profileAddressesTable, models.MergeActionDropped, plan.CollidingAddressLinkIDs},
+		{relationshipsTable, models.MergeActionMoved, plan.MovedRelationshipIDs},
+		{relationshipsTable, models.MergeActionDropped, plan.CollidingRelationshipIDs},
+		{relationshipsTable, models.MergeActionDropped, plan.SelfReferencingRelationshipIDs},
+		{propertyEntriesTable, models.MergeActionMoved, propertyEntryIDs},
+		{propertySnapshotsTable, models.MergeActionMoved, propertySnapshotIDs},
+	} {
+		entries = appendJournalEntries(entries, journal, set.table, set.action, set.ids)
+	}
+
+	return journal, entries, nil
+}
+
+func appendJournalEntries(
+	entries []*models.MergeJournalEntry,
+	journal *models.MergeJournal,
+	table, action string,
+	ids []string,
+) []*models.MergeJournalEntry {
+	for _, id := range ids {
+		entries = append(entries, &models.MergeJournalEntry{
+			JournalID:   journal.GetID(),
+			RecordTable: table,
+			RecordID:    id,
+			Action:      action,
+		})
+	}
+	return entries
+}
+
+// GetLatestMergeJournal returns the most recent merge of mergedID that has
+// not been undone.
+func (pr *profileRepository) GetLatestMergeJournal(
+	ctx context.Context,
+	mergedID string,
+) (*models.MergeJournal, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	journal := &models.MergeJournal{}
+	err := pr.Pool().DB(unscopedCtx, false).
+		Where("merged_id = ? AND unmerged_at IS NULL", mergedID).
+		Order("created_at DESC").
+		First(journal).Error
+	return journal, err
+}
+
+// Unmerge reverses the merge recorded in journal in a single transaction.
+// Moved rows still held by the target go back to the merged profile,
+// dropped rows are restored, replayed entries are removed and the merged
+// profile is undeleted. The target's properties cache returns to its
+// pre-merge snapshot, overlaid with global values written after the merge.
+// It fails with ErrMergeTargetGone once the target is no longer live.
+func (pr *profileRepository) Unmerge(ctx context.Context, journal *models.MergeJournal) error {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	targetID, mergedID := journal.TargetID, journal.MergedID
+
+	return pr.Pool().DB(unscopedCtx, false).Transaction(func(tx *gorm.DB) error {
+		// The target's own later merge or erasure took the rows with it
+		var liveTargets int64
+		err := tx.Model(&models.Profile{}).Where("id = ?", targetID).Count(&liveTargets).Error
+		if err != nil {
+			return err
+		}
+		if liveTargets == 0 {
+			return ErrMergeTargetGone
+		}
+
+		var entries []*models.MergeJournalEntry
+		err = tx.Where("journal_id = ?", journal.GetID()).Find(&entries).Error
+		if err != nil {
+			return err
+		}
+
+		recorded := make(map[string]map[string][]string)
+		for _, e := range entries {
+			if recorded[e.Action] == nil {
+				recorded[e.Action] = make(map[string][]string)
+			}
+			recorded[e.Action][e.RecordTable] = append(recorded[e.Action][e.RecordTable], e.RecordID)
+		}
+
+		for table, ids := range recorded[models.MergeActionMoved] {
+			if err = unmergeMoved(tx, table, ids, targetID, mergedID); err != nil {
+				return err
+			}
+		}
+
+		for table, ids := range recorded[models.MergeActionDropped] {
+			if err = tx.Table(table).Where("id IN ?", ids).Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+		}
+
+		if ids := recorded[models.MergeActionAppended][propertyEntriesTable]; len(ids) > 0 {
+			if err = tx.Where("id IN ?", ids).Delete(&models.PropertyEntry{}).Error; err != nil {
+				return err
+			}
+		}
+
+		err = tx.Unscoped().Model(&models.Profile{}).
+			Where("id = ?", mergedID).
+			Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+
+		var laterEntries []*models.PropertyEntry
+		err = tx.Raw(`SELECT DISTINCT ON (key) * FROM property_entries
+			 WHERE profile_id = ? AND scoped = FALSE AND deleted_at IS NULL AND created_at > ?
+			 ORDER BY key, created_at DESC`, targetID, journal.CreatedAt).
+			Scan(&laterEntries).Error
+		if err != nil {
+			return err
+		}
+
+		properties := data.JSONMap{}
+		for key, value := range journal.TargetProperties {
+			properties[key] = value
+		}
+		for _, e := range laterEntries {
+			if e.Tombstone {
+				delete(properties, e.Key)
+				continue
+			}
+			properties[e.Key] = e.TypedValue()
+		}
+
+		err = tx.Model(&models.Profile{}).
+			Where("id = ?", targetID).
+			Update("properties", properties).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(journal).Update("unmerged_at", time.Now()).Error
+	})
+}
+
+// unmergeMoved hands moved rows back to the merged profile, leaving any the
+// target has since let go of alone.
+func unmergeMoved(tx *gorm.DB, table string, ids []string, targetID, mergedID string) error {
+	if table == propertySnapshotsTable {
+		return tx.Model(&models.PropertySnapshot{}).
+			Where("id IN ? AND profile_id = ?", ids, targetID).
+			Updates(map[string]any{
+				"profile_id":  mergedID,
+				"merged_from": gorm.Expr("CASE WHEN merged_from = ? THEN '' ELSE merged_from END", mergedID),
+			}).Error
+	}
+
+	if table != relationshipsTable {
+		return tx.Table(table).
+			Where("id IN ? AND profile_id = ?", ids, targetID).
+			Update("profile_id", mergedID).Error
+	}
+
+	err := tx.Table(table).
+		Where("id IN ? AND parent_object = ? AND parent_object_id = ?", ids, profileObjectName, targetID).
+		Update("parent_object_id", mergedID).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Table(table).
+		Where("id IN ? AND child_object = ? AND child_object_id = ?", ids, profileObjectName, targetID).
+		Update("child_object_id", mergedID).Error
+}
+
+// PreviewMerge reports which of the merged profile's rows Merge would move
+// to target and which it would drop, using the same predicates Merge does.
+func (pr *profileRepository) PreviewMerge(
+	ctx context.Context,
+	targetID, mergedID string,
+) (*models.MergePreview, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	return planMerge(pr.Pool().DB(unscopedCtx, true), targetID, mergedID)
+}
+
+// planMerge lists the merged profile's rows that a merge would move to
+// target and those it would drop.
+func planMerge(db *gorm.DB, targetID, mergedID string) (*models.MergePreview, error) {
+	preview := &models.MergePreview{TargetID: targetID, MergedID: mergedID}
+
+	err := db.Model(&models.Contact{}).
+		Where("profile_id = ?", mergedID).
+		Pluck("id", &preview.MovedContactIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.Roster{}).
+		Where(rosterCollision, mergedID, targetID).
+		Pluck("id", &preview.CollidingRosterIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.Roster{}).
+		Where("profile_id = ? AND NOT ("+rosterCollision+")", mergedID, mergedID, targetID).
+		Pluck("id", &preview.MovedRosterIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.ProfileAddress{}).
+		Where(profileAddressCollision, mergedID, targetID).
+		Pluck("id", &preview.CollidingAddressLinkIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.ProfileAddress{}).
+		Where("profile_id = ? AND NOT ("+profileAddressCollision+")", mergedID, mergedID, targetID).
+		Pluck("id", &preview.MovedAddressLinkIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = relationshipSelfReference(db.Model(&models.Relationship{}), targetID, mergedID).
+		Pluck("id", &preview.SelfReferencingRelationshipIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.Relationship{}).
+		Where("(("+relationshipParentCollision+") OR ("+relationshipChildCollision+"))",
+			mergedID, targetID, mergedID, targetID).
+		Pluck("id", &preview.CollidingRelationshipIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var relationshipIDs []string
+	err = db.Model(&models.Relationship{}).
+		Where("((parent_object = ? AND parent_object_id = ?) OR (child_object = ? AND child_object_id = ?))",
+			profileObjectName, mergedID, profileObjectName, mergedID).
+		Pluck("id", &relationshipIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	dropped := make(map[string]bool)
+	for _, id := range preview.SelfReferencingRelationshipIDs {
+		dropped[id] = true
+	}
+	for _, id := range preview.CollidingRelationshipIDs {
+		dropped[id] = true
+	}
+	for _, id := range relationshipIDs {
+		if !dropped[id] {
+			preview.MovedRelationshipIDs = append(preview.MovedRelationshipIDs, id)
+		}
+	}
+
+	return preview, nil
+}
+
+func mergeContacts(tx *gorm.DB, targetID, mergedID string) error {
+	return tx.Model(&models.Contact{}).
+		Where("profile_id = ?", mergedID).
+		Update("profile_id", targetID).Error
+}
+
+func mergeVerifications(tx *gorm.DB, targetID, mergedID string) error {
+	return tx.Model(&models.Verification{}).
+		Where("profile_id = ?", mergedID).
+		Update("profile_id", targetID).Error
+}
+
+// mergeRosters moves roster entries across, keeping target's entry when both
+// profiles list the same contact under the same roster name. The unique
+// index on (profile_id, contact_id, name) also covers soft-deleted rows, so
+// target's deleted duplicates are purged before the move.
+func mergeRosters(tx *gorm.DB, targetID, mergedID string) error {
+	err := tx.Unscoped().
+		Where(`profile_id = ? AND deleted_at IS NOT NULL AND EXISTS (
+			SELECT 1 FROM rosters m WHERE m.profile_id = ? AND m.contact_id = rosters.contact_id
+			AND m.name = rosters.name AND m.deleted_at IS NULL)`, targetID, mergedID).
+		Delete(&models.Roster{}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Where(rosterCollision, mergedID, targetID).Delete(&models.Roster{}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Roster{}).
+		Where("profile_id = ?", mergedID).
+		Update("profile_id", targetID).Error
+}
+
+// mergeProfileAddresses moves address links across, dropping links to
+// addresses target is already linked to.
+func mergeProfileAddresses(tx *gorm.DB, targetID, mergedID string) error {
+	err := tx.Where(profileAddressCollision, mergedID, targetID).Delete(&models.ProfileAddress{}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.ProfileAddress{}).
+		Where("profile_id = ?", mergedID).
+		Update("profile_id", targetID).Error
+}
+
+// mergeRelationships re-points both ends of the merged profile's
+// relationships at target. Relationships target already has with the same
+// peer and type are dropped, as are relationships between the two profiles,
+// which would otherwise become self-referencing.
+func mergeRelationships(tx *gorm.DB, targetID, mergedID string) error {
+	err := relationshipSelfReference(tx, targetID, mergedID).Delete(&models.Relationship{}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Where(relationshipParentCollision, mergedID, targetID).Delete(&models.Relationship{}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Where(relationshipChildCollision, mergedID, targetID).Delete(&models.Relationship{}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&models.Relationship{}).
+		Where("parent_object = ? AND parent_object_id = ?", profileObjectName, mergedID).
+		Update("parent_object_id", targetID).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Relationship{}).
+		Where("child_object = ? AND child_object_id = ?", profileObjectName, mergedID).
+		Update("child_object_id", targetID).Error
+}
+
+// shadowedProperties returns entries re-asserting target's cached
+// properties, other than those being replayed, whose latest ledger entry is
+// one of the merged profile's tombstones now that its ledger has moved over.
+func shadowedProperties(
+	tx *gorm.DB,
+	target *models.Profile,
+	replayEntries []*models.PropertyEntry,
+) ([]*models.PropertyEntry, error) {
+	var keys []string
+	err := tx.Raw(`SELECT key FROM (
+			SELECT DISTINCT ON (key) key, tombstone FROM property_entries
+			WHERE profile_id = ? AND scoped = FALSE AND deleted_at IS NULL
+			ORDER BY key, created_at DESC, id DESC
+		 ) latest WHERE tombstone`, target.GetID()).
+		Scan(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	replayed := make(map[string]bool, len(replayEntries))
+	for _, e := range replayEntries {
+		replayed[e.Key] = true
+	}
+
+	var entries []*models.PropertyEntry
+	for _, key := range keys {
+		value, ok := target.Properties[key]
+		if !ok || replayed[key] {
+			continue
+		}
+		entry := &models.PropertyEntry{ProfileID: target.GetID(), Key: key}
+		if err = entry.SetValue(value); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// mergePropertyEntries moves the merged profile's ledger, global and scoped,
+// so its history stays visible on target.
+func mergePropertyEntries(tx *gorm.DB, targetID, mergedID string) error {
+	return tx.Model(&models.PropertyEntry{}).
+		Where("profile_id = ?", mergedID).
+		Update("profile_id", targetID).Error
+}
+
+// mergePropertySnapshots moves the merged profile's compacted properties
+// alongside target's own snapshots, marking the ones it compacted itself as
+// merged from it so unmerging can tell them apart.
+func mergePropertySnapshots(tx *gorm.DB, targetID, mergedID string) error {
+	return tx.Model(&models.PropertySnapshot{}).
+		Where("profile_id = ?", mergedID).
+		Updates(map[string]any{
+			"profile_id":  targetID,
+			"merged_from": gorm.Expr("CASE WHEN merged_from = '' THEN ? ELSE merged_from END", mergedID),
+		}).Error
+}
@@ -2,11 +2,17 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/pitabwire/frame/v2/datastore"
 	"github.com/pitabwire/frame/v2/datastore/pool"
 	"github.com/pitabwire/frame/v2/security"
 	"github.com/pitabwire/frame/v2/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/antinvestor/service-profile/apps/default/service/models"
 )
@@ -35,51 +41,302 @@ func (r *propertyEntryRepository) AppendEntries(
 	return r.Pool().DB(ctx, false).Create(&entries).Error
 }
 
-// LatestGlobalByProfile returns the latest global (scoped=false) entry per key.
+// ErrBeforeCompactionHorizon is returned for point-in-time reads earlier
+// than the horizon up to which the ledger has been folded into a snapshot.
+var ErrBeforeCompactionHorizon = errors.New("the property ledger has been compacted past the requested time")
+
+// LatestGlobalByProfile returns the latest global (scoped=false) entry per key,
+// leaving out keys whose latest entry is a tombstone.
 func (r *propertyEntryRepository) LatestGlobalByProfile(
 	ctx context.Context,
 	profileID string,
 ) ([]*models.PropertyEntry, error) {
-	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
-	var entries []*models.PropertyEntry
-	err := r.Pool().DB(unscopedCtx, true).
-		Raw(`SELECT DISTINCT ON (key) * FROM property_entries
-			 WHERE profile_id = ? AND scoped = FALSE AND deleted_at IS NULL
-			 ORDER BY key, created_at DESC`, profileID).
-		Scan(&entries).Error
-	return entries, err
+	return r.current(ctx, profileID, false, "", time.Time{})
 }
 
-// LatestScopedByProfileAndPartition returns latest scoped entries per key for a partition.
+// LatestScopedByProfileAndPartition returns latest scoped entries per key for a
+// partition, leaving out keys whose latest entry is a tombstone.
 func (r *propertyEntryRepository) LatestScopedByProfileAndPartition(
 	ctx context.Context,
 	profileID, partitionID string,
+) ([]*models.PropertyEntry, error) {
+	return r.current(ctx, profileID, true, partitionID, time.Time{})
+}
+
+// GlobalByProfileAsOf is LatestGlobalByProfile as it stood at asOf.
+func (r *propertyEntryRepository) GlobalByProfileAsOf(
+	ctx context.Context,
+	profileID string,
+	asOf time.Time,
+) ([]*models.PropertyEntry, error) {
+	return r.current(ctx, profileID, false, "", asOf)
+}
+
+// ScopedByProfileAndPartitionAsOf is LatestScopedByProfileAndPartition as it
+// stood at asOf.
+func (r *propertyEntryRepository) ScopedByProfileAndPartitionAsOf(
+	ctx context.Context,
+	profileID, partitionID string,
+	asOf time.Time,
+) ([]*models.PropertyEntry, error) {
+	return r.current(ctx, profileID, true, partitionID, asOf)
+}
+
+// current replays the scope's snapshot and the entries left in the ledger, up
+// to asOf unless it is zero, and returns the latest live entry per key.
+func (r *propertyEntryRepository) current(
+	ctx context.Context,
+	profileID string,
+	scoped bool,
+	partitionID string,
+	asOf time.Time,
 ) ([]*models.PropertyEntry, error) {
 	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	db := r.Pool().DB(unscopedCtx, true)
+
+	snapshot, err := findSnapshot(db, profileID, scoped, partitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	condition := "profile_id = ? AND scoped = ? AND deleted_at IS NULL"
+	args := []any{profileID, scoped}
+	if scoped {
+		condition += " AND partition_id = ?"
+		args = append(args, partitionID)
+	}
+	if snapshot != nil && !asOf.IsZero() && asOf.Before(snapshot.Horizon) {
+		return nil, ErrBeforeCompactionHorizon
+	}
+	if !asOf.IsZero() {
+		condition += " AND created_at <= ?"
+		args = append(args, asOf)
+	}
+
 	var entries []*models.PropertyEntry
-	err := r.Pool().DB(unscopedCtx, true).
-		Raw(`SELECT DISTINCT ON (key) * FROM property_entries
-			 WHERE profile_id = ? AND scoped = TRUE AND partition_id = ? AND deleted_at IS NULL
-			 ORDER BY key, created_at DESC`, profileID, partitionID).
+	err = db.Raw(`SELECT DISTINCT ON (key) * FROM property_entries
+			 WHERE `+condition+`
+			 ORDER BY key, created_at DESC, id DESC`, args...).
 		Scan(&entries).Error
-	return entries, err
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*models.PropertyEntry)
+	if snapshot != nil {
+		for key := range snapshot.Entries {
+			latest[key], _ = snapshot.Entry(key)
+		}
+	}
+	// Compaction removes the entries it folds, but entries moved over by a
+	// merge may predate the snapshot, so the later of the two wins.
+	for _, e := range entries {
+		if folded, ok := latest[e.Key]; !ok || !folded.CreatedAt.After(e.CreatedAt) {
+			latest[e.Key] = e
+		}
+	}
+
+	result := make([]*models.PropertyEntry, 0, len(latest))
+	for _, e := range latest {
+		if !e.Tombstone {
+			result = append(result, e)
+		}
+	}
+	slices.SortFunc(result, func(a, b *models.PropertyEntry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return result, nil
+}
+
+// findSnapshot returns the scope's snapshot, combined with those merges
+// moved onto it, or nil if it was never compacted.
+func findSnapshot(db *gorm.DB, profileID string, scoped bool, partitionID string) (*models.PropertySnapshot, error) {
+	snapshots, err := scopeSnapshots(db, profileID, scoped, partitionID)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+
+	snapshot := &models.PropertySnapshot{ProfileID: profileID, Scoped: scoped, ScopePartition: partitionID}
+	for _, s := range snapshots {
+		snapshot.Absorb(s)
+	}
+	return snapshot, nil
+}
+
+// scopeSnapshots returns every snapshot row of the scope, the scope's own
+// first.
+func scopeSnapshots(db *gorm.DB, profileID string, scoped bool, partitionID string) ([]*models.PropertySnapshot, error) {
+	var snapshots []*models.PropertySnapshot
+	err := db.Where("profile_id = ? AND scoped = ? AND scope_partition = ?", profileID, scoped, partitionID).
+		Order("merged_from").
+		Find(&snapshots).Error
+	return snapshots, err
 }
 
-// HistoryByKey returns all entries for a profile+key, most recent first.
-// Scoped entries are filtered to the caller's tenant.
+// HistoryByKey returns all entries for a profile+key, most recent first,
+// tombstones included. Entries compacted away are represented by the one
+// their snapshot kept. Scoped entries are filtered to the caller's tenant.
 func (r *propertyEntryRepository) HistoryByKey(
 	ctx context.Context,
 	profileID, key, callerTenantID string,
+) ([]*models.PropertyEntry, error) {
+	return r.history(ctx, profileID, []string{key}, callerTenantID)
+}
+
+func (r *propertyEntryRepository) HistoryByProfile(
+	ctx context.Context,
+	profileID, callerTenantID string,
+) ([]*models.PropertyEntry, error) {
+	return r.history(ctx, profileID, nil, callerTenantID)
+}
+
+// history returns the global entries of profileID and the scoped ones of
+// callerTenantID, snapshot entries included, for keys or for every key when
+// keys is empty. Newest first.
+func (r *propertyEntryRepository) history(
+	ctx context.Context,
+	profileID string,
+	keys []string,
+	callerTenantID string,
 ) ([]*models.PropertyEntry, error) {
 	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	db := r.Pool().DB(unscopedCtx, true)
+
+	query := db.Where("profile_id = ? AND (scoped = FALSE OR tenant_id = ?) AND deleted_at IS NULL",
+		profileID, callerTenantID)
+	if len(keys) > 0 {
+		query = query.Where("key IN ?", keys)
+	}
+
 	var entries []*models.PropertyEntry
-	err := r.Pool().DB(unscopedCtx, true).
-		Where("profile_id = ? AND key = ? AND (scoped = FALSE OR tenant_id = ?) AND deleted_at IS NULL",
-			profileID, key, callerTenantID).
+	err := query.
 		// id is an xid, so it breaks created_at ties (xid second-resolution
 		// timestamp means two entries written in the same second share
 		// created_at but remain sortable by id).
 		Order("created_at DESC, id DESC").
 		Find(&entries).Error
-	return entries, err
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*models.PropertySnapshot
+	err = db.Where("profile_id = ?", profileID).Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		snapshotKeys := keys
+		if len(snapshotKeys) == 0 {
+			for key := range snapshot.Entries {
+				snapshotKeys = append(snapshotKeys, key)
+			}
+		}
+		for _, key := range snapshotKeys {
+			folded, ok := snapshot.Entry(key)
+			if ok && (!folded.Scoped || folded.TenantID == callerTenantID) {
+				entries = append(entries, folded)
+			}
+		}
+	}
+
+	slices.SortStableFunc(entries, func(a, b *models.PropertyEntry) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return entries, nil
+}
+
+// CompactionCandidates lists up to limit ledger scopes, as snapshot stubs,
+// holding at least minEntries entries written at or before horizon. Profiles
+// that took part in a merge after unmergeableBefore are skipped so an unmerge
+// can still move their entries back.
+func (r *propertyEntryRepository) CompactionCandidates(
+	ctx context.Context,
+	horizon, unmergeableBefore time.Time,
+	minEntries, limit int,
+) ([]*models.PropertySnapshot, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	var scopes []*models.PropertySnapshot
+	err := r.Pool().DB(unscopedCtx, true).
+		Raw(`SELECT pe.profile_id, pe.scoped,
+				CASE WHEN pe.scoped THEN pe.partition_id ELSE '' END AS scope_partition
+			 FROM property_entries pe
+			 WHERE pe.deleted_at IS NULL AND pe.created_at <= ?
+			   AND NOT EXISTS (
+				SELECT 1 FROM merge_journals mj
+				WHERE (mj.target_id = pe.profile_id OR mj.merged_id = pe.profile_id)
+				  AND mj.unmerged_at IS NULL AND mj.deleted_at IS NULL AND mj.created_at > ?
+			   )
+			 GROUP BY 1, 2, 3
+			 HAVING count(*) >= ?
+			 LIMIT ?`, horizon, unmergeableBefore, minEntries, limit).
+		Scan(&scopes).Error
+	return scopes, err
+}
+
+// Compact folds the scope's entries written at or before horizon into its
+// snapshot and deletes them from the ledger, returning how many were folded.
+func (r *propertyEntryRepository) Compact(
+	ctx context.Context,
+	scope *models.PropertySnapshot,
+	horizon time.Time,
+) (int, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	folded := 0
+	err := r.Pool().DB(unscopedCtx, false).Transaction(func(tx *gorm.DB) error {
+		snapshots, err := scopeSnapshots(tx.Clauses(clause.Locking{Strength: "UPDATE"}),
+			scope.ProfileID, scope.Scoped, scope.ScopePartition)
+		if err != nil {
+			return err
+		}
+		snapshot := &models.PropertySnapshot{
+			ProfileID:      scope.ProfileID,
+			Scoped:         scope.Scoped,
+			ScopePartition: scope.ScopePartition,
+		}
+		// Snapshots moved over by merges past unmerging are absorbed
+		var absorbed []string
+		for _, s := range snapshots {
+			if s.MergedFrom == "" {
+				s.Absorb(snapshot)
+				snapshot = s
+				continue
+			}
+			snapshot.Absorb(s)
+			absorbed = append(absorbed, s.GetID())
+		}
+
+		entries := tx.Where("profile_id = ? AND scoped = ? AND created_at <= ?",
+			scope.ProfileID, scope.Scoped, horizon)
+		if scope.Scoped {
+			entries = entries.Where("partition_id = ?", scope.ScopePartition)
+		}
+
+		var folding []*models.PropertyEntry
+		if err = entries.Session(&gorm.Session{}).Order("created_at, id").Find(&folding).Error; err != nil {
+			return err
+		}
+		if len(folding) == 0 {
+			return nil
+		}
+
+		for _, e := range folding {
+			snapshot.Fold(e)
+		}
+		if horizon.After(snapshot.Horizon) {
+			snapshot.Horizon = horizon
+		}
+		if err = tx.Save(snapshot).Error; err != nil {
+			return err
+		}
+		if len(absorbed) > 0 {
+			err = tx.Unscoped().Where("id IN ?", absorbed).Delete(&models.PropertySnapshot{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		folded = len(folding)
+		return entries.Session(&gorm.Session{}).Unscoped().Delete(&models.PropertyEntry{}).Error
+	})
+	return folded, err
 }
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pitabwire/frame/v2/datastore"
+	"github.com/pitabwire/frame/v2/datastore/pool"
+	"github.com/pitabwire/frame/v2/workerpool"
+
+	"github.com/antinvestor/service-profile/apps/default/service/models"
+)
+
+type propertySchemaRepository struct {
+	datastore.BaseRepository[*models.PropertySchema]
+}
+
+func NewPropertySchemaRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) PropertySchemaRepository {
+	return &propertySchemaRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.PropertySchema](
+			ctx, dbPool, workMan, func() *models.PropertySchema { return &models.PropertySchema{} },
+		),
+	}
+}
+
+// List returns the caller tenant's schemas ordered by key.
+func (r *propertySchemaRepository) List(ctx context.Context) ([]*models.PropertySchema, error) {
+	var schemas []*models.PropertySchema
+	err := r.Pool().DB(ctx, true).
+		Order("key").
+		Find(&schemas).Error
+	return schemas, err
+}
+
+// GetByKey returns the caller tenant's schema for key.
+func (r *propertySchemaRepository) GetByKey(ctx context.Context, key string) (*models.PropertySchema, error) {
+	schema := &models.PropertySchema{}
+	err := r.Pool().DB(ctx, true).
+		Where("key = ?", key).
+		First(schema).Error
+	return schema, err
+}
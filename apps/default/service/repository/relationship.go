@@ -2,6 +2,10 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
 
 	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
 	"github.com/pitabwire/frame/v2/datastore"
@@ -37,6 +41,7 @@ func (ar *relationshipRepository) List(
 	childrenIDs []string,
 	lastRelationshipID string,
 	count int,
+	filter models.RelationshipFilter,
 ) ([]*models.Relationship, error) {
 	var relationshipList []*models.Relationship
 
@@ -63,10 +68,298 @@ func (ar *relationshipRepository) List(
 		database = database.Where("child_object_id IN ?", childrenIDs)
 	}
 
+	if len(filter.Roles) > 0 {
+		database = database.Where("role IN ?", filter.Roles)
+	}
+
+	if !filter.ActiveAt.IsZero() {
+		database = database.Where(
+			"(valid_from IS NULL OR valid_from <= ?) AND (valid_until IS NULL OR valid_until > ?)",
+			filter.ActiveAt, filter.ActiveAt)
+	}
+
 	err := database.Find(&relationshipList).Error
 	return relationshipList, err
 }
 
+func (ar *relationshipRepository) ListLapsed(
+	ctx context.Context,
+	now time.Time,
+	limit int,
+) ([]*models.Relationship, error) {
+	var relationshipList []*models.Relationship
+	err := ar.Pool().DB(ctx, false).Preload(clause.Associations).
+		Where("valid_until IS NOT NULL AND valid_until <= ? AND expired_at IS NULL", now).
+		Order("valid_until ASC").
+		Limit(limit).
+		Find(&relationshipList).Error
+	return relationshipList, err
+}
+
+func (ar *relationshipRepository) ClaimLapsed(
+	ctx context.Context,
+	relationshipID string,
+	at time.Time,
+) (bool, error) {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	result := ar.Pool().DB(unscopedCtx, false).
+		Model(&models.Relationship{}).
+		Where("id = ? AND expired_at IS NULL", relationshipID).
+		Update("expired_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (ar *relationshipRepository) ReleaseLapsed(
+	ctx context.Context,
+	relationshipID string,
+	at time.Time,
+) error {
+	unscopedCtx := security.SkipTenancyChecksOnClaims(ctx)
+	return ar.Pool().DB(unscopedCtx, false).
+		Model(&models.Relationship{}).
+		Where("id = ? AND expired_at = ?", relationshipID, at).
+		Update("expired_at", nil).Error
+}
+
+// maxRelationshipWalkRows caps the rows a walk of the relationship graph
+// returns.
+const maxRelationshipWalkRows = 5000
+
+// ErrRelationshipWalkTooLarge is returned for walks of the relationship
+// graph that would return more than maxRelationshipWalkRows rows.
+var ErrRelationshipWalkTooLarge = errors.New("too many relationships within the requested hops")
+
+// Walks of the relationship graph hold one row per relationship taken at
+// each depth. UNION drops the rows a level repeats, so a level never holds
+// more rows than there are relationships and a walk grows with the graph
+// and the depth rather than with the paths through it. Relationships
+// leading back to the entry the walk starts from are not taken, and the
+// depth bound ends walks round any other cycle.
+const (
+	descendantWalkQuery = `WITH RECURSIVE walk AS (
+			SELECT r.id, r.relationship_type_id, r.child_object AS object, r.child_object_id AS object_id, 1 AS depth
+			FROM relationships r
+			WHERE r.deleted_at IS NULL AND r.parent_object = @object AND r.parent_object_id = @object_id
+				AND NOT (r.child_object = @object AND r.child_object_id = @object_id)
+			UNION
+			SELECT r.id, r.relationship_type_id, r.child_object, r.child_object_id, w.depth + 1
+			FROM walk w
+			JOIN relationships r ON r.deleted_at IS NULL
+				AND r.parent_object_id = w.object_id AND r.parent_object = w.object
+			WHERE w.depth < @depth AND NOT (r.child_object = @object AND r.child_object_id = @object_id)
+		)
+		SELECT id, depth FROM walk`
+
+	ancestorWalkQuery = `WITH RECURSIVE walk AS (
+			SELECT r.id, r.relationship_type_id, r.parent_object AS object, r.parent_object_id AS object_id, 1 AS depth
+			FROM relationships r
+			WHERE r.deleted_at IS NULL AND r.child_object = @object AND r.child_object_id = @object_id
+				AND NOT (r.parent_object = @object AND r.parent_object_id = @object_id)
+			UNION
+			SELECT r.id, r.relationship_type_id, r.parent_object, r.parent_object_id, w.depth + 1
+			FROM walk w
+			JOIN relationships r ON r.deleted_at IS NULL
+				AND r.child_object_id = w.object_id AND r.child_object = w.object
+			WHERE w.depth < @depth AND NOT (r.parent_object = @object AND r.parent_object_id = @object_id)
+		)
+		SELECT id, depth FROM walk`
+
+	// The walk follows relationships either way, each row naming the entry
+	// it stepped from, and goes no further from the target.
+	shortestPathQuery = `WITH RECURSIVE walk AS (
+			SELECT ''::text AS id, ''::text AS previous_object, ''::text AS previous_object_id,
+				@from_object::text AS object, @from_object_id::text AS object_id, 0 AS depth
+			UNION
+			SELECT r.id::text, w.object, w.object_id, hop.object::text, hop.object_id::text, w.depth + 1
+			FROM walk w
+			JOIN relationships r ON r.deleted_at IS NULL AND (
+				(r.parent_object_id = w.object_id AND r.parent_object = w.object) OR
+				(r.child_object_id = w.object_id AND r.child_object = w.object))
+			CROSS JOIN LATERAL (SELECT
+				CASE WHEN r.parent_object_id = w.object_id AND r.parent_object = w.object
+					THEN r.child_object ELSE r.parent_object END AS object,
+				CASE WHEN r.parent_object_id = w.object_id AND r.parent_object = w.object
+					THEN r.child_object_id ELSE r.parent_object_id END AS object_id) hop
+			WHERE w.depth < @depth AND NOT (w.object = @to_object AND w.object_id = @to_object_id)
+				AND NOT (hop.object = @from_object AND hop.object_id = @from_object_id)
+		)
+		SELECT id, previous_object, previous_object_id, object, object_id, depth FROM walk WHERE depth > 0`
+)
+
+type relationshipWalkStep struct {
+	ID    string
+	Depth int
+}
+
+type relationshipPathStep struct {
+	ID               string
+	PreviousObject   string
+	PreviousObjectID string
+	Object           string
+	ObjectID         string
+	Depth            int
+}
+
+func (ar *relationshipRepository) Traverse(
+	ctx context.Context,
+	peerName, peerID string,
+	inverseRelation bool,
+	maxDepth int,
+	relationshipTypeIDs ...string,
+) ([]*models.RelationshipEdge, error) {
+	query := descendantWalkQuery
+	if inverseRelation {
+		query = ancestorWalkQuery
+	}
+	args := map[string]any{
+		"object":    peerName,
+		"object_id": peerID,
+		"depth":     maxDepth,
+		"limit":     maxRelationshipWalkRows + 1,
+	}
+	// The walk passes through relationships of every type; only those of
+	// the types asked for are returned and count towards the cap.
+	if len(relationshipTypeIDs) > 0 {
+		query += " WHERE relationship_type_id IN @types"
+		args["types"] = relationshipTypeIDs
+	}
+
+	var steps []relationshipWalkStep
+	err := ar.Pool().DB(ctx, true).
+		Raw(query+" LIMIT @limit", args).
+		Scan(&steps).Error
+	if err != nil || len(steps) == 0 {
+		return nil, err
+	}
+	if len(steps) > maxRelationshipWalkRows {
+		return nil, ErrRelationshipWalkTooLarge
+	}
+
+	// A relationship reached more than one way is kept at its fewest hops
+	depths := make(map[string]int, len(steps))
+	ids := make([]string, 0, len(steps))
+	for _, step := range steps {
+		depth, seen := depths[step.ID]
+		if !seen {
+			ids = append(ids, step.ID)
+		}
+		if !seen || step.Depth < depth {
+			depths[step.ID] = step.Depth
+		}
+	}
+
+	var relationshipList []*models.Relationship
+	err = ar.Pool().DB(ctx, true).Preload(clause.Associations).
+		Where("id IN ?", ids).
+		Find(&relationshipList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	edges := make([]*models.RelationshipEdge, 0, len(relationshipList))
+	for _, relationship := range relationshipList {
+		edges = append(edges, &models.RelationshipEdge{
+			Relationship: relationship,
+			Depth:        depths[relationship.GetID()],
+		})
+	}
+	slices.SortFunc(edges, func(a, b *models.RelationshipEdge) int {
+		if a.Depth != b.Depth {
+			return a.Depth - b.Depth
+		}
+		return strings.Compare(a.Relationship.GetID(), b.Relationship.GetID())
+	})
+	return edges, nil
+}
+
+func (ar *relationshipRepository) ShortestPath(
+	ctx context.Context,
+	fromName, fromID, toName, toID string,
+	maxDepth int,
+) ([]*models.Relationship, error) {
+	var steps []relationshipPathStep
+	err := ar.Pool().DB(ctx, true).
+		Raw(shortestPathQuery+" LIMIT @limit", map[string]any{
+			"from_object":    fromName,
+			"from_object_id": fromID,
+			"to_object":      toName,
+			"to_object_id":   toID,
+			"depth":          maxDepth,
+			"limit":          maxRelationshipWalkRows + 1,
+		}).
+		Scan(&steps).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) > maxRelationshipWalkRows {
+		return nil, ErrRelationshipWalkTooLarge
+	}
+
+	pathIDs := shortestPathIDs(steps, toName, toID)
+	if len(pathIDs) == 0 {
+		return nil, nil
+	}
+
+	var relationshipList []*models.Relationship
+	err = ar.Pool().DB(ctx, true).Preload(clause.Associations).
+		Where("id IN ?", pathIDs).
+		Find(&relationshipList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*models.Relationship, len(relationshipList))
+	for _, relationship := range relationshipList {
+		byID[relationship.GetID()] = relationship
+	}
+	path := make([]*models.Relationship, 0, len(pathIDs))
+	for _, id := range pathIDs {
+		if relationship, ok := byID[id]; ok {
+			path = append(path, relationship)
+		}
+	}
+	return path, nil
+}
+
+// shortestPathIDs follows the walk back from the fewest hops it reached the
+// target in, a step at a time, to the relationships of a shortest path in
+// order. Every step's previous entry was reached a hop earlier, so the
+// trail always leads back to the start.
+func shortestPathIDs(steps []relationshipPathStep, toName, toID string) []string {
+	slices.SortFunc(steps, func(a, b relationshipPathStep) int {
+		if a.Depth != b.Depth {
+			return a.Depth - b.Depth
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	type arrival struct {
+		depth            int
+		object, objectID string
+	}
+	reached := make(map[arrival]relationshipPathStep, len(steps))
+	for _, step := range steps {
+		key := arrival{depth: step.Depth, object: step.Object, objectID: step.ObjectID}
+		if _, ok := reached[key]; !ok {
+			reached[key] = step
+		}
+	}
+
+	for _, step := range steps {
+		if step.Object != toName || step.ObjectID != toID {
+			continue
+		}
+
+		pathIDs := make([]string, step.Depth)
+		for depth := step.Depth; depth > 0; depth-- {
+			pathIDs[depth-1] = step.ID
+			step = reached[arrival{depth: depth - 1, object: step.PreviousObject, objectID: step.PreviousObjectID}]
+		}
+		return pathIDs
+	}
+	return nil
+}
+
 func (ar *relationshipRepository) RelationshipTypeByID(
 	ctx context.Context,
 	profileTypeID string,
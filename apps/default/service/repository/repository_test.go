@@ -3,7 +3,9 @@ package repository_test
 import (
 	"context"
 	"testing"
+	"time"
 
+	profilev1 "buf.build/gen/go/antinvestor/profile/protocolbuffers/go/profile/v1"
 	"github.com/pitabwire/frame/v2"
 	"github.com/pitabwire/frame/v2/datastore"
 	"github.com/pitabwire/frame/v2/frametests/definition"
@@ -222,6 +224,55 @@ func (rts *RepositoryTestSuite) TestVerificationRepository_SaveAttempt() {
 	})
 }
 
+func (rts *RepositoryTestSuite) TestVerificationRepository_CountFailedAttempts() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		_, _, verificationRepo := rts.getRepositories(ctx, svc)
+
+		verification := &models.Verification{
+			ProfileID: util.IDString(),
+			ContactID: util.IDString(),
+			Code:      "654321",
+		}
+		verification.GenID(ctx)
+		require.NoError(t, verificationRepo.Create(ctx, verification))
+
+		since := time.Now().Add(-time.Minute)
+		attempts := map[string]*models.VerificationAttempt{}
+		for _, state := range []string{
+			models.VerificationAttemptFail, models.VerificationAttemptBlocked, models.VerificationAttemptSuccess,
+		} {
+			attempt := &models.VerificationAttempt{
+				VerificationID: verification.GetID(),
+				State:          state,
+				DeviceID:       "device-count",
+				IPAddress:      "192.168.2.1",
+			}
+			attempt.GenID(ctx)
+			require.NoError(t, verificationRepo.SaveAttempt(ctx, attempt))
+			attempts[state] = attempt
+		}
+
+		// Only the failed attempt counts, blocked and successful ones don't
+		requireCounts := func(want int) {
+			byContact, err := verificationRepo.CountFailedAttemptsByContact(ctx, verification.ContactID, since)
+			require.NoError(t, err)
+			require.Equal(t, want, byContact)
+			bySource, err := verificationRepo.CountFailedAttemptsBySource(ctx, "", "192.168.2.1", since)
+			require.NoError(t, err)
+			require.Equal(t, want, bySource)
+		}
+		requireCounts(1)
+
+		err := verificationRepo.UpdateAttemptState(
+			ctx, attempts[models.VerificationAttemptFail].GetID(), models.VerificationAttemptBlocked)
+		require.NoError(t, err)
+		requireCounts(0)
+	})
+}
+
 func (rts *RepositoryTestSuite) TestMigrate() {
 	t := rts.T()
 
@@ -234,6 +285,37 @@ func (rts *RepositoryTestSuite) TestMigrate() {
 	})
 }
 
+func (rts *RepositoryTestSuite) TestJobLocker_TryLock() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		locker := repository.NewJobLocker(svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName))
+
+		release, locked, err := locker.TryLock(ctx, "job")
+		require.NoError(t, err)
+		require.True(t, locked)
+
+		// A second runner is turned away while the first holds the lock but
+		// other jobs are unaffected
+		_, locked, err = locker.TryLock(ctx, "job")
+		require.NoError(t, err)
+		require.False(t, locked)
+
+		releaseOther, locked, err := locker.TryLock(ctx, "other_job")
+		require.NoError(t, err)
+		require.True(t, locked)
+		releaseOther()
+
+		release()
+
+		release, locked, err = locker.TryLock(ctx, "job")
+		require.NoError(t, err)
+		require.True(t, locked)
+		release()
+	})
+}
+
 func (rts *RepositoryTestSuite) TestContactRepository_DelinkFromProfile() {
 	t := rts.T()
 
@@ -286,3 +368,152 @@ func (rts *RepositoryTestSuite) TestContactRepository_DelinkFromProfile_WrongPro
 		require.Error(t, err)
 	})
 }
+
+func (rts *RepositoryTestSuite) TestProfileRepository_Merge() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		contactRepo, profileRepo, _ := rts.getRepositories(ctx, svc)
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		workMan := svc.WorkManager()
+		rosterRepo := repository.NewRosterRepository(ctx, dbPool, workMan)
+		propertyEntryRepo := repository.NewPropertyEntryRepository(ctx, dbPool, workMan)
+
+		profileType, err := profileRepo.GetTypeByUID(ctx, profilev1.ProfileType_PERSON)
+		require.NoError(t, err)
+
+		newProfile := func() *models.Profile {
+			p := &models.Profile{Properties: map[string]any{}, ProfileTypeID: profileType.GetID()}
+			p.GenID(ctx)
+			require.NoError(t, profileRepo.Create(ctx, p))
+			return p
+		}
+		target := newProfile()
+		merging := newProfile()
+
+		contact := &models.Contact{
+			LookUpToken:     []byte("merge-lookup-token-" + util.IDString()),
+			EncryptedDetail: []byte("encrypted-detail"),
+			EncryptionKeyID: "test-key-id",
+			ContactType:     "EMAIL",
+			ProfileID:       merging.GetID(),
+		}
+		contact.GenID(ctx)
+		require.NoError(t, contactRepo.Create(ctx, contact))
+
+		friend := &models.Contact{
+			LookUpToken:     []byte("merge-friend-token-" + util.IDString()),
+			EncryptedDetail: []byte("encrypted-detail"),
+			EncryptionKeyID: "test-key-id",
+			ContactType:     "EMAIL",
+		}
+		friend.GenID(ctx)
+		require.NoError(t, contactRepo.Create(ctx, friend))
+
+		// Both profiles list the same friend on their default roster
+		for _, profileID := range []string{target.GetID(), merging.GetID()} {
+			roster := &models.Roster{ProfileID: profileID, ContactID: friend.GetID(), Name: "default"}
+			roster.GenID(ctx)
+			require.NoError(t, rosterRepo.Create(ctx, roster))
+		}
+
+		entry := &models.PropertyEntry{ProfileID: merging.GetID(), Key: "country", Value: "Kenya"}
+		require.NoError(t, propertyEntryRepo.AppendEntries(ctx, []*models.PropertyEntry{entry}))
+
+		target.Properties["country"] = "Kenya"
+		replay := []*models.PropertyEntry{{ProfileID: target.GetID(), Key: "country", Value: "Kenya"}}
+		require.NoError(t, profileRepo.Merge(ctx, target, merging, replay))
+
+		contacts, err := contactRepo.GetByProfileID(ctx, target.GetID())
+		require.NoError(t, err)
+		require.Len(t, contacts, 1)
+		require.Equal(t, contact.GetID(), contacts[0].GetID())
+
+		rosters, err := rosterRepo.GetByContactIDsAndProfileID(ctx, []string{friend.GetID()}, target.GetID())
+		require.NoError(t, err)
+		require.Len(t, rosters, 1, "colliding roster entries should be de-duplicated")
+
+		history, err := propertyEntryRepo.HistoryByKey(ctx, target.GetID(), "country", "")
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+
+		_, err = profileRepo.GetByID(ctx, merging.GetID())
+		require.Error(t, err)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestRelationshipRepository_Walks() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+
+		relationshipRepo := repository.NewRelationshipRepository(
+			ctx, svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName), svc.WorkManager())
+
+		member, err := relationshipRepo.RelationshipType(ctx, profilev1.RelationshipType_MEMBER)
+		require.NoError(t, err)
+		affiliated, err := relationshipRepo.RelationshipType(ctx, profilev1.RelationshipType_AFFILIATED)
+		require.NoError(t, err)
+
+		link := func(parentID, childID string, relationshipType *models.RelationshipType) *models.Relationship {
+			relationship := &models.Relationship{
+				ParentObject:       "Group",
+				ParentObjectID:     parentID,
+				ChildObject:        "Group",
+				ChildObjectID:      childID,
+				RelationshipTypeID: relationshipType.GetID(),
+			}
+			relationship.GenID(ctx)
+			return relationship
+		}
+
+		// a → b → c → a closes a cycle, and c → d leads out of it
+		a, b, c, d := util.IDString(), util.IDString(), util.IDString(), util.IDString()
+		for _, relationship := range []*models.Relationship{
+			link(a, b, member), link(b, c, member), link(c, a, member), link(c, d, affiliated),
+		} {
+			require.NoError(t, relationshipRepo.Create(ctx, relationship))
+		}
+
+		edges, err := relationshipRepo.Traverse(ctx, "Group", a, false, 10)
+		require.NoError(t, err)
+		depths := map[string]int{}
+		for _, edge := range edges {
+			depths[edge.Relationship.ChildObjectID] = edge.Depth
+		}
+		require.Equal(t, map[string]int{b: 1, c: 2, d: 3}, depths)
+
+		edges, err = relationshipRepo.Traverse(ctx, "Group", a, false, 10, affiliated.GetID())
+		require.NoError(t, err)
+		require.Len(t, edges, 1)
+		require.Equal(t, d, edges[0].Relationship.ChildObjectID)
+
+		path, err := relationshipRepo.ShortestPath(ctx, "Group", d, "Group", a, 10)
+		require.NoError(t, err)
+		require.Len(t, path, 2)
+		require.Equal(t, d, path[0].ChildObjectID)
+		require.Equal(t, a, path[1].ChildObjectID)
+
+		// A walk returning more rows than the cap fails, while one keeping
+		// to a type with few relationships doesn't count the others
+		hub := util.IDString()
+		fanOut := make([]*models.Relationship, 0, 5001)
+		for range 5001 {
+			fanOut = append(fanOut, link(hub, util.IDString(), member))
+		}
+		require.NoError(t, relationshipRepo.BulkCreate(ctx, fanOut))
+		require.NoError(t, relationshipRepo.Create(ctx, link(hub, a, affiliated)))
+
+		_, err = relationshipRepo.Traverse(ctx, "Group", hub, false, 1)
+		require.ErrorIs(t, err, repository.ErrRelationshipWalkTooLarge)
+		_, err = relationshipRepo.ShortestPath(ctx, "Group", hub, "Group", d, 4)
+		require.ErrorIs(t, err, repository.ErrRelationshipWalkTooLarge)
+
+		edges, err = relationshipRepo.Traverse(ctx, "Group", hub, false, 1, affiliated.GetID())
+		require.NoError(t, err)
+		require.Len(t, edges, 1)
+	})
+}
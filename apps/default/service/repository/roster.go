@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/pitabwire/frame/v2/data"
 	"github.com/pitabwire/frame/v2/datastore"
@@ -22,7 +23,7 @@ func NewRosterRepository(ctx context.Context, dbPool pool.Pool, workMan workerpo
 	)
 
 	baseRepo.ExtendFieldsAllowed("rosters.profile_id", "rosters.searchable",
-		"rosters.name", "contacts.look_up_token")
+		"rosters.name", "contacts.look_up_token", "contacts.verification_id")
 
 	rosterRepo := rosterRepository{
 		BaseRepository: baseRepo,
@@ -124,6 +125,45 @@ func (rr *rosterRepository) GetByContactIDsAndProfileIDAndName(
 	return rosterList, err
 }
 
+func (rr *rosterRepository) RestoreByContactIDsAndProfileIDAndName(
+	ctx context.Context,
+	contactIDs []string,
+	profileID, name string,
+) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+	return rr.Pool().DB(ctx, false).
+		Unscoped().
+		Model(&models.Roster{}).
+		Where("profile_id = ? AND contact_id IN ? AND name = ? AND deleted_at IS NOT NULL", profileID, contactIDs, name).
+		Updates(map[string]any{"deleted_at": nil, "modified_at": time.Now()}).
+		Error
+}
+
+func (rr *rosterRepository) GetChangedSince(
+	ctx context.Context,
+	profileID, name string,
+	since time.Time,
+) ([]*models.Roster, error) {
+	db := rr.Pool().DB(ctx, true).
+		Unscoped().
+		Select("rosters.*").
+		Joins("LEFT JOIN contacts ON rosters.contact_id = contacts.id").
+		Preload("Contact").
+		Where("rosters.profile_id = ? AND rosters.name = ?", profileID, name)
+	if since.IsZero() {
+		db = db.Where("rosters.deleted_at IS NULL")
+	} else {
+		db = db.Where("(rosters.modified_at > ? OR rosters.deleted_at > ? OR contacts.verified_at > ?)",
+			since, since, since)
+	}
+
+	rosterList := make([]*models.Roster, 0)
+	err := db.Order("rosters.created_at").Find(&rosterList).Error
+	return rosterList, err
+}
+
 func (rr *rosterRepository) GetByProfileID(ctx context.Context, profileID string) ([]*models.Roster, error) {
 	rosterList := make([]*models.Roster, 0)
 	err := rr.Pool().DB(ctx, true).
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/url"
@@ -108,6 +109,7 @@ func (bs *ProfileBaseTestSuite) CreateService(
 	cfg.ServerPort = ""
 	cfg.DatabaseMigrate = true
 	cfg.DatabaseTraceQueries = true
+	cfg.VerificationTokenSigningKey = "test-verification-token-signing-key"
 
 	res := depOpts.ByIsDatabase(ctx)
 	testDS, cleanup, err0 := res.GetRandomisedDS(t.Context(), depOpts.Prefix())
@@ -151,6 +153,31 @@ func (bs *ProfileBaseTestSuite) CreateService(
 		cfg.QueueRelationshipDisConnectURI,
 	)
 
+	profileMergedQueuePublisher := frame.WithRegisterPublisher(
+		cfg.QueueProfileMergedName,
+		cfg.QueueProfileMergedURI,
+	)
+	profileErasedQueuePublisher := frame.WithRegisterPublisher(
+		cfg.QueueProfileErasedName,
+		cfg.QueueProfileErasedURI,
+	)
+	profileExportCompletedQueuePublisher := frame.WithRegisterPublisher(
+		cfg.QueueProfileExportCompletedName,
+		cfg.QueueProfileExportCompletedURI,
+	)
+	rosterMatchQueuePublisher := frame.WithRegisterPublisher(
+		cfg.QueueRosterMatchName,
+		cfg.QueueRosterMatchURI,
+	)
+	verificationExpiredQueuePublisher := frame.WithRegisterPublisher(
+		cfg.QueueContactVerificationExpiredName,
+		cfg.QueueContactVerificationExpiredURI,
+	)
+	relationshipExpiredQueuePublisher := frame.WithRegisterPublisher(
+		cfg.QueueRelationshipExpiredName,
+		cfg.QueueRelationshipExpiredURI,
+	)
+
 	evtsMan := svc.EventsManager()
 	qMan := svc.QueueManager()
 	workMan := svc.WorkManager()
@@ -160,12 +187,30 @@ func (bs *ProfileBaseTestSuite) CreateService(
 	verificationRepo := repository.NewVerificationRepository(ctx, dbPool, workMan)
 	relationshipRepo := repository.NewRelationshipRepository(ctx, dbPool, workMan)
 
+	key, _ := base64.StdEncoding.DecodeString(cfg.DEKActiveAES256GCMKey)
+	lookupKey, _ := base64.StdEncoding.DecodeString(cfg.DEKLookupTokenHMACSHA256Key)
+	dek := &aconfig.DEK{
+		KeyID: cfg.DEKActiveKeyID, Key: key, LookUpKey: lookupKey,
+		// Matches the old key of the business test DEKs, so rotation runs
+		OldKeyID: "old-key-id", OldKey: []byte("1234567890123456"),
+	}
+
 	svc.Init(ctx,
 		relationshipConnectQueuePublisher, relationshipDisConnectQueuePublisher,
+		profileMergedQueuePublisher, profileErasedQueuePublisher, profileExportCompletedQueuePublisher,
+		rosterMatchQueuePublisher, verificationExpiredQueuePublisher, relationshipExpiredQueuePublisher,
 		frame.WithRegisterEvents(
 			events.NewClientConnectedSetupQueue(ctx, &cfg, qMan, evtsMan, relationshipRepo),
-			events.NewContactVerificationQueue(&cfg, contactRepo, verificationRepo, bs.GetNotificationCli(t)),
-			events.NewContactVerificationAttemptedQueue(contactRepo, verificationRepo),
+			events.NewContactVerificationQueue(&cfg, dek, contactRepo, verificationRepo, bs.GetNotificationCli(t)),
+			events.NewContactVerificationAttemptedQueue(verificationRepo),
+			events.NewProfileMergedQueue(&cfg, qMan),
+			events.NewProfileErasedQueue(&cfg, qMan),
+			events.NewProfileExportCompletedQueue(&cfg, qMan),
+			events.NewRosterMatchQueue(&cfg, qMan, repository.NewProfileRepository(ctx, dbPool, workMan),
+				repository.NewRosterRepository(ctx, dbPool, workMan)),
+			events.NewContactVerificationExpiredQueue(&cfg, qMan),
+			events.NewRelationshipExpiredQueue(&cfg, qMan),
+			events.NewContactKeyRotationQueue(&cfg, dek, contactRepo),
 		),
 	)
 
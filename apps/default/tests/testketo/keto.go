@@ -61,9 +61,13 @@ class service_profile implements Namespace {
     granted_profile_create: (profile_user | service_profile)[]
     granted_profile_update: (profile_user | service_profile)[]
     granted_profile_merge: (profile_user | service_profile)[]
+    granted_profile_erase: (profile_user | service_profile)[]
+    granted_profile_export: (profile_user | service_profile)[]
     granted_contact_manage: (profile_user | service_profile)[]
     granted_roster_manage: (profile_user | service_profile)[]
     granted_relationship_manage: (profile_user | service_profile)[]
+    granted_schema_manage: (profile_user | service_profile)[]
+    granted_tenant_shred: (profile_user | service_profile)[]
     granted_devices_manage: (profile_user | service_profile)[]
     granted_devices_view: (profile_user | service_profile)[]
     granted_geolocation_manage: (profile_user | service_profile)[]
@@ -101,6 +105,18 @@ class service_profile implements Namespace {
       this.related.admin.includes(ctx.subject) ||
       this.related.granted_profile_merge.includes(ctx.subject),
 
+    profile_erase: (ctx: Context): boolean =>
+      this.related.service.includes(ctx.subject) ||
+      this.related.owner.includes(ctx.subject) ||
+      this.related.admin.includes(ctx.subject) ||
+      this.related.granted_profile_erase.includes(ctx.subject),
+
+    profile_export: (ctx: Context): boolean =>
+      this.related.service.includes(ctx.subject) ||
+      this.related.owner.includes(ctx.subject) ||
+      this.related.admin.includes(ctx.subject) ||
+      this.related.granted_profile_export.includes(ctx.subject),
+
     contact_manage: (ctx: Context): boolean =>
       this.related.service.includes(ctx.subject) ||
       this.related.owner.includes(ctx.subject) ||
@@ -119,6 +135,17 @@ class service_profile implements Namespace {
       this.related.admin.includes(ctx.subject) ||
       this.related.granted_relationship_manage.includes(ctx.subject),
 
+    schema_manage: (ctx: Context): boolean =>
+      this.related.service.includes(ctx.subject) ||
+      this.related.owner.includes(ctx.subject) ||
+      this.related.admin.includes(ctx.subject) ||
+      this.related.granted_schema_manage.includes(ctx.subject),
+
+    tenant_shred: (ctx: Context): boolean =>
+      this.related.service.includes(ctx.subject) ||
+      this.related.owner.includes(ctx.subject) ||
+      this.related.granted_tenant_shred.includes(ctx.subject),
+
     devices_manage: (ctx: Context): boolean =>
       this.related.service.includes(ctx.subject) ||
       this.related.owner.includes(ctx.subject) ||
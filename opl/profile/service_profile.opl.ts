@@ -22,12 +22,16 @@ class service_profile implements Namespace {
     granted_profile_create: (profile_user | service_profile)[]
     granted_profile_update: (profile_user | service_profile)[]
     granted_profile_merge: (profile_user | service_profile)[]
+    granted_profile_erase: (profile_user | service_profile)[]
+    granted_profile_export: (profile_user | service_profile)[]
     granted_contact_manage: (profile_user | service_profile)[]
     granted_roster_view: (profile_user | service_profile)[]
     granted_roster_manage: (profile_user | service_profile)[]
     granted_address_manage: (profile_user | service_profile)[]
     granted_relationship_view: (profile_user | service_profile)[]
     granted_relationship_manage: (profile_user | service_profile)[]
+    granted_schema_manage: (profile_user | service_profile)[]
+    granted_tenant_shred: (profile_user | service_profile)[]
   }
 
   permits = {
@@ -60,6 +64,18 @@ class service_profile implements Namespace {
       this.related.service.includes(ctx.subject) ||
       this.related.granted_profile_merge.includes(ctx.subject),
 
+    profile_erase: (ctx: Context): boolean =>
+      this.related.admin.includes(ctx.subject) ||
+      this.related.owner.includes(ctx.subject) ||
+      this.related.service.includes(ctx.subject) ||
+      this.related.granted_profile_erase.includes(ctx.subject),
+
+    profile_export: (ctx: Context): boolean =>
+      this.related.admin.includes(ctx.subject) ||
+      this.related.owner.includes(ctx.subject) ||
+      this.related.service.includes(ctx.subject) ||
+      this.related.granted_profile_export.includes(ctx.subject),
+
     contact_manage: (ctx: Context): boolean =>
       this.related.admin.includes(ctx.subject) ||
       this.related.member.includes(ctx.subject) ||
@@ -105,5 +121,16 @@ class service_profile implements Namespace {
       this.related.owner.includes(ctx.subject) ||
       this.related.service.includes(ctx.subject) ||
       this.related.granted_relationship_manage.includes(ctx.subject),
+
+    schema_manage: (ctx: Context): boolean =>
+      this.related.admin.includes(ctx.subject) ||
+      this.related.owner.includes(ctx.subject) ||
+      this.related.service.includes(ctx.subject) ||
+      this.related.granted_schema_manage.includes(ctx.subject),
+
+    tenant_shred: (ctx: Context): boolean =>
+      this.related.owner.includes(ctx.subject) ||
+      this.related.service.includes(ctx.subject) ||
+      this.related.granted_tenant_shred.includes(ctx.subject),
   }
 }